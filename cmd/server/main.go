@@ -13,14 +13,8 @@ import (
 	"github.com/eust-w/ai_code_reviewer/internal/chat"
 	"github.com/eust-w/ai_code_reviewer/internal/config"
 	"github.com/eust-w/ai_code_reviewer/internal/git"
-	"github.com/eust-w/ai_code_reviewer/internal/git/gitea"
-	"github.com/eust-w/ai_code_reviewer/internal/git/github"
-	"github.com/eust-w/ai_code_reviewer/internal/git/gitlab"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
 	"github.com/sirupsen/logrus"
-	
-	// 外部SDK包
-	ghSDK "github.com/google/go-github/v60/github"
-	glSDK "github.com/xanzy/go-gitlab"
 )
 
 func main() {
@@ -41,9 +35,9 @@ func main() {
 	// Load configuration
 	cfg := config.LoadConfig()
 
-	// Create git platform client based on configuration
+	// Create a client for every platform the server receives webhooks from
 	platformFactory := git.NewFactory(cfg)
-	platform, err := platformFactory.CreatePlatform()
+	platforms, err := platformFactory.CreatePlatforms()
 	if err != nil {
 		logrus.Fatalf("Failed to create git platform client: %v", err)
 	}
@@ -55,7 +49,7 @@ func main() {
 	}
 
 	// Create bot
-	reviewBot := bot.NewBot(cfg, platform, chatClient)
+	reviewBot := bot.NewMultiPlatformBot(cfg, platforms, chatClient)
 	
 	// 如果索引器存在，确保在程序退出时关闭资源
 	if indexManager := reviewBot.GetIndexManager(); indexManager != nil {
@@ -69,9 +63,23 @@ func main() {
 		}()
 	}
 
-	// Create webhook handler based on platform
-	webhookSecret := os.Getenv("WEBHOOK_SECRET")
-	
+	// 审查任务进入队列，由固定数量的 worker 执行
+	store, err := queue.NewStore(cfg.ReviewQueueDir)
+	if err != nil {
+		logrus.Fatalf("Failed to create review queue: %v", err)
+	}
+	reviewQueue := queue.New(queue.Options{
+		Workers:        cfg.ReviewWorkers,
+		KeyConcurrency: cfg.ReviewRepoConcurrency,
+		Debounce:       cfg.ReviewDebounce,
+		Store:          store,
+	})
+	registerJobHandlers(reviewQueue, reviewBot)
+	if err := reviewQueue.Start(); err != nil {
+		logrus.Fatalf("Failed to start review queue: %v", err)
+	}
+	logrus.Infof("Review queue started with %d workers, %d per repository", cfg.ReviewWorkers, cfg.ReviewRepoConcurrency)
+
 	// Create HTTP server
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -81,91 +89,19 @@ func main() {
 	addr := fmt.Sprintf(":%s", port)
 	mux := http.NewServeMux()
 	
-	// Register platform-specific webhook handlers
-	switch cfg.Platform {
-	case "github":
-		webhookHandler := github.NewWebhookHandler(webhookSecret)
-		webhookHandler.On("pull_request", func(payload interface{}) error {
-			event, ok := payload.(*ghSDK.PullRequestEvent)
-			if !ok {
-				return fmt.Errorf("invalid payload type for pull_request event")
-			}
-			
-			// Handle the event in a goroutine to avoid blocking the webhook handler
-			go func() {
-				// 添加错误恢复机制
-				defer func() {
-					if r := recover(); r != nil {
-						logrus.Errorf("Recovered from panic in GitHub request handler: %v", r)
-					}
-				}()
-				
-				ctx := context.Background()
-				if err := reviewBot.HandleGitHubPullRequest(ctx, event); err != nil {
-					logrus.Errorf("Error handling GitHub pull request event: %v", err)
-				}
-			}()
-			
-			return nil
-		})
-		mux.HandleFunc("/webhook", webhookHandler.HandleWebhook)
-	
-	case "gitlab":
-		webhookHandler := gitlab.NewWebhookHandler(webhookSecret)
-		webhookHandler.On("Merge Request Hook", func(payload interface{}) error {
-			event, ok := payload.(*glSDK.MergeEvent)
-			if !ok {
-				return fmt.Errorf("invalid payload type for merge request event")
-			}
-			
-			// Handle the event in a goroutine to avoid blocking the webhook handler
-			go func() {
-				// 添加错误恢复机制
-				defer func() {
-					if r := recover(); r != nil {
-						logrus.Errorf("Recovered from panic in GitLab request handler: %v", r)
-					}
-				}()
-				
-				ctx := context.Background()
-				if err := reviewBot.HandleGitLabMergeRequest(ctx, event); err != nil {
-					logrus.Errorf("Error handling GitLab merge request event: %v", err)
-				}
-			}()
-			
-			return nil
-		})
-		mux.HandleFunc("/webhook", webhookHandler.HandleWebhook)
-	
-	case "gitea":
-		webhookHandler := gitea.NewWebhookHandler(webhookSecret)
-		webhookHandler.On("pull_request", func(payload interface{}) error {
-			event, ok := payload.(*gitea.HookPullRequestEvent)
-			if !ok {
-				return fmt.Errorf("invalid payload type for pull request event")
-			}
-			
-			// Handle the event in a goroutine to avoid blocking the webhook handler
-			go func() {
-				// 添加错误恢复机制
-				defer func() {
-					if r := recover(); r != nil {
-						logrus.Errorf("Recovered from panic in Gitea request handler: %v", r)
-					}
-				}()
-				
-				ctx := context.Background()
-				if err := reviewBot.HandleGiteaPullRequest(ctx, event); err != nil {
-					logrus.Errorf("Error handling Gitea pull request event: %v", err)
-				}
-			}()
-			
-			return nil
-		})
-		mux.HandleFunc("/webhook", webhookHandler.HandleWebhook)
-	
-	default:
-		logrus.Fatalf("Unsupported platform: %s", cfg.Platform)
+	// Register a webhook handler with its own secret for every platform
+	for _, platform := range cfg.Platforms {
+		handler, err := newWebhookHandler(platform, cfg.WebhookSecrets[platform], reviewQueue)
+		if err != nil {
+			logrus.Fatalf("Failed to create webhook handler: %v", err)
+		}
+		mux.HandleFunc("/webhook/"+platform, handler)
+		logrus.Infof("Serving %s webhooks on /webhook/%s", platform, platform)
+		
+		// 只有一个平台时保留原来的 /webhook 地址
+		if len(cfg.Platforms) == 1 {
+			mux.HandleFunc("/webhook", handler)
+		}
 	}
 
 	// Add health check endpoint
@@ -200,7 +136,15 @@ func main() {
 	// 注意：索引器资源关闭已经通过defer设置，将在程序退出时自动执行
 	
 	if err := server.Shutdown(ctx); err != nil {
-		logrus.Fatalf("Error shutting down server: %v", err)
+		logrus.Errorf("Error shutting down server: %v", err)
+	}
+	
+	// 不再接收 Webhook 后，等待队列中的审查完成
+	logrus.Infof("Draining review queue, waiting up to %s...", cfg.ShutdownTimeout)
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancelDrain()
+	if err := reviewQueue.Shutdown(drainCtx); err != nil {
+		logrus.Warnf("Review queue not drained: %v, unfinished reviews resume on the next start", err)
 	}
 	
 	logrus.Info("Server stopped")
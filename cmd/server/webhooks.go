@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/eust-w/ai_code_reviewer/internal/bot"
+	"github.com/eust-w/ai_code_reviewer/internal/git/azuredevops"
+	"github.com/eust-w/ai_code_reviewer/internal/git/bitbucket"
+	"github.com/eust-w/ai_code_reviewer/internal/git/gitea"
+	"github.com/eust-w/ai_code_reviewer/internal/git/github"
+	"github.com/eust-w/ai_code_reviewer/internal/git/gitlab"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
+
+	// 外部SDK包
+	ghSDK "github.com/google/go-github/v60/github"
+	glSDK "github.com/xanzy/go-gitlab"
+)
+
+// Job kinds of the webhook events reviewed through the queue
+const (
+	jobGitHubPullRequest      = "github.pull_request"
+	jobGitHubCheckRun         = "github.check_run"
+	jobGitHubIssueComment     = "github.issue_comment"
+	jobGitHubReviewComment    = "github.pull_request_review_comment"
+	jobGitLabMergeRequest     = "gitlab.merge_request"
+	jobGitLabNote             = "gitlab.note"
+	jobGiteaPullRequest       = "gitea.pull_request"
+	jobBitbucketPullRequest   = "bitbucket.pull_request"
+	jobAzureDevOpsPullRequest = "azuredevops.pull_request"
+)
+
+// repoKey is the concurrency key of the jobs of a repository
+func repoKey(platform, owner, repo string) string {
+	return platform + "/" + owner + "/" + repo
+}
+
+// prGroup groups the jobs of a pull request, so that they run one at a time
+// and a review of a newer head commit supersedes the review of an older one
+func prGroup(platform, owner, repo string, number int) string {
+	return fmt.Sprintf("%s#%d", repoKey(platform, owner, repo), number)
+}
+
+// registerJobHandlers registers the handlers that review queued events
+func registerJobHandlers(q *queue.Queue, reviewBot *bot.Bot) {
+	q.HandleReview(jobGitHubPullRequest, func(ctx context.Context, job *queue.Job) error {
+		var event ghSDK.PullRequestEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitHubPullRequest(ctx, &event)
+	})
+	q.HandleReview(jobGitHubCheckRun, func(ctx context.Context, job *queue.Job) error {
+		var event ghSDK.CheckRunEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitHubCheckRun(ctx, &event)
+	})
+	q.Handle(jobGitHubIssueComment, func(ctx context.Context, job *queue.Job) error {
+		var event ghSDK.IssueCommentEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitHubIssueComment(ctx, &event)
+	})
+	q.Handle(jobGitHubReviewComment, func(ctx context.Context, job *queue.Job) error {
+		var event ghSDK.PullRequestReviewCommentEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitHubReviewComment(ctx, &event)
+	})
+	q.HandleReview(jobGitLabMergeRequest, func(ctx context.Context, job *queue.Job) error {
+		var event glSDK.MergeEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitLabMergeRequest(ctx, &event)
+	})
+	q.Handle(jobGitLabNote, func(ctx context.Context, job *queue.Job) error {
+		var event glSDK.MergeCommentEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGitLabNote(ctx, &event)
+	})
+	q.HandleReview(jobGiteaPullRequest, func(ctx context.Context, job *queue.Job) error {
+		var event gitea.HookPullRequestEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleGiteaPullRequest(ctx, &event)
+	})
+	q.HandleReview(jobBitbucketPullRequest, func(ctx context.Context, job *queue.Job) error {
+		var event bitbucket.PullRequestEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleBitbucketPullRequest(ctx, &event)
+	})
+	q.HandleReview(jobAzureDevOpsPullRequest, func(ctx context.Context, job *queue.Job) error {
+		var event azuredevops.PullRequestEvent
+		if err := job.Decode(&event); err != nil {
+			return err
+		}
+		return reviewBot.HandleAzureDevOpsPullRequest(ctx, &event)
+	})
+}
+
+// newWebhookHandler creates the webhook handler of a platform, verifying
+// deliveries with the platform's secret. Events are queued as review jobs
+// instead of being reviewed while the platform waits for the response; an
+// event for a new head commit of a pull request supersedes the queued or
+// running review of the previous one.
+func newWebhookHandler(platform, secret string, q *queue.Queue) (http.HandlerFunc, error) {
+	switch platform {
+	case "github":
+		webhookHandler := github.NewWebhookHandler(secret)
+		webhookHandler.On("pull_request", func(payload interface{}) error {
+			event, ok := payload.(*ghSDK.PullRequestEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for pull_request event")
+			}
+			repo := event.GetRepo()
+			owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+			return q.EnqueueRevision(jobGitHubPullRequest, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.GetNumber()), event.GetPullRequest().GetHead().GetSHA(), event)
+		})
+		// 检查运行的重新运行请求触发一次新的审查，只有审查检查的重新运行请求才进入队列
+		webhookHandler.On("check_run", func(payload interface{}) error {
+			event, ok := payload.(*ghSDK.CheckRunEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for check_run event")
+			}
+			run := event.GetCheckRun()
+			if event.GetAction() != "rerequested" || run.GetName() != github.CheckRunName || len(run.PullRequests) == 0 {
+				return nil
+			}
+			repo := event.GetRepo()
+			owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+			return q.EnqueueRevision(jobGitHubCheckRun, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, run.PullRequests[0].GetNumber()), run.GetHeadSHA(), event)
+		})
+		// PR 评论中的命令，只有包含命令的评论才进入队列；命令与同一 PR 的审查依次执行
+		webhookHandler.On("issue_comment", func(payload interface{}) error {
+			event, ok := payload.(*ghSDK.IssueCommentEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for issue_comment event")
+			}
+			if !bot.HasCommand(event.GetComment().GetBody()) {
+				return nil
+			}
+			repo := event.GetRepo()
+			owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+			return q.EnqueueRevision(jobGitHubIssueComment, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.GetIssue().GetNumber()), "", event)
+		})
+		webhookHandler.On("pull_request_review_comment", func(payload interface{}) error {
+			event, ok := payload.(*ghSDK.PullRequestReviewCommentEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for pull_request_review_comment event")
+			}
+			if !bot.HasCommand(event.GetComment().GetBody()) {
+				return nil
+			}
+			repo := event.GetRepo()
+			owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+			return q.EnqueueRevision(jobGitHubReviewComment, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.GetPullRequest().GetNumber()), "", event)
+		})
+		return webhookHandler.HandleWebhook, nil
+
+	case "gitlab":
+		webhookHandler := gitlab.NewWebhookHandler(secret)
+		webhookHandler.On("Merge Request Hook", func(payload interface{}) error {
+			event, ok := payload.(*glSDK.MergeEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for merge request event")
+			}
+			owner, name := event.Project.Namespace, event.Project.Name
+			return q.EnqueueRevision(jobGitLabMergeRequest, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.ObjectAttributes.IID), event.ObjectAttributes.LastCommit.ID, event)
+		})
+		webhookHandler.On("Note Hook", func(payload interface{}) error {
+			event, ok := payload.(*glSDK.MergeCommentEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for note event")
+			}
+			if !bot.HasCommand(event.ObjectAttributes.Note) {
+				return nil
+			}
+			owner, name := event.Project.Namespace, event.Project.Name
+			return q.EnqueueRevision(jobGitLabNote, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.MergeRequest.IID), "", event)
+		})
+		return webhookHandler.HandleWebhook, nil
+
+	case "gitea":
+		webhookHandler := gitea.NewWebhookHandler(secret)
+		webhookHandler.On("pull_request", func(payload interface{}) error {
+			event, ok := payload.(*gitea.HookPullRequestEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for pull request event")
+			}
+			owner, name := event.Repository.Owner.Username, event.Repository.Name
+			return q.EnqueueRevision(jobGiteaPullRequest, repoKey(platform, owner, name),
+				prGroup(platform, owner, name, event.PullRequest.Number), event.PullRequest.Head.Sha, event)
+		})
+		return webhookHandler.HandleWebhook, nil
+
+	case "bitbucket":
+		webhookHandler := bitbucket.NewWebhookHandler(secret)
+		handlePullRequest := func(payload interface{}) error {
+			event, ok := payload.(*bitbucket.PullRequestEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for pull request event")
+			}
+			return q.EnqueueRevision(jobBitbucketPullRequest, repoKey(platform, event.Owner, event.Repo),
+				prGroup(platform, event.Owner, event.Repo, event.Number), event.HeadSHA, event)
+		}
+		// Bitbucket Cloud 与 Bitbucket Server 的事件名不同
+		for _, event := range bitbucket.PullRequestEvents {
+			webhookHandler.On(event, handlePullRequest)
+		}
+		return webhookHandler.HandleWebhook, nil
+
+	case "azuredevops":
+		// 服务钩子使用 Basic 认证，密钥为密码或 "用户名:密码"
+		webhookHandler := azuredevops.NewWebhookHandler(secret)
+		handlePullRequest := func(payload interface{}) error {
+			event, ok := payload.(*azuredevops.PullRequestEvent)
+			if !ok {
+				return fmt.Errorf("invalid payload type for pull request event")
+			}
+			return q.EnqueueRevision(jobAzureDevOpsPullRequest, repoKey(platform, event.Owner, event.Repo),
+				prGroup(platform, event.Owner, event.Repo, event.Number), event.HeadSHA, event)
+		}
+		for _, event := range azuredevops.PullRequestEvents {
+			webhookHandler.On(event, handlePullRequest)
+		}
+		return webhookHandler.HandleWebhook, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported platform: %s", platform)
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
-	"time"
 
 	"github.com/eust-w/ai_code_reviewer/internal/chat"
 	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
 	"github.com/eust-w/ai_code_reviewer/internal/git"
 	"github.com/eust-w/ai_code_reviewer/internal/indexer"
 	"github.com/gobwas/glob"
@@ -18,14 +18,22 @@ import (
 
 // Bot handles the code review logic
 type Bot struct {
-	config   *config.Config
-	platform git.Platform
-	chat     *chat.Chat
-	indexer  *indexer.IndexManager
+	config *config.Config
+	// platforms maps platform names to their clients
+	platforms map[string]git.Platform
+	chat      *chat.Chat
+	indexer   *indexer.IndexManager
 }
 
-// NewBot creates a new Bot instance
+// NewBot creates a new Bot instance for the platform of the configuration
 func NewBot(cfg *config.Config, platform git.Platform, chat *chat.Chat) *Bot {
+	return NewMultiPlatformBot(cfg, map[string]git.Platform{cfg.Platform: platform}, chat)
+}
+
+// NewMultiPlatformBot creates a Bot that reviews pull requests of several
+// platforms, each with its own client. Events are reviewed with the client
+// of the platform they came from.
+func NewMultiPlatformBot(cfg *config.Config, platforms map[string]git.Platform, chat *chat.Chat) *Bot {
 	// 创建索引管理器（如果启用）
 	var idxManager *indexer.IndexManager
 	if cfg.EnableIndexing {
@@ -41,11 +49,20 @@ func NewBot(cfg *config.Config, platform git.Platform, chat *chat.Chat) *Bot {
 	}
 
 	return &Bot{
-		config:   cfg,
-		platform: platform,
-		chat:     chat,
-		indexer:  idxManager,
+		config:    cfg,
+		platforms: platforms,
+		chat:      chat,
+		indexer:   idxManager,
+	}
+}
+
+// platform returns the client of a platform
+func (b *Bot) platform(name string) (git.Platform, error) {
+	client, ok := b.platforms[name]
+	if !ok {
+		return nil, fmt.Errorf("platform %s is not configured", name)
 	}
+	return client, nil
 }
 
 // HandlePullRequestEvent handles GitHub pull request events
@@ -70,7 +87,11 @@ func (b *Bot) HandlePullRequestEvent(ctx context.Context, event *github.PullRequ
 
 	// Check if target label is required and present
 	if b.config.TargetLabel != "" {
-		labels, err := b.platform.GetPullRequestLabels(ctx, owner, repoName, prNumber)
+		client, err := b.platform("github")
+		if err != nil {
+			return err
+		}
+		labels, err := client.GetPullRequestLabels(ctx, owner, repoName, prNumber)
 		if err != nil {
 			return fmt.Errorf("failed to get PR labels: %w", err)
 		}
@@ -89,213 +110,45 @@ func (b *Bot) HandlePullRequestEvent(ctx context.Context, event *github.PullRequ
 		}
 	}
 
-	// Compare commits to get changed files
-	base := pr.GetBase().GetSHA()
-	head := pr.GetHead().GetSHA()
-
-	logrus.Debugf("Comparing commits: base=%s, head=%s", base, head)
-	changedFiles, commits, err := b.platform.CompareCommits(ctx, owner, repoName, base, head)
-	if err != nil {
-		return fmt.Errorf("failed to compare commits: %w", err)
-	}
-
-	// For synchronize events, only review files changed in the latest commit
-	if action == "synchronize" && len(commits) >= 2 {
-		lastCommitBase := commits[len(commits)-2].SHA
-		lastCommitHead := commits[len(commits)-1].SHA
-
-		logrus.Debugf("Comparing latest commits: base=%s, head=%s", lastCommitBase, lastCommitHead)
-		changedFiles, _, err = b.platform.CompareCommits(ctx, owner, repoName, lastCommitBase, lastCommitHead)
-		if err != nil {
-			return fmt.Errorf("failed to compare latest commits: %w", err)
-		}
-	}
-
-	// Filter files based on patterns
-	filteredFiles := b.filterFiles(changedFiles)
-	if len(filteredFiles) == 0 {
-		logrus.Info("No files to review after filtering")
-		return nil
-	}
-
-	// Review each file
-	start := time.Now()
-	reviewComments := make([]*git.ReviewComment, 0)
-
-	for _, file := range filteredFiles {
-		if file.Status != "modified" && file.Status != "added" {
-			continue
-		}
-
-		patch := file.Patch
-		if patch == "" || (b.config.MaxPatchLength > 0 && len(patch) > b.config.MaxPatchLength) {
-			logrus.Infof("Skipping %s: empty patch or too large", file.Filename)
-			continue
-		}
-
-		// 使用代码索引增强补丁信息（如果启用）
-		enhancedPatch := patch
-		logrus.Infof("[代码审查] 索引器状态: %v", b.indexer != nil)
-		if b.indexer != nil {
-			logrus.Infof("[代码审查] 开始使用代码索引增强 %s 的审查上下文", file.Filename)
-
-			// 获取仓库信息
-			repoInfo := indexer.RepoInfo{
-				Owner:    owner,
-				Name:     repoName,
-				Language: indexer.GetFileLanguage(file.Filename),
-				Branch:   pr.GetBase().GetRef(),
-			}
-			logrus.Infof("[代码审查] 仓库信息: 所有者=%s, 仓库=%s, 语言=%s, 分支=%s", 
-				repoInfo.Owner, repoInfo.Name, repoInfo.Language, repoInfo.Branch)
-
-			// 查询相关代码上下文
-			idxr, err := b.indexer.GetIndexer(owner, repoName)
-			if err != nil {
-				logrus.Warnf("[代码审查] 无法获取 %s/%s 的索引器: %v - 将在没有代码上下文的情况下继续", owner, repoName, err)
-			} else {
-				logrus.Infof("[代码审查] 成功获取 %s/%s 的索引器", owner, repoName)
-				
-				// 记录原始补丁信息
-				patchPreview := patch
-				if len(patch) > 200 {
-					patchPreview = patch[:200] + "..."
-				}
-				logrus.Infof("[代码审查] 原始补丁大小: %d 字节, 预览: %s", len(patch), patchPreview)
-				
-				// 查询上下文
-				logrus.Infof("[代码审查] 开始查询 %s 的代码上下文", file.Filename)
-				codeContextMap, err := idxr.QueryContext(ctx, []*git.CommitFile{file}, repoInfo)
-				if err != nil {
-					logrus.Warnf("[代码审查] 查询 %s 的代码上下文失败: %v - 将在没有上下文增强的情况下继续", file.Filename, err)
-				} else if codeContext, ok := codeContextMap[file.Filename]; ok && codeContext != nil {
-					// 记录上下文详细信息
-					logrus.Infof("[代码审查] 找到 %s 的代码上下文: %d 个导入, %d 个定义, %d 个引用, %d 个依赖, %d 个相似代码片段",
-						file.Filename, len(codeContext.Imports), len(codeContext.Definitions), 
-						len(codeContext.References), len(codeContext.Dependencies), len(codeContext.SimilarCode))
-					
-					// 使用上下文丰富补丁
-					logrus.Infof("[代码审查] 开始使用代码上下文增强 %s 的补丁", file.Filename)
-					enhancedPatch = indexer.EnrichPatchWithContext(patch, codeContext)
-					
-					// 记录增强后的补丁信息
-					logrus.Infof("[代码审查] %s 的补丁增强完成, 原始大小: %d 字节, 增强后大小: %d 字节", 
-						file.Filename, len(patch), len(enhancedPatch))
-				} else {
-					logrus.Infof("[代码审查] 没有找到 %s 的相关代码上下文", file.Filename)
-				}
-			}
-		}
-
-		result, err := b.chat.CodeReview(ctx, enhancedPatch)
-		if err != nil {
-			logrus.Errorf("Failed to review %s: %v", file.Filename, err)
-			continue
-		}
-
-		// 构建评论内容
-		commentBody := ""
-
-		// 根据配置的语言选择评论模板
-		language := strings.ToLower(b.config.Language)
-		if language == "" {
-			// 默认使用中文
-			language = "chinese"
-		}
-
-		// 如果没有建议和风险，则视为LGTM通过
-		isEmptyResult := result.Suggestions == "" && result.Risks == ""
-
-		if !result.LGTM && !isEmptyResult {
-			if language == "english" {
-				commentBody += "**LGTM: ✖️ Changes Required**\n\n"
-			} else {
-				commentBody += "**LGTM: ✖️ 需要修改**\n\n"
-			}
-		} else {
-			if language == "english" {
-				commentBody += "**LGTM: ✅ Code Looks Good**\n\n"
-			} else {
-				commentBody += "**LGTM: ✅ 代码看起来不错**\n\n"
-			}
-		}
-
-		// 添加总结（仅当内容非空时）
-		if result.Summary != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Summary\n%s\n\n", result.Summary)
-			} else {
-				commentBody += fmt.Sprintf("## 总结\n%s\n\n", result.Summary)
-			}
-		}
-
-		// 添加详细评论
-		if result.ReviewComment != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Detailed Comments\n%s\n\n", result.ReviewComment)
-			} else {
-				commentBody += fmt.Sprintf("## 详细评论\n%s\n\n", result.ReviewComment)
-			}
-		}
-
-		if result.Suggestions != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Suggestions\n%s\n\n", result.Suggestions)
-			} else {
-				commentBody += fmt.Sprintf("## 改进建议\n%s\n\n", result.Suggestions)
-			}
-		}
-
-		// 添加亮点（暂时注释掉）
-		/*
-			if result.Highlights != "" {
-				if language == "english" {
-					commentBody += fmt.Sprintf("## Code Highlights\n%s\n\n", result.Highlights)
-				} else {
-					commentBody += fmt.Sprintf("## 代码亮点\n%s\n\n", result.Highlights)
-				}
-			}
-		*/
-
-		// 添加风险（简化为一句话）
-		if result.Risks != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("**Potential Risks**: %s\n\n", result.Risks)
-			} else {
-				commentBody += fmt.Sprintf("**潜在风险**: %s\n\n", result.Risks)
-			}
-		}
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "github",
+		owner:    owner,
+		repo:     repoName,
+		number:   prNumber,
+		baseRef:  pr.GetBase().GetRef(),
+		baseSHA:  pr.GetBase().GetSHA(),
+		headSHA:  pr.GetHead().GetSHA(),
+		action:   action,
+	})
+}
 
-		patchLines := len(strings.Split(patch, "\n"))
-		reviewComments = append(reviewComments, &git.ReviewComment{
-			Path:     file.Filename,
-			Body:     commentBody,
-			Position: patchLines - 1,
-		})
+// isReviewable reports whether a changed file has text changes worth reviewing
+func isReviewable(file *git.CommitFile) bool {
+	if file.Diff == nil {
+		return file.Status == diff.StatusModified || file.Status == diff.StatusAdded
 	}
-
-	// Create the review
-	body := "LGTM 👍"
-	if len(reviewComments) > 0 {
-		body = "Code review by ChatGPT"
+	if file.Diff.IsBinary {
+		logrus.Debugf("Skipping binary file %s", file.Filename)
+		return false
 	}
 
-	latestCommitSHA := commits[len(commits)-1].SHA
-	err = b.platform.CreateReview(ctx, owner, repoName, prNumber, latestCommitSHA, reviewComments, body)
-	if err != nil {
-		return fmt.Errorf("failed to create review: %w", err)
+	switch file.Diff.Status {
+	case diff.StatusAdded, diff.StatusModified:
+		return true
+	case diff.StatusRenamed, diff.StatusCopied:
+		// 只改了文件名时没有需要审查的内容
+		return len(file.Diff.Hunks) > 0
+	default:
+		return false
 	}
-
-	logrus.Infof("Successfully reviewed PR #%d in %s", prNumber, time.Since(start))
-	return nil
 }
 
 // filterFiles filters files based on include/ignore patterns
-func (b *Bot) filterFiles(files []*git.CommitFile) []*git.CommitFile {
+func filterFiles(cfg *config.Config, files []*git.CommitFile) []*git.CommitFile {
 	logrus.Debugf("Filtering %d files", len(files))
-	logrus.Debugf("Include patterns: %v", b.config.IncludePatterns)
-	logrus.Debugf("Ignore patterns: %v", b.config.IgnorePatterns)
-	logrus.Debugf("Ignore list: %v", b.config.IgnoreList)
+	logrus.Debugf("Include patterns: %v", cfg.IncludePatterns)
+	logrus.Debugf("Ignore patterns: %v", cfg.IgnorePatterns)
+	logrus.Debugf("Ignore list: %v", cfg.IgnoreList)
 
 	if len(files) == 0 {
 		logrus.Debug("No files to filter")
@@ -309,7 +162,7 @@ func (b *Bot) filterFiles(files []*git.CommitFile) []*git.CommitFile {
 
 		// Check ignore list
 		ignored := false
-		for _, ignoreItem := range b.config.IgnoreList {
+		for _, ignoreItem := range cfg.IgnoreList {
 			if ignoreItem == filename {
 				logrus.Debugf("File %s ignored by ignore list", filename)
 				ignored = true
@@ -332,8 +185,8 @@ func (b *Bot) filterFiles(files []*git.CommitFile) []*git.CommitFile {
 		logrus.Debugf("Parsed pathname: %s", pathname)
 
 		// Check include patterns
-		if len(b.config.IncludePatterns) > 0 {
-			included := matchPatterns(b.config.IncludePatterns, pathname)
+		if len(cfg.IncludePatterns) > 0 {
+			included := matchPatterns(cfg.IncludePatterns, pathname)
 			logrus.Debugf("File %s include pattern match: %v", filename, included)
 			if !included {
 				logrus.Debugf("File %s excluded by include patterns", filename)
@@ -342,8 +195,8 @@ func (b *Bot) filterFiles(files []*git.CommitFile) []*git.CommitFile {
 		}
 
 		// Check ignore patterns
-		if len(b.config.IgnorePatterns) > 0 {
-			ignored := matchPatterns(b.config.IgnorePatterns, pathname)
+		if len(cfg.IgnorePatterns) > 0 {
+			ignored := matchPatterns(cfg.IgnorePatterns, pathname)
 			logrus.Debugf("File %s ignore pattern match: %v", filename, ignored)
 			if ignored {
 				logrus.Debugf("File %s excluded by ignore patterns", filename)
@@ -397,3 +250,18 @@ func matchPatterns(patterns []string, path string) bool {
 func (b *Bot) GetIndexManager() *indexer.IndexManager {
 	return b.indexer
 }
+
+// approve approves a pull request on platforms that support approvals.
+// Failures are logged only, the review itself has already been posted.
+func (b *Bot) approve(ctx context.Context, pr *pullRequestInfo) {
+	approver, ok := pr.client.(git.PullRequestApprover)
+	if !ok {
+		logrus.Debugf("Platform %s does not support approvals", pr.platform)
+		return
+	}
+	if err := approver.ApprovePullRequest(ctx, pr.owner, pr.repo, pr.number); err != nil {
+		logrus.Warnf("Failed to approve PR #%d: %v", pr.number, err)
+		return
+	}
+	logrus.Infof("Approved PR #%d", pr.number)
+}
@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// fakePlatform is an in-memory git.Platform that records what the bot
+// posts. Comments it creates are written by the bot.
+type fakePlatform struct {
+	// files maps "ref:path" to the content of a file
+	files map[string]string
+	// changes maps "base...head" to the files changed between two commits
+	changes  map[string][]*git.CommitFile
+	comments []*git.PRComment
+	reviews  [][]*git.ReviewComment
+	threads  []*git.ReviewThread
+	resolved []string
+	// statuses records "state description targetURL" of each status set
+	statuses []string
+	// collaborators lists the users with write access
+	collaborators map[string]bool
+}
+
+func (p *fakePlatform) GetPullRequest(ctx context.Context, owner, repo string, number int) (*git.PullRequest, error) {
+	return &git.PullRequest{
+		Number:  number,
+		HTMLURL: fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repo, number),
+	}, nil
+}
+
+func (p *fakePlatform) GetPullRequestLabels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	return nil, nil
+}
+
+func (p *fakePlatform) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]*git.CommitFile, []*git.Commit, error) {
+	files, ok := p.changes[base+"..."+head]
+	if !ok {
+		return nil, nil, fmt.Errorf("compare %s...%s: %w", base, head, models.ErrNotFound)
+	}
+	return files, []*git.Commit{{SHA: head}}, nil
+}
+
+func (p *fakePlatform) CreateReview(ctx context.Context, owner, repo string, number int, commitID string, comments []*git.ReviewComment, body string) error {
+	p.reviews = append(p.reviews, comments)
+	return nil
+}
+
+func (p *fakePlatform) CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error {
+	p.comments = append(p.comments, &git.PRComment{ID: int64(len(p.comments) + 1), Body: body, Mine: true})
+	return nil
+}
+
+func (p *fakePlatform) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*git.PRComment, error) {
+	comments := make([]*git.PRComment, 0, len(p.comments))
+	for _, comment := range p.comments {
+		copied := *comment
+		comments = append(comments, &copied)
+	}
+	return comments, nil
+}
+
+func (p *fakePlatform) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	for _, comment := range p.comments {
+		if comment.ID == id {
+			comment.Body = body
+			return nil
+		}
+	}
+	return fmt.Errorf("comment %d: %w", id, models.ErrNotFound)
+}
+
+func (p *fakePlatform) GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error) {
+	return "", nil
+}
+
+func (p *fakePlatform) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	content, ok := p.files[ref+":"+path]
+	if !ok {
+		return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+	}
+	return []byte(content), nil
+}
+
+func (p *fakePlatform) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	p.statuses = append(p.statuses, state+" "+description+" "+targetURL)
+	return nil
+}
+
+func (p *fakePlatform) ListReviewThreads(ctx context.Context, owner, repo string, number int) ([]*git.ReviewThread, error) {
+	return p.threads, nil
+}
+
+func (p *fakePlatform) ResolveReviewThread(ctx context.Context, owner, repo string, number int, id string) error {
+	p.resolved = append(p.resolved, id)
+	return nil
+}
+
+func (p *fakePlatform) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error) {
+	return p.collaborators[user], nil
+}
+
+// newTestBot returns a bot and a pull request of octo/service on a fake
+// GitHub
+func newTestBot(platform *fakePlatform) (*Bot, *pullRequestInfo) {
+	cfg := &config.Config{Platform: "github", Language: "english"}
+	b := NewBot(cfg, platform, nil)
+	pr := &pullRequestInfo{
+		platform: "github",
+		client:   platform,
+		owner:    "octo",
+		repo:     "service",
+		number:   1,
+		baseRef:  "main",
+		baseSHA:  "base",
+		headSHA:  "head",
+	}
+	return b, pr
+}
+
+// bodies returns the bodies of the comments on the fake platform
+func (p *fakePlatform) bodies() string {
+	bodies := make([]string, 0, len(p.comments))
+	for _, comment := range p.comments {
+		bodies = append(bodies, comment.Body)
+	}
+	return strings.Join(bodies, "\n---\n")
+}
+
+func TestPlatformRouting(t *testing.T) {
+	github, gitlab := &fakePlatform{}, &fakePlatform{}
+	b := NewMultiPlatformBot(&config.Config{Platform: "github"}, map[string]git.Platform{"github": github, "gitlab": gitlab}, nil)
+
+	tests := []struct {
+		name    string
+		want    git.Platform
+		wantErr bool
+	}{
+		{name: "github", want: github},
+		{name: "gitlab", want: gitlab},
+		{name: "gitea", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := b.platform(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("platform(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("platform(%q) returned the client of another platform", tt.name)
+		}
+	}
+
+	// 未配置平台的事件不会被审查
+	if err := b.handlePullRequest(context.Background(), &pullRequestInfo{platform: "gitea", owner: "o", repo: "r", number: 1}); err == nil {
+		t.Error("handlePullRequest() for an unconfigured platform error = nil")
+	}
+}
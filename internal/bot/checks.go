@@ -0,0 +1,232 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	githubplatform "github.com/eust-w/ai_code_reviewer/internal/git/github"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
+	"github.com/google/go-github/v60/github"
+	"github.com/sirupsen/logrus"
+)
+
+// checkRun is the check run of a review. A nil *checkRun is valid and does
+// nothing, so reviews run the same with and without check runs.
+type checkRun struct {
+	reporter git.CheckRunReporter
+	owner    string
+	repo     string
+	id       int64
+	english  bool
+	// done is set once the check run has been completed
+	done bool
+}
+
+// startCheckRun creates an in-progress check run for the head commit of a
+// pull request when GITHUB_CHECK_RUNS is enabled. It returns nil when check
+// runs are disabled or could not be created; the review is posted anyway.
+func (b *Bot) startCheckRun(ctx context.Context, pr *pullRequestInfo, english bool) *checkRun {
+	if !b.config.GithubCheckRuns {
+		return nil
+	}
+	reporter, ok := pr.client.(git.CheckRunReporter)
+	if !ok {
+		logrus.Debugf("Platform %s does not support check runs", pr.platform)
+		return nil
+	}
+
+	id, err := reporter.StartCheckRun(ctx, pr.owner, pr.repo, pr.headSHA)
+	if err != nil {
+		logrus.Warnf("Failed to start check run for PR #%d: %v", pr.number, err)
+		return nil
+	}
+	return &checkRun{reporter: reporter, owner: pr.owner, repo: pr.repo, id: id, english: english}
+}
+
+// complete completes the check run with the result of a review
+func (c *checkRun) complete(ctx context.Context, result *git.CheckRunResult) {
+	if c == nil || c.done {
+		return
+	}
+	c.done = true
+	if err := c.reporter.CompleteCheckRun(ctx, c.owner, c.repo, c.id, result); err != nil {
+		logrus.Warnf("Failed to complete check run %d: %v", c.id, err)
+	}
+}
+
+// abort completes a check run the review did not complete, so that it is
+// not left in progress when the review fails or is superseded
+func (c *checkRun) abort(ctx context.Context, err error) {
+	if c == nil || c.done {
+		return
+	}
+	result := &git.CheckRunResult{Conclusion: git.CheckConclusionNeutral}
+	if errors.Is(err, queue.ErrSuperseded) {
+		result.Conclusion = git.CheckConclusionCancelled
+		if c.english {
+			result.Title = "Review superseded"
+			result.Summary = "A newer commit was pushed, it is reviewed instead."
+		} else {
+			result.Title = "审查已取消"
+			result.Summary = "PR 有新的提交，将审查最新的提交。"
+		}
+		c.complete(ctx, result)
+		return
+	}
+	if c.english {
+		result.Title = "Review failed"
+		result.Summary = "The review could not be completed."
+	} else {
+		result.Title = "审查失败"
+		result.Summary = "未能完成审查。"
+	}
+	if err != nil {
+		result.Summary += fmt.Sprintf("\n\n```\n%v\n```", err)
+	}
+	c.complete(ctx, result)
+}
+
+// checkConclusion derives the check run conclusion of a review: neutral when
+// files could not be reviewed, success when every file is LGTM, failure when
+// the model reported risks and neutral for suggestions only
+func checkConclusion(incomplete int, reviews []*fileReview) string {
+	if incomplete > 0 {
+		return git.CheckConclusionNeutral
+	}
+	if allLGTM(reviews) {
+		return git.CheckConclusionSuccess
+	}
+	for _, review := range reviews {
+		if strings.TrimSpace(review.result.Risks) != "" {
+			return git.CheckConclusionFailure
+		}
+	}
+	return git.CheckConclusionNeutral
+}
+
+// checkRunResult builds the check run result of a finished review
+func checkRunResult(english bool, incomplete int, reviews []*fileReview, comments []*git.ReviewComment, summary string) *git.CheckRunResult {
+	result := &git.CheckRunResult{
+		Conclusion:  checkConclusion(incomplete, reviews),
+		Summary:     summary,
+		Annotations: checkAnnotations(comments, reviews),
+	}
+
+	titles := map[string][2]string{
+		"empty":                    {"No files to review", "没有需要审查的文件"},
+		"incomplete":               {"Review incomplete", "审查未完成"},
+		git.CheckConclusionSuccess: {"LGTM", "LGTM"},
+		git.CheckConclusionFailure: {"Risks found", "发现风险"},
+		git.CheckConclusionNeutral: {"Suggestions found", "有改进建议"},
+	}
+	key := result.Conclusion
+	switch {
+	case len(reviews) == 0:
+		key = "empty"
+	case incomplete > 0:
+		key = "incomplete"
+	}
+	if english {
+		result.Title = titles[key][0]
+	} else {
+		result.Title = titles[key][1]
+	}
+	return result
+}
+
+// checkAnnotations turns the inline comments and the findings outside the
+// diff into annotations. Annotations can only point at lines of the new
+// version of a file, so findings on deleted lines are left out.
+func checkAnnotations(comments []*git.ReviewComment, reviews []*fileReview) []*git.CheckAnnotation {
+	annotations := make([]*git.CheckAnnotation, 0, len(comments))
+	for _, comment := range comments {
+		if comment.Side == "LEFT" || comment.Line <= 0 {
+			continue
+		}
+		start := comment.Line
+		if comment.StartLine > 0 && comment.StartLine < comment.Line {
+			start = comment.StartLine
+		}
+		annotations = append(annotations, &git.CheckAnnotation{
+			Path:      comment.Path,
+			StartLine: start,
+			EndLine:   comment.Line,
+			Message:   stripFingerprint(comment.Body),
+		})
+	}
+
+	// 差异之外的问题同样可以作为注释显示在文件中
+	for _, review := range reviews {
+		for _, finding := range review.unanchored {
+			if finding.Line <= 0 || finding.DiffSide() != diff.SideRight {
+				continue
+			}
+			end := finding.Line
+			if finding.EndLine > finding.Line {
+				end = finding.EndLine
+			}
+			annotations = append(annotations, &git.CheckAnnotation{
+				Path:      review.path,
+				StartLine: finding.Line,
+				EndLine:   end,
+				Message:   finding.Body,
+			})
+		}
+	}
+	return annotations
+}
+
+// HandleGitHubCheckRun handles GitHub check run events. Rerun requests of
+// the review check run trigger a fresh review of the pull request.
+func (b *Bot) HandleGitHubCheckRun(ctx context.Context, event *github.CheckRunEvent) error {
+	run := event.GetCheckRun()
+	if event.GetAction() != "rerequested" || run.GetName() != githubplatform.CheckRunName {
+		logrus.Debugf("Skipping GitHub check run event: action=%s, name=%s", event.GetAction(), run.GetName())
+		return nil
+	}
+
+	client, err := b.platform("github")
+	if err != nil {
+		return err
+	}
+	ctx = githubplatform.WithInstallationID(ctx, event.GetInstallation().GetID())
+	owner := event.GetRepo().GetOwner().GetLogin()
+	repoName := event.GetRepo().GetName()
+
+	for _, ref := range run.PullRequests {
+		// 重新获取 PR，确保使用最新的基准提交
+		pr, err := client.GetPullRequest(ctx, owner, repoName, ref.GetNumber())
+		if err != nil {
+			return fmt.Errorf("failed to get PR #%d: %w", ref.GetNumber(), err)
+		}
+		if pr.State == "closed" || pr.Locked {
+			logrus.Infof("PR #%d is closed or locked, skipping rerun", pr.Number)
+			continue
+		}
+		if pr.Head.SHA != run.GetHeadSHA() {
+			logrus.Infof("Check run %d is for an outdated commit of PR #%d, skipping rerun", run.GetID(), pr.Number)
+			continue
+		}
+
+		logrus.Infof("Rerunning review of PR #%d", pr.Number)
+		err = b.handlePullRequest(ctx, &pullRequestInfo{
+			platform: "github",
+			owner:    owner,
+			repo:     repoName,
+			number:   pr.Number,
+			baseRef:  ref.GetBase().GetRef(),
+			baseSHA:  pr.Base.SHA,
+			headSHA:  pr.Head.SHA,
+			// 重新运行时审查整个 PR
+			action: "opened",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+)
+
+func TestCheckRunResult(t *testing.T) {
+	lgtm := &fileReview{path: "a.go", result: chat.ReviewResult{LGTM: true}}
+	suggestion := &fileReview{path: "b.go", result: chat.ReviewResult{Suggestions: "Rename x"}}
+	risk := &fileReview{path: "c.go", result: chat.ReviewResult{Risks: "Nil dereference"}}
+	outside := &fileReview{path: "d.go", result: chat.ReviewResult{LGTM: true}, unanchored: []chat.ReviewFinding{{Line: 9, Body: "x"}}}
+
+	tests := []struct {
+		name           string
+		incomplete     int
+		reviews        []*fileReview
+		wantConclusion string
+		wantTitle      string
+	}{
+		{name: "lgtm", reviews: []*fileReview{lgtm}, wantConclusion: git.CheckConclusionSuccess, wantTitle: "LGTM"},
+		{name: "suggestions", reviews: []*fileReview{lgtm, suggestion}, wantConclusion: git.CheckConclusionNeutral, wantTitle: "Suggestions found"},
+		{name: "risks", reviews: []*fileReview{suggestion, risk}, wantConclusion: git.CheckConclusionFailure, wantTitle: "Risks found"},
+		{name: "findings outside the diff", reviews: []*fileReview{outside}, wantConclusion: git.CheckConclusionNeutral, wantTitle: "Suggestions found"},
+		{name: "incomplete", incomplete: 1, reviews: []*fileReview{risk}, wantConclusion: git.CheckConclusionNeutral, wantTitle: "Review incomplete"},
+		{name: "empty", wantConclusion: git.CheckConclusionNeutral, wantTitle: "No files to review"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checkRunResult(true, tt.incomplete, tt.reviews, nil, "summary")
+			if result.Conclusion != tt.wantConclusion || result.Title != tt.wantTitle {
+				t.Errorf("checkRunResult() = %s %q, want %s %q", result.Conclusion, result.Title, tt.wantConclusion, tt.wantTitle)
+			}
+			if result.Summary != "summary" {
+				t.Errorf("Summary = %q", result.Summary)
+			}
+		})
+	}
+
+	if result := checkRunResult(false, 0, []*fileReview{risk}, nil, ""); result.Title != "发现风险" {
+		t.Errorf("checkRunResult() in Chinese title = %q", result.Title)
+	}
+}
+
+func TestCheckAnnotations(t *testing.T) {
+	comments := []*git.ReviewComment{
+		{Path: "a.go", Line: 3, Side: "RIGHT", Body: withFingerprint(chat.ReviewFinding{Body: "single"}, "0123456789abcdef")},
+		{Path: "a.go", StartLine: 5, Line: 7, Side: "RIGHT", Body: "range"},
+		{Path: "a.go", Line: 4, Side: "LEFT", Body: "deleted"},
+	}
+	reviews := []*fileReview{{path: "b.go", unanchored: []chat.ReviewFinding{
+		{Line: 10, EndLine: 12, Body: "outside"},
+		{Line: 20, Side: "old", Body: "deleted outside"},
+		{Body: "no line"},
+	}}}
+
+	want := []git.CheckAnnotation{
+		{Path: "a.go", StartLine: 3, EndLine: 3, Message: "single"},
+		{Path: "a.go", StartLine: 5, EndLine: 7, Message: "range"},
+		{Path: "b.go", StartLine: 10, EndLine: 12, Message: "outside"},
+	}
+	got := checkAnnotations(comments, reviews)
+	if len(got) != len(want) {
+		t.Fatalf("checkAnnotations() returned %d annotations, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if *got[i] != want[i] {
+			t.Errorf("annotation %d = %+v, want %+v", i, *got[i], want[i])
+		}
+	}
+}
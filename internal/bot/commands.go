@@ -0,0 +1,462 @@
+package bot
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	githubplatform "github.com/eust-w/ai_code_reviewer/internal/git/github"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
+	"github.com/google/go-github/v60/github"
+	"github.com/sirupsen/logrus"
+	"github.com/xanzy/go-gitlab"
+)
+
+// commandPrefix starts the lines of pull request comments that hold a
+// command for the bot, e.g. "/ai review"
+const commandPrefix = "/ai"
+
+// commandPlatforms are the platforms whose comment events are handled
+var commandPlatforms = map[string]bool{"github": true, "gitlab": true}
+
+// maxExplainLines bounds the snippet sent to the models by "/ai explain"
+const maxExplainLines = 200
+
+// errFileNotReviewable is returned by the review of a single file that the
+// pull request does not change or that the configuration excludes
+var errFileNotReviewable = errors.New("file is not changed by the pull request or is excluded from reviews")
+
+// selectFile returns the changed file with the given path, if the pull
+// request changes it
+func selectFile(files []*git.CommitFile, path string) []*git.CommitFile {
+	for _, file := range files {
+		if file.Filename == path {
+			return []*git.CommitFile{file}
+		}
+	}
+	return nil
+}
+
+// command is a command found in a pull request comment
+type command struct {
+	// line is the line of the comment that holds the command
+	line string
+	name string
+	args []string
+}
+
+// parseCommand returns the first command of a comment. Commands are lines
+// starting with "/ai"; quoted lines and code blocks are skipped, so replies
+// quoting a command do not run it again.
+func parseCommand(body string) (*command, bool) {
+	inCode := false
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		fields := strings.Fields(line)
+		if inCode || len(fields) == 0 || fields[0] != commandPrefix {
+			continue
+		}
+		if len(fields) == 1 {
+			return &command{line: line, name: "help"}, true
+		}
+		return &command{line: line, name: strings.ToLower(fields[1]), args: fields[2:]}, true
+	}
+	return nil, false
+}
+
+// HasCommand reports whether a pull request comment holds a command, so
+// that webhooks only queue the comments the bot acts on
+func HasCommand(body string) bool {
+	_, ok := parseCommand(body)
+	return ok
+}
+
+// commentCommand is a pull request comment that may hold a command
+type commentCommand struct {
+	pr     *pullRequestInfo
+	author string
+	body   string
+	// path, startLine and line locate the inline comment the command was
+	// posted on; they are empty for comments on the pull request itself
+	path      string
+	startLine int
+	line      int
+}
+
+// HandleGitHubIssueComment handles GitHub issue comment events. Comments on
+// pull requests may hold commands.
+func (b *Bot) HandleGitHubIssueComment(ctx context.Context, event *github.IssueCommentEvent) error {
+	comment := event.GetComment()
+	if event.GetAction() != "created" || event.GetIssue().GetPullRequestLinks() == nil {
+		logrus.Debugf("Skipping GitHub issue comment event: action=%s", event.GetAction())
+		return nil
+	}
+	if comment.GetUser().GetType() == "Bot" {
+		return nil
+	}
+
+	ctx = githubplatform.WithInstallationID(ctx, event.GetInstallation().GetID())
+	return b.handleCommentCommand(ctx, &commentCommand{
+		pr: &pullRequestInfo{
+			platform: "github",
+			owner:    event.GetRepo().GetOwner().GetLogin(),
+			repo:     event.GetRepo().GetName(),
+			number:   event.GetIssue().GetNumber(),
+		},
+		author: comment.GetUser().GetLogin(),
+		body:   comment.GetBody(),
+	})
+}
+
+// HandleGitHubReviewComment handles GitHub pull request review comment
+// events, i.e. inline comments and replies to them, which may hold commands
+func (b *Bot) HandleGitHubReviewComment(ctx context.Context, event *github.PullRequestReviewCommentEvent) error {
+	comment := event.GetComment()
+	if event.GetAction() != "created" {
+		logrus.Debugf("Skipping GitHub review comment event: action=%s", event.GetAction())
+		return nil
+	}
+	if comment.GetUser().GetType() == "Bot" {
+		return nil
+	}
+
+	// 过时的评论没有当前行号
+	line := comment.GetLine()
+	if line == 0 {
+		line = comment.GetOriginalLine()
+	}
+	pr := event.GetPullRequest()
+	ctx = githubplatform.WithInstallationID(ctx, event.GetInstallation().GetID())
+	return b.handleCommentCommand(ctx, &commentCommand{
+		pr: &pullRequestInfo{
+			platform: "github",
+			owner:    event.GetRepo().GetOwner().GetLogin(),
+			repo:     event.GetRepo().GetName(),
+			number:   pr.GetNumber(),
+			baseRef:  pr.GetBase().GetRef(),
+		},
+		author:    comment.GetUser().GetLogin(),
+		body:      comment.GetBody(),
+		path:      comment.GetPath(),
+		startLine: comment.GetStartLine(),
+		line:      line,
+	})
+}
+
+// HandleGitLabNote handles GitLab note events. Notes on merge requests,
+// including replies in discussions on the diff, may hold commands.
+func (b *Bot) HandleGitLabNote(ctx context.Context, event *gitlab.MergeCommentEvent) error {
+	note := event.ObjectAttributes
+	if note.NoteableType != "MergeRequest" || note.System || event.User == nil {
+		logrus.Debugf("Skipping GitLab note event: noteable_type=%s", note.NoteableType)
+		return nil
+	}
+
+	c := &commentCommand{
+		pr: &pullRequestInfo{
+			platform: "gitlab",
+			owner:    event.Project.Namespace,
+			repo:     event.Project.Name,
+			number:   event.MergeRequest.IID,
+			baseRef:  event.MergeRequest.TargetBranch,
+		},
+		author: event.User.Username,
+		body:   note.Note,
+	}
+	if position := note.Position; position != nil {
+		c.path, c.line = position.NewPath, position.NewLine
+		if c.line == 0 {
+			c.path, c.line = position.OldPath, position.OldLine
+		}
+	}
+	return b.handleCommentCommand(ctx, c)
+}
+
+// handleCommentCommand runs the command of a pull request comment, if it has
+// one. Commands can start paid reviews, so only collaborators with write
+// access may run them.
+func (b *Bot) handleCommentCommand(ctx context.Context, c *commentCommand) (err error) {
+	cmd, ok := parseCommand(c.body)
+	if !ok {
+		return nil
+	}
+	pr := c.pr
+	if pr.client, err = b.platform(pr.platform); err != nil {
+		return err
+	}
+
+	allowed, err := b.isCollaborator(ctx, pr, c.author)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		logrus.Infof("%s is not a collaborator of %s/%s, ignoring %q on PR #%d", c.author, pr.owner, pr.repo, cmd.line, pr.number)
+		body := fmt.Sprintf("只有拥有写权限的协作者可以使用 `%s` 命令。", commandPrefix)
+		if strings.ToLower(b.config.Language) == "english" {
+			body = fmt.Sprintf("Only collaborators with write access can run `%s` commands.", commandPrefix)
+		}
+		return b.reply(ctx, pr, cmd, body)
+	}
+
+	// 事件中的提交可能已过时，以 PR 的当前状态为准
+	current, err := pr.client.GetPullRequest(ctx, pr.owner, pr.repo, pr.number)
+	if err != nil {
+		return fmt.Errorf("failed to get PR #%d: %w", pr.number, err)
+	}
+	if current.State == "closed" || current.State == "merged" {
+		logrus.Infof("PR #%d is %s, ignoring %q", pr.number, current.State, cmd.line)
+		return nil
+	}
+	pr.baseSHA, pr.headSHA = current.Base.SHA, current.Head.SHA
+	logrus.Infof("Running %q of %s on PR #%d", cmd.line, c.author, pr.number)
+
+	if cmd.name == "review" {
+		// 推送新提交时像 webhook 审查一样取消本次审查
+		queue.ReviewRevision(ctx, pr.headSHA)
+		return b.reviewCommand(ctx, pr, cmd)
+	}
+
+	cfg, enabled := b.loadRepoConfig(ctx, pr)
+	if !enabled {
+		logrus.Infof("Reviews are disabled by %s in %s/%s, ignoring %q", config.RepoConfigFile, pr.owner, pr.repo, cmd.line)
+		return nil
+	}
+	english := strings.ToLower(cfg.Language) == "english"
+	switch cmd.name {
+	case "explain":
+		return b.explainCommand(ctx, pr, cfg, c, cmd)
+	case "ignore":
+		return b.ignoreCommand(ctx, pr, english, cmd)
+	default:
+		return b.reply(ctx, pr, cmd, commandHelp(english))
+	}
+}
+
+// isCollaborator reports whether a user has write access to the repository
+// of a pull request. Platforms that cannot tell are refused.
+func (b *Bot) isCollaborator(ctx context.Context, pr *pullRequestInfo, user string) (bool, error) {
+	checker, ok := pr.client.(git.CollaboratorChecker)
+	if !ok || user == "" {
+		return false, nil
+	}
+	allowed, err := checker.IsCollaborator(ctx, pr.owner, pr.repo, user)
+	if err != nil {
+		return false, fmt.Errorf("failed to check permission of %s: %w", user, err)
+	}
+	return allowed, nil
+}
+
+// reply answers a command with a comment on the pull request that quotes it
+func (b *Bot) reply(ctx context.Context, pr *pullRequestInfo, cmd *command, body string) error {
+	return pr.client.CreatePRComment(ctx, pr.owner, pr.repo, pr.number, fmt.Sprintf("> %s\n\n%s", cmd.line, body))
+}
+
+// reviewCommand reviews the whole pull request again for "/ai review", or a
+// single file of it for "/ai review <path>"
+func (b *Bot) reviewCommand(ctx context.Context, pr *pullRequestInfo, cmd *command) error {
+	// 与重新运行检查一样审查整个 PR，而不只是最新的提交
+	pr.action = "opened"
+	if len(cmd.args) > 0 {
+		pr.file = strings.TrimPrefix(cmd.args[0], "/")
+	}
+
+	err := b.handlePullRequest(ctx, pr)
+	if errors.Is(err, errFileNotReviewable) {
+		body := fmt.Sprintf("PR 没有修改 `%s`，或该文件被配置排除在审查之外。", pr.file)
+		if strings.ToLower(b.config.Language) == "english" {
+			body = fmt.Sprintf("`%s` is not changed by this pull request or is excluded from reviews.", pr.file)
+		}
+		return b.reply(ctx, pr, cmd, body)
+	}
+	return err
+}
+
+// explainCommand explains lines of a file at the head of the pull request
+// for "/ai explain <path>:<line>[-<end>] [question]". On an inline comment
+// the location defaults to the lines of the comment.
+func (b *Bot) explainCommand(ctx context.Context, pr *pullRequestInfo, cfg *config.Config, c *commentCommand, cmd *command) error {
+	english := strings.ToLower(cfg.Language) == "english"
+
+	var path string
+	var start, end int
+	var ok bool
+	args := cmd.args
+	if len(args) > 0 {
+		path, start, end, ok = parseLocation(args[0])
+		if ok {
+			args = args[1:]
+		}
+	}
+	if !ok && c.path != "" && c.line > 0 {
+		path, start, end, ok = c.path, c.line, c.line, true
+		if c.startLine > 0 && c.startLine < c.line {
+			start = c.startLine
+		}
+	}
+	if !ok {
+		return b.reply(ctx, pr, cmd, commandHelp(english))
+	}
+
+	content, err := pr.client.GetFileContent(ctx, pr.owner, pr.repo, path, pr.headSHA)
+	if errors.Is(err, models.ErrNotFound) {
+		body := fmt.Sprintf("PR 的最新提交中没有文件 `%s`。", path)
+		if english {
+			body = fmt.Sprintf("`%s` does not exist at the head of this pull request.", path)
+		}
+		return b.reply(ctx, pr, cmd, body)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %w", path, err)
+	}
+
+	snippet, ok := snippetLines(string(content), start, end)
+	if !ok {
+		body := fmt.Sprintf("`%s` 没有第 %d 行。", path, start)
+		if english {
+			body = fmt.Sprintf("`%s` has no line %d.", path, start)
+		}
+		return b.reply(ctx, pr, cmd, body)
+	}
+
+	explanation, err := b.chat.WithConfig(cfg).Explain(ctx, path, snippet, strings.Join(args, " "))
+	if err != nil {
+		return err
+	}
+	return b.reply(ctx, pr, cmd, explanation)
+}
+
+// parseLocation parses a location of the form <path>:<line>[-<end>]
+func parseLocation(location string) (path string, start, end int, ok bool) {
+	i := strings.LastIndex(location, ":")
+	if i <= 0 {
+		return "", 0, 0, false
+	}
+	path, lines := strings.TrimPrefix(location[:i], "/"), location[i+1:]
+
+	first, last, isRange := strings.Cut(lines, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start <= 0 {
+		return "", 0, 0, false
+	}
+	end = start
+	if isRange {
+		if end, err = strconv.Atoi(last); err != nil || end < start {
+			return "", 0, 0, false
+		}
+	}
+	return path, start, end, true
+}
+
+// snippetLines returns lines start to end of a file, at most
+// maxExplainLines of them
+func snippetLines(content string, start, end int) (string, bool) {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if start > len(lines) {
+		return "", false
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	if end-start+1 > maxExplainLines {
+		end = start + maxExplainLines - 1
+	}
+	return strings.Join(lines[start-1:end], "\n"), true
+}
+
+// ignoreCommand suppresses findings for "/ai ignore <fingerprint>...". The
+// fingerprints are kept in the summary comment, later reviews no longer
+// post the findings, and their open threads are resolved.
+func (b *Bot) ignoreCommand(ctx context.Context, pr *pullRequestInfo, english bool, cmd *command) error {
+	var fingerprints []string
+	for _, arg := range cmd.args {
+		if _, err := hex.DecodeString(arg); err != nil || len(arg) != 16 {
+			return b.reply(ctx, pr, cmd, commandHelp(english))
+		}
+		fingerprints = append(fingerprints, strings.ToLower(arg))
+	}
+	if len(fingerprints) == 0 {
+		return b.reply(ctx, pr, cmd, commandHelp(english))
+	}
+
+	summary := findSummaryComment(ctx, pr)
+	if summary == nil {
+		body := "PR 还没有审查结果，没有可以忽略的问题。"
+		if english {
+			body = "This pull request has not been reviewed yet, there are no findings to ignore."
+		}
+		return b.reply(ctx, pr, cmd, body)
+	}
+
+	ignored := ignoredFindings(summary.Body)
+	suppressed := make(map[string]bool, len(ignored)+len(fingerprints))
+	for _, fingerprint := range ignored {
+		suppressed[fingerprint] = true
+	}
+	for _, fingerprint := range fingerprints {
+		if !suppressed[fingerprint] {
+			suppressed[fingerprint] = true
+			ignored = append(ignored, fingerprint)
+		}
+	}
+	body := withIgnoredFindings(summary.Body, ignored)
+	if err := pr.client.UpdatePRComment(ctx, pr.owner, pr.repo, pr.number, summary.ID, body); err != nil {
+		return fmt.Errorf("failed to update summary comment %d: %w", summary.ID, err)
+	}
+
+	b.resolveIgnoredThreads(ctx, pr, fingerprints)
+	reply := fmt.Sprintf("后续审查将不再报告 %d 个问题。", len(fingerprints))
+	if english {
+		reply = fmt.Sprintf("%d finding(s) will no longer be reported.", len(fingerprints))
+	}
+	return b.reply(ctx, pr, cmd, reply)
+}
+
+// resolveIgnoredThreads resolves the open threads of the bot on ignored
+// findings. Failures are logged only, the findings are ignored anyway.
+func (b *Bot) resolveIgnoredThreads(ctx context.Context, pr *pullRequestInfo, fingerprints []string) {
+	resolver, ok := pr.client.(git.ReviewThreadResolver)
+	if !ok {
+		return
+	}
+	threads, err := resolver.ListReviewThreads(ctx, pr.owner, pr.repo, pr.number)
+	if err != nil {
+		logrus.Warnf("Failed to list review threads of PR #%d: %v", pr.number, err)
+		return
+	}
+	for _, thread := range threads {
+		fingerprint := commentFingerprint(thread.Body)
+		if thread.Resolved || fingerprint == "" || !slices.Contains(fingerprints, fingerprint) {
+			continue
+		}
+		if err := resolver.ResolveReviewThread(ctx, pr.owner, pr.repo, pr.number, thread.ID); err != nil {
+			logrus.Warnf("Failed to resolve review thread %s of PR #%d: %v", thread.ID, pr.number, err)
+		}
+	}
+}
+
+// commandHelp lists the commands
+func commandHelp(english bool) string {
+	if english {
+		return "Available commands:\n\n" +
+			"- `/ai review`: review the whole pull request again\n" +
+			"- `/ai review <path>`: review one file of the pull request again\n" +
+			"- `/ai explain <path>:<line>[-<end>] [question]`: explain lines of a file; on an inline comment the location can be left out\n" +
+			"- `/ai ignore <fingerprint>`: stop reporting a finding; the command is shown below each inline comment"
+	}
+	return "可用的命令：\n\n" +
+		"- `/ai review`：重新审查整个 PR\n" +
+		"- `/ai review <path>`：重新审查 PR 中的一个文件\n" +
+		"- `/ai explain <path>:<line>[-<end>] [问题]`：解释文件中的代码；在行内评论中可以省略位置\n" +
+		"- `/ai ignore <fingerprint>`：不再报告某个问题，命令显示在每条行内评论下方"
+}
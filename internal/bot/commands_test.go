@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want *command
+	}{
+		{name: "help", body: "/ai", want: &command{line: "/ai", name: "help"}},
+		{name: "arguments", body: "Thanks!\n  /ai Explain main.go:3 why?  \n/ai review", want: &command{line: "/ai Explain main.go:3 why?", name: "explain", args: []string{"main.go:3", "why?"}}},
+		{name: "quoted", body: "> /ai review\n\nDone"},
+		{name: "code block", body: "Run\n```\n/ai review\n```\n"},
+		{name: "after code block", body: "```\n/ai ignore x\n```\n/ai review a.go", want: &command{line: "/ai review a.go", name: "review", args: []string{"a.go"}}},
+		{name: "inside text", body: "Please run /ai review"},
+		{name: "other prefix", body: "/aide review"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseCommand(tt.body)
+			if ok != (tt.want != nil) || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCommand() = %+v, %v, want %+v", got, ok, tt.want)
+			}
+			if HasCommand(tt.body) != ok {
+				t.Errorf("HasCommand() = %v, want %v", !ok, ok)
+			}
+		})
+	}
+}
+
+func TestParseLocation(t *testing.T) {
+	tests := []struct {
+		location  string
+		wantPath  string
+		wantStart int
+		wantEnd   int
+		wantOK    bool
+	}{
+		{"main.go:3", "main.go", 3, 3, true},
+		{"/cmd/main.go:3-10", "cmd/main.go", 3, 10, true},
+		{"C:/src/main.go:7", "C:/src/main.go", 7, 7, true},
+		{"main.go", "", 0, 0, false},
+		{":3", "", 0, 0, false},
+		{"main.go:0", "", 0, 0, false},
+		{"main.go:5-3", "", 0, 0, false},
+		{"main.go:3-x", "", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		path, start, end, ok := parseLocation(tt.location)
+		if path != tt.wantPath || start != tt.wantStart || end != tt.wantEnd || ok != tt.wantOK {
+			t.Errorf("parseLocation(%q) = %q, %d, %d, %v, want %q, %d, %d, %v",
+				tt.location, path, start, end, ok, tt.wantPath, tt.wantStart, tt.wantEnd, tt.wantOK)
+		}
+	}
+}
+
+func TestSnippetLines(t *testing.T) {
+	long := strings.Repeat("x\n", maxExplainLines+50)
+
+	tests := []struct {
+		name       string
+		content    string
+		start, end int
+		want       string
+		wantOK     bool
+	}{
+		{name: "range", content: "a\nb\nc\n", start: 2, end: 3, want: "b\nc", wantOK: true},
+		{name: "past the end", content: "a\nb\nc\n", start: 3, end: 9, want: "c", wantOK: true},
+		{name: "no such line", content: "a\nb\nc\n", start: 4, end: 4},
+		{name: "too long", content: long, start: 1, end: maxExplainLines + 50, want: strings.TrimSuffix(strings.Repeat("x\n", maxExplainLines), "\n"), wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := snippetLines(tt.content, tt.start, tt.end)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("snippetLines() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestIgnoredFindings(t *testing.T) {
+	body := summaryMarker + "\n" + "## LGTM"
+	if got := ignoredFindings(body); got != nil {
+		t.Fatalf("ignoredFindings() = %v, want none", got)
+	}
+
+	body = withIgnoredFindings(body, []string{"0123456789abcdef", "fedcba9876543210"})
+	if !strings.HasPrefix(body, summaryMarker+"\n<!-- ai-code-review:ignored 0123456789abcdef,fedcba9876543210 -->\n") {
+		t.Errorf("withIgnoredFindings() = %q, want the list after the summary marker", body)
+	}
+	if got := ignoredFindings(body); !reflect.DeepEqual(got, []string{"0123456789abcdef", "fedcba9876543210"}) {
+		t.Errorf("ignoredFindings() = %v", got)
+	}
+
+	// 替换列表而不是追加
+	body = withIgnoredFindings(body, []string{"0000000000000000"})
+	if got := ignoredFindings(body); !reflect.DeepEqual(got, []string{"0000000000000000"}) {
+		t.Errorf("ignoredFindings() after replacing = %v", got)
+	}
+	if body = withIgnoredFindings(body, nil); body != summaryMarker+"\n## LGTM" {
+		t.Errorf("withIgnoredFindings() without fingerprints = %q", body)
+	}
+}
+
+func TestHandleCommentCommand(t *testing.T) {
+	const fingerprint = "0123456789abcdef"
+
+	tests := []struct {
+		name         string
+		author       string
+		body         string
+		wantReply    string
+		wantIgnored  bool
+		wantResolved bool
+	}{
+		{name: "no command", author: "alice", body: "Looks good"},
+		{name: "not a collaborator", author: "mallory", body: "/ai ignore " + fingerprint, wantReply: "Only collaborators with write access"},
+		{name: "unknown user", body: "/ai ignore " + fingerprint, wantReply: "Only collaborators with write access"},
+		{name: "collaborator", author: "alice", body: "/ai ignore " + fingerprint, wantReply: "1 finding(s) will no longer be reported", wantIgnored: true, wantResolved: true},
+		{name: "invalid fingerprint", author: "alice", body: "/ai ignore xyz", wantReply: "Available commands"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := &fakePlatform{
+				comments:      []*git.PRComment{{ID: 1, Body: summaryMarker + "\n## Suggestions", Mine: true}},
+				threads:       []*git.ReviewThread{{ID: "T1", Body: withFingerprint(chat.ReviewFinding{Body: "x"}, fingerprint)}},
+				collaborators: map[string]bool{"alice": true},
+			}
+			b, pr := newTestBot(platform)
+
+			if err := b.handleCommentCommand(context.Background(), &commentCommand{pr: pr, author: tt.author, body: tt.body}); err != nil {
+				t.Fatalf("handleCommentCommand() error = %v", err)
+			}
+
+			replies := platform.comments[1:]
+			if tt.wantReply == "" && len(replies) != 0 {
+				t.Errorf("comments:\n%s\nwant no reply", platform.bodies())
+			}
+			if tt.wantReply != "" && (len(replies) != 1 || !strings.HasPrefix(replies[0].Body, "> "+tt.body+"\n\n") || !strings.Contains(replies[0].Body, tt.wantReply)) {
+				t.Errorf("comments:\n%s\nwant a reply with %q", platform.bodies(), tt.wantReply)
+			}
+			if ignored := len(ignoredFindings(platform.comments[0].Body)) > 0; ignored != tt.wantIgnored {
+				t.Errorf("summary = %q, want ignored %v", platform.comments[0].Body, tt.wantIgnored)
+			}
+			if resolved := len(platform.resolved) > 0; resolved != tt.wantResolved {
+				t.Errorf("resolved = %v, want %v", platform.resolved, tt.wantResolved)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/sirupsen/logrus"
+)
+
+// anchorFindings maps the line-level findings of a file review onto the
+// file's diff hunks. Findings that can be anchored become inline review
+// comments; the rest are returned so they can be shown in the summary.
+func anchorFindings(file *git.CommitFile, findings []chat.ReviewFinding) ([]*git.ReviewComment, []chat.ReviewFinding) {
+	if len(findings) == 0 {
+		return nil, nil
+	}
+
+	var hunks []*diff.Hunk
+	if file.Diff != nil {
+		hunks = file.Diff.Hunks
+	} else {
+		var err error
+		hunks, err = diff.ParseHunks(file.Patch)
+		if err != nil {
+			logrus.Warnf("Failed to parse hunks of %s: %v, moving findings to summary", file.Filename, err)
+			return nil, findings
+		}
+	}
+
+	comments := make([]*git.ReviewComment, 0, len(findings))
+	unanchored := make([]chat.ReviewFinding, 0)
+
+	for _, finding := range findings {
+		if strings.TrimSpace(finding.Body) == "" {
+			continue
+		}
+
+		comment := anchorFinding(file.Filename, hunks, finding)
+		if comment == nil {
+			logrus.Debugf("Finding at %s:%d is outside the diff", file.Filename, finding.Line)
+			unanchored = append(unanchored, finding)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, unanchored
+}
+
+// anchorFinding builds a review comment for a single finding, or returns nil
+// if the finding does not point at a line of the diff. The comment body ends
+// with the fingerprint of the finding.
+func anchorFinding(path string, hunks []*diff.Hunk, finding chat.ReviewFinding) *git.ReviewComment {
+	side := finding.DiffSide()
+
+	first, firstHunk := diff.FindLine(hunks, finding.Line, side)
+	var last *diff.Line
+	var lastHunk *diff.Hunk
+	if finding.EndLine > finding.Line {
+		last, lastHunk = diff.FindLine(hunks, finding.EndLine, side)
+	}
+
+	// 多行评论必须位于同一个 hunk 内，否则退化为单行评论
+	if first != nil && last != nil && firstHunk == lastHunk {
+		fingerprint := findingFingerprint(path, firstHunk, finding.Line, finding.EndLine, side, finding.Rule)
+		return &git.ReviewComment{
+			Path:      path,
+			Body:      withFingerprint(finding, fingerprint),
+			Position:  last.Position,
+			Line:      finding.EndLine,
+			Side:      string(side),
+			StartLine: finding.Line,
+			StartSide: string(side),
+		}
+	}
+
+	anchor, hunk, number := first, firstHunk, finding.Line
+	if anchor == nil && last != nil {
+		anchor, hunk, number = last, lastHunk, finding.EndLine
+	}
+	if anchor == nil {
+		return nil
+	}
+
+	fingerprint := findingFingerprint(path, hunk, number, number, side, finding.Rule)
+	return &git.ReviewComment{
+		Path:     path,
+		Body:     withFingerprint(finding, fingerprint),
+		Position: anchor.Position,
+		Line:     number,
+		Side:     string(side),
+	}
+}
+
+// formatFindingLocation renders a finding's line range for the summary body
+func formatFindingLocation(path string, finding chat.ReviewFinding) string {
+	if finding.Line <= 0 {
+		return fmt.Sprintf("`%s`", path)
+	}
+	if finding.EndLine > finding.Line {
+		return fmt.Sprintf("`%s` L%d-L%d", path, finding.Line, finding.EndLine)
+	}
+	return fmt.Sprintf("`%s` L%d", path, finding.Line)
+}
@@ -0,0 +1,78 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+)
+
+func TestAnchorFindings(t *testing.T) {
+	// 第一个 hunk 覆盖新文件第 1-4 行，第二个覆盖第 11-13 行并删除旧文件第 11 行
+	file := &git.CommitFile{Filename: "main.go", Patch: "@@ -1,3 +1,4 @@\n a\n+b\n c\n d\n@@ -10,3 +11,3 @@\n x\n-w\n+y\n z\n"}
+
+	tests := []struct {
+		name          string
+		finding       chat.ReviewFinding
+		wantStartLine int
+		wantLine      int
+		wantSide      string
+		wantAnchored  bool
+	}{
+		{name: "single line", finding: chat.ReviewFinding{Line: 2, Body: "x"}, wantLine: 2, wantSide: "RIGHT", wantAnchored: true},
+		{name: "range in one hunk", finding: chat.ReviewFinding{Line: 1, EndLine: 3, Body: "x"}, wantStartLine: 1, wantLine: 3, wantSide: "RIGHT", wantAnchored: true},
+		{name: "range across hunks", finding: chat.ReviewFinding{Line: 3, EndLine: 12, Body: "x"}, wantLine: 3, wantSide: "RIGHT", wantAnchored: true},
+		{name: "range starting outside the diff", finding: chat.ReviewFinding{Line: 6, EndLine: 12, Body: "x"}, wantLine: 12, wantSide: "RIGHT", wantAnchored: true},
+		{name: "deleted line", finding: chat.ReviewFinding{Line: 11, Side: "old", Body: "x"}, wantLine: 11, wantSide: "LEFT", wantAnchored: true},
+		{name: "outside the diff", finding: chat.ReviewFinding{Line: 7, Body: "x"}},
+		{name: "no line", finding: chat.ReviewFinding{Body: "x"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, unanchored := anchorFindings(file, []chat.ReviewFinding{tt.finding})
+			if !tt.wantAnchored {
+				if len(comments) != 0 || len(unanchored) != 1 {
+					t.Fatalf("anchorFindings() = %d comments, %d unanchored, want the finding unanchored", len(comments), len(unanchored))
+				}
+				return
+			}
+			if len(comments) != 1 || len(unanchored) != 0 {
+				t.Fatalf("anchorFindings() = %d comments, %d unanchored, want one comment", len(comments), len(unanchored))
+			}
+			c := comments[0]
+			if c.Path != "main.go" || c.StartLine != tt.wantStartLine || c.Line != tt.wantLine || c.Side != tt.wantSide {
+				t.Errorf("comment = %s L%d-L%d %s, want L%d-L%d %s", c.Path, c.StartLine, c.Line, c.Side, tt.wantStartLine, tt.wantLine, tt.wantSide)
+			}
+			if commentFingerprint(c.Body) == "" {
+				t.Errorf("comment body %q has no fingerprint", c.Body)
+			}
+		})
+	}
+}
+
+func TestAnchorFindingsSkipsEmptyBodies(t *testing.T) {
+	file := &git.CommitFile{Filename: "main.go", Patch: "@@ -1 +1 @@\n-a\n+b\n"}
+	comments, unanchored := anchorFindings(file, []chat.ReviewFinding{{Line: 1, Body: "  "}, {Line: 9}})
+	if len(comments) != 0 || len(unanchored) != 0 {
+		t.Errorf("anchorFindings() = %d comments, %d unanchored, want none", len(comments), len(unanchored))
+	}
+}
+
+func TestFormatFindingLocation(t *testing.T) {
+	tests := []struct {
+		finding chat.ReviewFinding
+		want    string
+	}{
+		{chat.ReviewFinding{}, "`main.go`"},
+		{chat.ReviewFinding{Line: 3}, "`main.go` L3"},
+		{chat.ReviewFinding{Line: 3, EndLine: 3}, "`main.go` L3"},
+		{chat.ReviewFinding{Line: 3, EndLine: 5}, "`main.go` L3-L5"},
+	}
+
+	for _, tt := range tests {
+		if got := formatFindingLocation("main.go", tt.finding); got != tt.want {
+			t.Errorf("formatFindingLocation(%+v) = %q, want %q", tt.finding, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package bot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/sirupsen/logrus"
+)
+
+// findingMarker ends the inline comments of the bot with the fingerprint of
+// their finding, which later reviews compare their findings with
+const findingMarker = "<!-- ai-code-review:finding %s -->"
+
+// findingFingerprint identifies a finding across reviews by its file, its
+// rule and the content of the lines it points at, so that it is recognized
+// when other changes move the lines
+func findingFingerprint(path string, hunk *diff.Hunk, start, end int, side diff.Side, rule string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n", path, strings.ToLower(strings.TrimSpace(rule)))
+	for _, line := range hunk.Lines {
+		number := line.NewLine
+		if side == diff.SideLeft {
+			number = line.OldLine
+		}
+		if (side == diff.SideLeft && line.Type == diff.LineAdded) || (side == diff.SideRight && line.Type == diff.LineDeleted) {
+			continue
+		}
+		// 只比较内容，缩进变化不算修复
+		if number >= start && number <= end {
+			fmt.Fprintf(h, "%s\n", strings.TrimSpace(line.Content))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// withFingerprint appends the fingerprint marker of a finding to its
+// comment body
+func withFingerprint(finding chat.ReviewFinding, fingerprint string) string {
+	return finding.Body + "\n\n" + fmt.Sprintf(findingMarker, fingerprint)
+}
+
+// commentFingerprint returns the fingerprint of the finding of a comment of
+// the bot, or "" for other comments
+func commentFingerprint(body string) string {
+	start := strings.LastIndex(body, "<!-- ai-code-review:finding ")
+	if start < 0 {
+		return ""
+	}
+	var fingerprint string
+	if _, err := fmt.Sscanf(body[start:], findingMarker, &fingerprint); err != nil {
+		return ""
+	}
+	return fingerprint
+}
+
+// withIgnoreHint adds a hint how to suppress the finding of an inline comment
+// with "/ai ignore" to its body. The hint goes before the fingerprint marker,
+// which stays at the end.
+func withIgnoreHint(body string, english bool) string {
+	fingerprint := commentFingerprint(body)
+	if fingerprint == "" {
+		return body
+	}
+	// 提示不以命令开头，引用它的回复不会被当作命令
+	hint := fmt.Sprintf("<sub>回复 `%s ignore %s` 不再报告此问题</sub>", commandPrefix, fingerprint)
+	if english {
+		hint = fmt.Sprintf("<sub>Reply `%s ignore %s` to stop reporting this finding</sub>", commandPrefix, fingerprint)
+	}
+	start := strings.LastIndex(body, "<!-- ai-code-review:finding ")
+	return strings.TrimRight(body[:start], "\n") + "\n\n" + hint + "\n" + body[start:]
+}
+
+// withIgnoreHints returns copies of inline comments with the hint how to
+// suppress their finding, leaving the comments used for check run
+// annotations unchanged
+func withIgnoreHints(comments []*git.ReviewComment, english bool) []*git.ReviewComment {
+	hinted := make([]*git.ReviewComment, 0, len(comments))
+	for _, comment := range comments {
+		c := *comment
+		c.Body = withIgnoreHint(c.Body, english)
+		hinted = append(hinted, &c)
+	}
+	return hinted
+}
+
+// dropIgnoredFindings removes the comments of findings suppressed with
+// "/ai ignore"
+func dropIgnoredFindings(comments []*git.ReviewComment, ignored []string) []*git.ReviewComment {
+	if len(ignored) == 0 {
+		return comments
+	}
+	suppressed := make(map[string]bool, len(ignored))
+	for _, fingerprint := range ignored {
+		suppressed[fingerprint] = true
+	}
+
+	kept := make([]*git.ReviewComment, 0, len(comments))
+	for _, comment := range comments {
+		if suppressed[commentFingerprint(comment.Body)] {
+			logrus.Debugf("Finding on %s:%d is ignored, skipping", comment.Path, comment.Line)
+			continue
+		}
+		kept = append(kept, comment)
+	}
+	return kept
+}
+
+// stripFingerprint removes the fingerprint marker from a comment body, e.g.
+// for check run annotations, which are not rendered as markdown
+func stripFingerprint(body string) string {
+	if start := strings.LastIndex(body, "<!-- ai-code-review:finding "); start >= 0 {
+		return strings.TrimRight(body[:start], "\n")
+	}
+	return body
+}
+
+// reconcileFindings compares the inline comments of a review with the
+// threads of earlier reviews of the pull request. Threads of the bot on
+// fully reviewed files whose finding is gone are resolved if the lines they
+// are anchored to changed since, and findings that already have a thread
+// are not posted again. It returns the comments to post; platforms without
+// resolvable threads get all of them.
+func (b *Bot) reconcileFindings(ctx context.Context, pr *pullRequestInfo, reviewed map[string]bool, comments []*git.ReviewComment) []*git.ReviewComment {
+	resolver, ok := pr.client.(git.ReviewThreadResolver)
+	if !ok {
+		return comments
+	}
+	threads, err := resolver.ListReviewThreads(ctx, pr.owner, pr.repo, pr.number)
+	if err != nil {
+		logrus.Warnf("Failed to list review threads of PR #%d: %v, posting all findings", pr.number, err)
+		return comments
+	}
+
+	current := make(map[string]bool, len(comments))
+	for _, comment := range comments {
+		current[commentFingerprint(comment.Body)] = true
+	}
+
+	previous := make(map[string]bool)
+	changes := make(map[string][]*git.CommitFile)
+	resolved := 0
+	for _, thread := range threads {
+		fingerprint := commentFingerprint(thread.Body)
+		if fingerprint == "" {
+			continue
+		}
+		previous[fingerprint] = true
+		// 未审查或未完整审查的文件中的问题可能仍然存在
+		if thread.Resolved || current[fingerprint] || !reviewed[thread.Path] {
+			continue
+		}
+		// 代码未变时问题没有被修复，只是本次审查没有报告
+		if !anchorChanged(ctx, pr, thread, changes) {
+			logrus.Debugf("Lines of review thread %s on %s are unchanged, keeping it open", thread.ID, thread.Path)
+			continue
+		}
+		if err := resolver.ResolveReviewThread(ctx, pr.owner, pr.repo, pr.number, thread.ID); err != nil {
+			logrus.Warnf("Failed to resolve review thread %s on %s of PR #%d: %v", thread.ID, thread.Path, pr.number, err)
+			continue
+		}
+		resolved++
+	}
+
+	// 已有线程的问题不再重复发布，包括已被手动解决的
+	fresh := make([]*git.ReviewComment, 0, len(comments))
+	for _, comment := range comments {
+		if previous[commentFingerprint(comment.Body)] {
+			logrus.Debugf("Finding on %s:%d was already posted, skipping", comment.Path, comment.Line)
+			continue
+		}
+		fresh = append(fresh, comment)
+	}
+	logrus.Infof("PR #%d: resolved %d outdated threads, %d of %d findings are new", pr.number, resolved, len(fresh), len(comments))
+	return fresh
+}
+
+// anchorChanged reports whether the lines a review thread is anchored to
+// changed between the commit it was started on and the head of the pull
+// request. Threads whose anchor is unknown are reported unchanged. changes
+// caches the files changed since each commit.
+func anchorChanged(ctx context.Context, pr *pullRequestInfo, thread *git.ReviewThread, changes map[string][]*git.CommitFile) bool {
+	if thread.CommitID == "" || thread.Line == 0 || thread.CommitID == pr.headSHA {
+		return false
+	}
+	files, ok := changes[thread.CommitID]
+	if !ok {
+		var err error
+		files, _, err = pr.client.CompareCommits(ctx, pr.owner, pr.repo, thread.CommitID, pr.headSHA)
+		if err != nil {
+			logrus.Warnf("Failed to compare %s...%s of PR #%d: %v", thread.CommitID, pr.headSHA, pr.number, err)
+		}
+		changes[thread.CommitID] = files
+	}
+
+	for _, file := range files {
+		fd := file.Diff
+		if fd == nil {
+			continue
+		}
+		if thread.Side == string(diff.SideLeft) {
+			// 删除行上的问题随目标分支变化，文件有变化即视为已变
+			if fd.OldPath == thread.Path || fd.NewPath == thread.Path {
+				return true
+			}
+			continue
+		}
+		if fd.OldPath != thread.Path {
+			continue
+		}
+		if fd.Status == diff.StatusRemoved || fd.Status == diff.StatusRenamed {
+			return true
+		}
+		start := thread.StartLine
+		if start == 0 || start > thread.Line {
+			start = thread.Line
+		}
+		return diff.ChangesOldLines(fd.Hunks, start, thread.Line)
+	}
+	return false
+}
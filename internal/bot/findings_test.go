@@ -0,0 +1,84 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+)
+
+func TestReconcileFindings(t *testing.T) {
+	// old...head 只修改了 main.go 的第 2 行
+	fd, err := diff.NewFileDiff("main.go", "main.go", "modified", "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n")
+	if err != nil {
+		t.Fatalf("NewFileDiff() error = %v", err)
+	}
+	changes := map[string][]*git.CommitFile{"old...head": {{Filename: "main.go", Diff: fd}}}
+
+	thread := func(id, fingerprint, path string, line int) *git.ReviewThread {
+		body := withFingerprint(chat.ReviewFinding{Body: "finding " + id}, fingerprint)
+		return &git.ReviewThread{ID: id, Path: path, Body: body, CommitID: "old", Line: line, Side: "RIGHT"}
+	}
+	comment := func(fingerprint string) *git.ReviewComment {
+		return &git.ReviewComment{Path: "main.go", Line: 1, Side: "RIGHT", Body: withFingerprint(chat.ReviewFinding{Body: "finding"}, fingerprint)}
+	}
+
+	tests := []struct {
+		name         string
+		thread       *git.ReviewThread
+		comments     []*git.ReviewComment
+		wantResolved bool
+		wantPosted   int
+	}{
+		{name: "lines changed", thread: thread("T1", "gone", "main.go", 2), wantResolved: true},
+		{name: "lines unchanged", thread: thread("T1", "gone", "main.go", 3)},
+		{name: "no anchor", thread: &git.ReviewThread{ID: "T1", Path: "main.go", Body: withFingerprint(chat.ReviewFinding{Body: "x"}, "gone")}},
+		{name: "file not reviewed", thread: thread("T1", "gone", "util.go", 2)},
+		{name: "file unchanged since", thread: func() *git.ReviewThread { th := thread("T1", "gone", "main.go", 2); th.CommitID = "head"; return th }()},
+		{name: "finding still reported", thread: thread("T1", "same", "main.go", 2), comments: []*git.ReviewComment{comment("same"), comment("new")}, wantPosted: 1},
+		{name: "new findings", thread: thread("T1", "gone", "main.go", 3), comments: []*git.ReviewComment{comment("new")}, wantPosted: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := &fakePlatform{changes: changes, threads: []*git.ReviewThread{tt.thread}}
+			b, pr := newTestBot(platform)
+			reviewed := map[string]bool{"main.go": true}
+
+			posted := b.reconcileFindings(context.Background(), pr, reviewed, tt.comments)
+			if resolved := len(platform.resolved) > 0; resolved != tt.wantResolved {
+				t.Errorf("resolved = %v, want %v", platform.resolved, tt.wantResolved)
+			}
+			if len(posted) != tt.wantPosted {
+				t.Errorf("posted %d comments, want %d", len(posted), tt.wantPosted)
+			}
+		})
+	}
+}
+
+func TestFingerprints(t *testing.T) {
+	body := withFingerprint(chat.ReviewFinding{Body: "Check the error"}, "0123456789abcdef")
+	if got := commentFingerprint(body); got != "0123456789abcdef" {
+		t.Fatalf("commentFingerprint() = %q", got)
+	}
+	if got := commentFingerprint("Check the error"); got != "" {
+		t.Errorf("commentFingerprint() without marker = %q, want none", got)
+	}
+
+	// 提示插入在标记之前，指纹仍可读取
+	hinted := withIgnoreHint(body, true)
+	if got := commentFingerprint(hinted); got != "0123456789abcdef" {
+		t.Errorf("commentFingerprint() of hinted body = %q", got)
+	}
+	if got := stripFingerprint(hinted); got != fmt.Sprintf("Check the error\n\n<sub>Reply `%s ignore 0123456789abcdef` to stop reporting this finding</sub>", commandPrefix) {
+		t.Errorf("stripFingerprint() = %q", got)
+	}
+
+	comments := []*git.ReviewComment{{Body: body}, {Body: withFingerprint(chat.ReviewFinding{Body: "other"}, "fedcba9876543210")}}
+	if kept := dropIgnoredFindings(comments, []string{"0123456789abcdef"}); len(kept) != 1 || kept[0] != comments[1] {
+		t.Errorf("dropIgnoredFindings() kept %d comments, want the other finding", len(kept))
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/config"
 	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/eust-w/ai_code_reviewer/internal/git/azuredevops"
+	"github.com/eust-w/ai_code_reviewer/internal/git/bitbucket"
 	"github.com/eust-w/ai_code_reviewer/internal/indexer"
 	"github.com/eust-w/ai_code_reviewer/internal/git/gitea"
+	githubplatform "github.com/eust-w/ai_code_reviewer/internal/git/github"
 	"github.com/google/go-github/v60/github"
 	"github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
@@ -36,7 +40,19 @@ func (b *Bot) HandleGitHubPullRequest(ctx context.Context, event *github.PullReq
 	repoName := repo.GetName()
 	prNumber := pr.GetNumber()
 
-	return b.handlePullRequest(ctx, owner, repoName, prNumber, pr.GetBase().GetSHA(), pr.GetHead().GetSHA(), action)
+	// 以 GitHub App 认证时，使用事件所属安装的令牌
+	ctx = githubplatform.WithInstallationID(ctx, event.GetInstallation().GetID())
+
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "github",
+		owner:    owner,
+		repo:     repoName,
+		number:   prNumber,
+		baseRef:  pr.GetBase().GetRef(),
+		baseSHA:  pr.GetBase().GetSHA(),
+		headSHA:  pr.GetHead().GetSHA(),
+		action:   action,
+	})
 }
 
 // HandleGitLabMergeRequest handles GitLab merge request events
@@ -58,7 +74,16 @@ func (b *Bot) HandleGitLabMergeRequest(ctx context.Context, event *gitlab.MergeE
 	repoName := event.Project.Name
 	mrNumber := mr.IID
 
-	return b.handlePullRequest(ctx, owner, repoName, mrNumber, mr.OldRev, mr.LastCommit.ID, action)
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "gitlab",
+		owner:    owner,
+		repo:     repoName,
+		number:   mrNumber,
+		baseRef:  mr.TargetBranch,
+		baseSHA:  mr.OldRev,
+		headSHA:  mr.LastCommit.ID,
+		action:   action,
+	})
 }
 
 // HandleGiteaPullRequest handles Gitea pull request events
@@ -88,14 +113,147 @@ func (b *Bot) HandleGiteaPullRequest(ctx context.Context, event *gitea.HookPullR
 	repoName := event.Repository.Name
 	prNumber := int(pr.Number)
 
-	return b.handlePullRequest(ctx, owner, repoName, prNumber, pr.Base.Sha, pr.Head.Sha, string(action))
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "gitea",
+		owner:    owner,
+		repo:     repoName,
+		number:   prNumber,
+		baseRef:  pr.Base.Ref,
+		baseSHA:  pr.Base.Sha,
+		headSHA:  pr.Head.Sha,
+		action:   string(action),
+	})
+}
+
+// HandleBitbucketPullRequest handles Bitbucket Cloud and Bitbucket Server
+// pull request events
+func (b *Bot) HandleBitbucketPullRequest(ctx context.Context, event *bitbucket.PullRequestEvent) error {
+	// 与其他平台一致，新建映射为 opened，源分支更新映射为 synchronize
+	action := "synchronize"
+	if event.Opened() {
+		action = "opened"
+	}
+
+	if event.State != "OPEN" || event.Draft {
+		logrus.Info("Pull request is not open or is a draft, skipping")
+		return nil
+	}
+
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "bitbucket",
+		owner:    event.Owner,
+		repo:     event.Repo,
+		number:   event.Number,
+		baseRef:  event.BaseRef,
+		baseSHA:  event.BaseSHA,
+		headSHA:  event.HeadSHA,
+		action:   action,
+		// Bitbucket Cloud 修改标题、描述或评审人时也发送 pullrequest:updated
+		skipReviewed: event.EventKey == bitbucket.EventPullRequestUpdated,
+	})
+}
+
+// HandleAzureDevOpsPullRequest handles Azure DevOps pull request service
+// hook events
+func (b *Bot) HandleAzureDevOpsPullRequest(ctx context.Context, event *azuredevops.PullRequestEvent) error {
+	// 新建映射为 opened，其余更新映射为 synchronize
+	action := "synchronize"
+	if event.Opened() {
+		action = "opened"
+	}
+
+	if event.State != "active" || event.Draft {
+		logrus.Info("Pull request is not active or is a draft, skipping")
+		return nil
+	}
+
+	return b.handlePullRequest(ctx, &pullRequestInfo{
+		platform: "azuredevops",
+		owner:    event.Owner,
+		repo:     event.Repo,
+		number:   event.Number,
+		baseRef:  event.BaseRef,
+		baseSHA:  event.BaseSHA,
+		headSHA:  event.HeadSHA,
+		action:   action,
+		// 评审人投票和修改标题、描述也会发送 git.pullrequest.updated
+		skipReviewed: !event.Opened(),
+	})
+}
+
+// pullRequestInfo describes the pull request a review runs for
+type pullRequestInfo struct {
+	// platform is the name of the platform the event came from; client is
+	// its client, resolved when the review starts
+	platform string
+	client   git.Platform
+	owner    string
+	repo     string
+	number   int
+	baseRef  string
+	baseSHA  string
+	headSHA  string
+	action   string
+	// file limits the review to one file, for "/ai review <path>"
+	file string
+	// skipReviewed skips the review when the summary comment already
+	// records the head commit, for update events that are also sent for
+	// edits of the pull request that push no commits
+	skipReviewed bool
+}
+
+// compare returns the files changed by a pull request and its commits,
+// using the pull request API of the platform when available
+func (b *Bot) compare(ctx context.Context, pr *pullRequestInfo) ([]*git.CommitFile, []*git.Commit, error) {
+	if comparer, ok := pr.client.(git.PullRequestComparer); ok {
+		return comparer.ComparePullRequest(ctx, pr.owner, pr.repo, pr.number, pr.baseSHA, pr.headSHA)
+	}
+	return pr.client.CompareCommits(ctx, pr.owner, pr.repo, pr.baseSHA, pr.headSHA)
 }
 
 // Common handler for pull requests from any platform
-func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number int, baseSHA, headSHA, action string) error {
+func (b *Bot) handlePullRequest(ctx context.Context, pr *pullRequestInfo) (err error) {
+	owner, repo, number := pr.owner, pr.repo, pr.number
+	baseSHA, headSHA, action := pr.baseSHA, pr.headSHA, pr.action
+
+	// 使用事件所属平台的客户端
+	if pr.client, err = b.platform(pr.platform); err != nil {
+		return err
+	}
+	client := pr.client
+
+	if pr.skipReviewed && reviewedCommit(ctx, pr) == headSHA {
+		logrus.Infof("Commit %s of PR #%d is already reviewed, skipping", shortSHA(headSHA), number)
+		return nil
+	}
+
+	// 加载仓库级配置并与全局配置合并
+	cfg, enabled := b.loadRepoConfig(ctx, pr)
+	if !enabled {
+		logrus.Infof("Reviews are disabled by %s in %s/%s, skipping", config.RepoConfigFile, owner, repo)
+		return nil
+	}
+	reviewer := b.chat.WithConfig(cfg)
+	english := strings.ToLower(cfg.Language) == "english"
+
+	// 审查失败时检查运行和状态不能停留在进行中
+	// 单文件审查不代表整个 PR，不报告状态和检查运行
+	var status *statusReport
+	var check *checkRun
+	if pr.file == "" {
+		status = b.startStatusReport(ctx, pr)
+		check = b.startCheckRun(ctx, pr, english)
+	}
+	defer func() {
+		// 被新提交取代的审查已取消，仍需结束它的检查运行和状态
+		cleanupCtx := context.WithoutCancel(ctx)
+		check.abort(cleanupCtx, err)
+		status.abort(cleanupCtx, err)
+	}()
+
 	// Compare commits to get changed files
 	logrus.Debugf("Comparing commits: base=%s, head=%s", baseSHA, headSHA)
-	changedFiles, commits, err := b.platform.CompareCommits(ctx, owner, repo, baseSHA, headSHA)
+	changedFiles, commits, err := b.compare(ctx, pr)
 	if err != nil {
 		return fmt.Errorf("failed to compare commits: %w", err)
 	}
@@ -106,16 +264,28 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 		lastCommitHead := commits[len(commits)-1].SHA
 
 		logrus.Debugf("Comparing latest commits: base=%s, head=%s", lastCommitBase, lastCommitHead)
-		changedFiles, _, err = b.platform.CompareCommits(ctx, owner, repo, lastCommitBase, lastCommitHead)
-		if err != nil {
-			return fmt.Errorf("failed to compare latest commits: %w", err)
+		// 无法单独比较最新提交时（例如 Gitea 没有多个提交的 diff API）审查整个 PR
+		latestFiles, _, compareErr := client.CompareCommits(ctx, owner, repo, lastCommitBase, lastCommitHead)
+		if compareErr != nil {
+			logrus.Warnf("Failed to compare latest commits of PR #%d: %v, reviewing all changes", number, compareErr)
+		} else {
+			changedFiles = latestFiles
 		}
 	}
 
+	if pr.file != "" {
+		changedFiles = selectFile(changedFiles, pr.file)
+	}
+
 	// Filter files based on patterns
-	filteredFiles := b.filterFiles(changedFiles)
+	filteredFiles := filterFiles(cfg, changedFiles)
+	if len(filteredFiles) == 0 && pr.file != "" {
+		return errFileNotReviewable
+	}
 	if len(filteredFiles) == 0 {
 		logrus.Info("No files to review after filtering")
+		status.complete(ctx, git.StatusSuccess)
+		check.complete(ctx, checkRunResult(english, 0, nil, nil, formatReviewSummary(cfg, nil)))
 		return nil
 	}
 	
@@ -132,13 +302,15 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 		} else {
 			logrus.Infof("[DEBUG] 成功获取索引器: %v", idxr)
 			// 获取仓库路径和平台类型
-			platformType := b.config.Platform
+			platformType := pr.platform
 			var repoURL string
 			
 			// 根据不同平台构建仓库URL
 			switch platformType {
 			case "github":
-				repoURL = fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
+				if ghClient, ok := client.(*githubplatform.Client); ok {
+					repoURL = ghClient.CloneURL(owner, repo)
+				}
 			case "gitlab":
 				repoURL = fmt.Sprintf("https://gitlab.com/%s/%s.git", owner, repo)
 			case "gitea":
@@ -147,6 +319,14 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 				// 移除尾部斜杠
 				baseURL = strings.TrimSuffix(baseURL, "/")
 				repoURL = fmt.Sprintf("%s/%s/%s.git", baseURL, owner, repo)
+			case "bitbucket":
+				if bbClient, ok := client.(*bitbucket.Client); ok {
+					repoURL = bbClient.CloneURL(owner, repo)
+				}
+			case "azuredevops":
+				if adoClient, ok := client.(*azuredevops.Client); ok {
+					repoURL = adoClient.CloneURL(owner, repo)
+				}
 			default:
 				// 默认使用简单的路径格式
 				repoURL = fmt.Sprintf("%s/%s", owner, repo)
@@ -183,14 +363,15 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 	// Review each file
 	start := time.Now()
 	reviewComments := make([]*git.ReviewComment, 0)
+	fileReviews := make([]*fileReview, 0, len(filteredFiles))
 
 	for _, file := range filteredFiles {
-		if file.Status != "modified" && file.Status != "added" {
+		if !isReviewable(file) {
 			continue
 		}
 
 		patch := file.Patch
-		if patch == "" || (b.config.MaxPatchLength > 0 && len(patch) > b.config.MaxPatchLength) {
+		if patch == "" || (cfg.MaxPatchLength > 0 && len(patch) > cfg.MaxPatchLength) {
 			logrus.Infof("Skipping %s: empty patch or too large", file.Filename)
 			continue
 		}
@@ -222,10 +403,10 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 				if err != nil {
 					logrus.Warnf("Failed to query code context for %s: %v - continuing without context enhancement", file.Filename, err)
 				} else if codeContext, ok := codeContextMap[file.Filename]; ok && codeContext != nil {
-					// 使用上下文丰富补丁 - 调用包级函数
+					// 使用上下文丰富补丁，上下文不能挤占补丁本身的分块预算
 					logrus.Debugf("Found code context for %s with %d imports, %d definitions, %d similar snippets",
 						file.Filename, len(codeContext.Imports), len(codeContext.Definitions), len(codeContext.SimilarCode))
-					enhancedPatch = indexer.EnrichPatchWithContext(patch, codeContext)
+					enhancedPatch = indexer.EnrichPatchWithContextBudget(patch, codeContext, reviewer.Tokenizer(), reviewer.ChunkTokenBudget())
 					logrus.Infof("Enhanced patch for %s with code context", file.Filename)
 				} else {
 					logrus.Debugf("No relevant code context found for %s", file.Filename)
@@ -234,127 +415,100 @@ func (b *Bot) handlePullRequest(ctx context.Context, owner, repo string, number
 		}
 
 		// 使用增强的补丁进行代码审查
-		result, err := b.chat.CodeReview(ctx, enhancedPatch)
+		result, err := reviewer.CodeReview(ctx, enhancedPatch)
 		if err != nil {
+			// 审查失败不能视为通过，在总结中如实说明
 			logrus.Errorf("Failed to review %s: %v", file.Filename, err)
+			fileReviews = append(fileReviews, &fileReview{
+				path:   file.Filename,
+				result: chat.ReviewResult{Status: chat.ReviewStatusFailed},
+			})
 			continue
 		}
 
-		// 构建完整的评论内容，包含所有字段
-		commentBody := ""
-		language := strings.ToLower(b.config.Language)
-		// 添加总结
-		if result.Summary != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Summary\n%s\n\n", result.Summary)
-			} else {
-				commentBody += fmt.Sprintf("## 总结\n%s\n\n", result.Summary)
-			}
-		}
-		
-		// 添加详细评论
-		if result.ReviewComment != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Review Comment\n%s\n\n", result.ReviewComment)
-			} else {
-				commentBody += fmt.Sprintf("## 详细评论\n%s\n\n", result.ReviewComment)
-			}
-		}
-		
-		// 添加建议
-		if result.Suggestions != "" || result.Risks != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Suggestions\n%s\n\n", result.Suggestions)
-			} else {
-				commentBody += fmt.Sprintf("## 改进建议\n%s\n\n", result.Suggestions)
-			}
-		}else{
+		// 没有建议和风险时视为通过
+		if result.Suggestions == "" && result.Risks == "" && len(result.Comments) == 0 {
 			result.LGTM = true
 		}
-		
-		// // 添加亮点
-		// if result.Highlights != "" {
-		// 	if language == "english" {
-		// 		commentBody += fmt.Sprintf("## Highlights\n%s\n\n", result.Highlights)
-		// 	} else {
-		// 		commentBody += fmt.Sprintf("## 代码亮点\n%s\n\n", result.Highlights)
-		// 	}
-		// }
-		
-		// 添加风险
-		if result.Risks != "" {
-			if language == "english" {
-				commentBody += fmt.Sprintf("## Risks\n%s\n\n", result.Risks)
-			} else {
-				commentBody += fmt.Sprintf("## 潜在风险\n%s\n\n", result.Risks)
-			}
-		}
-		
-		// 添加 LGTM 状态
-		if !result.LGTM {
-			if language == "english" {
-				commentBody = fmt.Sprintf("**LGTM: ✖️ Changes Required**\n\n%s", commentBody)
-			} else {
-				commentBody = fmt.Sprintf("**LGTM: ✖️ 需要修改**\n\n%s", commentBody)
-			}
-		} else {
-			commentBody = fmt.Sprintf("**LGTM: ✅ 代码看起来不错**\n\n%s", commentBody)
-		}
-		
-		// 即使评论内容为空，也添加到评论列表，确保显示 LGTM 状态
-		patchLines := len(strings.Split(patch, "\n"))
-		reviewComments = append(reviewComments, &git.ReviewComment{
-			Path:     file.Filename,
-			Body:     commentBody,
-			Position: patchLines - 1,
+
+		// 将行级问题锚定到差异中，无法锚定的问题放入总结
+		comments, unanchored := anchorFindings(file, result.Comments)
+		reviewComments = append(reviewComments, comments...)
+		fileReviews = append(fileReviews, &fileReview{
+			path:       file.Filename,
+			result:     result,
+			unanchored: unanchored,
 		})
 	}
 
-	// Create the review with detailed information
-	// 始终创建一个有信息量的总结，不再仅显示 "LGTM 👍"
-	// 即使没有评论，也会显示一个基本的总结
-	
-	// 收集所有文件的审查结果
-	allSummaries := []string{}
-	allLGTM := true
-	
-	// 遍历所有评论，提取摘要信息
-	for _, comment := range reviewComments {
-		// 从评论中提取文件名
-		fileName := filepath.Base(comment.Path)
-		
-		// 检查评论中是否包含 "LGTM: false"
-		if strings.Contains(comment.Body, "LGTM: false") || strings.Contains(comment.Body, "\"lgtm\": false") || strings.Contains(comment.Body, "✖️ 需要修改") {
-			allLGTM = false
-			allSummaries = append(allSummaries, fmt.Sprintf("❌ `%s` 需要修改", fileName))
-		} else {
-			allSummaries = append(allSummaries, fmt.Sprintf("✅ `%s` 看起来不错", fileName))
+	// 审查期间推送了新提交时，不再发布过期的结果
+	if ctx.Err() != nil {
+		logrus.Infof("Review of PR #%d at %s canceled: %v", number, headSHA, context.Cause(ctx))
+		return context.Cause(ctx)
+	}
+
+	// 不再报告通过 "/ai ignore" 忽略的问题
+	if summary := findSummaryComment(ctx, pr); summary != nil {
+		reviewComments = dropIgnoredFindings(reviewComments, ignoredFindings(summary.Body))
+	}
+
+	// 部分平台的比较结果不含提交列表，此时使用拉取请求的头部提交
+	latestCommitSHA := headSHA
+	if len(commits) > 0 {
+		latestCommitSHA = commits[len(commits)-1].SHA
+	}
+	failed, partial := countIncompleteReviews(fileReviews)
+	if failed > 0 && failed == len(fileReviews) && cfg.ReviewFailureMode == config.ReviewFailureComment {
+		// 没有任何文件完成审查，只发布说明评论，不提交审查
+		summaryID, err := b.postResult(ctx, pr, latestCommitSHA, formatReviewUnavailable(cfg, fileReviews), english)
+		if err != nil {
+			return fmt.Errorf("failed to post review unavailable comment: %w", err)
 		}
+		status.linkComment(summaryID)
+		logrus.Warnf("Review of PR #%d unavailable: all %d files failed", number, failed)
+		status.complete(ctx, git.StatusError)
+		check.complete(ctx, checkRunResult(english, failed, fileReviews, nil, formatReviewUnavailable(cfg, fileReviews)))
+		return nil
 	}
-	// 创建总结
-	body := ""
-	if len(reviewComments) == 0 {
-		// 如果没有评论，说明没有需要审查的文件或所有文件都已过滤
-		body = "## 代码审查结果 ℹ️\n\n没有发现需要审查的文件。这可能是因为所有文件都被过滤或者变更太小。"
-	} else if allLGTM {
-		// 如果所有文件都通过了审查
-		body = "## 代码审查通过 ✅\n\n所有文件都通过了审查，请查看各文件的详细评论获取更多信息。"
-	} else {
-		// 如果有文件需要修改
-		body = "## 代码审查发现问题 ⚠️\n\n一些文件需要修改，请查看各文件的详细评论获取更多信息。"
-	}
-	// 添加文件摘要
-	if len(allSummaries) > 0 {
-		body += "\n\n### 文件摘要:\n" + strings.Join(allSummaries, "\n")
+
+	body := formatReviewSummary(cfg, fileReviews)
+
+	// 解决已修复问题的线程，已发布过的问题不再重复发布
+	reviewed := make(map[string]bool, len(fileReviews))
+	for _, review := range fileReviews {
+		if review.result.Status == chat.ReviewStatusReviewed {
+			reviewed[review.path] = true
+		}
+	}
+	newComments := b.reconcileFindings(ctx, pr, reviewed, reviewComments)
+	if commandPlatforms[pr.platform] {
+		newComments = withIgnoreHints(newComments, english)
 	}
-	
-	// 添加署名
-	body += "\n\n---\n*由 AI 代码审查助手自动生成*"
 
-	latestCommitSHA := commits[len(commits)-1].SHA
-	err = b.platform.CreateReview(ctx, owner, repo, number, latestCommitSHA, reviewComments, body)
+	// 审查只包含行内评论，总结发布在每次审查都原地更新的评论中
+	if len(newComments) > 0 {
+		err = client.CreateReview(ctx, owner, repo, number, latestCommitSHA, newComments, "")
+		if err != nil {
+			return fmt.Errorf("failed to create review: %w", err)
+		}
+	}
+	summaryID, err := b.postResult(ctx, pr, latestCommitSHA, body, english)
 	if err != nil {
-		return fmt.Errorf("failed to create review: %w", err)
+		return fmt.Errorf("failed to post review summary: %w", err)
+	}
+	status.linkComment(summaryID)
+
+	if cfg.ApproveOnLGTM && pr.file == "" && failed+partial == 0 && len(reviewComments) == 0 && allLGTM(fileReviews) {
+		b.approve(ctx, pr)
+	}
+	status.complete(ctx, reviewStatus(failed+partial, fileReviews))
+	check.complete(ctx, checkRunResult(english, failed+partial, fileReviews, reviewComments, body))
+
+	if failed+partial > 0 {
+		logrus.Warnf("Review of PR #%d incomplete: %d failed, %d partial of %d files", number, failed, partial, len(fileReviews))
+		if cfg.ReviewFailureMode == config.ReviewFailureError {
+			return fmt.Errorf("review incomplete: %d of %d files could not be fully reviewed", failed+partial, len(fileReviews))
+		}
 	}
 
 	logrus.Infof("Successfully reviewed PR #%d in %s", number, time.Since(start))
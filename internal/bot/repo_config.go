@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// repoConfigMarker starts the comment reporting an invalid repository
+// configuration file. Later reviews update the comment instead of posting
+// another one.
+const repoConfigMarker = "<!-- ai-code-review:config-error -->"
+
+// loadRepoConfig fetches the repository configuration file from the base
+// branch of the pull request and merges it over the global configuration.
+// It returns the configuration to use for the review and whether reviews are
+// enabled for the repository. Missing or invalid files fall back to the
+// global configuration; invalid files are reported on the pull request.
+func (b *Bot) loadRepoConfig(ctx context.Context, pr *pullRequestInfo) (*config.Config, bool) {
+	// 优先从目标分支读取，避免 PR 作者修改配置影响本次审查
+	ref := pr.baseRef
+	if ref == "" {
+		ref = pr.baseSHA
+	}
+	if ref == "" {
+		return b.config, true
+	}
+
+	data, err := pr.client.GetFileContent(ctx, pr.owner, pr.repo, config.RepoConfigFile, ref)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			logrus.Debugf("No %s found in %s/%s@%s, using global configuration", config.RepoConfigFile, pr.owner, pr.repo, ref)
+		} else {
+			logrus.Warnf("Failed to fetch %s from %s/%s@%s: %v, using global configuration", config.RepoConfigFile, pr.owner, pr.repo, ref, err)
+		}
+		return b.config, true
+	}
+
+	rc, err := config.ParseRepoConfig(data)
+	if err != nil {
+		logrus.Warnf("Invalid %s in %s/%s@%s: %v, using global configuration", config.RepoConfigFile, pr.owner, pr.repo, ref, err)
+		b.reportInvalidRepoConfig(ctx, pr, ref, err)
+		return b.config, true
+	}
+
+	logrus.Infof("Loaded %s from %s/%s@%s", config.RepoConfigFile, pr.owner, pr.repo, ref)
+	return b.config.Merge(rc), rc.IsEnabled()
+}
+
+// reportInvalidRepoConfig explains on the pull request why the repository
+// configuration file was rejected, in a single comment that is updated when
+// the problems change
+func (b *Bot) reportInvalidRepoConfig(ctx context.Context, pr *pullRequestInfo, ref string, err error) {
+	problems := []string{err.Error()}
+	var rcErr *config.RepoConfigError
+	if errors.As(err, &rcErr) {
+		problems = rcErr.Problems
+	}
+
+	body := formatInvalidRepoConfig(strings.ToLower(b.config.Language) == "english", ref, problems)
+	previous := findBotComment(ctx, pr, repoConfigMarker)
+	var postErr error
+	switch {
+	case previous == nil:
+		postErr = pr.client.CreatePRComment(ctx, pr.owner, pr.repo, pr.number, body)
+	case previous.Body == body:
+		// 每次审查都会检查配置，问题未变时不重复报告
+		logrus.Debugf("Invalid %s already reported in comment %d of PR #%d", config.RepoConfigFile, previous.ID, pr.number)
+		return
+	default:
+		postErr = pr.client.UpdatePRComment(ctx, pr.owner, pr.repo, pr.number, previous.ID, body)
+	}
+	if postErr != nil {
+		logrus.Errorf("Failed to report invalid %s on PR #%d: %v", config.RepoConfigFile, pr.number, postErr)
+	}
+}
+
+// formatInvalidRepoConfig builds the comment reporting the problems of the
+// repository configuration file on ref
+func formatInvalidRepoConfig(english bool, ref string, problems []string) string {
+	var body string
+	if english {
+		body = fmt.Sprintf("%s\n## AI Code Review Configuration Error ⚠️\n\n`%s` on `%s` is invalid, the review uses the default configuration instead:\n\n",
+			repoConfigMarker, config.RepoConfigFile, ref)
+	} else {
+		body = fmt.Sprintf("%s\n## AI 代码审查配置错误 ⚠️\n\n`%s`（`%s` 分支）无效，本次审查将使用默认配置：\n\n",
+			repoConfigMarker, config.RepoConfigFile, ref)
+	}
+	for _, problem := range problems {
+		body += fmt.Sprintf("- %s\n", problem)
+	}
+	return body
+}
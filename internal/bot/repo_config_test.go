@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func TestReportInvalidRepoConfig(t *testing.T) {
+	platform := &fakePlatform{files: map[string]string{
+		"main:" + config.RepoConfigFile: "langauge: english\n",
+	}}
+	b, pr := newTestBot(platform)
+	ctx := context.Background()
+
+	// 每次审查都会加载配置，问题只报告一次
+	for i := 0; i < 2; i++ {
+		if cfg, enabled := b.loadRepoConfig(ctx, pr); cfg != b.config || !enabled {
+			t.Fatalf("loadRepoConfig() = %v, %v, want the global configuration", cfg, enabled)
+		}
+	}
+	if len(platform.comments) != 1 || !strings.Contains(platform.comments[0].Body, "langauge") {
+		t.Fatalf("comments:\n%s\nwant a single report of the unknown key", platform.bodies())
+	}
+
+	// 问题变化时更新同一条评论
+	platform.files["main:"+config.RepoConfigFile] = "language: french\n"
+	b.loadRepoConfig(ctx, pr)
+	if len(platform.comments) != 1 || !strings.Contains(platform.comments[0].Body, "french") {
+		t.Errorf("comments:\n%s\nwant the report updated in place", platform.bodies())
+	}
+
+	// 其他用户伪造的报告不会被更新
+	platform.comments[0].Mine = false
+	b.loadRepoConfig(ctx, pr)
+	if len(platform.comments) != 2 {
+		t.Errorf("got %d comments, want a new report next to the one of another user", len(platform.comments))
+	}
+}
@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
+	"github.com/sirupsen/logrus"
+)
+
+// statusReport reports the review status of a pull request, as a pull
+// request status on platforms that have one and as a status of the head
+// commit otherwise. A nil *statusReport is valid and does nothing.
+type statusReport struct {
+	pr *pullRequestInfo
+	// targetURL links the status to the pull request while the review
+	// runs, and to its summary comment once it is posted
+	targetURL string
+	// done is set once the final status has been reported
+	done bool
+}
+
+// startStatusReport reports the review of a pull request as pending. It
+// returns nil when COMMIT_STATUS is disabled.
+func (b *Bot) startStatusReport(ctx context.Context, pr *pullRequestInfo) *statusReport {
+	if !b.config.CommitStatus {
+		return nil
+	}
+
+	s := &statusReport{pr: pr}
+	// 审查期间状态链接指向 PR 页面
+	if current, err := pr.client.GetPullRequest(ctx, pr.owner, pr.repo, pr.number); err != nil {
+		logrus.Debugf("Failed to get URL of PR #%d: %v", pr.number, err)
+	} else {
+		s.targetURL = current.HTMLURL
+	}
+	s.set(ctx, git.StatusPending, statusDescription(git.StatusPending))
+	return s
+}
+
+// linkComment links the final status to the summary comment with the given
+// ID on the pull request page. An ID of 0 keeps the link to the page.
+func (s *statusReport) linkComment(id int64) {
+	if s == nil || s.targetURL == "" || id == 0 {
+		return
+	}
+	s.targetURL = commentURL(s.pr.platform, s.targetURL, id)
+}
+
+// commentURL returns the URL of a general comment on the pull request page
+// prURL. Azure DevOps reports pull request statuses, which have no link, so
+// its page is returned as it is.
+func commentURL(platform, prURL string, id int64) string {
+	switch platform {
+	case "github", "gitea":
+		return fmt.Sprintf("%s#issuecomment-%d", prURL, id)
+	case "gitlab":
+		return fmt.Sprintf("%s#note_%d", prURL, id)
+	case "bitbucket":
+		if strings.HasPrefix(prURL, "https://bitbucket.org/") {
+			return fmt.Sprintf("%s#comment-%d", prURL, id)
+		}
+		// Bitbucket Server 在概览页按查询参数定位评论
+		return fmt.Sprintf("%s/overview?commentId=%d", strings.TrimSuffix(prURL, "/overview"), id)
+	default:
+		return prURL
+	}
+}
+
+// complete reports the final status of the review
+func (s *statusReport) complete(ctx context.Context, state string) {
+	s.finish(ctx, state, statusDescription(state))
+}
+
+// abort reports an error status if the review ended without a final
+// status, so that it is not left pending
+func (s *statusReport) abort(ctx context.Context, err error) {
+	if errors.Is(err, queue.ErrSuperseded) {
+		s.finish(ctx, git.StatusError, "Superseded by a newer commit")
+		return
+	}
+	s.finish(ctx, git.StatusError, statusDescription(git.StatusError))
+}
+
+func (s *statusReport) finish(ctx context.Context, state, description string) {
+	if s == nil || s.done {
+		return
+	}
+	s.done = true
+	s.set(ctx, state, description)
+}
+
+// set reports a status. Failures are logged only, e.g. when the token lacks
+// the permission to set statuses.
+func (s *statusReport) set(ctx context.Context, state, description string) {
+	pr := s.pr
+
+	var err error
+	if reporter, ok := pr.client.(git.PullRequestStatusReporter); ok {
+		err = reporter.SetPullRequestStatus(ctx, pr.owner, pr.repo, pr.number, state, description)
+	} else {
+		err = pr.client.SetCommitStatus(ctx, pr.owner, pr.repo, pr.headSHA, state, description, s.targetURL)
+	}
+	if err != nil {
+		logrus.Warnf("Failed to set %s status of PR #%d: %v", state, pr.number, err)
+	}
+}
+
+// reviewStatus returns the status of a finished review: error when files
+// could not be reviewed, success when every file is LGTM, failure otherwise
+func reviewStatus(incomplete int, reviews []*fileReview) string {
+	switch {
+	case incomplete > 0:
+		return git.StatusError
+	case allLGTM(reviews):
+		return git.StatusSuccess
+	default:
+		return git.StatusFailure
+	}
+}
+
+func statusDescription(state string) string {
+	switch state {
+	case git.StatusSuccess:
+		return "LGTM"
+	case git.StatusFailure:
+		return "Review found issues"
+	case git.StatusPending:
+		return "Review in progress"
+	default:
+		return "Review incomplete"
+	}
+}
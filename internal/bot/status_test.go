@@ -0,0 +1,70 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/eust-w/ai_code_reviewer/internal/queue"
+)
+
+func TestCommentURL(t *testing.T) {
+	tests := []struct {
+		platform string
+		prURL    string
+		want     string
+	}{
+		{"github", "https://github.com/octo/service/pull/7", "https://github.com/octo/service/pull/7#issuecomment-42"},
+		{"gitea", "https://gitea.example.com/owner/repo/pulls/7", "https://gitea.example.com/owner/repo/pulls/7#issuecomment-42"},
+		{"gitlab", "https://gitlab.com/group/project/-/merge_requests/7", "https://gitlab.com/group/project/-/merge_requests/7#note_42"},
+		{"bitbucket", "https://bitbucket.org/team/service/pull-requests/7", "https://bitbucket.org/team/service/pull-requests/7#comment-42"},
+		{"bitbucket", "https://bitbucket.example.com/projects/PRJ/repos/service/pull-requests/7", "https://bitbucket.example.com/projects/PRJ/repos/service/pull-requests/7/overview?commentId=42"},
+		{"azuredevops", "https://dev.azure.com/org/project/_git/service/pullrequest/7", "https://dev.azure.com/org/project/_git/service/pullrequest/7"},
+	}
+
+	for _, tt := range tests {
+		if got := commentURL(tt.platform, tt.prURL, 42); got != tt.want {
+			t.Errorf("commentURL(%q, %q) = %q, want %q", tt.platform, tt.prURL, got, tt.want)
+		}
+	}
+}
+
+func TestStatusReportAbort(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"superseded", fmt.Errorf("review of PR #1: %w", queue.ErrSuperseded), "error Superseded by a newer commit https://github.com/octo/service/pull/1#issuecomment-42"},
+		{"failed", errors.New("model unavailable"), "error " + statusDescription(git.StatusError) + " https://github.com/octo/service/pull/1#issuecomment-42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			platform := &fakePlatform{}
+			b, pr := newTestBot(platform)
+			b.config.CommitStatus = true
+			ctx := context.Background()
+
+			status := b.startStatusReport(ctx, pr)
+			status.linkComment(42)
+			status.abort(ctx, tt.err)
+			// 已结束的状态不会再被覆盖
+			status.complete(ctx, git.StatusSuccess)
+
+			want := []string{"pending " + statusDescription(git.StatusPending) + " https://github.com/octo/service/pull/1", tt.want}
+			if strings.Join(platform.statuses, "\n") != strings.Join(want, "\n") {
+				t.Errorf("statuses:\n%s\nwant:\n%s", strings.Join(platform.statuses, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+
+	// 未启用状态时为 nil，调用不会出错
+	var status *statusReport
+	status.linkComment(42)
+	status.abort(context.Background(), queue.ErrSuperseded)
+	status.complete(context.Background(), git.StatusSuccess)
+}
@@ -0,0 +1,247 @@
+package bot
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+// fileReview holds the review result of a single file
+type fileReview struct {
+	path   string
+	result chat.ReviewResult
+	// unanchored are findings that point outside the file's diff
+	unanchored []chat.ReviewFinding
+}
+
+// formatReviewSummary builds the body of the review from all file results
+func formatReviewSummary(cfg *config.Config, reviews []*fileReview) string {
+	english := strings.ToLower(cfg.Language) == "english"
+
+	// 收集所有文件的审查结果
+	allSummaries := []string{}
+	allLGTM := true
+	failed, partial := countIncompleteReviews(reviews)
+	for _, review := range reviews {
+		fileName := filepath.Base(review.path)
+		if review.result.Status == chat.ReviewStatusFailed {
+			if english {
+				allSummaries = append(allSummaries, fmt.Sprintf("⚠️ `%s` could not be reviewed", fileName))
+			} else {
+				allSummaries = append(allSummaries, fmt.Sprintf("⚠️ `%s` 未能完成审查", fileName))
+			}
+		} else if !review.result.LGTM {
+			allLGTM = false
+			if english {
+				allSummaries = append(allSummaries, fmt.Sprintf("❌ `%s` needs changes", fileName))
+			} else {
+				allSummaries = append(allSummaries, fmt.Sprintf("❌ `%s` 需要修改", fileName))
+			}
+		} else if review.result.Status == chat.ReviewStatusPartial {
+			if english {
+				allSummaries = append(allSummaries, fmt.Sprintf("⚠️ `%s` was only partially reviewed", fileName))
+			} else {
+				allSummaries = append(allSummaries, fmt.Sprintf("⚠️ `%s` 仅完成部分审查", fileName))
+			}
+		} else {
+			if english {
+				allSummaries = append(allSummaries, fmt.Sprintf("✅ `%s` looks good", fileName))
+			} else {
+				allSummaries = append(allSummaries, fmt.Sprintf("✅ `%s` 看起来不错", fileName))
+			}
+		}
+	}
+
+	// 创建总结
+	body := ""
+	if len(reviews) == 0 {
+		// 如果没有评论，说明没有需要审查的文件或所有文件都已过滤
+		if english {
+			body = "## Code Review Results ℹ️\n\nNo files needed review. All files may have been filtered out or the changes are too small."
+		} else {
+			body = "## 代码审查结果 ℹ️\n\n没有发现需要审查的文件。这可能是因为所有文件都被过滤或者变更太小。"
+		}
+	} else if failed == len(reviews) {
+		if english {
+			body = "## Code Review Unavailable ❌\n\nThe AI models could not review any file of this change, it has **not** been reviewed. Please review it manually or retry later."
+		} else {
+			body = "## 代码审查不可用 ❌\n\nAI 模型未能审查本次变更中的任何文件，变更**未经**审查。请人工审查或稍后重试。"
+		}
+	} else if allLGTM && failed+partial > 0 {
+		if english {
+			body = "## Code Review Incomplete ⚠️\n\nSome files could not be fully reviewed. No issues were found in the reviewed parts, see the details below."
+		} else {
+			body = "## 代码审查未完成 ⚠️\n\n部分文件未能完成审查，已审查的部分没有发现问题，请查看下方的详细信息。"
+		}
+	} else if allLGTM {
+		if english {
+			body = "## Code Review Passed ✅\n\nAll files passed the review, see the details below and the inline comments."
+		} else {
+			body = "## 代码审查通过 ✅\n\n所有文件都通过了审查，请查看下方的详细信息和行内评论。"
+		}
+	} else {
+		if english {
+			body = "## Code Review Found Issues ⚠️\n\nSome files need changes, see the details below and the inline comments."
+		} else {
+			body = "## 代码审查发现问题 ⚠️\n\n一些文件需要修改，请查看下方的详细信息和行内评论。"
+		}
+	}
+
+	// 添加文件摘要
+	if len(allSummaries) > 0 {
+		if english {
+			body += "\n\n### File Summary:\n" + strings.Join(allSummaries, "\n")
+		} else {
+			body += "\n\n### 文件摘要:\n" + strings.Join(allSummaries, "\n")
+		}
+	}
+
+	// 添加每个文件的详细结果
+	for _, review := range reviews {
+		if english {
+			body += fmt.Sprintf("\n\n---\n\n### File: `%s`\n\n", review.path)
+		} else {
+			body += fmt.Sprintf("\n\n---\n\n### 文件: `%s`\n\n", review.path)
+		}
+		body += formatFileReview(english, review)
+	}
+
+	// 添加署名
+	if english {
+		body += "\n\n---\n*Generated automatically by the AI code review assistant*"
+	} else {
+		body += "\n\n---\n*由 AI 代码审查助手自动生成*"
+	}
+
+	return body
+}
+
+// countIncompleteReviews returns the number of files that failed and that
+// were only partially reviewed
+func countIncompleteReviews(reviews []*fileReview) (failed, partial int) {
+	for _, review := range reviews {
+		switch review.result.Status {
+		case chat.ReviewStatusFailed:
+			failed++
+		case chat.ReviewStatusPartial:
+			partial++
+		}
+	}
+	return failed, partial
+}
+
+// allLGTM reports whether every file was reviewed without findings
+func allLGTM(reviews []*fileReview) bool {
+	for _, review := range reviews {
+		if !review.result.LGTM || len(review.unanchored) > 0 {
+			return false
+		}
+	}
+	return len(reviews) > 0
+}
+
+// formatReviewUnavailable builds the comment posted instead of a review when
+// no file could be reviewed and REVIEW_FAILURE_MODE is "comment"
+func formatReviewUnavailable(cfg *config.Config, reviews []*fileReview) string {
+	english := strings.ToLower(cfg.Language) == "english"
+
+	var body string
+	if english {
+		body = fmt.Sprintf("## AI Code Review Unavailable ⚠️\n\nThe AI models failed to review the %d changed file(s). This pull request has **not** been reviewed, please review it manually or retry later.\n", len(reviews))
+	} else {
+		body = fmt.Sprintf("## AI 代码审查不可用 ⚠️\n\nAI 模型未能审查变更的 %d 个文件，本 PR **未经**审查。请人工审查或稍后重试。\n", len(reviews))
+	}
+	for _, review := range reviews {
+		body += fmt.Sprintf("\n- `%s`", review.path)
+	}
+	return body
+}
+
+// formatFileReview renders the review result of one file
+func formatFileReview(english bool, review *fileReview) string {
+	result := review.result
+	commentBody := ""
+
+	if result.Status == chat.ReviewStatusFailed {
+		if english {
+			return "**Status: ⚠️ Review failed, this file was not reviewed**"
+		}
+		return "**状态: ⚠️ 审查失败，该文件未经审查**"
+	}
+
+	// 添加 LGTM 状态
+	if !result.LGTM {
+		if english {
+			commentBody += "**LGTM: ✖️ Changes Required**\n\n"
+		} else {
+			commentBody += "**LGTM: ✖️ 需要修改**\n\n"
+		}
+	} else {
+		if english {
+			commentBody += "**LGTM: ✅ Code Looks Good**\n\n"
+		} else {
+			commentBody += "**LGTM: ✅ 代码看起来不错**\n\n"
+		}
+	}
+
+	if result.Status == chat.ReviewStatusPartial {
+		if english {
+			commentBody += "**Note: ⚠️ Parts of this file could not be reviewed**\n\n"
+		} else {
+			commentBody += "**注意: ⚠️ 该文件有部分内容未能审查**\n\n"
+		}
+	}
+
+	// 添加总结
+	if result.Summary != "" {
+		if english {
+			commentBody += fmt.Sprintf("#### Summary\n%s\n\n", result.Summary)
+		} else {
+			commentBody += fmt.Sprintf("#### 总结\n%s\n\n", result.Summary)
+		}
+	}
+
+	// 添加详细评论
+	if result.ReviewComment != "" {
+		if english {
+			commentBody += fmt.Sprintf("#### Review Comment\n%s\n\n", result.ReviewComment)
+		} else {
+			commentBody += fmt.Sprintf("#### 详细评论\n%s\n\n", result.ReviewComment)
+		}
+	}
+
+	// 添加建议
+	if result.Suggestions != "" {
+		if english {
+			commentBody += fmt.Sprintf("#### Suggestions\n%s\n\n", result.Suggestions)
+		} else {
+			commentBody += fmt.Sprintf("#### 改进建议\n%s\n\n", result.Suggestions)
+		}
+	}
+
+	// 添加风险
+	if result.Risks != "" {
+		if english {
+			commentBody += fmt.Sprintf("**Potential Risks**: %s\n\n", result.Risks)
+		} else {
+			commentBody += fmt.Sprintf("**潜在风险**: %s\n\n", result.Risks)
+		}
+	}
+
+	// 添加无法定位到差异中的问题
+	if len(review.unanchored) > 0 {
+		if english {
+			commentBody += "#### Findings Outside the Diff\n"
+		} else {
+			commentBody += "#### 差异范围外的问题\n"
+		}
+		for _, finding := range review.unanchored {
+			commentBody += fmt.Sprintf("- %s: %s\n", formatFindingLocation(review.path, finding), finding.Body)
+		}
+	}
+
+	return strings.TrimRight(commentBody, "\n")
+}
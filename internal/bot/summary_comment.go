@@ -0,0 +1,220 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/sirupsen/logrus"
+)
+
+// summaryMarker starts the summary comment of the bot. Later reviews of the
+// pull request find the comment by it and update it in place.
+const summaryMarker = "<!-- ai-code-review:summary -->"
+
+// Hidden markers of the reviewed commit and of the list of earlier verdicts
+// in the summary comment
+const (
+	summaryCommitMarker = "<!-- ai-code-review:commit %s -->"
+	historyStartMarker  = "<!-- ai-code-review:history -->"
+	historyEndMarker    = "<!-- /ai-code-review:history -->"
+	// ignoredMarker lists the fingerprints of the findings suppressed with
+	// "/ai ignore", separated by commas
+	ignoredMarker = "<!-- ai-code-review:ignored %s -->"
+)
+
+// maxSummaryHistory bounds the earlier verdicts kept in the summary comment
+const maxSummaryHistory = 20
+
+// postSummary posts the summary of a review and returns the ID of the
+// summary comment, or 0 if it is not known. The summary comment of an
+// earlier review of the pull request is updated instead if there is one, and
+// its verdict is added to the history of the comment.
+func (b *Bot) postSummary(ctx context.Context, pr *pullRequestInfo, sha, summary string, english bool) (int64, error) {
+	previous := findSummaryComment(ctx, pr)
+	body := formatSummaryComment(english, sha, summary, previous)
+	if previous != nil {
+		err := pr.client.UpdatePRComment(ctx, pr.owner, pr.repo, pr.number, previous.ID, body)
+		if err == nil {
+			logrus.Debugf("Updated summary comment %d of PR #%d", previous.ID, pr.number)
+			return previous.ID, nil
+		}
+		// 更新失败（例如评论已被删除）时发布新的总结
+		logrus.Warnf("Failed to update summary comment %d of PR #%d: %v, posting a new one", previous.ID, pr.number, err)
+	}
+	if err := pr.client.CreatePRComment(ctx, pr.owner, pr.repo, pr.number, body); err != nil {
+		return 0, err
+	}
+	// 创建评论的接口不返回 ID，重新查找刚发布的总结
+	if created := findSummaryComment(ctx, pr); created != nil {
+		return created.ID, nil
+	}
+	return 0, nil
+}
+
+// postResult posts the result of a review: in the summary comment for a
+// review of the whole pull request, and as a comment of its own for the
+// review of a single file requested with "/ai review <path>", which does not
+// replace the verdict on the pull request. It returns the ID of the summary
+// comment, or 0 if it is not known or no summary was posted.
+func (b *Bot) postResult(ctx context.Context, pr *pullRequestInfo, sha, summary string, english bool) (int64, error) {
+	if pr.file == "" {
+		return b.postSummary(ctx, pr, sha, summary, english)
+	}
+	title := fmt.Sprintf("`%s` 在 `%s` 的审查结果：", pr.file, shortSHA(sha))
+	if english {
+		title = fmt.Sprintf("Review of `%s` at `%s`:", pr.file, shortSHA(sha))
+	}
+	return 0, pr.client.CreatePRComment(ctx, pr.owner, pr.repo, pr.number, title+"\n\n"+summary)
+}
+
+// findSummaryComment returns the latest summary comment of the bot on a pull
+// request, or nil if there is none or the comments cannot be listed
+func findSummaryComment(ctx context.Context, pr *pullRequestInfo) *git.PRComment {
+	return findBotComment(ctx, pr, summaryMarker)
+}
+
+// findBotComment returns the latest comment of the bot on a pull request
+// that starts with marker, or nil if there is none or the comments cannot be
+// listed
+func findBotComment(ctx context.Context, pr *pullRequestInfo, marker string) *git.PRComment {
+	comments, err := pr.client.ListPRComments(ctx, pr.owner, pr.repo, pr.number)
+	if err != nil {
+		logrus.Warnf("Failed to list comments of PR #%d: %v", pr.number, err)
+		return nil
+	}
+	var found *git.PRComment
+	for _, comment := range comments {
+		// 引用的评论以 ">" 开头，不会被误认为机器人的评论；其他用户
+		// 伪造的评论不可信，其中的忽略列表也不能采用
+		if comment.Mine && strings.HasPrefix(comment.Body, marker) {
+			found = comment
+		}
+	}
+	return found
+}
+
+// formatSummaryComment builds the summary comment of a review of sha. The
+// verdict of the previous summary, if any, is prepended to its history,
+// which is shown collapsed below the summary.
+func formatSummaryComment(english bool, sha, summary string, previous *git.PRComment) string {
+	body := summaryMarker + "\n" + fmt.Sprintf(summaryCommitMarker, sha) + "\n\n" + summary
+	// 被忽略的问题随总结评论保留
+	if previous != nil {
+		body = withIgnoredFindings(body, ignoredFindings(previous.Body))
+	}
+
+	var history []string
+	if previous != nil {
+		history = append(history, formatVerdict(previous.Body))
+		history = append(history, summaryHistory(previous.Body)...)
+	}
+	if len(history) > maxSummaryHistory {
+		history = history[:maxSummaryHistory]
+	}
+	if len(history) == 0 {
+		return body
+	}
+
+	title := fmt.Sprintf("历史审查结果 (%d)", len(history))
+	if english {
+		title = fmt.Sprintf("Previous reviews (%d)", len(history))
+	}
+	body += fmt.Sprintf("\n\n<details>\n<summary>%s</summary>\n\n%s\n%s\n%s\n\n</details>",
+		title, historyStartMarker, strings.Join(history, "\n"), historyEndMarker)
+	return body
+}
+
+// formatVerdict renders the verdict of a summary comment as a history entry:
+// the reviewed commit and the heading of the summary
+func formatVerdict(body string) string {
+	var sha, verdict string
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if sha == "" {
+			fmt.Sscanf(line, summaryCommitMarker, &sha)
+		}
+		if verdict == "" && strings.HasPrefix(line, "## ") {
+			verdict = strings.TrimPrefix(line, "## ")
+		}
+	}
+	sha = shortSHA(sha)
+	if sha == "" {
+		return "- " + verdict
+	}
+	return fmt.Sprintf("- `%s` %s", sha, verdict)
+}
+
+// reviewedCommit returns the commit recorded in the summary comment of the
+// bot on a pull request, or "" if there is none
+func reviewedCommit(ctx context.Context, pr *pullRequestInfo) string {
+	summary := findSummaryComment(ctx, pr)
+	if summary == nil {
+		return ""
+	}
+	for _, line := range strings.Split(summary.Body, "\n") {
+		var sha string
+		if _, err := fmt.Sscanf(strings.TrimSpace(line), summaryCommitMarker, &sha); err == nil {
+			return sha
+		}
+	}
+	return ""
+}
+
+// summaryHistory returns the history entries of a summary comment, newest
+// first
+func summaryHistory(body string) []string {
+	start := strings.Index(body, historyStartMarker)
+	end := strings.Index(body, historyEndMarker)
+	if start < 0 || end < start {
+		return nil
+	}
+
+	var entries []string
+	for _, line := range strings.Split(body[start+len(historyStartMarker):end], "\n") {
+		if line = strings.TrimSpace(line); strings.HasPrefix(line, "- ") {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// shortSHA abbreviates a commit SHA for display
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+// ignoredFindings returns the fingerprints of the findings suppressed in a
+// summary comment
+func ignoredFindings(body string) []string {
+	for _, line := range strings.Split(body, "\n") {
+		var list string
+		if _, err := fmt.Sscanf(strings.TrimSpace(line), ignoredMarker, &list); err == nil {
+			return strings.Split(list, ",")
+		}
+	}
+	return nil
+}
+
+// withIgnoredFindings replaces the list of suppressed findings of a summary
+// comment
+func withIgnoredFindings(body string, fingerprints []string) string {
+	lines := strings.Split(body, "\n")
+	kept := make([]string, 0, len(lines)+1)
+	for i, line := range lines {
+		var list string
+		if _, err := fmt.Sscanf(strings.TrimSpace(line), ignoredMarker, &list); err == nil {
+			continue
+		}
+		kept = append(kept, line)
+		// 列表紧跟在总结标记之后
+		if i == 0 && len(fingerprints) > 0 {
+			kept = append(kept, fmt.Sprintf(ignoredMarker, strings.Join(fingerprints, ",")))
+		}
+	}
+	return strings.Join(kept, "\n")
+}
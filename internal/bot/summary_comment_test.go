@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/chat"
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/git"
+	"github.com/eust-w/ai_code_reviewer/internal/git/azuredevops"
+	"github.com/eust-w/ai_code_reviewer/internal/git/bitbucket"
+)
+
+func TestPostSummary(t *testing.T) {
+	platform := &fakePlatform{}
+	b, pr := newTestBot(platform)
+	ctx := context.Background()
+
+	// 每次审查更新同一条总结评论，历史只保留最近的结果
+	for i := 1; i <= maxSummaryHistory+5; i++ {
+		sha := fmt.Sprintf("%07d", i) + strings.Repeat("f", 33)
+		id, err := b.postSummary(ctx, pr, sha, fmt.Sprintf("## Review %d\n\nDetails", i), true)
+		if err != nil {
+			t.Fatalf("postSummary() error = %v", err)
+		}
+		if id != 1 {
+			t.Fatalf("postSummary() = %d, want the ID of the first summary", id)
+		}
+	}
+	if len(platform.comments) != 1 {
+		t.Fatalf("got %d comments, want the summary updated in place:\n%s", len(platform.comments), platform.bodies())
+	}
+
+	body := platform.comments[0].Body
+	if !strings.HasPrefix(body, summaryMarker+"\n"+fmt.Sprintf(summaryCommitMarker, fmt.Sprintf("%07d", maxSummaryHistory+5)+strings.Repeat("f", 33))) {
+		t.Errorf("summary does not start with the markers of the latest review:\n%s", body)
+	}
+	history := summaryHistory(body)
+	if len(history) != maxSummaryHistory {
+		t.Fatalf("history has %d entries, want %d", len(history), maxSummaryHistory)
+	}
+	if want := fmt.Sprintf("- `%07d` Review %d", maxSummaryHistory+4, maxSummaryHistory+4); history[0] != want {
+		t.Errorf("newest history entry = %q, want %q", history[0], want)
+	}
+	if want := "- `0000005` Review 5"; history[len(history)-1] != want {
+		t.Errorf("oldest history entry = %q, want %q", history[len(history)-1], want)
+	}
+	if !strings.Contains(body, fmt.Sprintf("Previous reviews (%d)", maxSummaryHistory)) {
+		t.Errorf("summary has no history title:\n%s", body)
+	}
+}
+
+func TestPostSummaryIgnoresOtherUsers(t *testing.T) {
+	platform := &fakePlatform{}
+	b, pr := newTestBot(platform)
+	ctx := context.Background()
+
+	// 其他用户复制的总结评论不会被更新
+	if _, err := b.postSummary(ctx, pr, "abc", "## LGTM", true); err != nil {
+		t.Fatalf("postSummary() error = %v", err)
+	}
+	platform.comments[0].Mine = false
+	id, err := b.postSummary(ctx, pr, "def", "## LGTM", true)
+	if err != nil {
+		t.Fatalf("postSummary() error = %v", err)
+	}
+	if id != 2 || len(platform.comments) != 2 || strings.Contains(platform.comments[1].Body, "Previous reviews") {
+		t.Errorf("postSummary() = %d, comments:\n%s\nwant a new summary without history", id, platform.bodies())
+	}
+}
+
+func TestSkipReviewedBitbucketUpdate(t *testing.T) {
+	platform := &fakePlatform{}
+	b := NewMultiPlatformBot(&config.Config{Platform: "bitbucket"}, map[string]git.Platform{"bitbucket": platform}, &chat.Chat{})
+	_, pr := newTestBot(platform)
+	if _, err := b.postSummary(context.Background(), pr, "head", "## LGTM", true); err != nil {
+		t.Fatalf("postSummary() error = %v", err)
+	}
+	if got := reviewedCommit(context.Background(), pr); got != "head" {
+		t.Fatalf("reviewedCommit() = %q, want head", got)
+	}
+
+	// 修改标题触发的更新事件不会重新审查已审查的提交
+	event := &bitbucket.PullRequestEvent{EventKey: bitbucket.EventPullRequestUpdated, Owner: "octo", Repo: "service", Number: 1, State: "OPEN", BaseSHA: "base", HeadSHA: "head"}
+	if err := b.HandleBitbucketPullRequest(context.Background(), event); err != nil {
+		t.Fatalf("HandleBitbucketPullRequest() error = %v", err)
+	}
+	if len(platform.comments) != 1 || len(platform.statuses) != 0 {
+		t.Errorf("comments:\n%s\nstatuses: %v\nwant the reviewed commit skipped", platform.bodies(), platform.statuses)
+	}
+
+	// 新的提交仍会被审查
+	event.HeadSHA = "new"
+	if err := b.HandleBitbucketPullRequest(context.Background(), event); err == nil || !strings.Contains(err.Error(), "failed to compare") {
+		t.Errorf("HandleBitbucketPullRequest() of a new commit error = %v, want the review to compare it", err)
+	}
+}
+
+func TestSkipReviewedAzureDevOpsUpdate(t *testing.T) {
+	platform := &fakePlatform{}
+	b := NewMultiPlatformBot(&config.Config{Platform: "azuredevops"}, map[string]git.Platform{"azuredevops": platform}, &chat.Chat{})
+	_, pr := newTestBot(platform)
+	if _, err := b.postSummary(context.Background(), pr, "head", "## LGTM", true); err != nil {
+		t.Fatalf("postSummary() error = %v", err)
+	}
+
+	// 评审人投票触发的更新事件不会重新审查已审查的提交
+	event := &azuredevops.PullRequestEvent{EventType: azuredevops.EventPullRequestUpdated, Owner: "octo", Repo: "service", Number: 1, State: "active", BaseSHA: "base", HeadSHA: "head"}
+	if err := b.HandleAzureDevOpsPullRequest(context.Background(), event); err != nil {
+		t.Fatalf("HandleAzureDevOpsPullRequest() error = %v", err)
+	}
+	if len(platform.comments) != 1 || len(platform.statuses) != 0 {
+		t.Errorf("comments:\n%s\nstatuses: %v\nwant the reviewed commit skipped", platform.bodies(), platform.statuses)
+	}
+
+	event.HeadSHA = "new"
+	if err := b.HandleAzureDevOpsPullRequest(context.Background(), event); err == nil || !strings.Contains(err.Error(), "failed to compare") {
+		t.Errorf("HandleAzureDevOpsPullRequest() of a new commit error = %v, want the review to compare it", err)
+	}
+}
@@ -0,0 +1,227 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	RegisterProvider("anthropic", newAnthropicProvider)
+}
+
+// anthropicJSONInstruction is added to the system prompt in JSON mode, since
+// the Messages API has no response_format parameter
+const anthropicJSONInstruction = "Respond with a single valid JSON object only, without markdown code fences or any text before or after it."
+
+// anthropicRequest is the request body of the Messages API
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	MaxTokens   int                `json:"max_tokens"`
+	Temperature *float32           `json:"temperature,omitempty"`
+	TopP        *float32           `json:"top_p,omitempty"`
+}
+
+// anthropicMessage is a user or assistant turn of the conversation
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// anthropicResponse is the response body of the Messages API
+type anthropicResponse struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Role    string `json:"role"`
+	Model   string `json:"model"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      struct {
+		InputTokens  int `json:"input_tokens"`
+		OutputTokens int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// anthropicError is the error body of the Messages API
+type anthropicError struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicProvider talks to the Anthropic Messages API directly
+type anthropicProvider struct {
+	baseURL    string
+	apiKey     string
+	version    string
+	model      string
+	maxTokens  int
+	httpClient *http.Client
+}
+
+// newAnthropicProvider creates the provider for the Anthropic Messages API
+func newAnthropicProvider(cfg *config.Config) (Provider, error) {
+	if cfg.AnthropicAPIKey == "" || cfg.ClaudeModelName == "" {
+		return nil, nil
+	}
+	return &anthropicProvider{
+		baseURL:    strings.TrimRight(cfg.AnthropicBaseURL, "/"),
+		apiKey:     cfg.AnthropicAPIKey,
+		version:    cfg.AnthropicVersion,
+		model:      cfg.ClaudeModelName,
+		maxTokens:  cfg.ClaudeMaxTokens,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// Name returns the registry name of the provider
+func (p *anthropicProvider) Name() string {
+	return "anthropic"
+}
+
+// Model returns the configured model name
+func (p *anthropicProvider) Model() string {
+	return p.model
+}
+
+// Capabilities describes what the provider supports
+func (p *anthropicProvider) Capabilities() Capabilities {
+	return Capabilities{
+		// JSON 输出只能通过提示词约束
+		JSONMode:     false,
+		SystemPrompt: true,
+	}
+}
+
+// Complete sends the conversation to the /v1/messages endpoint
+func (p *anthropicProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	reqBody := p.buildRequest(messages, opts)
+
+	reqData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/v1/messages", bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", p.apiKey)
+	req.Header.Set("anthropic-version", p.version)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		message := string(respBody)
+		var apiErr anthropicError
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			message = fmt.Sprintf("%s: %s", apiErr.Error.Type, apiErr.Error.Message)
+		}
+		return nil, newAPIError(p.Name(), resp, message)
+	}
+
+	var msgResp anthropicResponse
+	if err := json.Unmarshal(respBody, &msgResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(respBody))
+	}
+
+	var content strings.Builder
+	for _, block := range msgResp.Content {
+		if block.Type == "text" {
+			content.WriteString(block.Text)
+		}
+	}
+	if content.Len() == 0 {
+		return nil, errors.New("Anthropic API returned no text content")
+	}
+
+	if msgResp.StopReason == "max_tokens" {
+		logrus.Warnf("Anthropic reply was truncated at %d tokens, consider raising CLAUDE_MAX_TOKENS", reqBody.MaxTokens)
+	}
+
+	model := msgResp.Model
+	if model == "" {
+		model = p.model
+	}
+
+	return &Completion{
+		Content:    content.String(),
+		Model:      model,
+		StopReason: msgResp.StopReason,
+		Usage: Usage{
+			InputTokens:  msgResp.Usage.InputTokens,
+			OutputTokens: msgResp.Usage.OutputTokens,
+		},
+	}, nil
+}
+
+// buildRequest converts the conversation to a Messages API request. System
+// messages are moved to the top-level system field and consecutive messages
+// of the same role are merged, as the API requires alternating turns.
+func (p *anthropicProvider) buildRequest(messages []LLMMessage, opts CompletionOptions) *anthropicRequest {
+	req := &anthropicRequest{
+		Model:     p.model,
+		MaxTokens: p.maxTokens,
+	}
+	// max_tokens 是必填字段
+	if opts.MaxTokens > 0 && (req.MaxTokens == 0 || opts.MaxTokens < req.MaxTokens) {
+		req.MaxTokens = opts.MaxTokens
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = 4000
+	}
+	// Anthropic 的 temperature 取值范围为 0-1，0 也需要发送
+	temperature := min(max(opts.Temperature, 0), 1)
+	req.Temperature = &temperature
+	if opts.TopP > 0 && opts.TopP < 1 {
+		topP := opts.TopP
+		req.TopP = &topP
+	}
+
+	var system []string
+	for _, message := range messages {
+		if message.Role == "system" {
+			system = append(system, message.Content)
+			continue
+		}
+		if n := len(req.Messages); n > 0 && req.Messages[n-1].Role == message.Role {
+			req.Messages[n-1].Content += "\n\n" + message.Content
+			continue
+		}
+		req.Messages = append(req.Messages, anthropicMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	if opts.JSONMode {
+		system = append(system, anthropicJSONInstruction)
+	}
+	req.System = strings.Join(system, "\n\n")
+
+	return req
+}
@@ -0,0 +1,143 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func newTestAnthropicProvider(t *testing.T, handler http.HandlerFunc) Provider {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	provider, err := newAnthropicProvider(&config.Config{
+		AnthropicAPIKey:  "test-key",
+		AnthropicBaseURL: server.URL,
+		AnthropicVersion: "2023-06-01",
+		ClaudeModelName:  "claude-test",
+		ClaudeMaxTokens:  1024,
+	})
+	if err != nil || provider == nil {
+		t.Fatalf("newAnthropicProvider() = %v, %v", provider, err)
+	}
+	return provider
+}
+
+func TestAnthropicProviderComplete(t *testing.T) {
+	provider := newTestAnthropicProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("path = %s, want /v1/messages", r.URL.Path)
+		}
+		if got := r.Header.Get("x-api-key"); got != "test-key" {
+			t.Errorf("x-api-key = %q", got)
+		}
+		if got := r.Header.Get("anthropic-version"); got != "2023-06-01" {
+			t.Errorf("anthropic-version = %q", got)
+		}
+
+		var req map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if _, ok := req["response_format"]; ok {
+			t.Errorf("request contains unsupported response_format field")
+		}
+		if req["model"] != "claude-test" || req["max_tokens"] != float64(1024) {
+			t.Errorf("model/max_tokens = %v/%v", req["model"], req["max_tokens"])
+		}
+		system, _ := req["system"].(string)
+		if !strings.HasPrefix(system, "You are a reviewer.") || !strings.Contains(system, "JSON") {
+			t.Errorf("system = %q", system)
+		}
+		messages, _ := req["messages"].([]any)
+		if len(messages) != 1 {
+			t.Fatalf("messages = %v, want a single user turn", messages)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{
+			"id": "msg_1",
+			"type": "message",
+			"role": "assistant",
+			"model": "claude-test-20250101",
+			"content": [{"type": "text", "text": "{\"lgtm\": "}, {"type": "text", "text": "true}"}],
+			"stop_reason": "end_turn",
+			"usage": {"input_tokens": 42, "output_tokens": 7}
+		}`))
+	})
+
+	completion, err := provider.Complete(context.Background(), []LLMMessage{
+		{Role: "system", Content: "You are a reviewer."},
+		{Role: "user", Content: "Review this patch."},
+	}, CompletionOptions{Temperature: 1, TopP: 1, JSONMode: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+
+	if completion.Content != `{"lgtm": true}` {
+		t.Errorf("Content = %q", completion.Content)
+	}
+	if completion.Model != "claude-test-20250101" || completion.StopReason != "end_turn" {
+		t.Errorf("Model/StopReason = %q/%q", completion.Model, completion.StopReason)
+	}
+	if completion.Usage.InputTokens != 42 || completion.Usage.OutputTokens != 7 {
+		t.Errorf("Usage = %+v", completion.Usage)
+	}
+}
+
+func TestAnthropicProviderError(t *testing.T) {
+	provider := newTestAnthropicProvider(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"type": "error", "error": {"type": "rate_limit_error", "message": "slow down"}}`))
+	})
+
+	_, err := provider.Complete(context.Background(), []LLMMessage{
+		{Role: "user", Content: "Review this patch."},
+	}, CompletionOptions{})
+	if err == nil {
+		t.Fatal("Complete() error = nil, want an error")
+	}
+	if !strings.Contains(err.Error(), "rate_limit_error") || !strings.Contains(err.Error(), "slow down") {
+		t.Errorf("Complete() error = %v", err)
+	}
+}
+
+func TestAnthropicProviderNotConfigured(t *testing.T) {
+	provider, err := newAnthropicProvider(&config.Config{ClaudeModelName: "claude-test"})
+	if provider != nil || err != nil {
+		t.Errorf("newAnthropicProvider() = %v, %v, want nil, nil", provider, err)
+	}
+}
+
+func TestAnthropicTemperature(t *testing.T) {
+	provider := &anthropicProvider{model: "claude-test", maxTokens: 1024}
+	tests := []struct {
+		temperature float32
+		want        float32
+	}{
+		// 0 表示确定性输出，不能被省略
+		{temperature: 0, want: 0},
+		{temperature: 0.3, want: 0.3},
+		{temperature: 1.5, want: 1},
+	}
+
+	for _, tt := range tests {
+		req := provider.buildRequest(nil, CompletionOptions{Temperature: tt.temperature})
+		data, err := json.Marshal(req)
+		if err != nil {
+			t.Fatalf("json.Marshal() error = %v", err)
+		}
+		var body map[string]any
+		_ = json.Unmarshal(data, &body)
+		if got, ok := body["temperature"].(float64); !ok || float32(got) != tt.want {
+			t.Errorf("temperature %v sent as %v, want %v", tt.temperature, body["temperature"], tt.want)
+		}
+	}
+}
@@ -1,17 +1,15 @@
 package chat
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"time"
 	"strings"
 	"github.com/eust-w/ai_code_reviewer/internal/config"
-	"github.com/sashabaranov/go-openai"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/tokenizer"
 	"github.com/sirupsen/logrus"
 )
 
@@ -23,13 +21,56 @@ type ReviewResult struct {
 	Suggestions   string `json:"suggestions"`   // 改进建议
 	Highlights    string `json:"highlights"`    // 代码亮点
 	Risks         string `json:"risks"`         // 潜在风险
+	Comments      []ReviewFinding `json:"comments"` // 行级问题
+
+	// Status 由审查流程设置，不来自模型输出
+	Status ReviewStatus `json:"-"`
+}
+
+// ReviewStatus tells how much of a patch was actually reviewed
+type ReviewStatus string
+
+const (
+	// ReviewStatusReviewed means the whole patch was reviewed
+	ReviewStatusReviewed ReviewStatus = "reviewed"
+	// ReviewStatusPartial means some chunks of the patch could not be reviewed
+	ReviewStatusPartial ReviewStatus = "partial"
+	// ReviewStatusFailed means nothing was reviewed, e.g. every model failed
+	ReviewStatusFailed ReviewStatus = "failed"
+)
+
+// ErrReviewFailed is returned when a patch could not be reviewed at all
+var ErrReviewFailed = errors.New("code review failed")
+
+// ReviewFinding is a single issue the model anchored to a line of the file
+type ReviewFinding struct {
+	// Line is the file-relative line number; for a range it is the first line
+	Line int `json:"line"`
+	// EndLine is the last line of a multi-line range, 0 for a single line
+	EndLine int `json:"end_line,omitempty"`
+	// Side is "new" for lines of the new file (default) or "old" for deleted lines
+	Side string `json:"side,omitempty"`
+	// Rule is a short identifier of the kind of issue, e.g. "unchecked-error".
+	// It tells findings on the same lines apart across reviews.
+	Rule string `json:"rule,omitempty"`
+	Body string `json:"body"`
+}
+
+// DiffSide converts the side reported by the model to a diff side
+func (f ReviewFinding) DiffSide() diff.Side {
+	switch strings.ToLower(strings.TrimSpace(f.Side)) {
+	case "old", "left", "-":
+		return diff.SideLeft
+	default:
+		return diff.SideRight
+	}
 }
 
 // LLMRequest 表示发送到 LLM API 的通用请求
 type LLMRequest struct {
 	Model    string      `json:"model"`
 	Messages []LLMMessage `json:"messages"`
-	Temperature float32   `json:"temperature,omitempty"`
+	Temperature *float32  `json:"temperature,omitempty"`
 	TopP       float32   `json:"top_p,omitempty"`
 	MaxTokens  int       `json:"max_tokens,omitempty"`
 	ResponseFormat *LLMResponseFormat `json:"response_format,omitempty"`
@@ -74,72 +115,205 @@ type DirectLLMResponse = LLMResponse
 
 // Chat handles interactions with LLM APIs (OpenAI, Azure OpenAI, Volc Deepseek V3)
 type Chat struct {
-	client openai.Client
 	config *config.Config
-	httpClient *http.Client
+	// providers 按配置的顺序依次尝试
+	providers []Provider
+	retry     RetryPolicy
+	// breakers 按提供者名称索引，WithConfig 返回的副本共享同一组熔断器
+	breakers map[string]*circuitBreaker
+	// tokenizer 对应首选提供者的模型，用于补丁分块和上下文预算
+	tokenizer tokenizer.Tokenizer
 }
 
 // NewChat creates a new Chat instance
 func NewChat(cfg *config.Config) (*Chat, error) {
-	// 创建 HTTP 客户端
-	httpClient := &http.Client{
-		Timeout: 60 * time.Second,
+	providers := make([]Provider, 0, len(cfg.LLMProviders))
+	for _, name := range cfg.LLMProviders {
+		provider, err := NewProvider(name, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create LLM provider %s: %w", name, err)
+		}
+		if provider == nil {
+			logrus.Debugf("LLM provider %s is not configured, skipping", name)
+			continue
+		}
+		logrus.Infof("Using LLM provider %s (timeout %s)", provider.Name(), providerTimeout(cfg, provider))
+		providers = append(providers, provider)
 	}
-	
-	// 检查直接 LLM 配置
-	if cfg.DirectLLMEndpoint != "" && cfg.DirectLLMModelID != "" && cfg.DirectLLMAPIKey != "" {
-		cfg.IsDirectLLM = true
-		logrus.Info("Using Direct LLM API")
-		return &Chat{
-			config: cfg,
-			httpClient: httpClient,
-		}, nil
+
+	if len(providers) == 0 {
+		return nil, errors.New("Either Direct LLM, LLM Proxy, or OpenAI API configuration is required")
 	}
-	
-	// 检查 LLM 代理配置
-	if cfg.LLMProxyEndpoint != "" && cfg.LLMProxyAPIKey != "" && 
-	   (cfg.ClaudeModelName != "" || cfg.DeepseekModelName != "") {
-		// 设置相应的标志
-		if cfg.ClaudeModelName != "" {
+
+	// 保留旧的标志，供分块等逻辑使用
+	for _, provider := range providers {
+		switch provider.Name() {
+		case "claude", "anthropic":
 			cfg.IsClaudeEnabled = true
-			logrus.Info("Using Claude via LLM Proxy")
-		}
-		if cfg.DeepseekModelName != "" {
+		case "deepseek":
 			cfg.IsDeepseekEnabled = true
-			logrus.Info("Using Deepseek via LLM Proxy")
+		case "direct":
+			cfg.IsDirectLLM = true
 		}
-		return &Chat{
-			config: cfg,
-			httpClient: httpClient,
-		}, nil
 	}
-	
-	// 检查 OpenAI 配置
-	if cfg.OpenAIAPIKey == "" {
-		return nil, errors.New("Either Direct LLM, LLM Proxy, or OpenAI API configuration is required")
+
+	breakers := make(map[string]*circuitBreaker, len(providers))
+	for _, provider := range providers {
+		breakers[provider.Name()] = newCircuitBreaker(cfg.LLMBreakerThreshold, cfg.LLMBreakerCooldown)
 	}
 
-	clientConfig := openai.DefaultConfig(cfg.OpenAIAPIKey)
-	clientConfig.BaseURL = cfg.OpenAIAPIEndpoint
+	tk := tokenizer.ForModel(providerModel(providers[0]))
+	logrus.Infof("Counting tokens with %s", tk.Name())
+
+	return &Chat{
+		config:    cfg,
+		providers: providers,
+		retry:     newRetryPolicy(cfg),
+		breakers:  breakers,
+		tokenizer: tk,
+	}, nil
+}
 
-	// Configure for Azure OpenAI if needed
-	if cfg.IsAzure {
-		clientConfig = openai.DefaultAzureConfig(
-			cfg.OpenAIAPIKey,
-			fmt.Sprintf("%s/%s", cfg.OpenAIAPIEndpoint, cfg.AzureDeployment),
-		)
-		clientConfig.APIVersion = cfg.AzureAPIVersion
-		clientConfig.AzureModelMapperFunc = func(model string) string {
-			return cfg.AzureDeployment
+// providerModel returns the model name of the provider, or its name if the
+// provider does not expose one
+func providerModel(provider Provider) string {
+	if mp, ok := provider.(ModelProvider); ok && mp.Model() != "" {
+		return mp.Model()
+	}
+	return provider.Name()
+}
+
+// Tokenizer returns the tokenizer matching the primary provider's model
+func (c *Chat) Tokenizer() tokenizer.Tokenizer {
+	if c.tokenizer == nil {
+		return tokenizer.Estimator{}
+	}
+	return c.tokenizer
+}
+
+// ChunkTokenBudget returns the maximum number of tokens of a patch chunk
+// sent in a single review request
+func (c *Chat) ChunkTokenBudget() int {
+	maxTokens := 4000 // 默认值
+	if c.config.IsClaudeEnabled {
+		maxTokens = c.config.ClaudeMaxTokens
+	}
+
+	// 已知上下文窗口时，为提示词和回复保留一半空间
+	if len(c.providers) > 0 {
+		if window := c.providers[0].Capabilities().MaxContextTokens; window > 0 && maxTokens > window/2 {
+			maxTokens = window / 2
 		}
 	}
+	return maxTokens
+}
 
-	client := openai.NewClientWithConfig(clientConfig)
-	return &Chat{
-		client: *client,
-		config: cfg,
-		httpClient: httpClient,
-	}, nil
+// providerTimeout returns the timeout for a single call to the provider.
+// Explicit per-provider settings win over the provider's own default, which
+// wins over the global LLM timeout.
+func providerTimeout(cfg *config.Config, provider Provider) time.Duration {
+	if timeout, ok := cfg.LLMProviderTimeouts[provider.Name()]; ok {
+		return timeout
+	}
+	if tp, ok := provider.(TimeoutProvider); ok && tp.DefaultTimeout() > 0 {
+		return tp.DefaultTimeout()
+	}
+	if cfg.LLMTimeout > 0 {
+		return cfg.LLMTimeout
+	}
+	return 60 * time.Second
+}
+
+// complete sends the messages to each provider in order until one succeeds.
+// Each provider is retried according to the retry policy; providers whose
+// circuit breaker is open are skipped.
+func (c *Chat) complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, Provider, error) {
+	errs := make([]error, 0, len(c.providers))
+	for _, provider := range c.providers {
+		breaker := c.breakers[provider.Name()]
+		if breaker != nil && !breaker.Allow() {
+			logrus.Warnf("%s API circuit breaker is open, skipping", provider.Name())
+			errs = append(errs, fmt.Errorf("%s: circuit breaker open", provider.Name()))
+			continue
+		}
+
+		completion, err := c.completeWithRetry(ctx, provider, breaker, messages, opts)
+		if err == nil {
+			return completion, provider, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+
+		// 调用方已取消时不再尝试其他模型
+		if ctx.Err() != nil {
+			break
+		}
+		logrus.Warnf("%s API failed: %v, trying next model", provider.Name(), err)
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// completeWithRetry calls a single provider, retrying retryable errors with
+// backoff
+func (c *Chat) completeWithRetry(ctx context.Context, provider Provider, breaker *circuitBreaker, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	timeout := providerTimeout(c.config, provider)
+	if breaker != nil {
+		// 试探调用没有成功或失败结果时（取消、请求本身被拒绝）也要结束试探
+		defer breaker.Release()
+	}
+	for attempt := 1; ; attempt++ {
+		logrus.Infof("Attempting to use %s API for code review (attempt %d/%d)", provider.Name(), attempt, c.retry.MaxAttempts)
+		modelStart := time.Now()
+
+		callCtx, cancel := context.WithTimeout(ctx, timeout)
+		completion, err := provider.Complete(callCtx, messages, opts)
+		cancel()
+		if err == nil {
+			if breaker != nil {
+				breaker.Success()
+			}
+			logrus.Infof("%s API call successful in %s (model: %s, tokens: %d in / %d out)",
+				provider.Name(), time.Since(modelStart), completion.Model,
+				completion.Usage.InputTokens, completion.Usage.OutputTokens)
+			return completion, nil
+		}
+		// 调用方取消不代表提供者不可用
+		if breaker != nil && ctx.Err() == nil && tripsBreaker(err) {
+			breaker.Failure()
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		if !isRetryable(err) {
+			logrus.Warnf("%s API returned a non-retryable error: %v", provider.Name(), err)
+			return nil, err
+		}
+		if attempt >= c.retry.MaxAttempts {
+			return nil, err
+		}
+		delay, ok := c.retry.Backoff(attempt, err)
+		if !ok {
+			logrus.Warnf("%s API asked to retry later than %s, giving up on it", provider.Name(), c.retry.MaxDelay)
+			return nil, err
+		}
+		if breaker != nil && !breaker.Allow() {
+			return nil, fmt.Errorf("circuit breaker opened: %w", err)
+		}
+
+		logrus.Warnf("%s API error: %v, retrying in %s", provider.Name(), err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WithConfig returns a copy of the Chat that uses cfg for prompts and model
+// parameters, e.g. a global configuration merged with repository settings.
+// The underlying API clients are shared.
+func (c *Chat) WithConfig(cfg *config.Config) *Chat {
+	clone := *c
+	clone.config = cfg
+	return &clone
 }
 
 // generatePrompt creates the prompt for code review
@@ -169,16 +343,26 @@ You MUST provide your feedback in a strict JSON format with the following struct
   "summary": string, // A concise summary of the code changes
   "suggestions": string, // Specific suggestions for improvements
   "highlights": string, // Positive aspects or well-implemented parts of the code
-  "risks": string // IMPORTANT: Keep this to a SINGLE, SHORT sentence (max 100 chars) describing the most critical risk only
+  "risks": string, // IMPORTANT: Keep this to a SINGLE, SHORT sentence (max 100 chars) describing the most critical risk only
+  "comments": [ // Line-level findings, one entry per concrete issue. Use an empty array if there are none.
+    {
+      "line": number, // Line number in the file, derived from the "@@ -a,b +c,d @@" hunk headers
+      "end_line": number, // Optional last line when the finding spans several lines
+      "side": "new" | "old", // "new" for added or unchanged lines (numbered from +c), "old" for deleted lines (numbered from -a)
+      "rule": string, // A short kebab-case identifier of the kind of issue, e.g. "unchecked-error" or "sql-injection"
+      "body": string // What is wrong and how to fix it. You can use markdown syntax in this string.
+    }
+  ]
 }
 
 IMPORTANT REQUIREMENTS:
 1. Your response MUST be a valid JSON object and NOTHING ELSE.
 2. Do NOT include any text before or after the JSON object.
 3. All fields MUST be present in your response.
-4. NEVER leave any field empty or null. If you have nothing to say for a field, provide a message like "No specific suggestions" or "No risks identified".
+4. NEVER leave any string field empty or null. If you have nothing to say for a field, provide a message like "No specific suggestions" or "No risks identified".
 5. Provide detailed and specific feedback for each field, with examples from the code where relevant, EXCEPT for the 'risks' field which must be a single, short sentence.
 6. Make sure your JSON is properly formatted and can be parsed by a standard JSON parser.
+7. Only reference lines that are part of the patch in "comments". Issues that cannot be tied to a changed line belong in "suggestions".
 
 Failure to follow these instructions will result in your review being rejected.
 `, languageInstruction)
@@ -189,243 +373,6 @@ Failure to follow these instructions will result in your review being rejected.
 		patch)
 }
 
-// extractNestedJSON 处理嵌套的 JSON 结构
-func extractNestedJSON(content string) string {
-	// 记录原始内容
-	logrus.Infof("Raw LLM response: %s", content)
-	
-	// 定义可能的嵌套结构
-	type NestedContent struct {
-		Value  map[string]interface{} `json:"value"`
-		Data   map[string]interface{} `json:"data"`
-		Input  map[string]interface{} `json:"input"`
-		Result map[string]interface{} `json:"result"`
-	}
-	
-	// 解析嵌套结构
-	var nested NestedContent
-	if err := json.Unmarshal([]byte(content), &nested); err != nil {
-		logrus.Warnf("Failed to parse nested JSON: %v, returning raw content", err)
-		return content
-	}
-	
-	// 检查哪个嵌套字段有值，并提取它
-	var innerContent map[string]interface{}
-	if nested.Value != nil {
-		logrus.Info("Extracted 'value' field from LLM response")
-		innerContent = nested.Value
-	} else if nested.Data != nil {
-		logrus.Info("Extracted 'data' field from LLM response")
-		innerContent = nested.Data
-	} else if nested.Input != nil {
-		logrus.Info("Extracted 'input' field from LLM response")
-		innerContent = nested.Input
-	} else if nested.Result != nil {
-		logrus.Info("Extracted 'result' field from LLM response")
-		innerContent = nested.Result
-	} else {
-		logrus.Warn("No nested field found in LLM response, returning raw content")
-		return content
-	}
-	
-	// 将提取的内容转换回 JSON
-	processedContent, err := json.Marshal(innerContent)
-	if err != nil {
-		logrus.Warnf("Failed to marshal extracted content: %v, returning raw content", err)
-		return content
-	}
-	
-	logrus.Infof("Processed LLM response: %s", string(processedContent))
-	return string(processedContent)
-}
-
-// callLLMAPI 调用通用 LLM API
-func (c *Chat) callLLMAPI(ctx context.Context, endpoint, apiKey, modelName, prompt string) (string, error) {
-	// 创建请求体
-	reqBody := LLMRequest{
-		Model: modelName,
-		Messages: []LLMMessage{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Temperature: c.config.Temperature,
-		TopP:        c.config.TopP,
-		ResponseFormat: &LLMResponseFormat{
-			Type: "json_object",
-		},
-	}
-	
-	if c.config.MaxTokens > 0 {
-		reqBody.MaxTokens = c.config.MaxTokens
-	}
-	
-	// 将请求体转换为 JSON
-	reqData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-	
-	// 创建 HTTP 请求
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		endpoint,
-		bytes.NewBuffer(reqData),
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", apiKey))
-	
-	// 发送请求
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	// 读取响应体
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-	
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API returned non-200 status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-	
-	// 解析响应
-	var llmResp LLMResponse
-	if err := json.Unmarshal(respBody, &llmResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(respBody))
-	}
-	
-	// 检查响应是否有内容
-	if len(llmResp.Choices) == 0 {
-		return "", errors.New("API returned empty choices")
-	}
-	
-	// 获取原始内容
-	rawContent := llmResp.Choices[0].Message.Content
-	
-	// 处理嵌套的 JSON 结构
-	return extractNestedJSON(rawContent), nil
-}
-
-// callClaudeAPI 调用 Claude API
-func (c *Chat) callClaudeAPI(ctx context.Context, prompt string) (string, error) {
-	// 创建请求体
-	reqBody := LLMRequest{
-		Model: c.config.ClaudeModelName,
-		Messages: []LLMMessage{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		},
-		Temperature: c.config.Temperature,
-		TopP:        c.config.TopP,
-		ResponseFormat: &LLMResponseFormat{
-			Type: "json_object",
-		},
-	}
-	
-	// 设置最大 token 数
-	reqBody.MaxTokens = c.config.ClaudeMaxTokens
-	
-	// 将请求体转换为 JSON
-	reqData, err := json.Marshal(reqBody)
-	if err != nil {
-		return "", fmt.Errorf("failed to marshal request: %w", err)
-	}
-	
-	// 创建 HTTP 请求
-	req, err := http.NewRequestWithContext(
-		ctx,
-		"POST",
-		c.config.LLMProxyEndpoint,
-		bytes.NewBuffer(reqData),
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
-	}
-	
-	// 设置请求头
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.LLMProxyAPIKey))
-	
-	// 发送请求
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-	
-	// 读取响应体
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response: %w", err)
-	}
-	
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("API returned non-200 status code: %d, body: %s", resp.StatusCode, string(respBody))
-	}
-	
-	// 解析响应
-	var llmResp LLMResponse
-	if err := json.Unmarshal(respBody, &llmResp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(respBody))
-	}
-	
-	// 检查响应是否有内容
-	if len(llmResp.Choices) == 0 {
-		return "", errors.New("API returned empty choices")
-	}
-	
-	// 获取原始内容
-	rawContent := llmResp.Choices[0].Message.Content
-	
-	// 处理嵌套的 JSON 结构
-	return extractNestedJSON(rawContent), nil
-}
-
-// estimateTokenCount 估算文本的 token 数量（糊略估计）
-func estimateTokenCount(text string) int {
-	// 一个简单的估算：平均每 4 个字符约为 1 个 token
-	return len(text) / 4
-}
-
-// callDirectLLMAPI 调用直接 LLM API
-func (c *Chat) callDirectLLMAPI(ctx context.Context, prompt string) (string, error) {
-	// 使用通用 LLM API 调用函数
-	return c.callLLMAPI(
-		ctx,
-		c.config.DirectLLMEndpoint, 
-		c.config.DirectLLMAPIKey, 
-		c.config.DirectLLMModelID, 
-		prompt,
-	)
-}
-
-// callDeepseekAPI 调用 Deepseek API
-func (c *Chat) callDeepseekAPI(ctx context.Context, prompt string) (string, error) {
-	// 使用通用 LLM API 调用函数
-	return c.callLLMAPI(
-		ctx,
-		c.config.LLMProxyEndpoint, 
-		c.config.LLMProxyAPIKey, 
-		c.config.DeepseekModelName, 
-		prompt,
-	)
-}
-
 // CodeReview performs a code review on the given patch
 func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, error) {
 	if patch == "" {
@@ -437,6 +384,7 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 			Suggestions:   "",
 			Highlights:    "",
 			Risks:         "",
+			Status:        ReviewStatusReviewed,
 		}, nil
 	}
 
@@ -444,19 +392,13 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 	patchSize := len(patch)
 	logrus.Infof("Starting code review for patch of size %d bytes", patchSize)
 	
-	// 确定最大 token 限制
-	maxTokens := 4000 // 默认值
-	if c.config.IsClaudeEnabled {
-		maxTokens = c.config.ClaudeMaxTokens
-	}
-	
-	// 分割补丁
-	patches := splitPatch(patch, maxTokens)
-	logrus.Debugf("Split patch into %d chunks", len(patches))
+	// 按 hunk 分割补丁
+	chunks := splitPatch(patch, c.ChunkTokenBudget(), c.Tokenizer())
+	logrus.Debugf("Split patch into %d chunks", len(chunks))
 	
 	// 如果有多个块，并发审查并合并结果
-	if len(patches) > 1 {
-		chunkCount := len(patches)
+	if len(chunks) > 1 {
+		chunkCount := len(chunks)
 		logrus.Infof("Performing multi-chunk code review with %d chunks", chunkCount)
 		
 		// 使用通道收集结果
@@ -467,8 +409,8 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 		}, chunkCount)
 		
 		// 并发处理所有块
-		for i, p := range patches {
-			chunkSize := len(p)
+		for i, chunk := range chunks {
+			chunkSize := len(chunk.Patch)
 			chunkIndex := i // 创建一个副本以在闭包中使用
 			logrus.Infof("Starting review of chunk %d/%d (size: %d bytes)", chunkIndex+1, chunkCount, chunkSize)
 			
@@ -492,7 +434,7 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 				}{idx, chunkResult, err}
 				
 				logrus.Infof("Chunk %d/%d review completed in %s", idx+1, chunkCount, time.Since(chunkStart))
-			}(chunkIndex, p)
+			}(chunkIndex, chunk.Patch)
 		}
 		
 		// 收集所有结果
@@ -501,38 +443,14 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 			r := <-resultChan
 			
 			if r.err != nil {
-				logrus.Warnf("Error reviewing chunk %d: %v, attempting to retry", r.index+1, r.err)
-				
-				// 尝试重试最多3次
-				var retryResult ReviewResult
-				var retryErr error
-				var retrySuccess bool = false
-				
-				for retryCount := 0; retryCount < 3; retryCount++ {
-					logrus.Infof("Retry %d/3 for chunk %d", retryCount+1, r.index+1)
-					retryResult, retryErr = c.reviewSingleChunk(ctx, c.generatePrompt(patches[r.index]))
-					
-					if retryErr == nil {
-						logrus.Infof("Retry %d/3 successful for chunk %d", retryCount+1, r.index+1)
-						retrySuccess = true
-						break
-					}
-					
-					logrus.Warnf("Retry %d/3 failed for chunk %d: %v", retryCount+1, r.index+1, retryErr)
-					time.Sleep(2 * time.Second) // 等待一下再重试
-				}
-				
-				if retrySuccess {
-					results[r.index] = retryResult
-					logrus.Infof("Successfully retried chunk %d, LGTM=%v, comment length=%d", 
-						r.index+1, retryResult.LGTM, len(retryResult.ReviewComment))
-				} else {
-					logrus.Errorf("All retries failed for chunk %d", r.index+1)
-					results[r.index] = ReviewResult{
-						LGTM: true,
-					}
+				// 重试已在 complete 中按统一的策略完成
+				logrus.Errorf("Failed to review chunk %d: %v", r.index+1, r.err)
+				results[r.index] = ReviewResult{
+					Status: ReviewStatusFailed,
 				}
 			} else {
+				// 重叠行可能在相邻块中都被报告，只保留负责该行的块的结果
+				r.result.Comments = ownedFindings(chunks, r.index, r.result.Comments)
 				results[r.index] = r.result
 				logrus.Infof("Chunk %d/%d result collected: LGTM=%v, comment length=%d", 
 					r.index+1, chunkCount, r.result.LGTM, len(r.result.ReviewComment))
@@ -541,7 +459,10 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 		
 		// 合并所有块的结果
 		mergedResult := mergeReviewResults(results)
-		logrus.Debugf("Code review completed in %s", time.Since(start))
+		logrus.Debugf("Code review completed in %s (status: %s)", time.Since(start), mergedResult.Status)
+		if mergedResult.Status == ReviewStatusFailed {
+			return mergedResult, fmt.Errorf("%w: all %d chunks failed", ErrReviewFailed, chunkCount)
+		}
 		return mergedResult, nil
 	}
 	
@@ -551,15 +472,34 @@ func (c *Chat) CodeReview(ctx context.Context, patch string) (ReviewResult, erro
 }
 	
 // splitPatch 将大型补丁分割成多个小块
-func splitPatch(patch string, maxTokens int) []string {
-	// 估算整个补丁的 token 数量
-	totalTokens := estimateTokenCount(patch)
+// 按文件和 hunk 分割，每块重复文件头和 hunk 头；无法解析时退回到按行分割
+func splitPatch(patch string, maxTokens int, tk tokenizer.Tokenizer) []diff.Chunk {
+	// 计算整个补丁的 token 数量
+	totalTokens := tk.CountTokens(patch)
 	
 	// 如果补丁足够小，直接返回
 	if totalTokens <= maxTokens {
-		return []string{patch}
+		return []diff.Chunk{{Patch: patch}}
 	}
 	
+	chunks, err := diff.Split(patch, maxTokens, tk, diff.DefaultOverlapLines)
+	if err == nil && len(chunks) > 0 {
+		return chunks
+	}
+	if err != nil {
+		logrus.Warnf("Failed to parse patch for splitting: %v, splitting by lines", err)
+	}
+	
+	parts := splitPatchLines(patch, maxTokens, tk)
+	chunks = make([]diff.Chunk, len(parts))
+	for i, part := range parts {
+		chunks[i] = diff.Chunk{Patch: part}
+	}
+	return chunks
+}
+
+// splitPatchLines 按文件和行分割无法解析的补丁
+func splitPatchLines(patch string, maxTokens int, tk tokenizer.Tokenizer) []string {
 	// 将补丁按文件分割
 	files := strings.Split(patch, "diff --git")
 	
@@ -581,7 +521,7 @@ func splitPatch(patch string, maxTokens int) []string {
 	currentTokens := 0
 	
 	for _, file := range files {
-		fileTokens := estimateTokenCount(file)
+		fileTokens := tk.CountTokens(file)
 		
 		// 如果单个文件超过限制，需要进一步分割
 		if fileTokens > maxTokens {
@@ -598,7 +538,7 @@ func splitPatch(patch string, maxTokens int) []string {
 			tempTokens := 0
 			
 			for _, line := range lines {
-				lineTokens := estimateTokenCount(line + "\n")
+				lineTokens := tk.CountTokens(line + "\n")
 				
 				if tempTokens + lineTokens > maxTokens {
 					result = append(result, tempChunk)
@@ -634,21 +574,56 @@ func splitPatch(patch string, maxTokens int) []string {
 	return result
 }
 
+// ownedFindings 过滤第 index 块的行级问题：其他块负责的行被丢弃，
+// 不属于任何块的行保留，交由调用方处理
+func ownedFindings(chunks []diff.Chunk, index int, findings []ReviewFinding) []ReviewFinding {
+	if len(chunks[index].Ranges) == 0 {
+		return findings
+	}
+	
+	kept := make([]ReviewFinding, 0, len(findings))
+	for _, finding := range findings {
+		side := finding.DiffSide()
+		if chunks[index].Contains("", finding.Line, side) {
+			kept = append(kept, finding)
+			continue
+		}
+		owned := false
+		for _, chunk := range chunks {
+			if chunk.Contains("", finding.Line, side) {
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			kept = append(kept, finding)
+		}
+	}
+	return kept
+}
+
 // mergeReviewResults 合并多个审查结果
 func mergeReviewResults(results []ReviewResult) ReviewResult {
 	if len(results) == 0 {
-		return ReviewResult{LGTM: true, ReviewComment: ""}
+		return ReviewResult{LGTM: true, ReviewComment: "", Status: ReviewStatusReviewed}
 	}
 	
 	// 默认认为代码没问题，除非有任何一个审查结果表明有问题
 	lgtm := true
+	failed := 0
 	comments := []string{}
 	summaries := []string{}
 	suggestions := []string{}
 	highlights := []string{}
 	risks := []string{}
+	findings := []ReviewFinding{}
 	
 	for _, result := range results {
+		// 失败的块没有被审查，不能计入通过
+		if result.Status == ReviewStatusFailed {
+			failed++
+			continue
+		}
 		if !result.LGTM {
 			lgtm = false
 		}
@@ -669,16 +644,33 @@ func mergeReviewResults(results []ReviewResult) ReviewResult {
 		if result.Risks != "" {
 			risks = append(risks, result.Risks)
 		}
+		findings = append(findings, result.Comments...)
+	}
+	
+	status := ReviewStatusReviewed
+	for _, result := range results {
+		if result.Status == ReviewStatusPartial {
+			status = ReviewStatusPartial
+		}
+	}
+	switch {
+	case failed == len(results):
+		status = ReviewStatusFailed
+		lgtm = false
+	case failed > 0:
+		status = ReviewStatusPartial
 	}
 	
 	// 生成最终的审查结果
 	return ReviewResult{
+		Status:        status,
 		LGTM:          lgtm,
 		ReviewComment: strings.Join(comments, "\n\n---\n\n"),
 		Summary:       strings.Join(summaries, "\n\n"),
 		Suggestions:   strings.Join(suggestions, "\n\n"),
 		Highlights:    strings.Join(highlights, "\n\n"),
 		Risks:         strings.Join(risks, "\n\n"),
+		Comments:      findings,
 	}
 }
 
@@ -688,104 +680,31 @@ func (c *Chat) reviewSingleChunk(ctx context.Context, prompt string) (ReviewResu
 	promptSize := len(prompt)
 	logrus.Infof("Starting single chunk review (prompt size: %d bytes)", promptSize)
 	
-	var content string
-	var err error
-	var modelUsed string
-	
-	// 尝试按优先级使用不同的模型
-	
-	// 1. 首先尝试使用 Claude
-	if c.config.IsClaudeEnabled {
-		logrus.Info("Attempting to use Claude API for code review")
-		modelStart := time.Now()
-		content, err = c.callClaudeAPI(ctx, prompt)
-		if err == nil {
-			// 调用成功，处理结果
-			modelUsed = "Claude"
-			logrus.Infof("Claude API call successful in %s", time.Since(modelStart))
-			goto ProcessResult
-		}
-		logrus.Warnf("Claude API error: %v, trying next model", err)
-	}
-	
-	// 2. 如果 Claude 失败，尝试使用 Deepseek
-	if c.config.IsDeepseekEnabled {
-		logrus.Info("Attempting to use Deepseek API for code review")
-		modelStart := time.Now()
-		content, err = c.callDeepseekAPI(ctx, prompt)
-		if err == nil {
-			// 调用成功，处理结果
-			modelUsed = "Deepseek"
-			logrus.Infof("Deepseek API call successful in %s", time.Since(modelStart))
-			goto ProcessResult
-		}
-		logrus.Warnf("Deepseek API error: %v, trying next model", err)
-	}
+	// 按配置的顺序尝试各个模型
+	completion, _, err := c.complete(ctx, []LLMMessage{
+		{
+			Role:    "user",
+			Content: prompt,
+		},
+	}, CompletionOptions{
+		Temperature: c.config.Temperature,
+		TopP:        c.config.TopP,
+		MaxTokens:   c.config.MaxTokens,
+		JSONMode:    true,
+	})
 	
-	// 3. 如果前两个失败，尝试使用直接 LLM
-	if c.config.IsDirectLLM {
-		logrus.Info("Attempting to use Direct LLM API for code review")
-		modelStart := time.Now()
-		content, err = c.callDirectLLMAPI(ctx, prompt)
-		if err == nil {
-			// 调用成功，处理结果
-			modelUsed = "Direct LLM"
-			logrus.Infof("Direct LLM API call successful in %s", time.Since(modelStart))
-			goto ProcessResult
-		}
-		logrus.Warnf("Direct LLM API error: %v, trying next model", err)
+	// 如果所有模型都失败了，返回错误信息
+	if err != nil {
+		logrus.Errorf("All LLM models failed, unable to perform code review: %v", err)
+		return ReviewResult{Status: ReviewStatusFailed}, fmt.Errorf("%w: %w", ErrReviewFailed, err)
 	}
-	
-	// 4. 最后尝试使用 OpenAI API
-	if c.config.OpenAIAPIKey != "" {
-		logrus.Info("Attempting to use OpenAI API for code review")
-		modelStart := time.Now()
-		
-		req := openai.ChatCompletionRequest{
-			Model: c.config.Model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: prompt,
-				},
-			},
-			Temperature: c.config.Temperature,
-			TopP:        c.config.TopP,
-			ResponseFormat: &openai.ChatCompletionResponseFormat{
-				Type: openai.ChatCompletionResponseFormatTypeJSONObject,
-			},
-		}
-		
-		if c.config.MaxTokens > 0 {
-			req.MaxTokens = c.config.MaxTokens
-		}
-
-		logrus.Debugf("Sending request to OpenAI API with model: %s", c.config.Model)
-		resp, err := c.client.CreateChatCompletion(ctx, req)
-		if err != nil {
-			logrus.Errorf("OpenAI API error: %v", err)
-			return ReviewResult{}, fmt.Errorf("OpenAI API error: %w", err)
-		}
-		
-		if len(resp.Choices) == 0 {
-			logrus.Warn("OpenAI API returned empty choices")
-			return ReviewResult{LGTM: true, ReviewComment: ""}, nil
-		}
-		
-		content = resp.Choices[0].Message.Content
-		modelUsed = fmt.Sprintf("OpenAI (%s)", c.config.Model)
-		logrus.Infof("OpenAI API call successful in %s", time.Since(modelStart))
+	if completion.Content == "" {
+		logrus.Errorf("%s returned an empty reply, unable to perform code review", completion.Model)
+		return ReviewResult{Status: ReviewStatusFailed}, fmt.Errorf("%w: empty reply from %s", ErrReviewFailed, completion.Model)
 	}
+	content := completion.Content
 	
-	// 如果所有模型都失败了，返回错误信息
-	if content == "" {
-		logrus.Error("All LLM models failed, unable to perform code review")
-		return ReviewResult{LGTM: true}, nil
-	}
-
-	// 处理结果的标签
-ProcessResult:
-	logrus.Infof("Code review completed in %s using model: %s", time.Since(start), modelUsed)
+	logrus.Infof("Code review completed in %s using model: %s", time.Since(start), completion.Model)
 	logrus.Infof("Raw response content: %s", content)
 	
 	// 尝试从响应中提取有用的内容
@@ -821,13 +740,12 @@ ProcessResult:
 					// 尝试解析嵌套结构
 					if err := json.Unmarshal([]byte(jsonContent), &nestedResult); err != nil {
 						logrus.Warnf("Failed to parse extracted JSON: %v", err)
-						// Fallback to using the raw content as the review comment
-						return ReviewResult{LGTM: true}, nil
+						return ReviewResult{Status: ReviewStatusFailed}, fmt.Errorf("%w: unparseable reply: %v", ErrReviewFailed, err)
 					}
 				}
 			} else {
-				// 如果无法提取 JSON，使用原始内容作为评论
-				return ReviewResult{LGTM: true}, nil
+				// 无法提取 JSON，说明模型没有按要求返回
+				return ReviewResult{Status: ReviewStatusFailed}, fmt.Errorf("%w: reply contains no JSON object", ErrReviewFailed)
 			}
 		}
 		
@@ -847,6 +765,7 @@ ProcessResult:
 		}
 	}
 	
+	result.Status = ReviewStatusReviewed
 	logrus.Infof("Review result: LGTM=%v, comment length=%d", result.LGTM, len(result.ReviewComment))
 	return result, nil
 }
@@ -0,0 +1,98 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/tokenizer"
+)
+
+func TestMergeReviewResultsStatus(t *testing.T) {
+	reviewed := ReviewResult{LGTM: true, Status: ReviewStatusReviewed}
+	issues := ReviewResult{LGTM: false, Status: ReviewStatusReviewed}
+	failed := ReviewResult{Status: ReviewStatusFailed}
+
+	tests := []struct {
+		name       string
+		results    []ReviewResult
+		wantStatus ReviewStatus
+		wantLGTM   bool
+	}{
+		{name: "all reviewed", results: []ReviewResult{reviewed, reviewed}, wantStatus: ReviewStatusReviewed, wantLGTM: true},
+		{name: "issues found", results: []ReviewResult{reviewed, issues}, wantStatus: ReviewStatusReviewed, wantLGTM: false},
+		{name: "some chunks failed", results: []ReviewResult{reviewed, failed}, wantStatus: ReviewStatusPartial, wantLGTM: true},
+		{name: "all chunks failed", results: []ReviewResult{failed, failed}, wantStatus: ReviewStatusFailed, wantLGTM: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeReviewResults(tt.results)
+			if got.Status != tt.wantStatus || got.LGTM != tt.wantLGTM {
+				t.Errorf("mergeReviewResults() status/LGTM = %s/%v, want %s/%v", got.Status, got.LGTM, tt.wantStatus, tt.wantLGTM)
+			}
+		})
+	}
+}
+
+func TestSplitPatch(t *testing.T) {
+	file := func(name string, lines int) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "diff --git a/%s b/%s\n@@ -1,%d +1,%d @@\n", name, name, lines, lines)
+		for i := 0; i < lines; i++ {
+			b.WriteString("+代码行\n")
+		}
+		return b.String()
+	}
+	tk := tokenizer.Estimator{}
+
+	small := file("a.go", 2) + file("b.go", 2)
+	if chunks := splitPatch(small, 1000, tk); len(chunks) != 1 {
+		t.Fatalf("splitPatch() of a small patch = %d chunks, want 1", len(chunks))
+	}
+
+	// 中文按字符计数，字节数/4 的旧估算会低估一半以上
+	large := file("a.go", 40) + file("b.go", 40)
+	chunks := splitPatch(large, 200, tk)
+	if len(chunks) < 2 {
+		t.Fatalf("splitPatch() = %d chunks, want at least 2", len(chunks))
+	}
+	if !strings.HasPrefix(chunks[1].Patch, "diff --git a/a.go b/a.go\n@@ ") && !strings.HasPrefix(chunks[1].Patch, "diff --git a/b.go b/b.go\n@@ ") {
+		t.Errorf("chunk does not repeat the file and hunk header:\n%s", chunks[1].Patch)
+	}
+	for i, chunk := range chunks {
+		if got := tk.CountTokens(chunk.Patch); got > 200 {
+			t.Errorf("chunk %d has %d tokens, want <= 200", i, got)
+		}
+	}
+}
+
+func TestOwnedFindings(t *testing.T) {
+	chunks := []diff.Chunk{
+		{Ranges: []diff.Range{{NewStart: 1, NewEnd: 10}}},
+		{Ranges: []diff.Range{{NewStart: 11, NewEnd: 20}}},
+	}
+	findings := []ReviewFinding{
+		{Line: 9, Body: "overlap line owned by the first chunk"},
+		{Line: 12, Body: "own line"},
+		{Line: 40, Body: "outside the diff"},
+	}
+
+	got := ownedFindings(chunks, 1, findings)
+	if len(got) != 2 || got[0].Line != 12 || got[1].Line != 40 {
+		t.Errorf("ownedFindings() = %+v, want lines 12 and 40", got)
+	}
+}
+
+func TestChunkTokenBudget(t *testing.T) {
+	c := newTestChat(&fakeProvider{name: "local", window: 2048})
+	if got := c.ChunkTokenBudget(); got != 1024 {
+		t.Errorf("ChunkTokenBudget() = %d, want half of the context window", got)
+	}
+
+	c = newTestChat(&fakeProvider{name: "hosted"})
+	if got := c.ChunkTokenBudget(); got != 4000 {
+		t.Errorf("ChunkTokenBudget() = %d, want 4000", got)
+	}
+}
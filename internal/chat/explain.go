@@ -0,0 +1,49 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Explain asks the models to explain a code snippet of a file, e.g. for the
+// "/ai explain" command. question is what the user asked, it may be empty.
+// The reply is markdown.
+func (c *Chat) Explain(ctx context.Context, path, snippet, question string) (string, error) {
+	language := strings.ToLower(c.config.Language)
+
+	var prompt string
+	if language == "english" {
+		prompt = fmt.Sprintf("Explain what the following code from `%s` does, how it works and anything a reviewer should pay attention to. Be concise and answer in markdown, in English.\n", path)
+		if question != "" {
+			prompt += fmt.Sprintf("\nThe reviewer asks: %s\n", question)
+		}
+	} else {
+		prompt = fmt.Sprintf("请解释以下来自 `%s` 的代码的作用、实现方式以及审查者需要注意的地方。回答要简洁，使用 markdown 和中文。\n", path)
+		if question != "" {
+			prompt += fmt.Sprintf("\n审查者的问题：%s\n", question)
+		}
+	}
+	prompt += "\n```\n" + snippet + "\n```\n"
+
+	completion, _, err := c.complete(ctx, []LLMMessage{
+		{
+			Role:    "user",
+			Content: prompt,
+		},
+	}, CompletionOptions{
+		Temperature: c.config.Temperature,
+		TopP:        c.config.TopP,
+		MaxTokens:   c.config.MaxTokens,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to explain code: %w", err)
+	}
+	if strings.TrimSpace(completion.Content) == "" {
+		return "", fmt.Errorf("failed to explain code: empty reply from %s", completion.Model)
+	}
+	logrus.Debugf("%s explained %d bytes of %s", completion.Model, len(snippet), path)
+	return strings.TrimSpace(completion.Content), nil
+}
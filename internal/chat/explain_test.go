@@ -0,0 +1,44 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// promptRecorder is a provider that records the prompts it receives
+type promptRecorder struct {
+	fakeProvider
+	reply   string
+	prompts []string
+}
+
+func (p *promptRecorder) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	for _, message := range messages {
+		p.prompts = append(p.prompts, message.Content)
+	}
+	return &Completion{Content: p.reply, Model: p.name}, nil
+}
+
+func TestExplain(t *testing.T) {
+	provider := &promptRecorder{fakeProvider: fakeProvider{name: "primary"}, reply: "  It retries the request.\n"}
+	c := newTestChat(provider)
+	c.config.Language = "english"
+
+	explanation, err := c.Explain(context.Background(), "client.go", "for i := 0; i < 3; i++ {}", "why three times?")
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+	if explanation != "It retries the request." {
+		t.Errorf("Explain() = %q", explanation)
+	}
+	prompt := provider.prompts[0]
+	if !strings.Contains(prompt, "`client.go`") || !strings.Contains(prompt, "for i := 0; i < 3; i++ {}") || !strings.Contains(prompt, "why three times?") {
+		t.Errorf("prompt = %q, want the path, snippet and question", prompt)
+	}
+
+	provider.reply = ""
+	if _, err := c.Explain(context.Background(), "client.go", "x", ""); err == nil {
+		t.Error("Explain() with an empty reply succeeded")
+	}
+}
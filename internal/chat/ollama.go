@@ -0,0 +1,199 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func init() {
+	RegisterProvider("ollama", newOllamaProvider)
+}
+
+// ollamaChatRequest is the request body of the Ollama /api/chat endpoint
+type ollamaChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []LLMMessage  `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Format   string        `json:"format,omitempty"`
+	Options  ollamaOptions `json:"options"`
+}
+
+// ollamaOptions are the model parameters of an Ollama request
+type ollamaOptions struct {
+	NumCtx     int `json:"num_ctx,omitempty"`
+	NumPredict int `json:"num_predict,omitempty"`
+	// Temperature is a pointer so that 0 is sent instead of omitted
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        float32  `json:"top_p,omitempty"`
+}
+
+// ollamaChatResponse is the non-streaming response of /api/chat
+type ollamaChatResponse struct {
+	Model      string     `json:"model"`
+	Message    LLMMessage `json:"message"`
+	Done       bool       `json:"done"`
+	DoneReason string     `json:"done_reason"`
+	// 输入与输出的 token 数
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// ollamaProvider talks to a local model server, either Ollama's native
+// /api/chat or the OpenAI-compatible endpoint of llama.cpp server. Nothing
+// leaves the network, which makes it usable for air-gapped reviews.
+type ollamaProvider struct {
+	baseURL   string
+	model     string
+	apiFormat string
+	numCtx    int
+	timeout   time.Duration
+	// compatible is used for the llama.cpp / OpenAI format
+	compatible *openAICompatibleProvider
+	httpClient *http.Client
+}
+
+// newOllamaProvider creates the provider for a local model server
+func newOllamaProvider(cfg *config.Config) (Provider, error) {
+	if cfg.OllamaBaseURL == "" || cfg.OllamaModel == "" {
+		return nil, nil
+	}
+
+	p := &ollamaProvider{
+		baseURL:    strings.TrimRight(cfg.OllamaBaseURL, "/"),
+		model:      cfg.OllamaModel,
+		apiFormat:  cfg.OllamaAPIFormat,
+		numCtx:     cfg.OllamaNumCtx,
+		timeout:    cfg.OllamaTimeout,
+		httpClient: &http.Client{},
+	}
+
+	switch p.apiFormat {
+	case "", "ollama":
+		p.apiFormat = "ollama"
+	case "openai":
+		// llama.cpp server 会把 response_format 转换为 JSON 语法约束
+		p.compatible = &openAICompatibleProvider{
+			name:       "ollama",
+			endpoint:   p.baseURL + "/v1/chat/completions",
+			model:      p.model,
+			httpClient: p.httpClient,
+		}
+	default:
+		return nil, fmt.Errorf("unsupported OLLAMA_API_FORMAT %q, use ollama or openai", cfg.OllamaAPIFormat)
+	}
+
+	return p, nil
+}
+
+// Name returns the registry name of the provider
+func (p *ollamaProvider) Name() string {
+	return "ollama"
+}
+
+// Model returns the configured model name
+func (p *ollamaProvider) Model() string {
+	return p.model
+}
+
+// Capabilities describes what the provider supports
+func (p *ollamaProvider) Capabilities() Capabilities {
+	return Capabilities{
+		JSONMode:         true,
+		SystemPrompt:     true,
+		MaxContextTokens: p.numCtx,
+	}
+}
+
+// DefaultTimeout returns the call timeout, local models are much slower
+// than hosted ones
+func (p *ollamaProvider) DefaultTimeout() time.Duration {
+	return p.timeout
+}
+
+// Complete sends the conversation to the local model server
+func (p *ollamaProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	if p.compatible != nil {
+		return p.compatible.Complete(ctx, messages, opts)
+	}
+
+	reqBody := ollamaChatRequest{
+		Model:    p.model,
+		Messages: messages,
+		Stream:   false,
+		Options: ollamaOptions{
+			NumCtx:      p.numCtx,
+			NumPredict:  opts.MaxTokens,
+			Temperature: &opts.Temperature,
+			TopP:        opts.TopP,
+		},
+	}
+	if opts.JSONMode {
+		// Ollama 使用 JSON 语法约束采样
+		reqBody.Format = "json"
+	}
+
+	reqData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.baseURL+"/api/chat", bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var chatResp ollamaChatResponse
+	if resp.StatusCode != http.StatusOK {
+		message := string(respBody)
+		if json.Unmarshal(respBody, &chatResp) == nil && chatResp.Error != "" {
+			message = chatResp.Error
+		}
+		return nil, newAPIError(p.Name(), resp, message)
+	}
+	if err := json.Unmarshal(respBody, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(respBody))
+	}
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("Ollama API error: %s", chatResp.Error)
+	}
+	if chatResp.Message.Content == "" {
+		return nil, errors.New("Ollama API returned empty content")
+	}
+
+	model := chatResp.Model
+	if model == "" {
+		model = p.model
+	}
+
+	return &Completion{
+		Content:    chatResp.Message.Content,
+		Model:      model,
+		StopReason: chatResp.DoneReason,
+		Usage: Usage{
+			InputTokens:  chatResp.PromptEvalCount,
+			OutputTokens: chatResp.EvalCount,
+		},
+	}, nil
+}
@@ -0,0 +1,115 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func TestOllamaProviderComplete(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("path = %s, want /api/chat", r.URL.Path)
+		}
+
+		var req ollamaChatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.Stream || req.Format != "json" || req.Options.NumCtx != 16384 {
+			t.Errorf("stream/format/num_ctx = %v/%q/%d", req.Stream, req.Format, req.Options.NumCtx)
+		}
+		// temperature 为 0 时也要发送，否则使用模型的默认值
+		if req.Options.Temperature == nil || *req.Options.Temperature != 0 {
+			t.Errorf("temperature = %v, want 0", req.Options.Temperature)
+		}
+
+		w.Write([]byte(`{
+			"model": "qwen2.5-coder",
+			"message": {"role": "assistant", "content": "{\"lgtm\": true}"},
+			"done": true,
+			"done_reason": "stop",
+			"prompt_eval_count": 120,
+			"eval_count": 8
+		}`))
+	}))
+	defer server.Close()
+
+	provider, err := newOllamaProvider(&config.Config{
+		OllamaBaseURL:   server.URL,
+		OllamaModel:     "qwen2.5-coder",
+		OllamaAPIFormat: "ollama",
+		OllamaNumCtx:    16384,
+		OllamaTimeout:   5 * time.Minute,
+	})
+	if err != nil || provider == nil {
+		t.Fatalf("newOllamaProvider() = %v, %v", provider, err)
+	}
+
+	cfg := &config.Config{LLMTimeout: time.Minute}
+	if got := providerTimeout(cfg, provider); got != 5*time.Minute {
+		t.Errorf("providerTimeout() = %s, want 5m", got)
+	}
+
+	completion, err := provider.Complete(context.Background(), []LLMMessage{
+		{Role: "user", Content: "Review this patch."},
+	}, CompletionOptions{JSONMode: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if completion.Content != `{"lgtm": true}` || completion.StopReason != "stop" {
+		t.Errorf("Content/StopReason = %q/%q", completion.Content, completion.StopReason)
+	}
+	if completion.Usage.InputTokens != 120 || completion.Usage.OutputTokens != 8 {
+		t.Errorf("Usage = %+v", completion.Usage)
+	}
+}
+
+func TestOllamaProviderOpenAIFormat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("path = %s, want /v1/chat/completions", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "" {
+			t.Errorf("unexpected Authorization header for a local server")
+		}
+
+		var req LLMRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("failed to decode request: %v", err)
+		}
+		if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_object" {
+			t.Errorf("response_format = %+v, want json_object", req.ResponseFormat)
+		}
+		if req.Temperature == nil || *req.Temperature != 0 {
+			t.Errorf("temperature = %v, want 0", req.Temperature)
+		}
+
+		w.Write([]byte(`{"model": "local", "choices": [{"message": {"role": "assistant", "content": "{}"}, "finish_reason": "stop"}]}`))
+	}))
+	defer server.Close()
+
+	provider, err := newOllamaProvider(&config.Config{
+		OllamaBaseURL:   server.URL,
+		OllamaModel:     "local",
+		OllamaAPIFormat: "openai",
+	})
+	if err != nil {
+		t.Fatalf("newOllamaProvider() error = %v", err)
+	}
+
+	completion, err := provider.Complete(context.Background(), []LLMMessage{
+		{Role: "user", Content: "Review this patch."},
+	}, CompletionOptions{JSONMode: true})
+	if err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if completion.Content != "{}" {
+		t.Errorf("Content = %q", completion.Content)
+	}
+}
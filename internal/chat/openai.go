@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/sashabaranov/go-openai"
+)
+
+func init() {
+	RegisterProvider("openai", newOpenAIProvider)
+}
+
+// openAIProvider uses the OpenAI SDK, for both OpenAI and Azure OpenAI
+type openAIProvider struct {
+	client *openai.Client
+	model  string
+}
+
+// newOpenAIProvider creates the OpenAI / Azure OpenAI provider
+func newOpenAIProvider(cfg *config.Config) (Provider, error) {
+	if cfg.OpenAIAPIKey == "" {
+		return nil, nil
+	}
+
+	clientConfig := openai.DefaultConfig(cfg.OpenAIAPIKey)
+	clientConfig.BaseURL = cfg.OpenAIAPIEndpoint
+
+	// Configure for Azure OpenAI if needed
+	if cfg.IsAzure {
+		clientConfig = openai.DefaultAzureConfig(
+			cfg.OpenAIAPIKey,
+			fmt.Sprintf("%s/%s", cfg.OpenAIAPIEndpoint, cfg.AzureDeployment),
+		)
+		clientConfig.APIVersion = cfg.AzureAPIVersion
+		clientConfig.AzureModelMapperFunc = func(model string) string {
+			return cfg.AzureDeployment
+		}
+	}
+
+	return &openAIProvider{
+		client: openai.NewClientWithConfig(clientConfig),
+		model:  cfg.Model,
+	}, nil
+}
+
+// Name returns the registry name of the provider
+func (p *openAIProvider) Name() string {
+	return "openai"
+}
+
+// Model returns the configured model name
+func (p *openAIProvider) Model() string {
+	return p.model
+}
+
+// Capabilities describes what the provider supports
+func (p *openAIProvider) Capabilities() Capabilities {
+	return Capabilities{
+		JSONMode:     true,
+		SystemPrompt: true,
+	}
+}
+
+// Complete sends a chat completion request through the OpenAI SDK
+func (p *openAIProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	req := openai.ChatCompletionRequest{
+		Model:       p.model,
+		Messages:    make([]openai.ChatCompletionMessage, 0, len(messages)),
+		Temperature: opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+	}
+	for _, message := range messages {
+		req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	if opts.JSONMode {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+		}
+	}
+
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, p.wrapError(err)
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("OpenAI API returned empty choices")
+	}
+
+	return &Completion{
+		Content:    resp.Choices[0].Message.Content,
+		Model:      resp.Model,
+		StopReason: string(resp.Choices[0].FinishReason),
+		Usage: Usage{
+			InputTokens:  resp.Usage.PromptTokens,
+			OutputTokens: resp.Usage.CompletionTokens,
+		},
+	}, nil
+}
+
+// wrapError converts SDK errors that carry an HTTP status to an APIError,
+// so the retry policy can classify them
+func (p *openAIProvider) wrapError(err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPStatusCode > 0 {
+		return &APIError{Provider: p.Name(), StatusCode: apiErr.HTTPStatusCode, Message: apiErr.Message}
+	}
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) && reqErr.HTTPStatusCode > 0 {
+		return &APIError{Provider: p.Name(), StatusCode: reqErr.HTTPStatusCode, Message: reqErr.Error()}
+	}
+	return fmt.Errorf("OpenAI API error: %w", err)
+}
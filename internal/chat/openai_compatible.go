@@ -0,0 +1,174 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func init() {
+	RegisterProvider("claude", newClaudeProxyProvider)
+	RegisterProvider("deepseek", newDeepseekProvider)
+	RegisterProvider("direct", newDirectLLMProvider)
+}
+
+// openAICompatibleProvider talks to any endpoint implementing the OpenAI
+// chat completions API, such as the LLM proxy or a direct LLM service
+type openAICompatibleProvider struct {
+	name     string
+	endpoint string
+	apiKey   string
+	model    string
+	// maxTokens overrides the requested max tokens when set
+	maxTokens  int
+	httpClient *http.Client
+}
+
+// newClaudeProxyProvider creates the provider for Claude behind the LLM proxy
+func newClaudeProxyProvider(cfg *config.Config) (Provider, error) {
+	if cfg.LLMProxyEndpoint == "" || cfg.LLMProxyAPIKey == "" || cfg.ClaudeModelName == "" {
+		return nil, nil
+	}
+	return &openAICompatibleProvider{
+		name:       "claude",
+		endpoint:   cfg.LLMProxyEndpoint,
+		apiKey:     cfg.LLMProxyAPIKey,
+		model:      cfg.ClaudeModelName,
+		maxTokens:  cfg.ClaudeMaxTokens,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// newDeepseekProvider creates the provider for Deepseek behind the LLM proxy
+func newDeepseekProvider(cfg *config.Config) (Provider, error) {
+	if cfg.LLMProxyEndpoint == "" || cfg.LLMProxyAPIKey == "" || cfg.DeepseekModelName == "" {
+		return nil, nil
+	}
+	return &openAICompatibleProvider{
+		name:       "deepseek",
+		endpoint:   cfg.LLMProxyEndpoint,
+		apiKey:     cfg.LLMProxyAPIKey,
+		model:      cfg.DeepseekModelName,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// newDirectLLMProvider creates the provider for a directly configured LLM endpoint
+func newDirectLLMProvider(cfg *config.Config) (Provider, error) {
+	if cfg.DirectLLMEndpoint == "" || cfg.DirectLLMModelID == "" || cfg.DirectLLMAPIKey == "" {
+		return nil, nil
+	}
+	return &openAICompatibleProvider{
+		name:       "direct",
+		endpoint:   cfg.DirectLLMEndpoint,
+		apiKey:     cfg.DirectLLMAPIKey,
+		model:      cfg.DirectLLMModelID,
+		httpClient: &http.Client{},
+	}, nil
+}
+
+// Name returns the registry name of the provider
+func (p *openAICompatibleProvider) Name() string {
+	return p.name
+}
+
+// Model returns the configured model name
+func (p *openAICompatibleProvider) Model() string {
+	return p.model
+}
+
+// Capabilities describes what the provider supports
+func (p *openAICompatibleProvider) Capabilities() Capabilities {
+	return Capabilities{
+		JSONMode:     true,
+		SystemPrompt: true,
+	}
+}
+
+// Complete sends a chat completion request to the endpoint
+func (p *openAICompatibleProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	// 创建请求体
+	reqBody := LLMRequest{
+		Model:       p.model,
+		Messages:    messages,
+		Temperature: &opts.Temperature,
+		TopP:        opts.TopP,
+		MaxTokens:   opts.MaxTokens,
+	}
+	if p.maxTokens > 0 {
+		reqBody.MaxTokens = p.maxTokens
+	}
+	if opts.JSONMode {
+		reqBody.ResponseFormat = &LLMResponseFormat{
+			Type: "json_object",
+		}
+	}
+
+	// 将请求体转换为 JSON
+	reqData, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// 创建 HTTP 请求
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint, bytes.NewBuffer(reqData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// 设置请求头
+	req.Header.Set("Content-Type", "application/json")
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.apiKey))
+	}
+
+	// 发送请求
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 读取响应体
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	// 检查响应状态码
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(p.name, resp, string(respBody))
+	}
+
+	// 解析响应
+	var llmResp LLMResponse
+	if err := json.Unmarshal(respBody, &llmResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w, body: %s", err, string(respBody))
+	}
+
+	// 检查响应是否有内容
+	if len(llmResp.Choices) == 0 {
+		return nil, errors.New("API returned empty choices")
+	}
+
+	model := llmResp.Model
+	if model == "" {
+		model = p.model
+	}
+
+	return &Completion{
+		Content:    llmResp.Choices[0].Message.Content,
+		Model:      model,
+		StopReason: llmResp.Choices[0].FinishReason,
+		Usage: Usage{
+			InputTokens:  llmResp.Usage.PromptTokens,
+			OutputTokens: llmResp.Usage.CompletionTokens,
+		},
+	}, nil
+}
@@ -0,0 +1,120 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+// Provider is a backend that can complete a chat conversation
+type Provider interface {
+	// Name returns the registry name of the provider, e.g. "openai"
+	Name() string
+
+	// Complete sends the messages to the model and returns its reply
+	Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error)
+
+	// Capabilities describes what the provider supports
+	Capabilities() Capabilities
+}
+
+// TimeoutProvider is implemented by providers that need a different default
+// call timeout than LLM_TIMEOUT, e.g. slow local models
+type TimeoutProvider interface {
+	DefaultTimeout() time.Duration
+}
+
+// ModelProvider is implemented by providers that know which model they
+// call, used to pick the matching tokenizer
+type ModelProvider interface {
+	Model() string
+}
+
+// CompletionOptions are the per-request model parameters
+type CompletionOptions struct {
+	Temperature float32
+	TopP        float32
+	// MaxTokens limits the length of the reply, 0 means provider default
+	MaxTokens int
+	// JSONMode asks the provider to return a single JSON object
+	JSONMode bool
+}
+
+// Capabilities describes the features a provider supports
+type Capabilities struct {
+	// JSONMode is true if the provider can enforce JSON output
+	JSONMode bool
+	// SystemPrompt is true if system messages are supported
+	SystemPrompt bool
+	// MaxContextTokens is the context window of the model, 0 if unknown
+	MaxContextTokens int
+}
+
+// Completion is the reply of a provider
+type Completion struct {
+	Content string
+	// Model is the model that actually produced the reply
+	Model string
+	// StopReason is the provider's reason for ending the reply
+	StopReason string
+	Usage      Usage
+}
+
+// Usage holds the token accounting of a completion
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// ProviderFactory creates a provider from the configuration. It returns
+// (nil, nil) if the provider is not configured.
+type ProviderFactory func(cfg *config.Config) (Provider, error)
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]ProviderFactory)
+)
+
+// RegisterProvider makes a provider available under the given name.
+// Registering the same name twice panics.
+func RegisterProvider(name string, factory ProviderFactory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	name = strings.ToLower(name)
+	if _, exists := providers[name]; exists {
+		panic(fmt.Sprintf("chat: provider %q registered twice", name))
+	}
+	providers[name] = factory
+}
+
+// RegisteredProviders returns the names of all registered providers
+func RegisteredProviders() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProvider creates the provider registered under name. It returns
+// (nil, nil) if the provider exists but is not configured.
+func NewProvider(name string, cfg *config.Config) (Provider, error) {
+	providersMu.RLock()
+	factory, ok := providers[strings.ToLower(name)]
+	providersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q (registered: %s)", name, strings.Join(RegisteredProviders(), ", "))
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+// stubProvider answers with its name, or fails when down is set
+type stubProvider struct {
+	name  string
+	down  bool
+	calls int
+}
+
+func (p *stubProvider) Name() string               { return p.name }
+func (p *stubProvider) Capabilities() Capabilities { return Capabilities{} }
+
+func (p *stubProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	p.calls++
+	if p.down {
+		return nil, errors.New("service unavailable")
+	}
+	return &Completion{Content: p.name, Model: p.name}, nil
+}
+
+// stubProviders are registered once for the whole package; NewChat looks
+// them up by name like the built-in providers
+var stubProviders = map[string]*stubProvider{
+	"stub-down":  {name: "stub-down", down: true},
+	"stub-first": {name: "stub-first"},
+	"stub-last":  {name: "stub-last"},
+}
+
+func init() {
+	for name, provider := range stubProviders {
+		RegisterProvider(name, func(cfg *config.Config) (Provider, error) { return provider, nil })
+	}
+	// 未配置的提供者返回 (nil, nil)
+	RegisterProvider("stub-unconfigured", func(cfg *config.Config) (Provider, error) { return nil, nil })
+}
+
+func TestNewChatProviders(t *testing.T) {
+	tests := []struct {
+		name      string
+		providers []string
+		want      []string
+		wantErr   string
+	}{
+		{name: "configured order", providers: []string{"stub-last", "stub-first"}, want: []string{"stub-last", "stub-first"}},
+		{name: "skips unconfigured", providers: []string{"stub-unconfigured", "stub-first", "stub-last"}, want: []string{"stub-first", "stub-last"}},
+		{name: "case insensitive", providers: []string{"Stub-First"}, want: []string{"stub-first"}},
+		{name: "unknown provider", providers: []string{"stub-first", "mistral"}, wantErr: `unknown LLM provider "mistral"`},
+		{name: "none configured", providers: []string{"stub-unconfigured", "openai"}, wantErr: "configuration is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewChat(&config.Config{LLMProviders: tt.providers})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewChat() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewChat() error = %v", err)
+			}
+			var got []string
+			for _, provider := range c.providers {
+				got = append(got, provider.Name())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("providers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompleteFallsBackInOrder(t *testing.T) {
+	c, err := NewChat(&config.Config{LLMProviders: []string{"stub-down", "stub-first", "stub-last"}})
+	if err != nil {
+		t.Fatalf("NewChat() error = %v", err)
+	}
+	for _, provider := range stubProviders {
+		provider.calls = 0
+	}
+
+	completion, provider, err := c.complete(context.Background(), []LLMMessage{{Role: "user", Content: "review"}}, CompletionOptions{})
+	if err != nil {
+		t.Fatalf("complete() error = %v", err)
+	}
+	if provider.Name() != "stub-first" || completion.Content != "stub-first" {
+		t.Errorf("complete() answered by %s, want stub-first", provider.Name())
+	}
+	if calls := []int{stubProviders["stub-down"].calls, stubProviders["stub-first"].calls, stubProviders["stub-last"].calls}; !reflect.DeepEqual(calls, []int{1, 1, 0}) {
+		t.Errorf("calls = %v, want the providers tried in order until one succeeds", calls)
+	}
+}
+
+func TestRegisterProviderTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterProvider() of a registered name did not panic")
+		}
+	}()
+	RegisterProvider("STUB-FIRST", func(cfg *config.Config) (Provider, error) { return nil, nil })
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+// APIError is returned by providers when the backend answers with a
+// non-success HTTP status
+type APIError struct {
+	Provider   string
+	StatusCode int
+	// RetryAfter is the delay requested by the backend, 0 if none
+	RetryAfter time.Duration
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API returned status %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// newAPIError builds an APIError from an HTTP response
+func newAPIError(provider string, resp *http.Response, message string) *APIError {
+	return &APIError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header, time.Now()),
+		Message:    message,
+	}
+}
+
+// parseRetryAfter reads the delay requested by the backend. It supports the
+// standard Retry-After header in seconds or as an HTTP date, and the
+// retry-after-ms header sent by OpenAI-style APIs.
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if ms := header.Get("retry-after-ms"); ms != "" {
+		if v, err := strconv.ParseFloat(ms, 64); err == nil && v > 0 {
+			return time.Duration(v * float64(time.Millisecond))
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds <= 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// isRetryable reports whether a failed provider call may succeed when tried
+// again. Rate limits, server errors and network failures are retryable;
+// other client errors such as a bad request or invalid key are not.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		// 网络错误、超时、响应解析失败等
+		return true
+	}
+
+	switch apiErr.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusConflict,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529: // Anthropic overloaded
+		return true
+	}
+	return false
+}
+
+// tripsBreaker reports whether the error says something about the health of
+// the backend, rather than about the single request
+func tripsBreaker(err error) bool {
+	if isRetryable(err) {
+		return true
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden
+	}
+	return false
+}
+
+// RetryPolicy controls how failed provider calls are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls per provider, including the first
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// newRetryPolicy creates the retry policy from the configuration
+func newRetryPolicy(cfg *config.Config) RetryPolicy {
+	policy := RetryPolicy{
+		MaxAttempts: cfg.LLMMaxRetries + 1,
+		BaseDelay:   cfg.LLMRetryBaseDelay,
+		MaxDelay:    cfg.LLMRetryMaxDelay,
+	}
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = time.Second
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	return policy
+}
+
+// Backoff returns the delay before the given retry (1 for the first retry).
+// It uses exponential backoff with full jitter, and honours the Retry-After
+// of the failed call if that is longer. ok is false if the backend asked for
+// a longer pause than MaxDelay, in which case the caller should move on.
+func (p RetryPolicy) Backoff(retry int, err error) (delay time.Duration, ok bool) {
+	ceiling := p.BaseDelay
+	for i := 1; i < retry && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	delay = time.Duration(rand.Int63n(int64(ceiling) + 1))
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		if apiErr.RetryAfter > p.MaxDelay {
+			return 0, false
+		}
+		if apiErr.RetryAfter > delay {
+			delay = apiErr.RetryAfter
+		}
+	}
+	return delay, true
+}
+
+// circuitBreaker skips a provider for a cooldown window after repeated
+// failures. After the cooldown a single trial call is let through; its
+// outcome closes the breaker or opens it again.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	failures  int
+	openUntil time.Time
+	trial     bool
+}
+
+// newCircuitBreaker creates a breaker, a threshold <= 0 disables it
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a call may be made
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true
+	}
+	if b.now().Before(b.openUntil) || b.trial {
+		return false
+	}
+	// 冷却结束，放行一次试探调用
+	b.trial = true
+	return true
+}
+
+// Success records a successful call and closes the breaker
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure records a failed call and opens the breaker once the threshold is
+// reached
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.trial = false
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Release ends a trial call without an outcome, e.g. when the caller
+// canceled it or the request itself was rejected, so that the next call is
+// let through as a trial again. It does nothing when no trial is running.
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
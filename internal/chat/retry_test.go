@@ -0,0 +1,270 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{name: "none", header: http.Header{}, want: 0},
+		{name: "seconds", header: http.Header{"Retry-After": {"3"}}, want: 3 * time.Second},
+		{name: "http date", header: http.Header{"Retry-After": {now.Add(10 * time.Second).Format(http.TimeFormat)}}, want: 10 * time.Second},
+		{name: "milliseconds", header: http.Header{"Retry-After-Ms": {"1500"}}, want: 1500 * time.Millisecond},
+		{name: "garbage", header: http.Header{"Retry-After": {"soon"}}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "rate limited", err: &APIError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "server error", err: &APIError{StatusCode: http.StatusBadGateway}, want: true},
+		{name: "anthropic overloaded", err: &APIError{StatusCode: 529}, want: true},
+		{name: "bad request", err: &APIError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "unauthorized", err: &APIError{StatusCode: http.StatusUnauthorized}, want: false},
+		{name: "network error", err: errors.New("connection reset"), want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryable(tt.err); got != tt.want {
+				t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for retry := 1; retry <= 6; retry++ {
+		ceiling := min(100*time.Millisecond<<(retry-1), time.Second)
+		for i := 0; i < 20; i++ {
+			delay, ok := policy.Backoff(retry, errors.New("boom"))
+			if !ok || delay < 0 || delay > ceiling {
+				t.Fatalf("Backoff(%d) = %s, %v, want within [0, %s]", retry, delay, ok, ceiling)
+			}
+		}
+	}
+
+	delay, ok := policy.Backoff(1, &APIError{StatusCode: 429, RetryAfter: 800 * time.Millisecond})
+	if !ok || delay != 800*time.Millisecond {
+		t.Errorf("Backoff() with Retry-After = %s, %v, want 800ms", delay, ok)
+	}
+
+	if _, ok := policy.Backoff(1, &APIError{StatusCode: 429, RetryAfter: time.Minute}); ok {
+		t.Errorf("Backoff() with Retry-After above MaxDelay should give up")
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	breaker := newCircuitBreaker(2, time.Minute)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	if !breaker.Allow() {
+		t.Fatal("breaker opened before reaching the threshold")
+	}
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatal("breaker should be open after reaching the threshold")
+	}
+
+	// 冷却结束后只放行一次试探调用
+	now = now.Add(time.Minute)
+	if !breaker.Allow() {
+		t.Fatal("breaker should allow a trial call after the cooldown")
+	}
+	if breaker.Allow() {
+		t.Fatal("breaker should allow a single trial call only")
+	}
+
+	breaker.Failure()
+	if breaker.Allow() {
+		t.Fatal("failed trial call should reopen the breaker")
+	}
+
+	now = now.Add(time.Minute)
+	breaker.Allow()
+	breaker.Success()
+	if !breaker.Allow() || !breaker.Allow() {
+		t.Fatal("successful trial call should close the breaker")
+	}
+}
+
+// fakeProvider returns the queued errors before succeeding
+type fakeProvider struct {
+	name   string
+	errs   []error
+	calls  int
+	window int
+}
+
+func (p *fakeProvider) Name() string { return p.name }
+func (p *fakeProvider) Capabilities() Capabilities {
+	return Capabilities{MaxContextTokens: p.window}
+}
+
+func (p *fakeProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	p.calls++
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return nil, err
+	}
+	return &Completion{Content: "{}", Model: p.name}, nil
+}
+
+func newTestChat(providers ...Provider) *Chat {
+	cfg := &config.Config{
+		LLMTimeout:          time.Second,
+		LLMMaxRetries:       2,
+		LLMRetryBaseDelay:   time.Millisecond,
+		LLMRetryMaxDelay:    5 * time.Millisecond,
+		LLMBreakerThreshold: 3,
+		LLMBreakerCooldown:  time.Minute,
+	}
+	c := &Chat{
+		config:    cfg,
+		providers: providers,
+		retry:     newRetryPolicy(cfg),
+		breakers:  make(map[string]*circuitBreaker),
+	}
+	for _, provider := range providers {
+		c.breakers[provider.Name()] = newCircuitBreaker(cfg.LLMBreakerThreshold, cfg.LLMBreakerCooldown)
+	}
+	return c
+}
+
+func TestCompleteRetriesAndFallsBack(t *testing.T) {
+	t.Run("retries retryable errors", func(t *testing.T) {
+		primary := &fakeProvider{name: "primary", errs: []error{
+			&APIError{StatusCode: http.StatusServiceUnavailable},
+			&APIError{StatusCode: http.StatusTooManyRequests},
+		}}
+		c := newTestChat(primary)
+
+		completion, provider, err := c.complete(context.Background(), nil, CompletionOptions{})
+		if err != nil || provider != primary || completion.Model != "primary" {
+			t.Fatalf("complete() = %v, %v, %v", completion, provider, err)
+		}
+		if primary.calls != 3 {
+			t.Errorf("calls = %d, want 3", primary.calls)
+		}
+	})
+
+	t.Run("fatal error falls back without retrying", func(t *testing.T) {
+		primary := &fakeProvider{name: "primary", errs: []error{&APIError{StatusCode: http.StatusBadRequest}}}
+		secondary := &fakeProvider{name: "secondary"}
+		c := newTestChat(primary, secondary)
+
+		_, provider, err := c.complete(context.Background(), nil, CompletionOptions{})
+		if err != nil || provider != secondary {
+			t.Fatalf("complete() provider = %v, err = %v, want secondary", provider, err)
+		}
+		if primary.calls != 1 {
+			t.Errorf("primary calls = %d, want 1", primary.calls)
+		}
+	})
+
+	t.Run("open breaker skips the provider", func(t *testing.T) {
+		down := &APIError{StatusCode: http.StatusBadGateway}
+		primary := &fakeProvider{name: "primary", errs: []error{down, down, down, down}}
+		secondary := &fakeProvider{name: "secondary"}
+		c := newTestChat(primary, secondary)
+
+		if _, _, err := c.complete(context.Background(), nil, CompletionOptions{}); err != nil {
+			t.Fatalf("complete() error = %v", err)
+		}
+		calls := primary.calls
+		if _, provider, _ := c.complete(context.Background(), nil, CompletionOptions{}); provider != secondary {
+			t.Fatalf("complete() provider = %v, want secondary", provider)
+		}
+		if primary.calls != calls {
+			t.Errorf("primary was called while its breaker was open")
+		}
+	})
+}
+
+func TestCircuitBreakerTrialWithoutOutcome(t *testing.T) {
+	tests := []struct {
+		name string
+		// cancel cancels the context of the trial call while it runs
+		cancel bool
+		err    error
+	}{
+		{name: "canceled trial", cancel: true, err: context.Canceled},
+		{name: "bad request trial", err: &APIError{StatusCode: http.StatusBadRequest}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+			primary := &cancelingProvider{fakeProvider: fakeProvider{name: "primary", errs: []error{tt.err}}}
+			c := newTestChat(primary)
+			breaker := c.breakers["primary"]
+			breaker.now = func() time.Time { return now }
+			for i := 0; i < c.config.LLMBreakerThreshold; i++ {
+				breaker.Failure()
+			}
+			now = now.Add(c.config.LLMBreakerCooldown)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				primary.cancel = cancel
+			}
+			if _, _, err := c.complete(ctx, nil, CompletionOptions{}); err == nil {
+				t.Fatal("complete() error = nil, want the trial error")
+			}
+			if primary.calls != 1 {
+				t.Fatalf("calls = %d, want a single trial call", primary.calls)
+			}
+
+			// 试探调用没有结果，下一次调用仍然作为试探放行
+			if _, _, err := c.complete(context.Background(), nil, CompletionOptions{}); err != nil {
+				t.Fatalf("complete() after the trial error = %v, want a new trial", err)
+			}
+			if !breaker.Allow() {
+				t.Error("successful trial should close the breaker")
+			}
+		})
+	}
+}
+
+// cancelingProvider cancels the context of the caller during the call
+type cancelingProvider struct {
+	fakeProvider
+	cancel context.CancelFunc
+}
+
+func (p *cancelingProvider) Complete(ctx context.Context, messages []LLMMessage, opts CompletionOptions) (*Completion, error) {
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	return p.fakeProvider.Complete(ctx, messages, opts)
+}
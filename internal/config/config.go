@@ -4,18 +4,61 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
+// Review failure modes
+const (
+	// ReviewFailureSummary reports failed files in the regular review summary
+	ReviewFailureSummary = "summary"
+	// ReviewFailureComment posts a "review unavailable" comment instead of a
+	// review when nothing could be reviewed
+	ReviewFailureComment = "comment"
+	// ReviewFailureError posts the summary and fails the run, so CI shows a
+	// failing status
+	ReviewFailureError = "error"
+)
+
+// Gitea review modes
+const (
+	// GiteaReviewAuto uses pull request reviews if the server supports them
+	GiteaReviewAuto = "auto"
+	// GiteaReviewReview always posts pull request reviews with inline comments
+	GiteaReviewReview = "review"
+	// GiteaReviewComment posts all comments as one issue comment, for old
+	// Gitea versions without the review API
+	GiteaReviewComment = "comment"
+)
+
 // Config holds all configuration for the application
 type Config struct {
-	// Platform selection
-	Platform string
+	// Platform selection. Platforms lists the platforms a server receives
+	// webhooks from, each on /webhook/<platform>; it defaults to Platform
+	Platform  string
+	Platforms []string
+	// WebhookSecrets maps platforms to their webhook secret, read from
+	// <PLATFORM>_WEBHOOK_SECRET with WEBHOOK_SECRET as fallback
+	WebhookSecrets map[string]string
 
-	// GitHub related
-	GithubToken string
+	// GitHub related. GithubBaseURL is the API root of a GitHub Enterprise
+	// Server instance, e.g. https://github.example.com/api/v3; empty for
+	// github.com
+	GithubToken   string
+	GithubBaseURL string
+	// GitHub App authentication, used instead of GithubToken when
+	// GithubAppID is set. The private key is given inline (PEM) or as a
+	// file path; GithubAppInstallationID is the installation used for
+	// requests that cannot be mapped to a repository
+	GithubAppID             int64
+	GithubAppPrivateKey     string
+	GithubAppPrivateKeyPath string
+	GithubAppInstallationID int64
+	// GithubCheckRuns reports reviews as check runs in addition to the
+	// review; requires GitHub App authentication
+	GithubCheckRuns bool
 
 	// GitLab related
 	GitlabToken   string
@@ -24,9 +67,46 @@ type Config struct {
 	// Gitea related
 	GiteaToken   string
 	GiteaBaseURL string
+	// GiteaReviewMode is "auto", "review" or "comment"
+	GiteaReviewMode string
+
+	// Bitbucket related. BitbucketBaseURL is the Bitbucket Cloud API root or
+	// the root URL of a Bitbucket Server / Data Center instance
+	BitbucketBaseURL     string
+	BitbucketToken       string
+	BitbucketUsername    string
+	BitbucketAppPassword string
+
+	// Azure DevOps related. AzureDevOpsOrgURL is the organization URL, e.g.
+	// https://dev.azure.com/myorg, or an Azure DevOps Server collection URL
+	AzureDevOpsOrgURL string
+	AzureDevOpsToken  string
 
 	// Common Git platform settings
 	TargetLabel string
+	// ReviewFailureMode controls what happens when a review could not be
+	// completed: "summary", "comment" or "error"
+	ReviewFailureMode string
+	// ApproveOnLGTM approves pull requests without findings on platforms
+	// that support approvals
+	ApproveOnLGTM bool
+	// CommitStatus reports the review as a commit status (a pull request
+	// status on Azure DevOps): pending while it runs, then the verdict
+	CommitStatus bool
+	
+	// Review job queue. ReviewWorkers bounds the reviews run at the same
+	// time and ReviewRepoConcurrency those of one repository (0 for no
+	// limit). Queued jobs are kept in ReviewQueueDir so that they survive
+	// restarts.
+	ReviewWorkers         int
+	ReviewRepoConcurrency int
+	ReviewQueueDir        string
+	// ReviewDebounce delays the review of a push, so that a burst of pushes
+	// to a pull request is reviewed once, at the last commit
+	ReviewDebounce time.Duration
+	// ShutdownTimeout is how long a stopping server waits for queued and
+	// running reviews before canceling them
+	ShutdownTimeout time.Duration
 	
 	// Code indexing related
 	EnableIndexing bool
@@ -66,10 +146,32 @@ type Config struct {
 	ClaudeMaxTokens     int
 	IsClaudeEnabled     bool
 	
+	// Anthropic Messages API related
+	AnthropicAPIKey     string
+	AnthropicBaseURL    string
+	AnthropicVersion    string
+	
+	// Local model (Ollama / llama.cpp) related
+	OllamaBaseURL       string
+	OllamaModel         string
+	OllamaAPIFormat     string        // "ollama" 或 "openai"（llama.cpp server）
+	OllamaNumCtx        int
+	OllamaTimeout       time.Duration
+	
 	// Deepseek model related
 	DeepseekModelName   string
 	IsDeepseekEnabled   bool
 	
+	// LLM provider selection
+	LLMProviders        []string                 // 按顺序尝试的提供者
+	LLMTimeout          time.Duration            // 单次调用的默认超时
+	LLMProviderTimeouts map[string]time.Duration // 按提供者覆盖的超时
+	LLMMaxRetries       int                      // 每个提供者的最大重试次数
+	LLMRetryBaseDelay   time.Duration            // 指数退避的初始间隔
+	LLMRetryMaxDelay    time.Duration            // 单次退避的最大间隔
+	LLMBreakerThreshold int                      // 连续失败多少次后熔断，0 表示关闭
+	LLMBreakerCooldown  time.Duration            // 熔断后跳过该提供者的时长
+	
 	// Code indexing related
 	IndexerStorageType  string
 	ChromaHost          string
@@ -87,10 +189,18 @@ func LoadConfig() *Config {
 
 	config := &Config{
 		// Platform selection (default to GitHub if not specified)
-		Platform:           getEnvWithDefault("PLATFORM", "github"),
+		Platform:           strings.ToLower(getEnvWithDefault("PLATFORM", "github")),
 
 		// GitHub configuration
 		GithubToken:        os.Getenv("GITHUB_TOKEN"),
+		GithubBaseURL:      os.Getenv("GITHUB_BASE_URL"),
+
+		// GitHub App configuration，私钥可在 .env 中用 \n 表示换行
+		GithubAppID:             parseInt64(os.Getenv("GITHUB_APP_ID"), 0),
+		GithubAppPrivateKey:     strings.ReplaceAll(os.Getenv("GITHUB_APP_PRIVATE_KEY"), `\n`, "\n"),
+		GithubAppPrivateKeyPath: os.Getenv("GITHUB_APP_PRIVATE_KEY_PATH"),
+		GithubAppInstallationID: parseInt64(os.Getenv("GITHUB_APP_INSTALLATION_ID"), 0),
+		GithubCheckRuns:         os.Getenv("GITHUB_CHECK_RUNS") == "true",
 
 		// GitLab configuration
 		GitlabToken:        os.Getenv("GITLAB_TOKEN"),
@@ -99,9 +209,23 @@ func LoadConfig() *Config {
 		// Gitea configuration
 		GiteaToken:         os.Getenv("GITEA_TOKEN"),
 		GiteaBaseURL:       os.Getenv("GITEA_BASE_URL"),
+		GiteaReviewMode:    strings.ToLower(getEnvWithDefault("GITEA_REVIEW_MODE", GiteaReviewAuto)),
+
+		// Bitbucket configuration
+		BitbucketBaseURL:     getEnvWithDefault("BITBUCKET_BASE_URL", "https://api.bitbucket.org/2.0"),
+		BitbucketToken:       os.Getenv("BITBUCKET_TOKEN"),
+		BitbucketUsername:    os.Getenv("BITBUCKET_USERNAME"),
+		BitbucketAppPassword: os.Getenv("BITBUCKET_APP_PASSWORD"),
+
+		// Azure DevOps configuration
+		AzureDevOpsOrgURL: os.Getenv("AZURE_DEVOPS_ORG_URL"),
+		AzureDevOpsToken:  os.Getenv("AZURE_DEVOPS_TOKEN"),
 
 		// Common Git platform settings
 		TargetLabel:        os.Getenv("TARGET_LABEL"),
+		ReviewFailureMode:  strings.ToLower(getEnvWithDefault("REVIEW_FAILURE_MODE", ReviewFailureSummary)),
+		ApproveOnLGTM:      os.Getenv("APPROVE_ON_LGTM") == "true",
+		CommitStatus:       getEnvWithDefault("COMMIT_STATUS", "true") == "true",
 
 		// OpenAI configuration
 		OpenAIAPIKey:       os.Getenv("OPENAI_API_KEY"),
@@ -116,6 +240,17 @@ func LoadConfig() *Config {
 		IgnoreList:         splitAndTrim(os.Getenv("IGNORE"), "\n"),
 	}
 
+	// 未设置 PLATFORMS 时只服务 PLATFORM 一个平台
+	for _, platform := range splitAndTrim(strings.ToLower(getEnvWithDefault("PLATFORMS", config.Platform)), ",") {
+		if !containsString(config.Platforms, platform) {
+			config.Platforms = append(config.Platforms, platform)
+		}
+	}
+	config.WebhookSecrets = make(map[string]string, len(webhookSecretEnv))
+	for platform, key := range webhookSecretEnv {
+		config.WebhookSecrets[platform] = getEnvWithDefault(key, os.Getenv("WEBHOOK_SECRET"))
+	}
+
 	// Parse numeric values
 	config.Temperature = parseFloat32(getEnvWithDefault("temperature", "1"))
 	config.TopP = parseFloat32(getEnvWithDefault("top_p", "1"))
@@ -141,10 +276,39 @@ func LoadConfig() *Config {
 	config.ClaudeMaxTokens = parseInt(os.Getenv("CLAUDE_MAX_TOKENS"), 4000)
 	config.IsClaudeEnabled = config.LLMProxyEndpoint != "" && config.LLMProxyAPIKey != "" && config.ClaudeModelName != ""
 	
+	// Load Anthropic Messages API configuration
+	config.AnthropicAPIKey = os.Getenv("ANTHROPIC_API_KEY")
+	config.AnthropicBaseURL = getEnvWithDefault("ANTHROPIC_BASE_URL", "https://api.anthropic.com")
+	config.AnthropicVersion = getEnvWithDefault("ANTHROPIC_VERSION", "2023-06-01")
+	
+	// Load local model configuration
+	config.OllamaBaseURL = os.Getenv("OLLAMA_BASE_URL")
+	config.OllamaModel = os.Getenv("OLLAMA_MODEL")
+	config.OllamaAPIFormat = strings.ToLower(getEnvWithDefault("OLLAMA_API_FORMAT", "ollama"))
+	config.OllamaNumCtx = parseInt(os.Getenv("OLLAMA_NUM_CTX"), 8192)
+	config.OllamaTimeout = parseDuration(os.Getenv("OLLAMA_TIMEOUT"), 5*time.Minute)
+	
 	// Load Deepseek model configuration
 	config.DeepseekModelName = os.Getenv("DEEPSEEK_MODEL_NAME")
 	config.IsDeepseekEnabled = os.Getenv("DEEPSEEK_ENABLED") == "true"
 	
+	// Load LLM provider selection
+	config.LLMProviders = splitAndTrim(getEnvWithDefault("LLM_PROVIDERS", "anthropic,claude,deepseek,direct,openai,ollama"), ",")
+	config.LLMTimeout = parseDuration(os.Getenv("LLM_TIMEOUT"), 60*time.Second)
+	config.LLMProviderTimeouts = parseDurationMap(os.Getenv("LLM_PROVIDER_TIMEOUTS"))
+	config.LLMMaxRetries = parseInt(os.Getenv("LLM_MAX_RETRIES"), 3)
+	config.LLMRetryBaseDelay = parseDuration(os.Getenv("LLM_RETRY_BASE_DELAY"), time.Second)
+	config.LLMRetryMaxDelay = parseDuration(os.Getenv("LLM_RETRY_MAX_DELAY"), 30*time.Second)
+	config.LLMBreakerThreshold = parseInt(os.Getenv("LLM_BREAKER_THRESHOLD"), 5)
+	config.LLMBreakerCooldown = parseDuration(os.Getenv("LLM_BREAKER_COOLDOWN"), time.Minute)
+	
+	// Load review queue configuration
+	config.ReviewWorkers = parseInt(os.Getenv("REVIEW_WORKERS"), 4)
+	config.ReviewRepoConcurrency = parseInt(os.Getenv("REVIEW_REPO_CONCURRENCY"), 1)
+	config.ReviewQueueDir = getEnvWithDefault("REVIEW_QUEUE_DIR", "./data/queue")
+	config.ReviewDebounce = parseDuration(os.Getenv("REVIEW_DEBOUNCE"), 10*time.Second)
+	config.ShutdownTimeout = parseDuration(os.Getenv("SHUTDOWN_TIMEOUT"), 5*time.Minute)
+	
 	// Load code indexing configuration
 	config.EnableIndexing = os.Getenv("ENABLE_INDEXING") == "true"
 	config.IndexerStorageType = getEnvWithDefault("INDEXER_STORAGE_TYPE", "local")
@@ -156,9 +320,42 @@ func LoadConfig() *Config {
 	config.LocalStoragePath = getEnvWithDefault("INDEXER_LOCAL_STORAGE_PATH", "./data/index")
 	config.IndexerVectorType = getEnvWithDefault("INDEXER_VECTOR_TYPE", "simple")
 
+	switch config.ReviewFailureMode {
+	case ReviewFailureSummary, ReviewFailureComment, ReviewFailureError:
+	default:
+		logrus.Warnf("Unknown REVIEW_FAILURE_MODE %q, using %s", config.ReviewFailureMode, ReviewFailureSummary)
+		config.ReviewFailureMode = ReviewFailureSummary
+	}
+	
+	switch config.GiteaReviewMode {
+	case GiteaReviewAuto, GiteaReviewReview, GiteaReviewComment:
+	default:
+		logrus.Warnf("Unknown GITEA_REVIEW_MODE %q, using %s", config.GiteaReviewMode, GiteaReviewAuto)
+		config.GiteaReviewMode = GiteaReviewAuto
+	}
+	
 	return config
 }
 
+// webhookSecretEnv maps platforms to the environment variable of their
+// webhook secret
+var webhookSecretEnv = map[string]string{
+	"github":      "GITHUB_WEBHOOK_SECRET",
+	"gitlab":      "GITLAB_WEBHOOK_SECRET",
+	"gitea":       "GITEA_WEBHOOK_SECRET",
+	"bitbucket":   "BITBUCKET_WEBHOOK_SECRET",
+	"azuredevops": "AZURE_DEVOPS_WEBHOOK_SECRET",
+}
+
+// ForPlatform returns a copy of the configuration with Platform set to the
+// given platform, used to create the client of one platform of a
+// multi-platform server
+func (c *Config) ForPlatform(platform string) *Config {
+	copied := *c
+	copied.Platform = platform
+	return &copied
+}
+
 // Helper functions
 func getEnvWithDefault(key, defaultValue string) string {
 	value := os.Getenv(key)
@@ -194,6 +391,51 @@ func parseInt(value string, defaultValue int) int {
 	return i
 }
 
+func parseInt64(value string, defaultValue int64) int64 {
+	if value == "" {
+		return defaultValue
+	}
+	
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logrus.Warnf("Failed to parse int value: %s, using default %d", value, defaultValue)
+		return defaultValue
+	}
+	return i
+}
+
+func parseDuration(value string, defaultValue time.Duration) time.Duration {
+	if value == "" {
+		return defaultValue
+	}
+	
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		logrus.Warnf("Failed to parse duration value: %s, using default %s", value, defaultValue)
+		return defaultValue
+	}
+	return d
+}
+
+// parseDurationMap parses "name=duration" pairs separated by commas, e.g. "claude=120s,openai=30s"
+func parseDurationMap(value string) map[string]time.Duration {
+	result := make(map[string]time.Duration)
+	for _, pair := range splitAndTrim(value, ",") {
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			logrus.Warnf("Ignoring malformed duration entry: %s", pair)
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if err != nil {
+			logrus.Warnf("Ignoring invalid duration for %s: %s", name, raw)
+			continue
+		}
+		result[strings.ToLower(strings.TrimSpace(name))] = d
+	}
+	return result
+}
+
 func getEnvIntWithDefault(key string, defaultValue int) int {
 	value := os.Getenv(key)
 	return parseInt(value, defaultValue)
@@ -216,3 +458,12 @@ func splitAndTrim(value, separator string) []string {
 	
 	return result
 }
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
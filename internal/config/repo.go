@@ -0,0 +1,149 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the per-repository configuration file, read from the
+// base branch of the pull request
+const RepoConfigFile = ".ai-review.yaml"
+
+// RepoConfig holds the settings a repository can override. Unset fields keep
+// the value of the global configuration.
+type RepoConfig struct {
+	// Enabled can be set to false to turn reviews off for the repository
+	Enabled         *bool    `yaml:"enabled"`
+	Language        *string  `yaml:"language"`
+	Prompt          *string  `yaml:"prompt"`
+	MaxPatchLength  *int     `yaml:"max_patch_length"`
+	IncludePatterns []string `yaml:"include_patterns"`
+	IgnorePatterns  []string `yaml:"ignore_patterns"`
+	IgnoreList      []string `yaml:"ignore"`
+}
+
+// RepoConfigError lists all problems found in a repository configuration file
+type RepoConfigError struct {
+	Problems []string
+}
+
+func (e *RepoConfigError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", RepoConfigFile, strings.Join(e.Problems, "; "))
+}
+
+// ParseRepoConfig decodes and validates a repository configuration file.
+// Unknown keys are rejected so that typos do not go unnoticed.
+func ParseRepoConfig(data []byte) (*RepoConfig, error) {
+	rc := &RepoConfig{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(rc); err != nil && !errors.Is(err, io.EOF) {
+		return nil, &RepoConfigError{Problems: []string{err.Error()}}
+	}
+
+	if err := rc.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rc, nil
+}
+
+// Validate checks the values of the repository configuration
+func (rc *RepoConfig) Validate() error {
+	problems := []string{}
+
+	if rc.Language != nil {
+		language := strings.ToLower(strings.TrimSpace(*rc.Language))
+		if language != "chinese" && language != "english" {
+			problems = append(problems, fmt.Sprintf("language must be \"chinese\" or \"english\", got %q", *rc.Language))
+		}
+	}
+
+	if rc.Prompt != nil && strings.TrimSpace(*rc.Prompt) == "" {
+		problems = append(problems, "prompt must not be empty")
+	}
+
+	if rc.MaxPatchLength != nil && *rc.MaxPatchLength < 0 {
+		problems = append(problems, fmt.Sprintf("max_patch_length must be >= 0, got %d", *rc.MaxPatchLength))
+	}
+
+	problems = append(problems, validatePatterns("include_patterns", rc.IncludePatterns)...)
+	problems = append(problems, validatePatterns("ignore_patterns", rc.IgnorePatterns)...)
+
+	for i, item := range rc.IgnoreList {
+		if strings.TrimSpace(item) == "" {
+			problems = append(problems, fmt.Sprintf("ignore[%d] must not be empty", i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &RepoConfigError{Problems: problems}
+	}
+	return nil
+}
+
+// validatePatterns makes sure every pattern is a valid glob
+func validatePatterns(field string, patterns []string) []string {
+	problems := []string{}
+	for i, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			problems = append(problems, fmt.Sprintf("%s[%d] must not be empty", field, i))
+			continue
+		}
+		if _, err := glob.Compile(pattern); err != nil {
+			problems = append(problems, fmt.Sprintf("%s[%d] %q is not a valid glob: %v", field, i, pattern, err))
+		}
+	}
+	return problems
+}
+
+// IsEnabled reports whether reviews are enabled for the repository
+func (rc *RepoConfig) IsEnabled() bool {
+	return rc.Enabled == nil || *rc.Enabled
+}
+
+// Merge returns a copy of the global configuration with the repository
+// settings applied on top. The receiver is not modified.
+func (c *Config) Merge(rc *RepoConfig) *Config {
+	merged := *c
+	if rc == nil {
+		return &merged
+	}
+
+	if rc.Language != nil {
+		merged.Language = strings.TrimSpace(*rc.Language)
+	}
+	if rc.Prompt != nil {
+		merged.Prompt = *rc.Prompt
+	}
+	if rc.MaxPatchLength != nil {
+		merged.MaxPatchLength = *rc.MaxPatchLength
+	}
+	if rc.IncludePatterns != nil {
+		merged.IncludePatterns = trimAll(rc.IncludePatterns)
+	}
+	if rc.IgnorePatterns != nil {
+		merged.IgnorePatterns = trimAll(rc.IgnorePatterns)
+	}
+	if rc.IgnoreList != nil {
+		merged.IgnoreList = trimAll(rc.IgnoreList)
+	}
+
+	return &merged
+}
+
+func trimAll(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		result = append(result, strings.TrimSpace(value))
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseRepoConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantErr      bool
+		wantProblems int
+	}{
+		{
+			name: "valid config",
+			data: `
+language: english
+prompt: Focus on concurrency bugs.
+max_patch_length: 20000
+include_patterns: ["*.go"]
+ignore_patterns: ["/vendor/**"]
+`,
+			wantErr: false,
+		},
+		{
+			name:    "empty file",
+			data:    "",
+			wantErr: false,
+		},
+		{
+			name:         "unknown key",
+			data:         "langauge: english\n",
+			wantErr:      true,
+			wantProblems: 1,
+		},
+		{
+			name: "invalid values",
+			data: `
+language: french
+max_patch_length: -1
+include_patterns: ["[a-"]
+`,
+			wantErr:      true,
+			wantProblems: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRepoConfig([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRepoConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			var rcErr *RepoConfigError
+			if !errors.As(err, &rcErr) {
+				t.Fatalf("ParseRepoConfig() error type = %T, want *RepoConfigError", err)
+			}
+			if len(rcErr.Problems) != tt.wantProblems {
+				t.Errorf("ParseRepoConfig() problems = %v, want %d", rcErr.Problems, tt.wantProblems)
+			}
+		})
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	global := &Config{
+		Language:        "chinese",
+		Prompt:          "global prompt",
+		MaxPatchLength:  1000,
+		IncludePatterns: []string{"*.go"},
+	}
+
+	rc, err := ParseRepoConfig([]byte("language: english\nignore_patterns: [\"*.md\"]\n"))
+	if err != nil {
+		t.Fatalf("ParseRepoConfig() error = %v", err)
+	}
+
+	merged := global.Merge(rc)
+	if merged.Language != "english" {
+		t.Errorf("Merge() language = %q, want english", merged.Language)
+	}
+	if merged.Prompt != "global prompt" || merged.MaxPatchLength != 1000 {
+		t.Errorf("Merge() overrode unset fields: %+v", merged)
+	}
+	if len(merged.IgnorePatterns) != 1 || merged.IgnorePatterns[0] != "*.md" {
+		t.Errorf("Merge() ignore patterns = %v", merged.IgnorePatterns)
+	}
+	if global.Language != "chinese" {
+		t.Errorf("Merge() modified the global configuration")
+	}
+}
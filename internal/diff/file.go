@@ -0,0 +1,300 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// File statuses, the values match the GitHub API
+const (
+	StatusAdded    = "added"
+	StatusModified = "modified"
+	StatusRemoved  = "removed"
+	StatusRenamed  = "renamed"
+	StatusCopied   = "copied"
+)
+
+// Patch is a parsed unified diff
+type Patch struct {
+	// Prelude is any text before the first file, e.g. code context added
+	// by the indexer
+	Prelude string
+	Files   []*FileDiff
+}
+
+// FileDiff is the diff of a single file
+type FileDiff struct {
+	// OldPath is empty for added files
+	OldPath string
+	// NewPath is empty for removed files
+	NewPath string
+	// Status is one of the Status* constants
+	Status   string
+	IsBinary bool
+	// Additions and Deletions count the added and deleted lines
+	Additions int
+	Deletions int
+	// Header holds the git header lines (diff --git, index, ---, +++ ...)
+	Header []string
+	Hunks  []*Hunk
+	// Patch is the raw text of the hunks, starting at the first @@ line
+	Patch string
+}
+
+// Path returns the path of the file in the new version, or the old path
+// for removed files
+func (f *FileDiff) Path() string {
+	if f.NewPath != "" {
+		return f.NewPath
+	}
+	return f.OldPath
+}
+
+// ParseFileDiffs parses a multi-file git diff, e.g. the .diff of a pull request
+func ParseFileDiffs(raw string) ([]*FileDiff, error) {
+	patch, err := ParsePatch(raw)
+	if err != nil {
+		return nil, err
+	}
+	return patch.Files, nil
+}
+
+// NewFileDiff builds the diff of a single file from the metadata and the
+// per-file patch returned by a platform API. Paths and status from the API
+// win over what can be read from the patch header.
+func NewFileDiff(oldPath, newPath, status, patch string) (*FileDiff, error) {
+	parsed, err := ParsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	fd := &FileDiff{Status: StatusModified}
+	if len(parsed.Files) > 0 {
+		fd = parsed.Files[0]
+	}
+	if status != "" {
+		fd.Status = NormalizeStatus(status)
+	}
+	if newPath != "" {
+		fd.NewPath = newPath
+	}
+	if oldPath != "" {
+		fd.OldPath = oldPath
+	}
+	switch fd.Status {
+	case StatusAdded:
+		fd.OldPath = ""
+	case StatusRemoved:
+		if fd.OldPath == "" {
+			fd.OldPath = fd.NewPath
+		}
+		fd.NewPath = ""
+	default:
+		if fd.OldPath == "" {
+			fd.OldPath = fd.NewPath
+		}
+	}
+	if isBinaryPatch(patch) {
+		fd.IsBinary = true
+	}
+	return fd, nil
+}
+
+// NormalizeStatus maps the file status names of the different platforms to
+// the Status* constants
+func NormalizeStatus(status string) string {
+	switch strings.ToLower(status) {
+	case "added", "new", "a":
+		return StatusAdded
+	case "removed", "deleted", "d":
+		return StatusRemoved
+	case "renamed", "r":
+		return StatusRenamed
+	case "copied", "c":
+		return StatusCopied
+	default:
+		return StatusModified
+	}
+}
+
+// ParsePatch parses a multi-file git diff or the bare hunks of a single
+// file, as returned by the GitHub and GitLab APIs
+func ParsePatch(patch string) (*Patch, error) {
+	lines := strings.Split(patch, "\n")
+	result := &Patch{}
+	var prelude []string
+
+	// 只认行首的 diff 头，新增行中出现的 "diff --git " 不算
+	if !strings.HasPrefix(patch, "diff --git ") && !strings.Contains(patch, "\ndiff --git ") {
+		file := &FileDiff{}
+		i := 0
+		for ; i < len(lines) && !strings.HasPrefix(lines[i], "@@"); i++ {
+			if isFileHeaderLine(lines[i]) {
+				file.Header = append(file.Header, lines[i])
+			} else {
+				prelude = append(prelude, lines[i])
+			}
+		}
+		if err := file.parseBody(lines[i:]); err != nil {
+			return nil, err
+		}
+		result.Prelude = joinLines(prelude)
+		if len(file.Hunks) > 0 || len(file.Header) > 0 {
+			result.Files = append(result.Files, file)
+		}
+		return result, nil
+	}
+
+	var (
+		current *FileDiff
+		body    []string
+	)
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		if err := current.parseBody(body); err != nil {
+			return err
+		}
+		result.Files = append(result.Files, current)
+		return nil
+	}
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "diff --git ") {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = &FileDiff{Header: []string{line}}
+			body = nil
+			continue
+		}
+		switch {
+		case current == nil:
+			prelude = append(prelude, line)
+		case len(body) == 0 && !strings.HasPrefix(line, "@@"):
+			current.Header = append(current.Header, line)
+		default:
+			body = append(body, line)
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+
+	result.Prelude = joinLines(prelude)
+	return result, nil
+}
+
+// parseBody reads the header and the hunk lines of the file
+func (f *FileDiff) parseBody(body []string) error {
+	f.parseHeader()
+
+	hunks, err := ParseHunks(strings.Join(body, "\n"))
+	if err != nil {
+		return fmt.Errorf("%s: %w", f.Path(), err)
+	}
+	f.Hunks = hunks
+	f.Patch = strings.Join(body, "\n")
+	for _, h := range hunks {
+		for _, l := range h.Lines {
+			switch l.Type {
+			case LineAdded:
+				f.Additions++
+			case LineDeleted:
+				f.Deletions++
+			}
+		}
+	}
+	return nil
+}
+
+// parseHeader derives paths, status and the binary flag from the git header
+func (f *FileDiff) parseHeader() {
+	f.Status = StatusModified
+	oldSet, newSet := false, false
+
+	for _, line := range f.Header {
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			// "diff --git a/old b/new"，路径中含空格时以 " b/" 为分隔
+			rest := strings.TrimPrefix(line, "diff --git ")
+			if i := strings.LastIndex(rest, " b/"); i >= 0 {
+				f.OldPath = strings.TrimPrefix(rest[:i], "a/")
+				f.NewPath = rest[i+3:]
+			}
+		case strings.HasPrefix(line, "--- "):
+			f.OldPath, oldSet = headerPath(strings.TrimPrefix(line, "--- "), "a/"), true
+		case strings.HasPrefix(line, "+++ "):
+			f.NewPath, newSet = headerPath(strings.TrimPrefix(line, "+++ "), "b/"), true
+		case strings.HasPrefix(line, "new file mode"):
+			f.Status = StatusAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			f.Status = StatusRemoved
+		case strings.HasPrefix(line, "rename from "):
+			f.Status = StatusRenamed
+			f.OldPath = strings.TrimPrefix(line, "rename from ")
+		case strings.HasPrefix(line, "rename to "):
+			f.NewPath = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "copy from "):
+			f.Status = StatusCopied
+			f.OldPath = strings.TrimPrefix(line, "copy from ")
+		case strings.HasPrefix(line, "copy to "):
+			f.NewPath = strings.TrimPrefix(line, "copy to ")
+		case strings.HasPrefix(line, "Binary files "), strings.HasPrefix(line, "GIT binary patch"):
+			f.IsBinary = true
+		}
+	}
+
+	// ---/+++ 为 /dev/null 时表示新增或删除
+	if oldSet && f.OldPath == "" {
+		f.Status = StatusAdded
+	}
+	if newSet && f.NewPath == "" {
+		f.Status = StatusRemoved
+	}
+	switch f.Status {
+	case StatusAdded:
+		f.OldPath = ""
+	case StatusRemoved:
+		f.NewPath = ""
+	}
+}
+
+// headerPath strips the a/ or b/ prefix of a ---/+++ path, /dev/null
+// becomes the empty path
+func headerPath(name, prefix string) string {
+	// git 会在含空格的文件名后追加制表符
+	name = strings.TrimRight(name, "\t")
+	if name == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(name, prefix)
+}
+
+// isFileHeaderLine reports whether a line before the first hunk belongs to
+// the git file header
+func isFileHeaderLine(line string) bool {
+	for _, prefix := range []string{"--- ", "+++ ", "index ", "new file mode", "deleted file mode",
+		"old mode", "new mode", "similarity index", "rename from", "rename to", "copy from", "copy to",
+		"Binary files", "GIT binary patch"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBinaryPatch reports whether a patch describes a binary change
+func isBinaryPatch(patch string) bool {
+	return strings.HasPrefix(patch, "Binary files ") || strings.Contains(patch, "\nBinary files ") ||
+		strings.Contains(patch, "GIT binary patch")
+}
+
+func joinLines(lines []string) string {
+	text := strings.Join(lines, "\n")
+	if strings.TrimSpace(text) == "" {
+		return ""
+	}
+	return text + "\n"
+}
@@ -0,0 +1,170 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+)
+
+const multiFileDiff = `diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -1,2 +1,3 @@
+ package main
++import "fmt"
+ func main() {}
+diff --git a/new.go b/new.go
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/new.go
+@@ -0,0 +1,2 @@
++package main
++func helper() {}
+diff --git a/old.go b/old.go
+deleted file mode 100644
+index 4444444..0000000
+--- a/old.go
++++ /dev/null
+@@ -1 +0,0 @@
+-package main
+diff --git a/pkg/a.go b/pkg/b.go
+similarity index 90%
+rename from pkg/a.go
+rename to pkg/b.go
+index 5555555..6666666 100644
+--- a/pkg/a.go
++++ b/pkg/b.go
+@@ -1,2 +1,2 @@
+-package a
++package b
+ func f() {}
+diff --git a/logo.png b/logo.png
+index 7777777..8888888 100644
+Binary files a/logo.png and b/logo.png differ
+`
+
+func TestParseFileDiffs(t *testing.T) {
+	files, err := ParseFileDiffs(multiFileDiff)
+	if err != nil {
+		t.Fatalf("ParseFileDiffs() error = %v", err)
+	}
+
+	want := []FileDiff{
+		{OldPath: "main.go", NewPath: "main.go", Status: StatusModified, Additions: 1},
+		{NewPath: "new.go", Status: StatusAdded, Additions: 2},
+		{OldPath: "old.go", Status: StatusRemoved, Deletions: 1},
+		{OldPath: "pkg/a.go", NewPath: "pkg/b.go", Status: StatusRenamed, Additions: 1, Deletions: 1},
+		{OldPath: "logo.png", NewPath: "logo.png", Status: StatusModified, IsBinary: true},
+	}
+	if len(files) != len(want) {
+		t.Fatalf("ParseFileDiffs() got %d files, want %d", len(files), len(want))
+	}
+	for i, w := range want {
+		got := files[i]
+		if got.OldPath != w.OldPath || got.NewPath != w.NewPath || got.Status != w.Status ||
+			got.IsBinary != w.IsBinary || got.Additions != w.Additions || got.Deletions != w.Deletions {
+			t.Errorf("file %d = {%s %s %s binary=%v +%d -%d}, want {%s %s %s binary=%v +%d -%d}", i,
+				got.OldPath, got.NewPath, got.Status, got.IsBinary, got.Additions, got.Deletions,
+				w.OldPath, w.NewPath, w.Status, w.IsBinary, w.Additions, w.Deletions)
+		}
+	}
+	if files[1].Patch != "@@ -0,0 +1,2 @@\n+package main\n+func helper() {}" {
+		t.Errorf("Patch = %q, want the hunk text only", files[1].Patch)
+	}
+	if files[2].Path() != "old.go" {
+		t.Errorf("Path() of a removed file = %q, want old.go", files[2].Path())
+	}
+}
+
+func TestNewFileDiff(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldPath     string
+		newPath     string
+		status      string
+		patch       string
+		wantOld     string
+		wantNew     string
+		wantStatus  string
+		wantBinary  bool
+		wantAdded   int
+		wantDeleted int
+	}{
+		{name: "github modified", oldPath: "", newPath: "main.go", status: "modified", patch: samplePatch,
+			wantOld: "main.go", wantNew: "main.go", wantStatus: StatusModified, wantAdded: 3, wantDeleted: 2},
+		{name: "gitlab deleted", oldPath: "old.go", newPath: "old.go", status: "deleted", patch: "@@ -1 +0,0 @@\n-package old\n",
+			wantOld: "old.go", wantStatus: StatusRemoved, wantDeleted: 1},
+		{name: "gitlab renamed", oldPath: "a.go", newPath: "b.go", status: "renamed",
+			wantOld: "a.go", wantNew: "b.go", wantStatus: StatusRenamed},
+		{name: "binary", newPath: "logo.png", status: "added", patch: "Binary files /dev/null and b/logo.png differ\n",
+			wantNew: "logo.png", wantStatus: StatusAdded, wantBinary: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFileDiff(tt.oldPath, tt.newPath, tt.status, tt.patch)
+			if err != nil {
+				t.Fatalf("NewFileDiff() error = %v", err)
+			}
+			if got.OldPath != tt.wantOld || got.NewPath != tt.wantNew || got.Status != tt.wantStatus ||
+				got.IsBinary != tt.wantBinary || got.Additions != tt.wantAdded || got.Deletions != tt.wantDeleted {
+				t.Errorf("NewFileDiff() = {%s %s %s binary=%v +%d -%d}", got.OldPath, got.NewPath, got.Status,
+					got.IsBinary, got.Additions, got.Deletions)
+			}
+		})
+	}
+}
+
+func TestParsePatchPrelude(t *testing.T) {
+	parsed, err := ParsePatch("/* context */\n" + multiFileDiff)
+	if err != nil {
+		t.Fatalf("ParsePatch() error = %v", err)
+	}
+	if parsed.Prelude != "/* context */\n" || len(parsed.Files) != 5 {
+		t.Errorf("ParsePatch() prelude = %q, %d files", parsed.Prelude, len(parsed.Files))
+	}
+}
+
+func TestParsePatchHunksMentioningDiffHeader(t *testing.T) {
+	patch := "@@ -1,1 +1,2 @@\n line\n+diff --git a/x b/x\n"
+	parsed, err := ParsePatch(patch)
+	if err != nil {
+		t.Fatalf("ParsePatch() error = %v", err)
+	}
+	if len(parsed.Files) != 1 || len(parsed.Files[0].Hunks) != 1 || len(parsed.Files[0].Hunks[0].Lines) != 2 {
+		t.Fatalf("ParsePatch() = %+v, want the hunk of a single file", parsed.Files)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	patch, err := Unified("package main\n\nfunc main() {}\n", "package main\n\nimport \"fmt\"\n\nfunc main() {}\n", 3)
+	if err != nil {
+		t.Fatalf("Unified() error = %v", err)
+	}
+	hunks, err := ParseHunks(patch)
+	if err != nil {
+		t.Fatalf("ParseHunks() error = %v", err)
+	}
+	if len(hunks) != 1 || hunks[0].OldStart != 1 || hunks[0].NewLines != 5 {
+		t.Fatalf("hunks = %+v, patch:\n%s", hunks, patch)
+	}
+	added := 0
+	for _, l := range hunks[0].Lines {
+		if l.Type == LineAdded {
+			added++
+		}
+	}
+	if added != 2 {
+		t.Errorf("got %d added lines, want 2, patch:\n%s", added, patch)
+	}
+
+	// 新增文件的所有行都是新增行
+	patch, _ = Unified("", "a\nb", 3)
+	if !strings.HasPrefix(patch, "@@ -0,0 +1,2 @@\n+a\n+b\n") {
+		t.Errorf("Unified() of an added file = %q", patch)
+	}
+	if patch, _ := Unified("same\n", "same\n", 3); patch != "" {
+		t.Errorf("Unified() of equal texts = %q", patch)
+	}
+}
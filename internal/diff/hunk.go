@@ -0,0 +1,216 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Side identifies which version of a file a line number refers to.
+// The values match the GitHub review API.
+type Side string
+
+const (
+	// SideLeft is the old version of the file (deleted or context lines)
+	SideLeft Side = "LEFT"
+	// SideRight is the new version of the file (added or context lines)
+	SideRight Side = "RIGHT"
+)
+
+// LineType is the kind of a line inside a hunk
+type LineType int
+
+const (
+	LineContext LineType = iota
+	LineAdded
+	LineDeleted
+)
+
+// Line is a single line inside a hunk
+type Line struct {
+	Type    LineType
+	Content string
+	// OldLine is the line number in the old file, 0 for added lines
+	OldLine int
+	// NewLine is the line number in the new file, 0 for deleted lines
+	NewLine int
+	// Position is the GitHub diff position: the number of lines below the
+	// first @@ header of the file patch
+	Position int
+}
+
+// Hunk is one @@ section of a file patch
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	// Header is the full @@ line including the optional section heading
+	Header string
+	// Position is the GitHub diff position of the header line itself
+	Position int
+	Lines    []*Line
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ParseHunks parses the hunks of a single-file patch. Any file header lines
+// (diff --git, ---, +++ ...) before the first @@ header are skipped.
+func ParseHunks(patch string) ([]*Hunk, error) {
+	hunks := make([]*Hunk, 0)
+	var current *Hunk
+	oldLine, newLine := 0, 0
+	// position 从第一个 @@ 头开始计数，头本身为 0
+	position := -1
+
+	for _, raw := range strings.Split(patch, "\n") {
+		raw = strings.TrimSuffix(raw, "\r")
+
+		if strings.HasPrefix(raw, "@@") {
+			header, err := parseHunkHeader(raw)
+			if err != nil {
+				return nil, err
+			}
+			position++
+			header.Position = position
+			current = header
+			oldLine, newLine = header.OldStart, header.NewStart
+			hunks = append(hunks, current)
+			continue
+		}
+
+		// 第一个 hunk 之前的内容是文件头
+		if current == nil {
+			continue
+		}
+
+		if raw == "" {
+			// 有些工具会去掉空上下文行的前导空格；hunk 已完整时则是补丁末尾
+			if current.complete(oldLine, newLine) {
+				continue
+			}
+			raw = " "
+		}
+
+		line := &Line{}
+		switch raw[0] {
+		case '+':
+			line.Type = LineAdded
+			line.NewLine = newLine
+			newLine++
+		case '-':
+			line.Type = LineDeleted
+			line.OldLine = oldLine
+			oldLine++
+		case ' ':
+			line.Type = LineContext
+			line.OldLine = oldLine
+			line.NewLine = newLine
+			oldLine++
+			newLine++
+		case '\\':
+			// "\ No newline at end of file" 占用一个 position，但不是代码行
+			position++
+			continue
+		default:
+			// 多文件补丁中下一个文件的头部，结束当前 hunk
+			current = nil
+			continue
+		}
+		position++
+		line.Position = position
+		line.Content = raw[1:]
+		current.Lines = append(current.Lines, line)
+	}
+
+	return hunks, nil
+}
+
+// complete reports whether all lines announced by the hunk header have been read
+func (h *Hunk) complete(oldLine, newLine int) bool {
+	return oldLine >= h.OldStart+h.OldLines && newLine >= h.NewStart+h.NewLines
+}
+
+// LineCounters returns the old and new file line counters at each line of
+// the hunk. Added lines get the next old line number and deleted lines the
+// next new line number, as GitLab uses for line codes.
+func (h *Hunk) LineCounters() (oldPos, newPos []int) {
+	oldPos = make([]int, len(h.Lines))
+	newPos = make([]int, len(h.Lines))
+	oldLine, newLine := h.OldStart, h.NewStart
+	for i, l := range h.Lines {
+		oldPos[i], newPos[i] = oldLine, newLine
+		if l.Type != LineAdded {
+			oldLine++
+		}
+		if l.Type != LineDeleted {
+			newLine++
+		}
+	}
+	return oldPos, newPos
+}
+
+// parseHunkHeader parses a "@@ -a,b +c,d @@ heading" line
+func parseHunkHeader(raw string) (*Hunk, error) {
+	m := hunkHeaderRe.FindStringSubmatch(raw)
+	if m == nil {
+		return nil, fmt.Errorf("invalid hunk header: %q", raw)
+	}
+
+	h := &Hunk{Header: raw, OldLines: 1, NewLines: 1}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		h.OldLines, _ = strconv.Atoi(m[2])
+	}
+	h.NewStart, _ = strconv.Atoi(m[3])
+	if m[4] != "" {
+		h.NewLines, _ = strconv.Atoi(m[4])
+	}
+	return h, nil
+}
+
+// FindLine looks up the diff line for a file line number on the given side.
+// It returns the line and its hunk, or nil if the line is not part of the diff.
+func FindLine(hunks []*Hunk, number int, side Side) (*Line, *Hunk) {
+	if number <= 0 {
+		return nil, nil
+	}
+	for _, h := range hunks {
+		for _, l := range h.Lines {
+			if side == SideLeft {
+				if l.Type != LineAdded && l.OldLine == number {
+					return l, h
+				}
+			} else if l.Type != LineDeleted && l.NewLine == number {
+				return l, h
+			}
+		}
+	}
+	return nil, nil
+}
+
+// ChangesOldLines reports whether the hunks delete or replace any of the
+// lines start to end of the old file, or insert lines between them
+func ChangesOldLines(hunks []*Hunk, start, end int) bool {
+	for _, h := range hunks {
+		// previous 是新增行之前最后一个旧文件的行号
+		previous := h.OldStart - 1
+		for _, l := range h.Lines {
+			switch l.Type {
+			case LineDeleted:
+				if l.OldLine >= start && l.OldLine <= end {
+					return true
+				}
+				previous = l.OldLine
+			case LineAdded:
+				if previous >= start && previous < end {
+					return true
+				}
+			default:
+				previous = l.OldLine
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package diff
+
+import (
+	"testing"
+)
+
+const samplePatch = `@@ -1,4 +1,5 @@ package main
+ import "fmt"
+-func a() {}
++func a() int { return 1 }
++func b() {}
+
+ func main() {
+@@ -10,3 +11,3 @@ func main() {
+ 	x := 1
+-	fmt.Println(x)
++	fmt.Println(x + 1)
+\ No newline at end of file`
+
+func TestParseHunks(t *testing.T) {
+	hunks, err := ParseHunks(samplePatch)
+	if err != nil {
+		t.Fatalf("ParseHunks() error = %v", err)
+	}
+	if len(hunks) != 2 {
+		t.Fatalf("ParseHunks() got %d hunks, want 2", len(hunks))
+	}
+	if hunks[1].Position != 7 {
+		t.Errorf("second hunk header position = %d, want 7", hunks[1].Position)
+	}
+	if hunks[1].OldStart != 10 || hunks[1].NewStart != 11 {
+		t.Errorf("second hunk starts = -%d +%d, want -10 +11", hunks[1].OldStart, hunks[1].NewStart)
+	}
+}
+
+func TestFindLine(t *testing.T) {
+	hunks, err := ParseHunks(samplePatch)
+	if err != nil {
+		t.Fatalf("ParseHunks() error = %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		line         int
+		side         Side
+		wantPosition int
+		wantType     LineType
+		wantFound    bool
+	}{
+		{name: "added line", line: 3, side: SideRight, wantPosition: 4, wantType: LineAdded, wantFound: true},
+		{name: "deleted line", line: 2, side: SideLeft, wantPosition: 2, wantType: LineDeleted, wantFound: true},
+		{name: "context line new side", line: 5, side: SideRight, wantPosition: 6, wantType: LineContext, wantFound: true},
+		{name: "empty context line", line: 4, side: SideRight, wantPosition: 5, wantType: LineContext, wantFound: true},
+		{name: "second hunk added", line: 12, side: SideRight, wantPosition: 10, wantType: LineAdded, wantFound: true},
+		{name: "outside diff", line: 8, side: SideRight, wantFound: false},
+		{name: "old side outside diff", line: 7, side: SideLeft, wantFound: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			line, _ := FindLine(hunks, tt.line, tt.side)
+			if (line != nil) != tt.wantFound {
+				t.Fatalf("FindLine() found = %v, want %v", line != nil, tt.wantFound)
+			}
+			if line == nil {
+				return
+			}
+			if line.Position != tt.wantPosition {
+				t.Errorf("FindLine() position = %d, want %d", line.Position, tt.wantPosition)
+			}
+			if line.Type != tt.wantType {
+				t.Errorf("FindLine() type = %v, want %v", line.Type, tt.wantType)
+			}
+		})
+	}
+}
+
+func TestChangesOldLines(t *testing.T) {
+	sample, err := ParseHunks(samplePatch)
+	if err != nil {
+		t.Fatalf("ParseHunks() error = %v", err)
+	}
+	insertion, err := ParseHunks("@@ -1,2 +1,3 @@\n a\n+b\n c\n")
+	if err != nil {
+		t.Fatalf("ParseHunks() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		hunks      []*Hunk
+		start, end int
+		want       bool
+	}{
+		{name: "replaced line", hunks: sample, start: 2, end: 2, want: true},
+		{name: "context line", hunks: sample, start: 1, end: 1, want: false},
+		{name: "lines after the change", hunks: sample, start: 3, end: 4, want: false},
+		{name: "range reaching a deleted line", hunks: sample, start: 10, end: 11, want: true},
+		{name: "outside the hunks", hunks: sample, start: 5, end: 9, want: false},
+		{name: "insertion inside the range", hunks: insertion, start: 1, end: 2, want: true},
+		{name: "insertion after the range", hunks: insertion, start: 1, end: 1, want: false},
+		{name: "insertion before the range", hunks: insertion, start: 2, end: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ChangesOldLines(tt.hunks, tt.start, tt.end); got != tt.want {
+				t.Errorf("ChangesOldLines(%d, %d) = %v, want %v", tt.start, tt.end, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultOverlapLines is the number of lines repeated from the previous
+// chunk when a hunk has to be split
+const DefaultOverlapLines = 3
+
+// Range is a part of a file owned by a chunk. Line numbers are inclusive,
+// both ends are 0 if the chunk has no lines on that side.
+type Range struct {
+	Path     string
+	OldStart int
+	OldEnd   int
+	NewStart int
+	NewEnd   int
+}
+
+// Contains reports whether the file line on the given side is in the range.
+// An empty path matches any file.
+func (r Range) Contains(path string, number int, side Side) bool {
+	if path != "" && r.Path != "" && path != r.Path {
+		return false
+	}
+	if side == SideLeft {
+		return r.OldStart > 0 && number >= r.OldStart && number <= r.OldEnd
+	}
+	return r.NewStart > 0 && number >= r.NewStart && number <= r.NewEnd
+}
+
+// Chunk is a self-contained part of a patch that fits the token budget
+type Chunk struct {
+	Patch string
+	// Ranges are the lines this chunk is responsible for; overlap lines
+	// repeated from the previous chunk are not included
+	Ranges []Range
+}
+
+// Contains reports whether the chunk owns the file line on the given side
+func (c Chunk) Contains(path string, number int, side Side) bool {
+	for _, r := range c.Ranges {
+		if r.Contains(path, number, side) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenCounter counts the tokens of a text, see package tokenizer
+type TokenCounter interface {
+	CountTokens(text string) int
+}
+
+// Split cuts a patch into chunks of at most maxTokens tokens. Hunks are
+// kept whole when they fit; larger hunks are cut into smaller hunks with
+// recomputed @@ headers that repeat the last overlap lines of the previous
+// part. Every chunk repeats the header of the file it starts in.
+func Split(patch string, maxTokens int, tk TokenCounter, overlap int) ([]Chunk, error) {
+	parsed, err := ParsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	s := &splitter{maxTokens: maxTokens, tk: tk, overlap: overlap}
+	s.current.WriteString(parsed.Prelude)
+	s.tokens = tk.CountTokens(parsed.Prelude)
+
+	for _, file := range parsed.Files {
+		header := joinLines(file.Header)
+		headerTokens := tk.CountTokens(header)
+		if len(file.Hunks) == 0 {
+			s.add(file, header, headerTokens, "", 0, Range{Path: file.Path()})
+			continue
+		}
+		for _, h := range file.Hunks {
+			text := renderHunk(h, 0, 0, len(h.Lines))
+			tokens := tk.CountTokens(text)
+			if headerTokens+tokens <= maxTokens {
+				s.add(file, header, headerTokens, text, tokens, ownedRange(file.Path(), h, 0, len(h.Lines)))
+				continue
+			}
+			s.splitHunk(file, header, headerTokens, h)
+		}
+	}
+	s.flush()
+
+	return s.chunks, nil
+}
+
+// splitter accumulates rendered hunks into chunks
+type splitter struct {
+	maxTokens int
+	tk        TokenCounter
+	overlap   int
+
+	chunks  []Chunk
+	current strings.Builder
+	tokens  int
+	ranges  []Range
+	// file is the file whose header was last written to the current chunk
+	file *FileDiff
+}
+
+// add appends text of file to the current chunk, starting a new chunk if it
+// does not fit
+func (s *splitter) add(file *FileDiff, header string, headerTokens int, text string, tokens int, owned Range) {
+	need := tokens
+	if s.file != file {
+		need += headerTokens
+	}
+	// 只有前导上下文的块不值得单独审查
+	if len(s.ranges) > 0 && s.tokens+need > s.maxTokens {
+		s.flush()
+		need = headerTokens + tokens
+	}
+	if s.file != file {
+		s.current.WriteString(header)
+		s.file = file
+	}
+	s.current.WriteString(text)
+	s.tokens += need
+	s.ranges = append(s.ranges, owned)
+}
+
+// flush closes the current chunk
+func (s *splitter) flush() {
+	if s.current.Len() > 0 {
+		s.chunks = append(s.chunks, Chunk{Patch: s.current.String(), Ranges: s.ranges})
+	}
+	s.current.Reset()
+	s.tokens = 0
+	s.ranges = nil
+	s.file = nil
+}
+
+// splitHunk cuts a hunk that does not fit into a chunk on its own
+func (s *splitter) splitHunk(file *FileDiff, header string, headerTokens int, h *Hunk) {
+	lineTokens := make([]int, len(h.Lines))
+	for i, l := range h.Lines {
+		lineTokens[i] = s.tk.CountTokens(linePrefix(l.Type) + l.Content + "\n")
+	}
+	// 重新计算的 @@ 头与原来的长度相近，多留一些余量
+	base := headerTokens + s.tk.CountTokens(h.Header+"\n") + 4
+
+	for start := 0; start < len(h.Lines); {
+		from := start
+		if start > 0 {
+			from = max(start-s.overlap, 0)
+		}
+		tokens := base
+		for i := from; i < start; i++ {
+			tokens += lineTokens[i]
+		}
+		// 每块至少包含一行新内容，避免超长的单行导致死循环
+		end := start + 1
+		tokens += lineTokens[start]
+		for end < len(h.Lines) && tokens+lineTokens[end] <= s.maxTokens {
+			tokens += lineTokens[end]
+			end++
+		}
+
+		text := renderHunk(h, from, start, end)
+		s.add(file, header, headerTokens, text, s.tk.CountTokens(text), ownedRange(file.Path(), h, start, end))
+		start = end
+	}
+}
+
+// renderHunk renders lines [from, end) of a hunk with a recomputed header.
+// Lines before start are overlap; they are only relevant to the caller's
+// ownership bookkeeping.
+func renderHunk(h *Hunk, from, start, end int) string {
+	if from == 0 && end == len(h.Lines) {
+		var b strings.Builder
+		b.WriteString(h.Header + "\n")
+		writeLines(&b, h.Lines)
+		return b.String()
+	}
+
+	oldPos, newPos := h.LineCounters()
+	oldCount, newCount := 0, 0
+	for _, l := range h.Lines[from:end] {
+		if l.Type != LineAdded {
+			oldCount++
+		}
+		if l.Type != LineDeleted {
+			newCount++
+		}
+	}
+	oldStart, newStart := oldPos[from], newPos[from]
+	// 与 git 一致，行数为 0 时起始行号指向前一行
+	if oldCount == 0 && h.OldLines > 0 {
+		oldStart--
+	}
+	if newCount == 0 && h.NewLines > 0 {
+		newStart--
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@%s\n", oldStart, oldCount, newStart, newCount, hunkHeading(h.Header))
+	writeLines(&b, h.Lines[from:end])
+	return b.String()
+}
+
+// hunkHeading returns the optional section heading after the @@ markers,
+// including its leading space
+func hunkHeading(header string) string {
+	if i := strings.Index(header[2:], "@@"); i >= 0 {
+		return header[i+4:]
+	}
+	return ""
+}
+
+func writeLines(b *strings.Builder, lines []*Line) {
+	for _, l := range lines {
+		b.WriteString(linePrefix(l.Type))
+		b.WriteString(l.Content)
+		b.WriteString("\n")
+	}
+}
+
+func linePrefix(t LineType) string {
+	switch t {
+	case LineAdded:
+		return "+"
+	case LineDeleted:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// ownedRange returns the file lines covered by lines [start, end) of a hunk
+func ownedRange(path string, h *Hunk, start, end int) Range {
+	r := Range{Path: path}
+	for _, l := range h.Lines[start:end] {
+		if l.Type != LineAdded {
+			if r.OldStart == 0 {
+				r.OldStart = l.OldLine
+			}
+			r.OldEnd = l.OldLine
+		}
+		if l.Type != LineDeleted {
+			if r.NewStart == 0 {
+				r.NewStart = l.NewLine
+			}
+			r.NewEnd = l.NewLine
+		}
+	}
+	return r
+}
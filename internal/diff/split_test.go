@@ -0,0 +1,116 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// lineTokenizer counts one token per line
+type lineTokenizer struct{}
+
+func (lineTokenizer) Name() string { return "lines" }
+func (lineTokenizer) CountTokens(text string) int {
+	return strings.Count(text, "\n")
+}
+
+func TestSplitKeepsHunksWhole(t *testing.T) {
+	patch := "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n" + samplePatch
+
+	chunks, err := Split(patch, 10, lineTokenizer{}, DefaultOverlapLines)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	if len(chunks) != 2 {
+		t.Fatalf("Split() got %d chunks, want 2:\n%v", len(chunks), chunks)
+	}
+	for i, chunk := range chunks {
+		if !strings.HasPrefix(chunk.Patch, "diff --git a/a.go b/a.go\n--- a/a.go\n+++ b/a.go\n@@ ") {
+			t.Errorf("chunk %d does not start with the file and hunk header:\n%s", i, chunk.Patch)
+		}
+		if _, err := ParseHunks(chunk.Patch); err != nil {
+			t.Errorf("chunk %d is not a valid patch: %v", i, err)
+		}
+	}
+	if !chunks[0].Contains("a.go", 3, SideRight) || chunks[0].Contains("a.go", 12, SideRight) {
+		t.Errorf("first chunk ranges = %+v", chunks[0].Ranges)
+	}
+	if !chunks[1].Contains("a.go", 12, SideRight) || !chunks[1].Contains("a.go", 11, SideLeft) {
+		t.Errorf("second chunk ranges = %+v", chunks[1].Ranges)
+	}
+}
+
+func TestSplitLargeHunk(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("@@ -1,20 +1,30 @@ func big() {\n")
+	for i := 1; i <= 20; i++ {
+		fmt.Fprintf(&b, " line %d\n", i)
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "+added after %d\n", i)
+		}
+	}
+
+	chunks, err := Split(b.String(), 12, lineTokenizer{}, 2)
+	if err != nil {
+		t.Fatalf("Split() error = %v", err)
+	}
+	if len(chunks) < 3 {
+		t.Fatalf("Split() got %d chunks, want at least 3", len(chunks))
+	}
+
+	covered := map[int]int{}
+	for i, chunk := range chunks {
+		if got := (lineTokenizer{}).CountTokens(chunk.Patch); got > 12 {
+			t.Errorf("chunk %d has %d tokens, want <= 12", i, got)
+		}
+		hunks, err := ParseHunks(chunk.Patch)
+		if err != nil || len(hunks) != 1 {
+			t.Fatalf("chunk %d is not a single valid hunk: %v\n%s", i, err, chunk.Patch)
+		}
+		if !strings.HasSuffix(hunks[0].Header, "@@ func big() {") {
+			t.Errorf("chunk %d header %q lost the section heading", i, hunks[0].Header)
+		}
+		// 重新计算的头部必须与内容一致
+		h := hunks[0]
+		if !headerMatches(h) {
+			t.Errorf("chunk %d header %q does not match its %d lines", i, h.Header, len(h.Lines))
+		}
+		if i > 0 && !strings.HasPrefix(h.Lines[0].Content, "line") && !strings.HasPrefix(h.Lines[0].Content, "added") {
+			t.Errorf("chunk %d does not start with overlap lines", i)
+		}
+		for _, l := range h.Lines {
+			if chunk.Contains("", l.NewLine, SideRight) {
+				covered[l.NewLine]++
+			}
+		}
+	}
+	for line := 1; line <= 30; line++ {
+		if covered[line] != 1 {
+			t.Errorf("new line %d is owned by %d chunks, want 1", line, covered[line])
+		}
+	}
+}
+
+// headerMatches reports whether the line counts of the @@ header match the hunk body
+func headerMatches(h *Hunk) bool {
+	oldLines, newLines := 0, 0
+	for _, l := range h.Lines {
+		if l.Type != LineAdded {
+			oldLines++
+		}
+		if l.Type != LineDeleted {
+			newLines++
+		}
+	}
+	return oldLines == h.OldLines && newLines == h.NewLines
+}
+
+func TestSplitSmallPatch(t *testing.T) {
+	chunks, err := Split(samplePatch, 1000, lineTokenizer{}, DefaultOverlapLines)
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("Split() = %d chunks, %v, want 1", len(chunks), err)
+	}
+	if len(chunks[0].Ranges) != 2 || !strings.Contains(chunks[0].Patch, "@@ -10,3 +11,3 @@ func main() {") {
+		t.Errorf("Split() = %+v, want both hunks in one chunk", chunks[0])
+	}
+}
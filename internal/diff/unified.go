@@ -0,0 +1,41 @@
+package diff
+
+import (
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// DefaultContextLines is the number of unchanged lines shown around the
+// changes of a unified diff, as in git diff
+const DefaultContextLines = 3
+
+// Unified computes the hunks of a unified diff between two versions of a
+// file, in the format ParseHunks reads. It is used for platforms whose APIs
+// return file contents but no patches.
+func Unified(oldText, newText string, context int) (string, error) {
+	if oldText == newText {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:       splitLines(oldText),
+		B:       splitLines(newText),
+		Context: context,
+	})
+}
+
+// splitLines splits text into lines that keep their newline. Unlike
+// difflib.SplitLines it does not add an empty line after a final newline.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		// 最后一行没有换行符
+		lines[len(lines)-1] += "\n"
+	}
+	return lines
+}
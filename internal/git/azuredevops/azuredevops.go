@@ -0,0 +1,916 @@
+package azuredevops
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// apiVersion is the REST API version sent with every request
+const apiVersion = "7.1"
+
+// pageSize is the page size used for paginated API calls
+const pageSize = 500
+
+// maxFileSize bounds the file versions downloaded to diff a change. Larger
+// files are listed without a patch, like the patches GitHub omits.
+const maxFileSize = 1 << 20
+
+// errTooLarge is returned for responses larger than the limit of the request
+var errTooLarge = errors.New("response too large")
+
+// StatusContext identifies the review status among the statuses of a pull
+// request, e.g. in branch policies
+var StatusContext = statusContext{Name: models.StatusContext, Genre: "ai-code-reviewer"}
+
+// Client implements the models.GitPlatform interface for Azure DevOps Repos.
+// The owner is the project and the repo is the repository name.
+type Client struct {
+	httpClient *http.Client
+	orgURL     string
+	config     *config.Config
+
+	mu sync.Mutex
+	// userID is the ID of the authenticated user once looked up
+	userID string
+}
+
+// NewClient creates a new Azure DevOps client
+func NewClient(cfg *config.Config) (*Client, error) {
+	// 只有当选择的平台是Azure DevOps时，才检查令牌和组织URL
+	if cfg.Platform == "azuredevops" {
+		if cfg.AzureDevOpsToken == "" {
+			return nil, errors.New("Azure DevOps token is required when using Azure DevOps platform")
+		}
+
+		if cfg.AzureDevOpsOrgURL == "" {
+			return nil, errors.New("Azure DevOps organization URL is required when using Azure DevOps platform")
+		}
+	}
+
+	return &Client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		orgURL:     strings.TrimSuffix(cfg.AzureDevOpsOrgURL, "/"),
+		config:     cfg,
+	}, nil
+}
+
+// CloneURL returns the HTTPS clone URL of a repository
+func (c *Client) CloneURL(project, repo string) string {
+	return fmt.Sprintf("%s/%s/_git/%s", c.orgURL, url.PathEscape(project), url.PathEscape(repo))
+}
+
+// Azure DevOps API types, only the fields used by the client
+
+type pullRequest struct {
+	PullRequestID int    `json:"pullRequestId"`
+	Title         string `json:"title"`
+	Description   string `json:"description"`
+	// Status is active, completed or abandoned
+	Status                string      `json:"status"`
+	IsDraft               bool        `json:"isDraft"`
+	SourceRefName         string      `json:"sourceRefName"`
+	TargetRefName         string      `json:"targetRefName"`
+	LastMergeSourceCommit *commitRef  `json:"lastMergeSourceCommit"`
+	LastMergeTargetCommit *commitRef  `json:"lastMergeTargetCommit"`
+	Labels                []label     `json:"labels"`
+	Repository            *repository `json:"repository"`
+}
+
+type commitRef struct {
+	CommitID string `json:"commitId"`
+}
+
+type label struct {
+	Name   string `json:"name"`
+	Active *bool  `json:"active"`
+}
+
+type repository struct {
+	Name    string `json:"name"`
+	WebURL  string `json:"webUrl"`
+	Project struct {
+		Name string `json:"name"`
+	} `json:"project"`
+}
+
+type iteration struct {
+	ID              int        `json:"id"`
+	SourceRefCommit *commitRef `json:"sourceRefCommit"`
+	TargetRefCommit *commitRef `json:"targetRefCommit"`
+	CommonRefCommit *commitRef `json:"commonRefCommit"`
+}
+
+// change is an entry of the iteration changes and commit diff APIs
+type change struct {
+	ChangeTrackingID int `json:"changeTrackingId"`
+	Item             struct {
+		Path     string `json:"path"`
+		IsFolder bool   `json:"isFolder"`
+	} `json:"item"`
+	OriginalPath     string `json:"originalPath"`
+	SourceServerItem string `json:"sourceServerItem"`
+	// ChangeType is a comma separated list such as "edit" or "edit, rename"
+	ChangeType string `json:"changeType"`
+}
+
+type thread struct {
+	ID            int64           `json:"id,omitempty"`
+	IsDeleted     bool            `json:"isDeleted,omitempty"`
+	Comments      []threadComment `json:"comments"`
+	Status        string          `json:"status"`
+	ThreadContext *threadContext  `json:"threadContext,omitempty"`
+	// PullRequestThreadContext ties the thread to the change of an
+	// iteration, so it stays on the right line after later pushes
+	PullRequestThreadContext *pullRequestThreadContext `json:"pullRequestThreadContext,omitempty"`
+}
+
+type threadComment struct {
+	ID              int    `json:"id,omitempty"`
+	ParentCommentID int    `json:"parentCommentId"`
+	Content         string `json:"content"`
+	// CommentType is "text" for user comments and "system" for the
+	// comments Azure DevOps adds on pushes and votes
+	CommentType string `json:"commentType"`
+	// Author is set by Azure DevOps
+	Author *identityRef `json:"author,omitempty"`
+}
+
+type identityRef struct {
+	ID string `json:"id"`
+}
+
+// threadContext anchors a thread to lines of the new (right) or old (left)
+// version of a file
+type threadContext struct {
+	FilePath       string    `json:"filePath"`
+	LeftFileStart  *position `json:"leftFileStart,omitempty"`
+	LeftFileEnd    *position `json:"leftFileEnd,omitempty"`
+	RightFileStart *position `json:"rightFileStart,omitempty"`
+	RightFileEnd   *position `json:"rightFileEnd,omitempty"`
+}
+
+type position struct {
+	Line   int `json:"line"`
+	Offset int `json:"offset"`
+}
+
+type pullRequestThreadContext struct {
+	ChangeTrackingID int `json:"changeTrackingId"`
+	IterationContext struct {
+		FirstComparingIteration  int `json:"firstComparingIteration"`
+		SecondComparingIteration int `json:"secondComparingIteration"`
+	} `json:"iterationContext"`
+}
+
+type statusContext struct {
+	Name  string `json:"name"`
+	Genre string `json:"genre"`
+}
+
+type commitStatus struct {
+	State       string        `json:"state"`
+	Description string        `json:"description"`
+	TargetURL   string        `json:"targetUrl,omitempty"`
+	Context     statusContext `json:"context"`
+}
+
+type pullRequestStatus struct {
+	State       string        `json:"state"`
+	Description string        `json:"description"`
+	Context     statusContext `json:"context"`
+	IterationID int           `json:"iterationId,omitempty"`
+}
+
+func (c *Client) repoPath(project, repo string) string {
+	return fmt.Sprintf("/%s/_apis/git/repositories/%s", url.PathEscape(project), url.PathEscape(repo))
+}
+
+// GetPullRequest gets a pull request by number
+func (c *Client) GetPullRequest(ctx context.Context, owner, repo string, number int) (*models.PullRequest, error) {
+	var pr pullRequest
+	if err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/pullrequests/%d", c.repoPath(owner, repo), number), nil, nil, &pr); err != nil {
+		return nil, err
+	}
+
+	result := &models.PullRequest{
+		Number:      pr.PullRequestID,
+		Title:       pr.Title,
+		Description: pr.Description,
+		State:       pr.Status,
+		Locked:      false, // Azure DevOps doesn't lock pull requests
+		Labels:      activeLabels(pr.Labels),
+	}
+	if pr.LastMergeTargetCommit != nil {
+		result.Base.SHA = pr.LastMergeTargetCommit.CommitID
+	}
+	if pr.LastMergeSourceCommit != nil {
+		result.Head.SHA = pr.LastMergeSourceCommit.CommitID
+	}
+	if pr.Repository != nil && pr.Repository.WebURL != "" {
+		result.HTMLURL = fmt.Sprintf("%s/pullrequest/%d", pr.Repository.WebURL, pr.PullRequestID)
+	}
+	return result, nil
+}
+
+// GetPullRequestLabels gets the labels (tags) of a pull request
+func (c *Client) GetPullRequestLabels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	pr, err := c.GetPullRequest(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return pr.Labels, nil
+}
+
+func activeLabels(labels []label) []string {
+	names := make([]string, 0, len(labels))
+	for _, l := range labels {
+		if l.Active == nil || *l.Active {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
+
+// CompareCommits compares two commits and returns the files that changed
+func (c *Client) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	logrus.Debugf("Comparing commits for %s/%s: base=%s, head=%s", owner, repo, base, head)
+	repoPath := c.repoPath(owner, repo)
+
+	var changes []change
+	for skip := 0; ; skip += pageSize {
+		var page struct {
+			Changes            []change `json:"changes"`
+			AllChangesIncluded bool     `json:"allChangesIncluded"`
+		}
+		query := url.Values{
+			"baseVersion":       {base},
+			"baseVersionType":   {"commit"},
+			"targetVersion":     {head},
+			"targetVersionType": {"commit"},
+			"$top":              {fmt.Sprint(pageSize)},
+			"$skip":             {fmt.Sprint(skip)},
+		}
+		if err := c.doJSON(ctx, http.MethodGet, repoPath+"/diffs/commits", query, nil, &page); err != nil {
+			return nil, nil, fmt.Errorf("failed to compare %s...%s: %w", base, head, err)
+		}
+		changes = append(changes, page.Changes...)
+		if page.AllChangesIncluded || len(page.Changes) < pageSize {
+			break
+		}
+	}
+
+	files, err := c.commitFiles(ctx, owner, repo, base, head, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var commits []commitRef
+	for skip := 0; ; skip += pageSize {
+		var page struct {
+			Value []commitRef `json:"value"`
+		}
+		query := url.Values{
+			"searchCriteria.itemVersion.version":        {head},
+			"searchCriteria.itemVersion.versionType":    {"commit"},
+			"searchCriteria.compareVersion.version":     {base},
+			"searchCriteria.compareVersion.versionType": {"commit"},
+			"searchCriteria.$top":                       {fmt.Sprint(pageSize)},
+			"searchCriteria.$skip":                      {fmt.Sprint(skip)},
+		}
+		if err := c.doJSON(ctx, http.MethodGet, repoPath+"/commits", query, nil, &page); err != nil {
+			return nil, nil, fmt.Errorf("failed to list commits of %s...%s: %w", base, head, err)
+		}
+		commits = append(commits, page.Value...)
+		if len(page.Value) < pageSize {
+			break
+		}
+	}
+	// Azure DevOps 按从新到旧返回提交
+	gitCommits := make([]*models.Commit, len(commits))
+	for i, commit := range commits {
+		gitCommits[len(commits)-1-i] = &models.Commit{SHA: commit.CommitID}
+	}
+
+	return files, gitCommits, nil
+}
+
+// ComparePullRequest compares the latest iteration of a pull request with
+// its merge base. The returned commits are the heads of the iterations,
+// one per push, oldest first, so comparing the last two reviews the last
+// push.
+func (c *Client) ComparePullRequest(ctx context.Context, owner, repo string, number int, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	iterations, err := c.listIterations(ctx, owner, repo, number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(iterations) == 0 {
+		return nil, nil, fmt.Errorf("pull request %d has no iterations", number)
+	}
+	latest := iterations[len(iterations)-1]
+
+	changes, err := c.listIterationChanges(ctx, owner, repo, number, latest.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 以合并基准为旧版本，与 PR 页面的差异一致
+	if latest.CommonRefCommit != nil {
+		base = latest.CommonRefCommit.CommitID
+	} else if latest.TargetRefCommit != nil {
+		base = latest.TargetRefCommit.CommitID
+	}
+	if latest.SourceRefCommit != nil {
+		head = latest.SourceRefCommit.CommitID
+	}
+	files, err := c.commitFiles(ctx, owner, repo, base, head, changes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commits := make([]*models.Commit, 0, len(iterations))
+	for _, it := range iterations {
+		if it.SourceRefCommit != nil {
+			commits = append(commits, &models.Commit{SHA: it.SourceRefCommit.CommitID})
+		}
+	}
+	return files, commits, nil
+}
+
+func (c *Client) listIterations(ctx context.Context, project, repo string, number int) ([]iteration, error) {
+	var iterations struct {
+		Value []iteration `json:"value"`
+	}
+	path := fmt.Sprintf("%s/pullRequests/%d/iterations", c.repoPath(project, repo), number)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, nil, &iterations); err != nil {
+		return nil, fmt.Errorf("failed to list iterations of pull request %d: %w", number, err)
+	}
+	return iterations.Value, nil
+}
+
+// listIterationChanges lists all changes of an iteration against the
+// target branch
+func (c *Client) listIterationChanges(ctx context.Context, project, repo string, number, iterationID int) ([]change, error) {
+	path := fmt.Sprintf("%s/pullRequests/%d/iterations/%d/changes", c.repoPath(project, repo), number, iterationID)
+
+	var changes []change
+	query := url.Values{"$top": {fmt.Sprint(pageSize)}, "$compareTo": {"0"}}
+	for {
+		var page struct {
+			ChangeEntries []change `json:"changeEntries"`
+			NextSkip      int      `json:"nextSkip"`
+			NextTop       int      `json:"nextTop"`
+		}
+		if err := c.doJSON(ctx, http.MethodGet, path, query, nil, &page); err != nil {
+			return nil, fmt.Errorf("failed to list changes of pull request %d: %w", number, err)
+		}
+		changes = append(changes, page.ChangeEntries...)
+		if page.NextSkip == 0 || page.NextTop == 0 {
+			return changes, nil
+		}
+		query.Set("$skip", fmt.Sprint(page.NextSkip))
+		query.Set("$top", fmt.Sprint(page.NextTop))
+	}
+}
+
+// fileChange is a changed file of a comparison
+type fileChange struct {
+	oldPath, newPath, status string
+}
+
+// commitFiles builds the changed files with patches computed from the file
+// contents at base and head, since Azure DevOps has no patch API. Binary
+// files are told apart by their item metadata, so that only text files are
+// downloaded.
+func (c *Client) commitFiles(ctx context.Context, project, repo, base, head string, changes []change) ([]*models.CommitFile, error) {
+	var fileChanges []fileChange
+	var oldPaths, newPaths []string
+	for _, ch := range changes {
+		if ch.Item.IsFolder || ch.Item.Path == "" {
+			continue
+		}
+		fc := fileChange{status: changeStatus(ch.ChangeType), newPath: strings.TrimPrefix(ch.Item.Path, "/")}
+		fc.oldPath = fc.newPath
+		if original := firstNonEmpty(ch.OriginalPath, ch.SourceServerItem); original != "" {
+			fc.oldPath = strings.TrimPrefix(original, "/")
+		}
+		if fc.status != diff.StatusAdded {
+			oldPaths = append(oldPaths, fc.oldPath)
+		}
+		if fc.status != diff.StatusRemoved {
+			newPaths = append(newPaths, fc.newPath)
+		}
+		fileChanges = append(fileChanges, fc)
+	}
+
+	// 获取元数据失败时根据下载的内容判断二进制文件
+	oldBinary, err := c.binaryItems(ctx, project, repo, base, oldPaths)
+	if err != nil {
+		logrus.Warnf("Failed to get item metadata at %s: %v", base, err)
+	}
+	newBinary, err := c.binaryItems(ctx, project, repo, head, newPaths)
+	if err != nil {
+		logrus.Warnf("Failed to get item metadata at %s: %v", head, err)
+	}
+
+	files := make([]*models.CommitFile, 0, len(fileChanges))
+	for _, fc := range fileChanges {
+		var fd *diff.FileDiff
+		if oldBinary[fc.oldPath] || newBinary[fc.newPath] {
+			fd, err = binaryFileDiff(fc)
+		} else {
+			fd, err = c.fileDiff(ctx, project, repo, base, head, fc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		contentsURL := c.itemURL(project, repo, fd.Path(), head)
+		files = append(files, models.NewCommitFile(fd, contentsURL))
+	}
+	return files, nil
+}
+
+// itemDescriptor identifies a version of a file in an items batch request
+type itemDescriptor struct {
+	Path        string `json:"path"`
+	Version     string `json:"version"`
+	VersionType string `json:"versionType"`
+}
+
+// binaryItems returns the paths of the files among paths that are binary
+// at version, according to their item metadata
+func (c *Client) binaryItems(ctx context.Context, project, repo, version string, paths []string) (map[string]bool, error) {
+	versionType := "branch"
+	if isCommitID(version) {
+		versionType = "commit"
+	}
+
+	binary := make(map[string]bool)
+	for start := 0; start < len(paths); start += pageSize {
+		end := min(start+pageSize, len(paths))
+		request := struct {
+			ItemDescriptors        []itemDescriptor `json:"itemDescriptors"`
+			IncludeContentMetadata bool             `json:"includeContentMetadata"`
+		}{IncludeContentMetadata: true}
+		for _, path := range paths[start:end] {
+			request.ItemDescriptors = append(request.ItemDescriptors, itemDescriptor{Path: "/" + path, Version: version, VersionType: versionType})
+		}
+
+		// 每个描述符对应一组条目
+		var response struct {
+			Value [][]struct {
+				Path            string `json:"path"`
+				ContentMetadata struct {
+					IsBinary bool `json:"isBinary"`
+				} `json:"contentMetadata"`
+			} `json:"value"`
+		}
+		if err := c.doJSON(ctx, http.MethodPost, c.repoPath(project, repo)+"/itemsbatch", nil, request, &response); err != nil {
+			return nil, err
+		}
+		for _, items := range response.Value {
+			for _, item := range items {
+				if item.ContentMetadata.IsBinary {
+					binary[strings.TrimPrefix(item.Path, "/")] = true
+				}
+			}
+		}
+	}
+	return binary, nil
+}
+
+// binaryFileDiff returns the diff of a binary file, which has no patch
+func binaryFileDiff(fc fileChange) (*diff.FileDiff, error) {
+	fd, err := diff.NewFileDiff(fc.oldPath, fc.newPath, fc.status, "")
+	if err != nil {
+		return nil, err
+	}
+	fd.IsBinary = true
+	return fd, nil
+}
+
+// fileDiff fetches both versions of a file and diffs them. Files larger than
+// maxFileSize are returned without a patch.
+func (c *Client) fileDiff(ctx context.Context, project, repo, base, head string, fc fileChange) (*diff.FileDiff, error) {
+	oldPath, newPath, status := fc.oldPath, fc.newPath, fc.status
+	var oldContent, newContent []byte
+	var err error
+	if status != diff.StatusAdded {
+		oldContent, err = c.fileContent(ctx, project, repo, oldPath, base, maxFileSize)
+	}
+	if err == nil && status != diff.StatusRemoved {
+		newContent, err = c.fileContent(ctx, project, repo, newPath, head, maxFileSize)
+	}
+	if errors.Is(err, errTooLarge) {
+		logrus.Warnf("%s is larger than %d bytes, skipping its patch", newPath, maxFileSize)
+		return diff.NewFileDiff(oldPath, newPath, status, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if isBinary(oldContent) || isBinary(newContent) {
+		return binaryFileDiff(fc)
+	}
+
+	patch, err := diff.Unified(string(oldContent), string(newContent), diff.DefaultContextLines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s: %w", newPath, err)
+	}
+	return diff.NewFileDiff(oldPath, newPath, status, patch)
+}
+
+// changeStatus maps an Azure DevOps change type to a diff status
+func changeStatus(changeType string) string {
+	switch {
+	case strings.Contains(changeType, "add"):
+		return diff.StatusAdded
+	case strings.Contains(changeType, "delete"):
+		return diff.StatusRemoved
+	case strings.Contains(changeType, "rename"):
+		return diff.StatusRenamed
+	default:
+		return diff.StatusModified
+	}
+}
+
+// isBinary reports whether content looks like a binary file, the same
+// heuristic git uses
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// CreateReview creates a review on a pull request. The body becomes a
+// general thread and every comment a thread anchored to its lines.
+func (c *Client) CreateReview(ctx context.Context, owner, repo string, number int, commitID string, comments []*models.ReviewComment, body string) error {
+	if body != "" {
+		if err := c.CreatePRComment(ctx, owner, repo, number, body); err != nil {
+			return err
+		}
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+
+	// 将线程关联到最新迭代中的变更，后续推送后评论仍能定位
+	iterationID, trackingIDs := c.changeTrackingIDs(ctx, owner, repo, number)
+
+	path := fmt.Sprintf("%s/pullRequests/%d/threads", c.repoPath(owner, repo), number)
+	for _, comment := range comments {
+		t := newThread(comment.Body)
+		t.ThreadContext = commentContext(comment)
+		if id, ok := trackingIDs[comment.Path]; ok {
+			t.PullRequestThreadContext = &pullRequestThreadContext{ChangeTrackingID: id}
+			t.PullRequestThreadContext.IterationContext.FirstComparingIteration = 1
+			t.PullRequestThreadContext.IterationContext.SecondComparingIteration = iterationID
+		}
+		err := c.doJSON(ctx, http.MethodPost, path, nil, t, nil)
+		if err == nil {
+			continue
+		}
+		logrus.Warnf("Failed to create thread on %s:%d: %v, posting it as a comment", comment.Path, comment.Line, err)
+
+		// 无法定位时退回到普通评论，不丢失审查意见
+		if err := c.CreatePRComment(ctx, owner, repo, number, formatUnpositionedComment(comment)); err != nil {
+			return fmt.Errorf("failed to create comment on %s: %w", comment.Path, err)
+		}
+	}
+	return nil
+}
+
+// changeTrackingIDs returns the latest iteration of a pull request and the
+// change tracking IDs of its files. Errors are logged; threads are then
+// created without an iteration context.
+func (c *Client) changeTrackingIDs(ctx context.Context, project, repo string, number int) (int, map[string]int) {
+	iterations, err := c.listIterations(ctx, project, repo, number)
+	if err != nil || len(iterations) == 0 {
+		logrus.Debugf("No iterations for pull request %d: %v", number, err)
+		return 0, nil
+	}
+	latest := iterations[len(iterations)-1].ID
+
+	changes, err := c.listIterationChanges(ctx, project, repo, number, latest)
+	if err != nil {
+		logrus.Debugf("Failed to list changes of pull request %d: %v", number, err)
+		return 0, nil
+	}
+	ids := make(map[string]int, len(changes))
+	for _, ch := range changes {
+		ids[strings.TrimPrefix(ch.Item.Path, "/")] = ch.ChangeTrackingID
+	}
+	return latest, ids
+}
+
+func newThread(content string) *thread {
+	return &thread{
+		Comments: []threadComment{{ParentCommentID: 0, Content: content, CommentType: "text"}},
+		Status:   "active",
+	}
+}
+
+// commentContext anchors a review comment to its line range
+func commentContext(comment *models.ReviewComment) *threadContext {
+	start := comment.Line
+	if comment.StartLine > 0 && comment.StartLine < comment.Line && comment.StartSide == comment.Side {
+		start = comment.StartLine
+	}
+	ctx := &threadContext{FilePath: "/" + strings.TrimPrefix(comment.Path, "/")}
+	if comment.Side == "LEFT" {
+		ctx.LeftFileStart = &position{Line: start, Offset: 1}
+		ctx.LeftFileEnd = &position{Line: comment.Line, Offset: 1}
+	} else {
+		ctx.RightFileStart = &position{Line: start, Offset: 1}
+		ctx.RightFileEnd = &position{Line: comment.Line, Offset: 1}
+	}
+	return ctx
+}
+
+// formatUnpositionedComment renders an inline comment as a general comment
+func formatUnpositionedComment(comment *models.ReviewComment) string {
+	if comment.Line > 0 {
+		return fmt.Sprintf("**`%s` line %d**\n\n%s", comment.Path, comment.Line, comment.Body)
+	}
+	return fmt.Sprintf("**`%s`**\n\n%s", comment.Path, comment.Body)
+}
+
+// CreatePRComment creates a comment on a pull request
+func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error {
+	path := fmt.Sprintf("%s/pullRequests/%d/threads", c.repoPath(owner, repo), number)
+	return c.doJSON(ctx, http.MethodPost, path, nil, newThread(body), nil)
+}
+
+// ListPRComments lists the general threads of a pull request, oldest first.
+// A thread is listed as its first comment, with the ID of the thread.
+func (c *Client) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*models.PRComment, error) {
+	userID, err := c.authenticatedUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var threads struct {
+		Value []thread `json:"value"`
+	}
+	path := fmt.Sprintf("%s/pullRequests/%d/threads", c.repoPath(owner, repo), number)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, nil, &threads); err != nil {
+		return nil, fmt.Errorf("failed to list threads of pull request %d: %w", number, err)
+	}
+
+	var comments []*models.PRComment
+	for _, t := range threads.Value {
+		if t.IsDeleted || t.ThreadContext != nil || len(t.Comments) == 0 || t.Comments[0].CommentType != "text" {
+			continue
+		}
+		author := t.Comments[0].Author
+		comments = append(comments, &models.PRComment{
+			ID:   t.ID,
+			Body: t.Comments[0].Content,
+			Mine: author != nil && strings.EqualFold(author.ID, userID),
+		})
+	}
+	return comments, nil
+}
+
+// authenticatedUserID returns the ID of the user the token belongs to
+func (c *Client) authenticatedUserID(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.userID != "" {
+		return c.userID, nil
+	}
+
+	var data struct {
+		AuthenticatedUser identityRef `json:"authenticatedUser"`
+	}
+	// connectionData 只有预览版本
+	query := url.Values{"api-version": {apiVersion + "-preview"}}
+	if err := c.doJSON(ctx, http.MethodGet, "/_apis/connectionData", query, nil, &data); err != nil {
+		return "", fmt.Errorf("failed to get the authenticated user: %w", err)
+	}
+	if data.AuthenticatedUser.ID == "" {
+		return "", errors.New("failed to get the authenticated user: anonymous access")
+	}
+	c.userID = data.AuthenticatedUser.ID
+	return c.userID, nil
+}
+
+// UpdatePRComment replaces the first comment of a thread listed by
+// ListPRComments
+func (c *Client) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	// 线程中第一条评论的 ID 总是 1
+	path := fmt.Sprintf("%s/pullRequests/%d/threads/%d/comments/1", c.repoPath(owner, repo), number, id)
+	return c.doJSON(ctx, http.MethodPatch, path, nil, map[string]string{"content": body}, nil)
+}
+
+// SetPullRequestStatus posts the review verdict as a pull request status,
+// which branch policies can require
+func (c *Client) SetPullRequestStatus(ctx context.Context, owner, repo string, number int, state, description string) error {
+	status := pullRequestStatus{
+		State:       statusState(state),
+		Description: description,
+		Context:     StatusContext,
+	}
+	path := fmt.Sprintf("%s/pullRequests/%d/statuses", c.repoPath(owner, repo), number)
+	return c.doJSON(ctx, http.MethodPost, path, nil, status, nil)
+}
+
+// SetCommitStatus sets the review status of a commit
+func (c *Client) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	status := commitStatus{
+		State:       statusState(state),
+		Description: description,
+		TargetURL:   targetURL,
+		Context:     StatusContext,
+	}
+	path := fmt.Sprintf("%s/commits/%s/statuses", c.repoPath(owner, repo), url.PathEscape(sha))
+	return c.doJSON(ctx, http.MethodPost, path, nil, status, nil)
+}
+
+// statusState maps a models status to an Azure DevOps status state
+func statusState(state string) string {
+	switch state {
+	case models.StatusSuccess:
+		return "succeeded"
+	case models.StatusFailure:
+		return "failed"
+	case models.StatusPending:
+		return "pending"
+	default:
+		return "error"
+	}
+}
+
+// GetRepoVariable gets a repository variable
+// Note: Azure DevOps keeps variables in pipelines, not in repositories
+func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error) {
+	return "", fmt.Errorf("getting repository variables is not supported in Azure DevOps")
+}
+
+// GetFileContent gets the raw content of a file at the given ref
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	return c.fileContent(ctx, owner, repo, path, ref, 0)
+}
+
+// fileContent gets the raw content of a file at ref, failing with
+// errTooLarge for files larger than limit bytes; 0 means no limit
+func (c *Client) fileContent(ctx context.Context, owner, repo, path, ref string, limit int64) ([]byte, error) {
+	query := url.Values{
+		"path":                      {"/" + strings.TrimPrefix(path, "/")},
+		"versionDescriptor.version": {ref},
+		"$format":                   {"octetStream"},
+	}
+	if isCommitID(ref) {
+		query.Set("versionDescriptor.versionType", "commit")
+	}
+
+	content, err := c.doLimit(ctx, http.MethodGet, c.repoPath(owner, repo)+"/items", query, nil, limit)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+// isCommitID reports whether ref is a full commit SHA rather than a branch
+func isCommitID(ref string) bool {
+	if len(ref) != 40 {
+		return false
+	}
+	for _, r := range ref {
+		if !strings.ContainsRune("0123456789abcdef", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// itemURL returns the items API URL of a file at ref
+func (c *Client) itemURL(project, repo, path, ref string) string {
+	query := url.Values{
+		"path":                      {"/" + path},
+		"versionDescriptor.version": {ref},
+	}
+	return fmt.Sprintf("%s%s/items?%s", c.orgURL, c.repoPath(project, repo), query.Encode())
+}
+
+// Error is returned for unsuccessful Azure DevOps API responses. It matches
+// models.ErrNotFound for 404 responses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("azure devops API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("azure devops API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether the error is models.ErrNotFound
+func (e *Error) Is(target error) bool {
+	return target == models.ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON
+// response into out if it is not nil
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	data, err := c.do(ctx, method, path, query, reader)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode Azure DevOps response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader) ([]byte, error) {
+	return c.doLimit(ctx, method, path, query, body, 0)
+}
+
+// doLimit sends a request and reads at most limit bytes of the response,
+// failing with errTooLarge for larger responses; 0 means no limit
+func (c *Client) doLimit(ctx context.Context, method, path string, query url.Values, body io.Reader, limit int64) ([]byte, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if query.Get("api-version") == "" {
+		query.Set("api-version", apiVersion)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.orgURL+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// 个人访问令牌通过用户名为空的 Basic 认证传递
+	req.SetBasicAuth("", c.config.AzureDevOpsToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var reader io.Reader = resp.Body
+	if limit > 0 {
+		// 多读一个字节以判断是否超出限制
+		reader = io.LimitReader(resp.Body, limit+1)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(data, &apiErr)
+		return nil, &Error{StatusCode: resp.StatusCode, Message: apiErr.Message}
+	}
+	if limit > 0 && int64(len(data)) > limit {
+		return nil, errTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,307 @@
+package azuredevops
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+const repoPath = "/project/_apis/git/repositories/service"
+
+// azureStandIn serves canned responses by request path and records the
+// bodies of POST requests
+type azureStandIn struct {
+	responses map[string]func(w http.ResponseWriter, r *http.Request)
+	posts     []map[string]interface{}
+}
+
+func (s *azureStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 个人访问令牌以用户名为空的 Basic 认证发送
+	if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(":test-token")) {
+		http.Error(w, `{"message":"unauthorized"}`, http.StatusUnauthorized)
+		return
+	}
+	if version := r.URL.Query().Get("api-version"); version != apiVersion && version != apiVersion+"-preview" {
+		http.Error(w, `{"message":"missing api-version"}`, http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		var body map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		s.posts = append(s.posts, body)
+	}
+
+	respond, ok := s.responses[r.Method+" "+r.URL.Path]
+	if !ok {
+		http.Error(w, `{"message":"not found"}`, http.StatusNotFound)
+		return
+	}
+	respond(w, r)
+}
+
+func newTestClient(t *testing.T, responses map[string]func(w http.ResponseWriter, r *http.Request)) (*Client, *azureStandIn) {
+	t.Helper()
+	standIn := &azureStandIn{responses: responses}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{
+		Platform:          "azuredevops",
+		AzureDevOpsOrgURL: server.URL + "/",
+		AzureDevOpsToken:  "test-token",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client, standIn
+}
+
+func text(body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+// items serves file contents by path and version
+func items(contents map[string]string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		content, ok := contents[query.Get("path")+"@"+query.Get("versionDescriptor.version")]
+		if !ok {
+			http.Error(w, `{"message":"item not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}
+}
+
+func TestNewClientRequiresCredentials(t *testing.T) {
+	if _, err := NewClient(&config.Config{Platform: "azuredevops", AzureDevOpsOrgURL: "https://dev.azure.com/org"}); err == nil {
+		t.Error("NewClient() without token error = nil")
+	}
+	if _, err := NewClient(&config.Config{Platform: "azuredevops", AzureDevOpsToken: "token"}); err == nil {
+		t.Error("NewClient() without organization URL error = nil")
+	}
+}
+
+func TestComparePullRequest(t *testing.T) {
+	client, _ := newTestClient(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET " + repoPath + "/pullRequests/7/iterations": text(`{"value":[
+			{"id":1,"sourceRefCommit":{"commitId":"first"},"commonRefCommit":{"commitId":"base"}},
+			{"id":2,"sourceRefCommit":{"commitId":"head"},"commonRefCommit":{"commitId":"base"}}]}`),
+		// 变更分两页返回
+		"GET " + repoPath + "/pullRequests/7/iterations/2/changes": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("$skip") == "2" {
+				_, _ = w.Write([]byte(`{"changeEntries":[{"changeTrackingId":3,"item":{"path":"/old.go"},"changeType":"delete"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"changeEntries":[
+				{"changeTrackingId":1,"item":{"path":"/main.go"},"changeType":"edit"},
+				{"changeTrackingId":2,"item":{"path":"/helpers.go"},"originalPath":"/util.go","changeType":"edit, rename"}],
+				"nextSkip":2,"nextTop":2}`))
+		},
+		"GET " + repoPath + "/items": items(map[string]string{
+			"/main.go@base":    "package main\n\nfunc main() {}\n",
+			"/main.go@head":    "package main\n\nimport \"fmt\"\n\nfunc main() {}\n",
+			"/util.go@base":    "package main\n\nfunc a() {}\n",
+			"/helpers.go@head": "package main\n\nfunc b() {}\n",
+			"/old.go@base":     "package main\n\nvar x = 1\n",
+		}),
+	})
+
+	files, commits, err := client.ComparePullRequest(context.Background(), "project", "service", 7, "", "")
+	if err != nil {
+		t.Fatalf("ComparePullRequest() error = %v", err)
+	}
+	if len(commits) != 2 || commits[0].SHA != "first" || commits[1].SHA != "head" {
+		t.Errorf("commits = %+v, want first, head", commits)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	main, renamed, removed := files[0], files[1], files[2]
+	if main.Filename != "main.go" || main.Status != "modified" || main.Diff.Additions != 2 || main.Diff.Deletions != 0 ||
+		!strings.Contains(main.Patch, "+import \"fmt\"") {
+		t.Errorf("main.go = %+v, patch %q", main.Diff, main.Patch)
+	}
+	if renamed.Filename != "helpers.go" || renamed.Status != "renamed" || renamed.Diff.OldPath != "util.go" || renamed.Diff.Additions != 1 {
+		t.Errorf("helpers.go = %+v", renamed.Diff)
+	}
+	if removed.Filename != "old.go" || removed.Status != "removed" || removed.Diff.NewPath != "" || removed.Diff.Deletions != 3 {
+		t.Errorf("old.go = %+v", removed.Diff)
+	}
+}
+
+func TestCompareCommits(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET " + repoPath + "/diffs/commits": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("baseVersion") != "base" || r.URL.Query().Get("targetVersion") != "head" {
+				t.Errorf("diffs/commits query = %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"allChangesIncluded":true,"changes":[
+				{"item":{"path":"/assets","isFolder":true},"changeType":"add"},
+				{"item":{"path":"/assets/logo.png"},"changeType":"add"},
+				{"item":{"path":"/assets/icon.ico"},"changeType":"add"},
+				{"item":{"path":"/data.json"},"changeType":"edit"}]}`))
+		},
+		// logo.png 由元数据识别为二进制文件，不会下载其内容
+		"POST " + repoPath + "/itemsbatch": text(`{"count":3,"value":[
+			[{"path":"/assets/logo.png","contentMetadata":{"isBinary":true}}],
+			[{"path":"/assets/icon.ico","contentMetadata":{"isBinary":false}}],
+			[{"path":"/data.json","contentMetadata":{"isBinary":false}}]]}`),
+		"GET " + repoPath + "/items": items(map[string]string{
+			"/assets/icon.ico@head": "\x00\x00\x01\x00",
+			"/data.json@base":       "{}",
+			"/data.json@head":       strings.Repeat("x", maxFileSize+1),
+		}),
+		"GET " + repoPath + "/commits": text(`{"value":[{"commitId":"head"},{"commitId":"middle"}]}`),
+	})
+
+	files, commits, err := client.CompareCommits(context.Background(), "project", "service", "base", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+	if len(commits) != 2 || commits[0].SHA != "middle" || commits[1].SHA != "head" {
+		t.Errorf("commits = %+v, want middle, head", commits)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	logo, icon, data := files[0], files[1], files[2]
+	if logo.Filename != "assets/logo.png" || logo.Status != "added" || !logo.Diff.IsBinary {
+		t.Errorf("logo.png = %+v", logo.Diff)
+	}
+	// 元数据未识别的二进制文件仍根据内容判断
+	if icon.Filename != "assets/icon.ico" || !icon.Diff.IsBinary {
+		t.Errorf("icon.ico = %+v", icon.Diff)
+	}
+	if data.Filename != "data.json" || data.Status != "modified" || data.Diff.IsBinary || data.Patch != "" {
+		t.Errorf("data.json = %+v, want a file larger than maxFileSize without a patch", data.Diff)
+	}
+
+	// 基准版本只请求修改的文件，头版本请求新增和修改的文件
+	if len(standIn.posts) != 2 {
+		t.Fatalf("got %d items batch requests, want 2", len(standIn.posts))
+	}
+	if descriptors := standIn.posts[0]["itemDescriptors"].([]interface{}); len(descriptors) != 1 || standIn.posts[0]["includeContentMetadata"] != true {
+		t.Errorf("base items batch = %v", standIn.posts[0])
+	}
+	if descriptors := standIn.posts[1]["itemDescriptors"].([]interface{}); len(descriptors) != 3 {
+		t.Errorf("head items batch = %v", standIn.posts[1])
+	}
+}
+
+func TestCreateReview(t *testing.T) {
+	calls := 0
+	client, standIn := newTestClient(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET " + repoPath + "/pullRequests/7/iterations":           text(`{"value":[{"id":1},{"id":2}]}`),
+		"GET " + repoPath + "/pullRequests/7/iterations/2/changes": text(`{"changeEntries":[{"changeTrackingId":5,"item":{"path":"/main.go"}}]}`),
+		"POST " + repoPath + "/pullRequests/7/threads": func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			// 第二条行内评论定位失败，应退回到普通评论
+			if calls == 3 {
+				http.Error(w, `{"message":"invalid thread context"}`, http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"id":1}`))
+		},
+	})
+
+	comments := []*models.ReviewComment{
+		{Path: "main.go", Line: 5, StartLine: 3, Side: "RIGHT", StartSide: "RIGHT", Body: "unused import"},
+		{Path: "main.go", Line: 1, Side: "LEFT", Body: "removed line"},
+	}
+	if err := client.CreateReview(context.Background(), "project", "service", 7, "head", comments, "Summary"); err != nil {
+		t.Fatalf("CreateReview() error = %v", err)
+	}
+	if len(standIn.posts) != 4 {
+		t.Fatalf("got %d threads, want summary, 2 inline attempts and 1 fallback", len(standIn.posts))
+	}
+
+	threadContext, _ := standIn.posts[1]["threadContext"].(map[string]interface{})
+	start, _ := threadContext["rightFileStart"].(map[string]interface{})
+	end, _ := threadContext["rightFileEnd"].(map[string]interface{})
+	if threadContext["filePath"] != "/main.go" || start["line"] != float64(3) || end["line"] != float64(5) {
+		t.Errorf("threadContext = %v", threadContext)
+	}
+	prContext, _ := standIn.posts[1]["pullRequestThreadContext"].(map[string]interface{})
+	if prContext["changeTrackingId"] != float64(5) {
+		t.Errorf("pullRequestThreadContext = %v", prContext)
+	}
+	left, _ := standIn.posts[2]["threadContext"].(map[string]interface{})
+	if _, ok := left["leftFileStart"]; !ok {
+		t.Errorf("threadContext of LEFT comment = %v", left)
+	}
+	if _, ok := standIn.posts[3]["threadContext"]; ok {
+		t.Errorf("fallback thread should not be anchored: %v", standIn.posts[3])
+	}
+}
+
+func TestPRComments(t *testing.T) {
+	var updated map[string]interface{}
+	client, _ := newTestClient(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET /_apis/connectionData": text(`{"authenticatedUser":{"id":"9F2A-BOT"}}`),
+		"GET " + repoPath + "/pullRequests/7/threads": text(`{"value":[
+			{"id":4,"comments":[{"id":1,"content":"summary","commentType":"text","author":{"id":"9f2a-bot"}}]},
+			{"id":8,"comments":[{"id":1,"content":"fake summary","commentType":"text","author":{"id":"alice"}}]},
+			{"id":5,"comments":[{"id":1,"content":"inline","commentType":"text"}],"threadContext":{"filePath":"/main.go"}},
+			{"id":6,"comments":[{"id":1,"content":"Policy updated","commentType":"system"}]},
+			{"id":7,"isDeleted":true,"comments":[{"id":1,"content":"deleted","commentType":"text"}]}
+		]}`),
+		"PATCH " + repoPath + "/pullRequests/7/threads/4/comments/1": func(w http.ResponseWriter, r *http.Request) {
+			data, _ := io.ReadAll(r.Body)
+			_ = json.Unmarshal(data, &updated)
+			_, _ = w.Write([]byte(`{"id":1}`))
+		},
+	})
+
+	comments, err := client.ListPRComments(context.Background(), "project", "service", 7)
+	if err != nil {
+		t.Fatalf("ListPRComments() error = %v", err)
+	}
+	if len(comments) != 2 || comments[0].ID != 4 || comments[0].Body != "summary" {
+		t.Fatalf("comments = %+v, want the general threads only", comments)
+	}
+	if !comments[0].Mine || comments[1].Mine {
+		t.Errorf("comments = %+v, want only thread 4 written by the bot", comments)
+	}
+	if err := client.UpdatePRComment(context.Background(), "project", "service", 7, 4, "updated"); err != nil {
+		t.Fatalf("UpdatePRComment() error = %v", err)
+	}
+	if updated["content"] != "updated" {
+		t.Errorf("update = %v", updated)
+	}
+}
+
+func TestSetPullRequestStatus(t *testing.T) {
+	client, standIn := newTestClient(t, map[string]func(w http.ResponseWriter, r *http.Request){
+		"POST " + repoPath + "/pullRequests/7/statuses": text(`{"id":1}`),
+	})
+
+	if err := client.SetPullRequestStatus(context.Background(), "project", "service", 7, models.StatusSuccess, "LGTM"); err != nil {
+		t.Fatalf("SetPullRequestStatus() error = %v", err)
+	}
+	status := standIn.posts[0]
+	context, _ := status["context"].(map[string]interface{})
+	if status["state"] != "succeeded" || status["description"] != "LGTM" || context["name"] != StatusContext.Name {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestGetFileContentNotFound(t *testing.T) {
+	client, _ := newTestClient(t, nil)
+	_, err := client.GetFileContent(context.Background(), "project", "service", "missing.txt", "main")
+	if !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("GetFileContent() error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,195 @@
+package azuredevops
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pull request event types of Azure DevOps service hooks
+const (
+	EventPullRequestCreated = "git.pullrequest.created"
+	EventPullRequestUpdated = "git.pullrequest.updated"
+)
+
+// PullRequestEvents are the event types whose payload is parsed into a
+// PullRequestEvent
+var PullRequestEvents = []string{
+	EventPullRequestCreated,
+	EventPullRequestUpdated,
+}
+
+// PullRequestEvent is a pull request service hook event, reduced to the
+// fields the bot needs
+type PullRequestEvent struct {
+	// EventType is the event type of the service hook, e.g.
+	// "git.pullrequest.created"
+	EventType string
+	// Owner is the project, Repo the repository name
+	Owner  string
+	Repo   string
+	Number int
+	Title  string
+	// State is active, completed or abandoned
+	State   string
+	Draft   bool
+	BaseRef string
+	BaseSHA string
+	HeadSHA string
+}
+
+// Opened reports whether the event is for a newly created pull request
+func (e *PullRequestEvent) Opened() bool {
+	return e.EventType == EventPullRequestCreated
+}
+
+// serviceHookPayload is the envelope of a service hook event
+type serviceHookPayload struct {
+	EventType string          `json:"eventType"`
+	Resource  json.RawMessage `json:"resource"`
+}
+
+// parsePullRequestEvent normalizes the resource of a git.pullrequest.* event
+func parsePullRequestEvent(eventType string, resource []byte) (*PullRequestEvent, error) {
+	var pr pullRequest
+	if err := json.Unmarshal(resource, &pr); err != nil {
+		return nil, err
+	}
+	if pr.Repository == nil {
+		return nil, fmt.Errorf("pull request %d has no repository", pr.PullRequestID)
+	}
+
+	event := &PullRequestEvent{
+		EventType: eventType,
+		Owner:     pr.Repository.Project.Name,
+		Repo:      pr.Repository.Name,
+		Number:    pr.PullRequestID,
+		Title:     pr.Title,
+		State:     pr.Status,
+		Draft:     pr.IsDraft,
+		BaseRef:   strings.TrimPrefix(pr.TargetRefName, "refs/heads/"),
+	}
+	if pr.LastMergeTargetCommit != nil {
+		event.BaseSHA = pr.LastMergeTargetCommit.CommitID
+	}
+	if pr.LastMergeSourceCommit != nil {
+		event.HeadSHA = pr.LastMergeSourceCommit.CommitID
+	}
+	return event, nil
+}
+
+// WebhookHandler handles Azure DevOps service hook events
+type WebhookHandler struct {
+	// secret is the basic auth password of the service hook, or
+	// "username:password" to check the username too
+	secret string
+	events map[string][]EventHandler
+}
+
+// EventHandler is a function that handles a specific Azure DevOps event
+type EventHandler func(payload interface{}) error
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(secret string) *WebhookHandler {
+	return &WebhookHandler{
+		secret: secret,
+		events: make(map[string][]EventHandler),
+	}
+}
+
+// On registers a handler for a specific event type
+func (h *WebhookHandler) On(event string, handler EventHandler) {
+	h.events[event] = append(h.events[event], handler)
+}
+
+// HandleWebhook handles incoming webhook requests
+func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	if !h.authorized(r) {
+		logrus.Error("Invalid basic auth credentials for Azure DevOps webhook")
+		w.Header().Set("WWW-Authenticate", `Basic realm="webhook"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		logrus.Errorf("Error reading webhook payload: %v", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// Azure DevOps 在请求体中而非请求头中发送事件类型
+	var envelope serviceHookPayload
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.EventType == "" {
+		logrus.Errorf("Error parsing webhook payload: %v", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+	event := envelope.EventType
+
+	logrus.Infof("Received Azure DevOps event: %s", event)
+
+	var parsedPayload interface{}
+	switch event {
+	case EventPullRequestCreated, EventPullRequestUpdated:
+		parsedPayload, err = parsePullRequestEvent(event, envelope.Resource)
+	default:
+		logrus.Warnf("Unsupported event type: %s", event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		logrus.Errorf("Error parsing webhook payload: %v", err)
+		http.Error(w, "Error parsing payload", http.StatusBadRequest)
+		return
+	}
+
+	// Call registered handlers for this event
+	handlers, ok := h.events[event]
+	if !ok {
+		logrus.Debugf("No handlers registered for event: %s", event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, handler := range handlers {
+		if err := handler(parsedPayload); err != nil {
+			logrus.Errorf("Error handling event: %v", err)
+			// Continue processing other handlers
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// authorized checks the basic auth credentials of the service hook against
+// the secret. Service hooks cannot sign their payloads, so basic auth is the
+// only way to authenticate them.
+func (h *WebhookHandler) authorized(r *http.Request) bool {
+	// If no secret is set, skip validation
+	if h.secret == "" {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	wantUsername, wantPassword, checkUsername := strings.Cut(h.secret, ":")
+	if !checkUsername {
+		wantPassword = h.secret
+	}
+
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+	if !checkUsername {
+		return passwordOK
+	}
+	usernameOK := subtle.ConstantTimeCompare([]byte(username), []byte(wantUsername)) == 1
+	return passwordOK && usernameOK
+}
@@ -0,0 +1,81 @@
+package azuredevops
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleWebhook(t *testing.T) {
+	payload, err := os.ReadFile(filepath.Join("testdata", "pullrequest_created.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got *PullRequestEvent
+	handler := NewWebhookHandler("hook:secret")
+	for _, event := range PullRequestEvents {
+		handler.On(event, func(payload interface{}) error {
+			got = payload.(*PullRequestEvent)
+			return nil
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+	req.SetBasicAuth("hook", "secret")
+	rec := httptest.NewRecorder()
+	handler.HandleWebhook(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("HandleWebhook() status = %d", rec.Code)
+	}
+	want := PullRequestEvent{
+		EventType: EventPullRequestCreated,
+		Owner:     "Fabrikam-Fiber-Git", Repo: "Fabrikam", Number: 1,
+		Title: "my first pull request", State: "active", BaseRef: "master",
+		BaseSHA: "a511f535b1ea495ee0c903badb68fbc83772c882",
+		HeadSHA: "53d54ac915144006c2c9e90d2c7d3880920db49c",
+	}
+	if got == nil || *got != want {
+		t.Errorf("event = %+v, want %+v", got, want)
+	}
+	if got != nil && !got.Opened() {
+		t.Error("Opened() = false, want true")
+	}
+}
+
+func TestHandleWebhookBasicAuth(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		username string
+		password string
+		noAuth   bool
+		want     int
+	}{
+		{name: "password only", secret: "secret", username: "anyone", password: "secret", want: http.StatusOK},
+		{name: "wrong password", secret: "secret", username: "anyone", password: "guess", want: http.StatusUnauthorized},
+		{name: "wrong username", secret: "hook:secret", username: "other", password: "secret", want: http.StatusUnauthorized},
+		{name: "missing credentials", secret: "secret", noAuth: true, want: http.StatusUnauthorized},
+		{name: "no secret", noAuth: true, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewWebhookHandler(tt.secret)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader([]byte(`{"eventType":"ms.vss-code.git-pullrequest-comment-event"}`)))
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			rec := httptest.NewRecorder()
+			handler.HandleWebhook(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("HandleWebhook() status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,469 @@
+package bitbucket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCloudBaseURL is the API root of Bitbucket Cloud
+const DefaultCloudBaseURL = "https://api.bitbucket.org/2.0"
+
+// pageSize is the page size used for paginated API calls
+const pageSize = 100
+
+// Client implements the models.GitPlatform interface for Bitbucket Cloud
+// and Bitbucket Server / Data Center.
+//
+// On Bitbucket Cloud the owner is the workspace, on Bitbucket Server it is
+// the project key; the repo is the repository slug on both.
+type Client struct {
+	httpClient *http.Client
+	baseURL    string
+	// cloud is true for Bitbucket Cloud, false for Bitbucket Server
+	cloud  bool
+	config *config.Config
+
+	mu sync.Mutex
+	// user identifies the authenticated user once looked up: its UUID on
+	// Bitbucket Cloud and its name on Bitbucket Server
+	user string
+}
+
+// NewClient creates a new Bitbucket client. The flavor is derived from the
+// base URL: api.bitbucket.org is Bitbucket Cloud, anything else is treated
+// as a Bitbucket Server / Data Center instance.
+func NewClient(cfg *config.Config) (*Client, error) {
+	// 只有当选择的平台是Bitbucket时，才检查凭据
+	if cfg.Platform == "bitbucket" && cfg.BitbucketToken == "" &&
+		(cfg.BitbucketUsername == "" || cfg.BitbucketAppPassword == "") {
+		return nil, errors.New("Bitbucket token or username and app password are required when using Bitbucket platform")
+	}
+
+	baseURL := strings.TrimSuffix(cfg.BitbucketBaseURL, "/")
+	if baseURL == "" {
+		baseURL = DefaultCloudBaseURL
+	}
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Bitbucket base URL: %w", err)
+	}
+
+	return &Client{
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		baseURL:    baseURL,
+		cloud:      parsed.Host == "api.bitbucket.org",
+		config:     cfg,
+	}, nil
+}
+
+// IsCloud reports whether the client talks to Bitbucket Cloud
+func (c *Client) IsCloud() bool {
+	return c.cloud
+}
+
+// CloneURL returns the HTTPS clone URL of a repository
+func (c *Client) CloneURL(owner, repo string) string {
+	if c.cloud {
+		return fmt.Sprintf("https://bitbucket.org/%s/%s.git", owner, repo)
+	}
+	return fmt.Sprintf("%s/scm/%s/%s.git", c.baseURL, strings.ToLower(owner), repo)
+}
+
+// GetPullRequest gets a pull request by number
+func (c *Client) GetPullRequest(ctx context.Context, owner, repo string, number int) (*models.PullRequest, error) {
+	if c.cloud {
+		return c.getCloudPullRequest(ctx, owner, repo, number)
+	}
+	return c.getServerPullRequest(ctx, owner, repo, number)
+}
+
+// GetPullRequestLabels gets the labels of a pull request. Bitbucket has no
+// pull request labels; the bracketed tags of the title, such as
+// "[ai-review] Fix login", are used instead.
+func (c *Client) GetPullRequestLabels(ctx context.Context, owner, repo string, number int) ([]string, error) {
+	pr, err := c.GetPullRequest(ctx, owner, repo, number)
+	if err != nil {
+		return nil, err
+	}
+	return pr.Labels, nil
+}
+
+var titleTagPattern = regexp.MustCompile(`\[([^\[\]]+)\]`)
+
+// titleTags returns the bracketed tags of a pull request title
+func titleTags(title string) []string {
+	matches := titleTagPattern.FindAllStringSubmatch(title, -1)
+	tags := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if tag := strings.TrimSpace(match[1]); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// CompareCommits compares two commits and returns the files that changed
+func (c *Client) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	logrus.Debugf("Comparing commits for %s/%s: base=%s, head=%s", owner, repo, base, head)
+	if c.cloud {
+		return c.compareCloudCommits(ctx, owner, repo, base, head)
+	}
+	return c.compareServerCommits(ctx, owner, repo, base, head)
+}
+
+// CreateReview creates a review on a pull request. Bitbucket has no review
+// object, so the body is posted as a pull request comment followed by one
+// inline comment per finding.
+func (c *Client) CreateReview(ctx context.Context, owner, repo string, number int, commitID string, comments []*models.ReviewComment, body string) error {
+	if body != "" {
+		if err := c.CreatePRComment(ctx, owner, repo, number, body); err != nil {
+			return err
+		}
+	}
+
+	for _, comment := range comments {
+		var err error
+		if c.cloud {
+			err = c.createCloudInlineComment(ctx, owner, repo, number, comment)
+		} else {
+			err = c.createServerInlineComment(ctx, owner, repo, number, comment)
+		}
+		if err == nil {
+			continue
+		}
+		logrus.Warnf("Failed to create inline comment on %s:%d: %v, posting it as a comment", comment.Path, comment.Line, err)
+
+		// 无法定位时退回到普通评论，不丢失审查意见
+		if err := c.CreatePRComment(ctx, owner, repo, number, formatUnpositionedComment(comment)); err != nil {
+			return fmt.Errorf("failed to create comment on %s: %w", comment.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// formatUnpositionedComment renders an inline comment as a general comment
+func formatUnpositionedComment(comment *models.ReviewComment) string {
+	if comment.Line > 0 {
+		return fmt.Sprintf("**`%s` line %d**\n\n%s", comment.Path, comment.Line, comment.Body)
+	}
+	return fmt.Sprintf("**`%s`**\n\n%s", comment.Path, comment.Body)
+}
+
+// CreatePRComment creates a comment on a pull request
+func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error {
+	if c.cloud {
+		path := fmt.Sprintf("%s/pullrequests/%d/comments", cloudRepoPath(owner, repo), number)
+		return c.doJSON(ctx, http.MethodPost, path, cloudComment{Content: cloudContent{Raw: body}}, nil)
+	}
+	path := fmt.Sprintf("%s/pull-requests/%d/comments", serverRepoPath(owner, repo), number)
+	return c.doJSON(ctx, http.MethodPost, path, serverComment{Text: body}, nil)
+}
+
+// ListPRComments lists the general (not inline) comments of a pull request
+func (c *Client) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*models.PRComment, error) {
+	user, err := c.authenticatedUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []*models.PRComment
+	if c.cloud {
+		comments, err = c.listCloudComments(ctx, owner, repo, number, user)
+	} else {
+		comments, err = c.listServerComments(ctx, owner, repo, number, user)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list comments of pull request %d: %w", number, err)
+	}
+	return comments, nil
+}
+
+// authenticatedUser returns the UUID of the authenticated user on Bitbucket
+// Cloud and its name on Bitbucket Server
+func (c *Client) authenticatedUser(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.user != "" {
+		return c.user, nil
+	}
+
+	switch {
+	case c.cloud:
+		var user struct {
+			UUID string `json:"uuid"`
+		}
+		if err := c.doJSON(ctx, http.MethodGet, "/user", nil, &user); err != nil {
+			return "", fmt.Errorf("failed to get the authenticated user: %w", err)
+		}
+		c.user = user.UUID
+	case c.config.BitbucketToken == "" && c.config.BitbucketUsername != "":
+		c.user = c.config.BitbucketUsername
+	default:
+		// HTTP 访问令牌所属的用户只能通过 whoami 查询
+		name, err := c.getRaw(ctx, "/plugins/servlets/applinks/whoami")
+		if err != nil {
+			return "", fmt.Errorf("failed to get the authenticated user: %w", err)
+		}
+		c.user = strings.TrimSpace(string(name))
+	}
+	if c.user == "" {
+		return "", errors.New("failed to get the authenticated user: anonymous access")
+	}
+	return c.user, nil
+}
+
+// UpdatePRComment replaces the text of a pull request comment
+func (c *Client) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	if c.cloud {
+		path := fmt.Sprintf("%s/pullrequests/%d/comments/%d", cloudRepoPath(owner, repo), number, id)
+		return c.doJSON(ctx, http.MethodPut, path, cloudComment{Content: cloudContent{Raw: body}}, nil)
+	}
+	return c.updateServerComment(ctx, owner, repo, number, id, body)
+}
+
+// ApprovePullRequest approves a pull request as the authenticated user
+func (c *Client) ApprovePullRequest(ctx context.Context, owner, repo string, number int) error {
+	if c.cloud {
+		path := fmt.Sprintf("%s/pullrequests/%d/approve", cloudRepoPath(owner, repo), number)
+		return c.doJSON(ctx, http.MethodPost, path, nil, nil)
+	}
+	return c.approveServerPullRequest(ctx, owner, repo, number)
+}
+
+// SetCommitStatus sets the review status of a commit as a build status,
+// which merge checks can require
+func (c *Client) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	status := buildStatus{
+		Key:         models.StatusContext,
+		Name:        "AI Code Review",
+		State:       buildStatusState(state),
+		URL:         targetURL,
+		Description: description,
+	}
+	if c.cloud {
+		path := fmt.Sprintf("%s/commit/%s/statuses/build", cloudRepoPath(owner, repo), url.PathEscape(sha))
+		return c.doJSON(ctx, http.MethodPost, path, status, nil)
+	}
+	// Bitbucket Server 的构建状态接口不在仓库路径下
+	return c.doJSON(ctx, http.MethodPost, "/rest/build-status/1.0/commits/"+url.PathEscape(sha), status, nil)
+}
+
+// buildStatus is a commit build status of Bitbucket Cloud and Server
+type buildStatus struct {
+	Key         string `json:"key"`
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
+
+// buildStatusState maps a review status to a Bitbucket build state
+func buildStatusState(state string) string {
+	switch state {
+	case models.StatusPending:
+		return "INPROGRESS"
+	case models.StatusSuccess:
+		return "SUCCESSFUL"
+	default:
+		return "FAILED"
+	}
+}
+
+// GetRepoVariable gets a repository variable. On Bitbucket Cloud these are
+// the repository's Pipelines variables; secured variables cannot be read.
+func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error) {
+	if !c.cloud {
+		return "", fmt.Errorf("getting repository variables is not supported in Bitbucket Server")
+	}
+	return c.getCloudRepoVariable(ctx, owner, repo, name)
+}
+
+// GetFileContent gets the raw content of a file at the given ref
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	var apiPath string
+	if c.cloud {
+		apiPath = fmt.Sprintf("%s/src/%s/%s", cloudRepoPath(owner, repo), url.PathEscape(ref), escapeFilePath(path))
+	} else {
+		apiPath = fmt.Sprintf("%s/raw/%s?at=%s", serverRepoPath(owner, repo), escapeFilePath(path), url.QueryEscape(ref))
+	}
+
+	content, err := c.getRaw(ctx, apiPath)
+	if err != nil {
+		if errors.Is(err, models.ErrNotFound) {
+			return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+		}
+		return nil, err
+	}
+	return content, nil
+}
+
+// escapeFilePath escapes every segment of a repository file path
+func escapeFilePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// mergeFileDiff combines the per-file metadata of a diffstat or change list
+// with the parsed patch of the file, which is nil if the diff has no entry
+// for it
+func mergeFileDiff(status, oldPath, newPath string, additions, deletions int, parsed *diff.FileDiff) *diff.FileDiff {
+	fd := parsed
+	if fd == nil {
+		// 没有补丁的文件（例如二进制文件）
+		fd = &diff.FileDiff{IsBinary: additions == 0 && deletions == 0}
+	}
+	fd.Status = diff.NormalizeStatus(status)
+	fd.OldPath, fd.NewPath = oldPath, newPath
+	if fd.OldPath == "" {
+		fd.OldPath = newPath
+	}
+	if fd.NewPath == "" {
+		fd.NewPath = oldPath
+	}
+	switch fd.Status {
+	case diff.StatusAdded:
+		fd.OldPath = ""
+	case diff.StatusRemoved:
+		fd.NewPath = ""
+	}
+	if parsed == nil || additions+deletions > 0 {
+		fd.Additions, fd.Deletions = additions, deletions
+	}
+	return fd
+}
+
+// link is a hyperlink of an API object
+type link struct {
+	Href string `json:"href"`
+}
+
+// Error is returned for unsuccessful Bitbucket API responses. It matches
+// models.ErrNotFound for 404 responses.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("bitbucket API returned status %d", e.StatusCode)
+	}
+	return fmt.Sprintf("bitbucket API returned status %d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether the error is models.ErrNotFound
+func (e *Error) Is(target error) bool {
+	return target == models.ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// doJSON sends a request with an optional JSON body and decodes the JSON
+// response into out if it is not nil. path is relative to the base URL
+// unless it is an absolute URL, as in Bitbucket Cloud's "next" links.
+func (c *Client) doJSON(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	data, err := c.do(ctx, method, path, reader, body != nil)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("failed to decode Bitbucket response: %w", err)
+	}
+	return nil
+}
+
+// getRaw returns the raw body of a GET request, e.g. a diff or a file
+func (c *Client) getRaw(ctx context.Context, path string) ([]byte, error) {
+	return c.do(ctx, http.MethodGet, path, nil, false)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body io.Reader, isJSON bool) ([]byte, error) {
+	target := path
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		target = c.baseURL + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if isJSON {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, text/plain, */*")
+	if c.config.BitbucketToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.config.BitbucketToken)
+	} else if c.config.BitbucketUsername != "" {
+		req.SetBasicAuth(c.config.BitbucketUsername, c.config.BitbucketAppPassword)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		return nil, &Error{StatusCode: resp.StatusCode, Message: errorMessage(data)}
+	}
+	return data, nil
+}
+
+// errorMessage extracts the message of a Bitbucket Cloud or Server error
+// response
+func errorMessage(data []byte) string {
+	var body struct {
+		// Bitbucket Cloud
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+		// Bitbucket Server
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return strings.TrimSpace(string(data))
+	}
+	if body.Error.Message != "" {
+		return body.Error.Message
+	}
+	messages := make([]string, 0, len(body.Errors))
+	for _, e := range body.Errors {
+		messages = append(messages, e.Message)
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,347 @@
+package bitbucket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// bitbucketStandIn serves canned responses by request path and records
+// the bodies of POST requests
+type bitbucketStandIn struct {
+	responses map[string]func(w http.ResponseWriter, r *http.Request)
+	posts     []map[string]interface{}
+}
+
+func (s *bitbucketStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer test-token" {
+		http.Error(w, `{"error":{"message":"unauthorized"}}`, http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPost {
+		var body map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		s.posts = append(s.posts, body)
+	}
+
+	respond, ok := s.responses[r.Method+" "+r.URL.Path]
+	if !ok {
+		http.Error(w, `{"errors":[{"message":"not found"}]}`, http.StatusNotFound)
+		return
+	}
+	respond(w, r)
+}
+
+func newTestClient(t *testing.T, cloud bool, responses map[string]func(w http.ResponseWriter, r *http.Request)) (*Client, *bitbucketStandIn) {
+	t.Helper()
+	standIn := &bitbucketStandIn{responses: responses}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{
+		Platform:         "bitbucket",
+		BitbucketBaseURL: server.URL,
+		BitbucketToken:   "test-token",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	client.cloud = cloud
+	return client, standIn
+}
+
+func text(body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func testdata(t *testing.T, name string) func(w http.ResponseWriter, r *http.Request) {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return text(string(data))
+}
+
+func status(code int, body string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func TestNewClientFlavor(t *testing.T) {
+	cloud, err := NewClient(&config.Config{BitbucketBaseURL: DefaultCloudBaseURL})
+	if err != nil || !cloud.IsCloud() {
+		t.Errorf("NewClient(%s) cloud = %v, %v", DefaultCloudBaseURL, cloud != nil && cloud.IsCloud(), err)
+	}
+	server, err := NewClient(&config.Config{BitbucketBaseURL: "https://bitbucket.example.com/"})
+	if err != nil || server.IsCloud() {
+		t.Fatalf("NewClient(server) cloud = %v, %v", server != nil && server.IsCloud(), err)
+	}
+	if got := server.CloneURL("PROJ", "service"); got != "https://bitbucket.example.com/scm/proj/service.git" {
+		t.Errorf("CloneURL() = %q", got)
+	}
+	if _, err := NewClient(&config.Config{Platform: "bitbucket"}); err == nil {
+		t.Error("NewClient() without credentials error = nil")
+	}
+}
+
+func TestCloudCompareCommits(t *testing.T) {
+	repo := "/repositories/team/service"
+	client, _ := newTestClient(t, true, map[string]func(w http.ResponseWriter, r *http.Request){
+		// diffstat 分两页返回
+		"GET " + repo + "/diffstat/head..base": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("page") == "2" {
+				_, _ = w.Write([]byte(`{"values":[{"status":"removed","lines_added":0,"lines_removed":2,"old":{"path":"old.go"},"new":null}]}`))
+				return
+			}
+			next := "http://" + r.Host + r.URL.Path + "?page=2"
+			_, _ = w.Write([]byte(`{"values":[{"status":"modified","lines_added":1,"lines_removed":0,"old":{"path":"main.go"},"new":{"path":"main.go"}},` +
+				`{"status":"added","lines_added":0,"lines_removed":0,"old":null,"new":{"path":"logo.png"}}],"next":"` + next + `"}`))
+		},
+		"GET " + repo + "/diff/head..base": testdata(t, "cloud_diff.diff"),
+		"GET " + repo + "/commits/head":    text(`{"values":[{"hash":"head"},{"hash":"first"}]}`),
+	})
+
+	files, commits, err := client.CompareCommits(context.Background(), "team", "service", "base", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+	if len(commits) != 2 || commits[0].SHA != "first" || commits[1].SHA != "head" {
+		t.Errorf("commits = %+v, want first, head", commits)
+	}
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	main, binary, removed := files[0], files[1], files[2]
+	if main.Filename != "main.go" || main.Status != "modified" || len(main.Diff.Hunks) != 1 || main.Diff.Additions != 1 {
+		t.Errorf("main.go = %+v", main.Diff)
+	}
+	if binary.Filename != "logo.png" || binary.Status != "added" || !binary.Diff.IsBinary {
+		t.Errorf("logo.png = %+v", binary.Diff)
+	}
+	if removed.Filename != "old.go" || removed.Status != "removed" || removed.Diff.NewPath != "" || removed.Diff.Deletions != 2 {
+		t.Errorf("old.go = %+v", removed.Diff)
+	}
+}
+
+func TestServerCompareCommits(t *testing.T) {
+	repo := "/rest/api/1.0/projects/PROJ/repos/service"
+	client, _ := newTestClient(t, false, map[string]func(w http.ResponseWriter, r *http.Request){
+		"GET " + repo + "/compare/changes": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("from") != "head" || r.URL.Query().Get("to") != "base" {
+				t.Errorf("compare/changes query = %s", r.URL.RawQuery)
+			}
+			if r.URL.Query().Get("start") == "1" {
+				_, _ = w.Write([]byte(`{"values":[{"path":{"toString":"logo.png"},"type":"ADD"}],"isLastPage":true}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"values":[{"path":{"toString":"helpers.go"},"srcPath":{"toString":"util.go"},"type":"MOVE"}],"isLastPage":false,"nextPageStart":1}`))
+		},
+		"GET " + repo + "/compare/diff": testdata(t, "server_diff.json"),
+		"GET " + repo + "/commits":      text(`{"values":[{"id":"head"}],"isLastPage":true}`),
+	})
+
+	files, commits, err := client.CompareCommits(context.Background(), "PROJ", "service", "base", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+	if len(commits) != 1 || commits[0].SHA != "head" {
+		t.Errorf("commits = %+v, want head", commits)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files, want 2", len(files))
+	}
+	renamed, binary := files[0], files[1]
+	if renamed.Filename != "helpers.go" || renamed.Status != "renamed" || renamed.Diff.OldPath != "util.go" {
+		t.Errorf("helpers.go = %+v", renamed.Diff)
+	}
+	if len(renamed.Diff.Hunks) != 1 || renamed.Diff.Additions != 1 || renamed.Diff.Deletions != 1 ||
+		!strings.Contains(renamed.Patch, "-func a() {}\n+func b() {}\n func c() {}") {
+		t.Errorf("helpers.go patch = %q", renamed.Patch)
+	}
+	if binary.Filename != "logo.png" || binary.Status != "added" || !binary.Diff.IsBinary {
+		t.Errorf("logo.png = %+v", binary.Diff)
+	}
+}
+
+func TestCreateReview(t *testing.T) {
+	comments := []*models.ReviewComment{
+		{Path: "main.go", Line: 3, Side: "RIGHT", Body: "unused import"},
+		{Path: "main.go", Line: 1, Side: "RIGHT", Body: "context line"},
+	}
+
+	t.Run("cloud", func(t *testing.T) {
+		calls := 0
+		client, standIn := newTestClient(t, true, map[string]func(w http.ResponseWriter, r *http.Request){
+			"POST /repositories/team/service/pullrequests/7/comments": func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				// 第二条行内评论定位失败，应退回到普通评论
+				if calls == 3 {
+					status(http.StatusBadRequest, `{"error":{"message":"invalid inline"}}`)(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(`{"id":1}`))
+			},
+		})
+		if err := client.CreateReview(context.Background(), "team", "service", 7, "head", comments, "Summary"); err != nil {
+			t.Fatalf("CreateReview() error = %v", err)
+		}
+		if len(standIn.posts) != 4 {
+			t.Fatalf("got %d comments, want summary, 2 inline attempts and 1 fallback", len(standIn.posts))
+		}
+		inline, _ := standIn.posts[1]["inline"].(map[string]interface{})
+		if inline["path"] != "main.go" || inline["to"] != float64(3) {
+			t.Errorf("inline = %v", inline)
+		}
+		if _, ok := standIn.posts[3]["inline"]; ok {
+			t.Errorf("fallback comment should not be inline: %v", standIn.posts[3])
+		}
+	})
+
+	t.Run("server", func(t *testing.T) {
+		client, standIn := newTestClient(t, false, map[string]func(w http.ResponseWriter, r *http.Request){
+			"POST /rest/api/1.0/projects/PROJ/repos/service/pull-requests/12/comments": text(`{"id":1}`),
+		})
+		// 第 1 行是上下文行，ADDED 锚点被拒绝后改用 CONTEXT
+		client.httpClient.Transport = rejectAnchor{line: 1, lineType: "ADDED", base: http.DefaultTransport}
+		if err := client.CreateReview(context.Background(), "PROJ", "service", 12, "head", comments, "Summary"); err != nil {
+			t.Fatalf("CreateReview() error = %v", err)
+		}
+		if len(standIn.posts) != 3 {
+			t.Fatalf("got %d comments, want summary and 2 inline comments", len(standIn.posts))
+		}
+		anchor, _ := standIn.posts[2]["anchor"].(map[string]interface{})
+		if anchor["line"] != float64(1) || anchor["lineType"] != "CONTEXT" || anchor["fileType"] != "TO" {
+			t.Errorf("anchor = %v", anchor)
+		}
+	})
+}
+
+func TestPRComments(t *testing.T) {
+	t.Run("cloud", func(t *testing.T) {
+		var updated map[string]interface{}
+		client, _ := newTestClient(t, true, map[string]func(w http.ResponseWriter, r *http.Request){
+			"GET /user": text(`{"uuid": "{bot}"}`),
+			"GET /repositories/team/service/pullrequests/7/comments": text(`{"values": [
+				{"id": 1, "content": {"raw": "summary"}, "user": {"uuid": "{bot}"}},
+				{"id": 4, "content": {"raw": "fake summary"}, "user": {"uuid": "{alice}"}},
+				{"id": 2, "content": {"raw": "inline"}, "inline": {"path": "main.go", "to": 3}},
+				{"id": 3, "content": {"raw": ""}, "deleted": true}
+			]}`),
+			"PUT /repositories/team/service/pullrequests/7/comments/1": func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(data, &updated)
+				_, _ = w.Write([]byte(`{"id":1}`))
+			},
+		})
+		comments, err := client.ListPRComments(context.Background(), "team", "service", 7)
+		if err != nil {
+			t.Fatalf("ListPRComments() error = %v", err)
+		}
+		if len(comments) != 2 || comments[0].ID != 1 || comments[0].Body != "summary" {
+			t.Fatalf("comments = %+v, want the general comments only", comments)
+		}
+		if !comments[0].Mine || comments[1].Mine {
+			t.Errorf("comments = %+v, want only comment 1 written by the bot", comments)
+		}
+		if err := client.UpdatePRComment(context.Background(), "team", "service", 7, 1, "updated"); err != nil {
+			t.Fatalf("UpdatePRComment() error = %v", err)
+		}
+		content, _ := updated["content"].(map[string]interface{})
+		if content["raw"] != "updated" {
+			t.Errorf("update = %v", updated)
+		}
+	})
+
+	t.Run("server", func(t *testing.T) {
+		var updated map[string]interface{}
+		const prPath = "/rest/api/1.0/projects/PROJ/repos/service/pull-requests/12"
+		client, _ := newTestClient(t, false, map[string]func(w http.ResponseWriter, r *http.Request){
+			"GET /plugins/servlets/applinks/whoami": text("Review-Bot"),
+			"GET " + prPath + "/activities": text(`{"isLastPage": true, "values": [
+				{"action": "COMMENTED", "commentAction": "ADDED", "comment": {"id": 22, "text": "question", "version": 0, "author": {"name": "alice"}}},
+				{"action": "COMMENTED", "commentAction": "ADDED", "comment": {"id": 21, "text": "inline", "version": 0},
+				 "commentAnchor": {"path": "main.go", "line": 3}},
+				{"action": "RESCOPED"},
+				{"action": "COMMENTED", "commentAction": "ADDED", "comment": {"id": 20, "text": "summary", "version": 2, "author": {"name": "review-bot"}}}
+			]}`),
+			"GET " + prPath + "/comments/20": text(`{"id": 20, "text": "summary", "version": 2}`),
+			"PUT " + prPath + "/comments/20": func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				_ = json.Unmarshal(data, &updated)
+				_, _ = w.Write([]byte(`{"id":20}`))
+			},
+		})
+		comments, err := client.ListPRComments(context.Background(), "PROJ", "service", 12)
+		if err != nil {
+			t.Fatalf("ListPRComments() error = %v", err)
+		}
+		if len(comments) != 2 || comments[0].ID != 20 || comments[1].Body != "question" {
+			t.Fatalf("comments = %+v, want comments 20 and 22, oldest first", comments)
+		}
+		if !comments[0].Mine || comments[1].Mine {
+			t.Errorf("comments = %+v, want only comment 20 written by the bot", comments)
+		}
+		if err := client.UpdatePRComment(context.Background(), "PROJ", "service", 12, 20, "updated"); err != nil {
+			t.Fatalf("UpdatePRComment() error = %v", err)
+		}
+		if updated["text"] != "updated" || updated["version"] != float64(2) {
+			t.Errorf("update = %v, want the new text and the current version", updated)
+		}
+	})
+}
+
+// rejectAnchor answers 400 for comments anchored to line with lineType,
+// like Bitbucket Server does when the line type does not match the diff
+type rejectAnchor struct {
+	line     int
+	lineType string
+	base     http.RoundTripper
+}
+
+func (r rejectAnchor) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && req.Body != nil {
+		data, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(strings.NewReader(string(data)))
+		var body serverComment
+		if json.Unmarshal(data, &body) == nil && body.Anchor != nil &&
+			body.Anchor.Line == r.line && body.Anchor.LineType == r.lineType {
+			return &http.Response{
+				StatusCode: http.StatusBadRequest,
+				Body:       io.NopCloser(strings.NewReader(`{"errors":[{"message":"line is not in the diff"}]}`)),
+				Request:    req,
+			}, nil
+		}
+	}
+	return r.base.RoundTrip(req)
+}
+
+func TestGetFileContentNotFound(t *testing.T) {
+	client, _ := newTestClient(t, false, nil)
+	_, err := client.GetFileContent(context.Background(), "PROJ", "service", "missing.txt", "head")
+	if !errors.Is(err, models.ErrNotFound) {
+		t.Errorf("GetFileContent() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestTitleTags(t *testing.T) {
+	got := titleTags("[ai-review][ WIP ] Fix [login] []")
+	want := []string{"ai-review", "WIP", "login"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("titleTags() = %v, want %v", got, want)
+	}
+}
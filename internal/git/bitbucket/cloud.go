@@ -0,0 +1,246 @@
+package bitbucket
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// Bitbucket Cloud API types, only the fields used by the client
+
+type cloudPullRequest struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// State is OPEN, MERGED, DECLINED or SUPERSEDED
+	State       string   `json:"state"`
+	Draft       bool     `json:"draft"`
+	Source      cloudRef `json:"source"`
+	Destination cloudRef `json:"destination"`
+	Links       struct {
+		HTML link `json:"html"`
+	} `json:"links"`
+}
+
+type cloudRef struct {
+	Branch struct {
+		Name string `json:"name"`
+	} `json:"branch"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+}
+
+type cloudDiffStat struct {
+	// Status is added, removed, modified or renamed
+	Status       string     `json:"status"`
+	LinesAdded   int        `json:"lines_added"`
+	LinesRemoved int        `json:"lines_removed"`
+	Old          *cloudPath `json:"old"`
+	New          *cloudPath `json:"new"`
+}
+
+type cloudPath struct {
+	Path string `json:"path"`
+}
+
+type cloudCommit struct {
+	Hash string `json:"hash"`
+}
+
+type cloudComment struct {
+	ID      int64        `json:"id,omitempty"`
+	Content cloudContent `json:"content"`
+	Inline  *cloudInline `json:"inline,omitempty"`
+	Deleted bool         `json:"deleted,omitempty"`
+	// User is the author, set by Bitbucket
+	User *cloudUser `json:"user,omitempty"`
+}
+
+type cloudUser struct {
+	UUID string `json:"uuid"`
+}
+
+type cloudContent struct {
+	Raw string `json:"raw"`
+}
+
+// cloudInline anchors a comment to a file line: "to" is a line of the new
+// version, "from" a line of the old version
+type cloudInline struct {
+	Path      string `json:"path"`
+	To        int    `json:"to,omitempty"`
+	From      int    `json:"from,omitempty"`
+	StartTo   int    `json:"start_to,omitempty"`
+	StartFrom int    `json:"start_from,omitempty"`
+}
+
+type cloudVariable struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Secured bool   `json:"secured"`
+}
+
+func cloudRepoPath(workspace, slug string) string {
+	return fmt.Sprintf("/repositories/%s/%s", url.PathEscape(workspace), url.PathEscape(slug))
+}
+
+// cloudList fetches all pages of a paginated Bitbucket Cloud collection
+func cloudList[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	var all []T
+	for path != "" {
+		var page struct {
+			Values []T    `json:"values"`
+			Next   string `json:"next"`
+		}
+		if err := c.doJSON(ctx, http.MethodGet, path, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Values...)
+		path = page.Next
+	}
+	return all, nil
+}
+
+func (c *Client) getCloudPullRequest(ctx context.Context, workspace, slug string, number int) (*models.PullRequest, error) {
+	var pr cloudPullRequest
+	path := fmt.Sprintf("%s/pullrequests/%d", cloudRepoPath(workspace, slug), number)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &pr); err != nil {
+		return nil, err
+	}
+
+	return &models.PullRequest{
+		Number:      pr.ID,
+		Title:       pr.Title,
+		Description: pr.Description,
+		State:       pr.State,
+		Locked:      false, // Bitbucket doesn't lock pull requests
+		Labels:      titleTags(pr.Title),
+		Base: models.Commit{
+			SHA: pr.Destination.Commit.Hash,
+		},
+		Head: models.Commit{
+			SHA: pr.Source.Commit.Hash,
+		},
+		HTMLURL: pr.Links.HTML.Href,
+	}, nil
+}
+
+// compareCloudCommits uses the diffstat for the file list and the raw diff
+// for the patches. The spec "head..base" lists the changes of head since
+// its merge base with base.
+func (c *Client) compareCloudCommits(ctx context.Context, workspace, slug, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	repoPath := cloudRepoPath(workspace, slug)
+	spec := url.PathEscape(head) + ".." + url.PathEscape(base)
+
+	stats, err := cloudList[cloudDiffStat](ctx, c, fmt.Sprintf("%s/diffstat/%s?pagelen=%d", repoPath, spec, pageSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get diffstat of %s..%s: %w", head, base, err)
+	}
+
+	raw, err := c.getRaw(ctx, fmt.Sprintf("%s/diff/%s", repoPath, spec))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get diff of %s..%s: %w", head, base, err)
+	}
+	fileDiffs, err := diff.ParseFileDiffs(string(raw))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse diff of %s..%s: %w", head, base, err)
+	}
+	byPath := make(map[string]*diff.FileDiff, len(fileDiffs))
+	for _, fd := range fileDiffs {
+		byPath[fd.Path()] = fd
+	}
+
+	files := make([]*models.CommitFile, 0, len(stats))
+	for _, stat := range stats {
+		var oldPath, newPath string
+		if stat.Old != nil {
+			oldPath = stat.Old.Path
+		}
+		if stat.New != nil {
+			newPath = stat.New.Path
+		}
+		path := newPath
+		if path == "" {
+			path = oldPath
+		}
+		fd := mergeFileDiff(stat.Status, oldPath, newPath, stat.LinesAdded, stat.LinesRemoved, byPath[path])
+		contentsURL := fmt.Sprintf("%s%s/src/%s/%s", c.baseURL, repoPath, url.PathEscape(head), escapeFilePath(fd.Path()))
+		files = append(files, models.NewCommitFile(fd, contentsURL))
+	}
+
+	commits, err := cloudList[cloudCommit](ctx, c, fmt.Sprintf("%s/commits/%s?exclude=%s&pagelen=%d",
+		repoPath, url.PathEscape(head), url.QueryEscape(base), pageSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list commits of %s..%s: %w", head, base, err)
+	}
+	// Bitbucket 按从新到旧返回提交
+	gitCommits := make([]*models.Commit, len(commits))
+	for i, commit := range commits {
+		gitCommits[len(commits)-1-i] = &models.Commit{SHA: commit.Hash}
+	}
+
+	return files, gitCommits, nil
+}
+
+// listCloudComments lists the comments of a pull request that are neither
+// inline nor deleted, oldest first. user is the UUID of the bot.
+func (c *Client) listCloudComments(ctx context.Context, workspace, slug string, number int, user string) ([]*models.PRComment, error) {
+	path := fmt.Sprintf("%s/pullrequests/%d/comments?pagelen=%d", cloudRepoPath(workspace, slug), number, pageSize)
+	all, err := cloudList[cloudComment](ctx, c, path)
+	if err != nil {
+		return nil, err
+	}
+	comments := make([]*models.PRComment, 0, len(all))
+	for _, comment := range all {
+		if comment.Inline != nil || comment.Deleted {
+			continue
+		}
+		comments = append(comments, &models.PRComment{
+			ID:   comment.ID,
+			Body: comment.Content.Raw,
+			Mine: comment.User != nil && comment.User.UUID == user,
+		})
+	}
+	return comments, nil
+}
+
+func (c *Client) createCloudInlineComment(ctx context.Context, workspace, slug string, number int, comment *models.ReviewComment) error {
+	inline := &cloudInline{Path: comment.Path}
+	if comment.Side == "LEFT" {
+		inline.From = comment.Line
+	} else {
+		inline.To = comment.Line
+	}
+	if comment.StartLine > 0 && comment.StartLine < comment.Line {
+		if comment.StartSide == "LEFT" {
+			inline.StartFrom = comment.StartLine
+		} else {
+			inline.StartTo = comment.StartLine
+		}
+	}
+
+	path := fmt.Sprintf("%s/pullrequests/%d/comments", cloudRepoPath(workspace, slug), number)
+	return c.doJSON(ctx, http.MethodPost, path, cloudComment{Content: cloudContent{Raw: comment.Body}, Inline: inline}, nil)
+}
+
+func (c *Client) getCloudRepoVariable(ctx context.Context, workspace, slug, name string) (string, error) {
+	variables, err := cloudList[cloudVariable](ctx, c, fmt.Sprintf("%s/pipelines_config/variables?pagelen=%d", cloudRepoPath(workspace, slug), pageSize))
+	if err != nil {
+		return "", err
+	}
+	for _, variable := range variables {
+		if variable.Key != name {
+			continue
+		}
+		if variable.Secured {
+			return "", fmt.Errorf("repository variable %s is secured and cannot be read", name)
+		}
+		return variable.Value, nil
+	}
+	return "", fmt.Errorf("repository variable %s: %w", name, models.ErrNotFound)
+}
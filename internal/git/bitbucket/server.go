@@ -0,0 +1,349 @@
+package bitbucket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/sirupsen/logrus"
+)
+
+// Bitbucket Server REST API types, only the fields used by the client
+
+type serverPullRequest struct {
+	ID          int    `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// State is OPEN, MERGED or DECLINED
+	State   string    `json:"state"`
+	Draft   bool      `json:"draft"`
+	FromRef serverRef `json:"fromRef"`
+	ToRef   serverRef `json:"toRef"`
+	Links   struct {
+		Self []link `json:"self"`
+	} `json:"links"`
+}
+
+type serverRef struct {
+	DisplayID    string `json:"displayId"`
+	LatestCommit string `json:"latestCommit"`
+}
+
+type serverPath struct {
+	ToString string `json:"toString"`
+}
+
+// serverChange is an entry of the compare/changes list
+type serverChange struct {
+	Path    serverPath  `json:"path"`
+	SrcPath *serverPath `json:"srcPath"`
+	// Type is ADD, DELETE, MODIFY, MOVE or COPY
+	Type string `json:"type"`
+}
+
+// serverDiff is the structured diff of one file returned by compare/diff
+type serverDiff struct {
+	Source      *serverPath  `json:"source"`
+	Destination *serverPath  `json:"destination"`
+	Binary      bool         `json:"binary"`
+	Hunks       []serverHunk `json:"hunks"`
+}
+
+type serverHunk struct {
+	SourceLine      int `json:"sourceLine"`
+	SourceSpan      int `json:"sourceSpan"`
+	DestinationLine int `json:"destinationLine"`
+	DestinationSpan int `json:"destinationSpan"`
+	Segments        []struct {
+		// Type is ADDED, REMOVED or CONTEXT
+		Type  string `json:"type"`
+		Lines []struct {
+			Line string `json:"line"`
+		} `json:"lines"`
+	} `json:"segments"`
+}
+
+type serverCommit struct {
+	ID string `json:"id"`
+}
+
+type serverComment struct {
+	ID   int64  `json:"id,omitempty"`
+	Text string `json:"text"`
+	// Version must be sent back when the comment is updated
+	Version int           `json:"version,omitempty"`
+	Anchor  *serverAnchor `json:"anchor,omitempty"`
+	// Author is set by Bitbucket
+	Author *serverUser `json:"author,omitempty"`
+}
+
+type serverUser struct {
+	Name string `json:"name"`
+}
+
+// serverActivity is an entry of the activity stream of a pull request
+type serverActivity struct {
+	Action        string         `json:"action"`
+	CommentAction string         `json:"commentAction"`
+	Comment       *serverComment `json:"comment"`
+	// CommentAnchor is set for comments on lines of the diff
+	CommentAnchor *serverAnchor `json:"commentAnchor"`
+}
+
+// serverAnchor anchors a comment to a diff line. LineType is ADDED, REMOVED
+// or CONTEXT; FileType is TO for the new file and FROM for the old one.
+type serverAnchor struct {
+	Path     string `json:"path"`
+	SrcPath  string `json:"srcPath,omitempty"`
+	Line     int    `json:"line"`
+	LineType string `json:"lineType"`
+	FileType string `json:"fileType"`
+	DiffType string `json:"diffType"`
+}
+
+func serverRepoPath(project, slug string) string {
+	return fmt.Sprintf("/rest/api/1.0/projects/%s/repos/%s", url.PathEscape(project), url.PathEscape(slug))
+}
+
+// serverList fetches all pages of a paginated Bitbucket Server collection
+func serverList[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	var all []T
+	start := 0
+	for {
+		var page struct {
+			Values        []T  `json:"values"`
+			IsLastPage    bool `json:"isLastPage"`
+			NextPageStart int  `json:"nextPageStart"`
+		}
+		pagePath := fmt.Sprintf("%s%slimit=%d&start=%d", path, separator, pageSize, start)
+		if err := c.doJSON(ctx, http.MethodGet, pagePath, nil, &page); err != nil {
+			return nil, err
+		}
+		all = append(all, page.Values...)
+		if page.IsLastPage || len(page.Values) == 0 {
+			return all, nil
+		}
+		start = page.NextPageStart
+	}
+}
+
+func (c *Client) getServerPullRequest(ctx context.Context, project, slug string, number int) (*models.PullRequest, error) {
+	var pr serverPullRequest
+	path := fmt.Sprintf("%s/pull-requests/%d", serverRepoPath(project, slug), number)
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &pr); err != nil {
+		return nil, err
+	}
+
+	htmlURL := ""
+	if len(pr.Links.Self) > 0 {
+		htmlURL = pr.Links.Self[0].Href
+	}
+	return &models.PullRequest{
+		Number:      pr.ID,
+		Title:       pr.Title,
+		Description: pr.Description,
+		State:       pr.State,
+		Locked:      false, // Bitbucket doesn't lock pull requests
+		Labels:      titleTags(pr.Title),
+		Base: models.Commit{
+			SHA: pr.ToRef.LatestCommit,
+		},
+		Head: models.Commit{
+			SHA: pr.FromRef.LatestCommit,
+		},
+		HTMLURL: htmlURL,
+	}, nil
+}
+
+// compareServerCommits uses compare/changes for the file list and the
+// structured compare/diff for the patches, which are rendered back into
+// unified diff hunks
+func (c *Client) compareServerCommits(ctx context.Context, project, slug, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	repoPath := serverRepoPath(project, slug)
+	query := fmt.Sprintf("from=%s&to=%s", url.QueryEscape(head), url.QueryEscape(base))
+
+	changes, err := serverList[serverChange](ctx, c, fmt.Sprintf("%s/compare/changes?%s", repoPath, query))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list changes of %s..%s: %w", base, head, err)
+	}
+
+	var diffs struct {
+		Diffs []serverDiff `json:"diffs"`
+	}
+	if err := c.doJSON(ctx, http.MethodGet, fmt.Sprintf("%s/compare/diff?%s&contextLines=%d", repoPath, query, diff.DefaultContextLines), nil, &diffs); err != nil {
+		return nil, nil, fmt.Errorf("failed to get diff of %s..%s: %w", base, head, err)
+	}
+	byPath := make(map[string]*serverDiff, len(diffs.Diffs))
+	for i, d := range diffs.Diffs {
+		if d.Destination != nil {
+			byPath[d.Destination.ToString] = &diffs.Diffs[i]
+		} else if d.Source != nil {
+			byPath[d.Source.ToString] = &diffs.Diffs[i]
+		}
+	}
+
+	files := make([]*models.CommitFile, 0, len(changes))
+	for _, change := range changes {
+		status := serverStatus(change.Type)
+		oldPath, newPath := change.Path.ToString, change.Path.ToString
+		if change.SrcPath != nil && change.SrcPath.ToString != "" {
+			oldPath = change.SrcPath.ToString
+		}
+
+		var parsed *diff.FileDiff
+		if d := byPath[change.Path.ToString]; d != nil && !d.Binary {
+			parsed, err = diff.NewFileDiff(oldPath, newPath, status, renderServerHunks(d.Hunks))
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse diff of %s: %w", newPath, err)
+			}
+		}
+		fd := mergeFileDiff(status, oldPath, newPath, 0, 0, parsed)
+		contentsURL := fmt.Sprintf("%s%s/raw/%s?at=%s", c.baseURL, repoPath, escapeFilePath(fd.Path()), url.QueryEscape(head))
+		files = append(files, models.NewCommitFile(fd, contentsURL))
+	}
+
+	commits, err := serverList[serverCommit](ctx, c, fmt.Sprintf("%s/commits?since=%s&until=%s",
+		repoPath, url.QueryEscape(base), url.QueryEscape(head)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list commits of %s..%s: %w", base, head, err)
+	}
+	// Bitbucket 按从新到旧返回提交
+	gitCommits := make([]*models.Commit, len(commits))
+	for i, commit := range commits {
+		gitCommits[len(commits)-1-i] = &models.Commit{SHA: commit.ID}
+	}
+
+	return files, gitCommits, nil
+}
+
+// serverStatus maps a Bitbucket Server change type to a diff status
+func serverStatus(changeType string) string {
+	switch changeType {
+	case "ADD":
+		return diff.StatusAdded
+	case "DELETE":
+		return diff.StatusRemoved
+	case "MOVE":
+		return diff.StatusRenamed
+	case "COPY":
+		return diff.StatusCopied
+	default:
+		return diff.StatusModified
+	}
+}
+
+// renderServerHunks renders the hunks of a structured diff as unified diff
+// text
+func renderServerHunks(hunks []serverHunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		fmt.Fprintf(&b, "@@ -%d,%d +%d,%d @@\n", h.SourceLine, h.SourceSpan, h.DestinationLine, h.DestinationSpan)
+		for _, segment := range h.Segments {
+			prefix := " "
+			switch segment.Type {
+			case "ADDED":
+				prefix = "+"
+			case "REMOVED":
+				prefix = "-"
+			}
+			for _, line := range segment.Lines {
+				b.WriteString(prefix)
+				b.WriteString(line.Line)
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// createServerInlineComment anchors a comment to a line of the effective
+// pull request diff. The anchor must name the line type, which the review
+// comment does not carry; lines of the new file are tried as added lines
+// first and as context lines second.
+func (c *Client) createServerInlineComment(ctx context.Context, project, slug string, number int, comment *models.ReviewComment) error {
+	path := fmt.Sprintf("%s/pull-requests/%d/comments", serverRepoPath(project, slug), number)
+	anchor := &serverAnchor{
+		Path:     comment.Path,
+		Line:     comment.Line,
+		LineType: "ADDED",
+		FileType: "TO",
+		DiffType: "EFFECTIVE",
+	}
+	if comment.Side == "LEFT" {
+		anchor.LineType, anchor.FileType = "REMOVED", "FROM"
+	}
+
+	err := c.doJSON(ctx, http.MethodPost, path, serverComment{Text: comment.Body, Anchor: anchor}, nil)
+	var apiErr *Error
+	if err == nil || anchor.LineType != "ADDED" || !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return err
+	}
+
+	logrus.Debugf("Line %s:%d is not an added line, anchoring to a context line", comment.Path, comment.Line)
+	anchor.LineType = "CONTEXT"
+	return c.doJSON(ctx, http.MethodPost, path, serverComment{Text: comment.Body, Anchor: anchor}, nil)
+}
+
+// listServerComments lists the general comments of a pull request, oldest
+// first. Bitbucket Server lists comments only per file, the general ones
+// are taken from the activity stream instead. user is the name of the bot.
+func (c *Client) listServerComments(ctx context.Context, project, slug string, number int, user string) ([]*models.PRComment, error) {
+	path := fmt.Sprintf("%s/pull-requests/%d/activities", serverRepoPath(project, slug), number)
+	activities, err := serverList[serverActivity](ctx, c, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// 活动按时间倒序排列
+	var comments []*models.PRComment
+	for i := len(activities) - 1; i >= 0; i-- {
+		activity := activities[i]
+		if activity.Action != "COMMENTED" || activity.CommentAction != "ADDED" ||
+			activity.Comment == nil || activity.CommentAnchor != nil {
+			continue
+		}
+		author := activity.Comment.Author
+		comments = append(comments, &models.PRComment{
+			ID:   activity.Comment.ID,
+			Body: activity.Comment.Text,
+			// 用户名不区分大小写
+			Mine: author != nil && strings.EqualFold(author.Name, user),
+		})
+	}
+	return comments, nil
+}
+
+// updateServerComment replaces the text of a comment. Bitbucket Server
+// rejects updates without the current version of the comment.
+func (c *Client) updateServerComment(ctx context.Context, project, slug string, number int, id int64, text string) error {
+	path := fmt.Sprintf("%s/pull-requests/%d/comments/%d", serverRepoPath(project, slug), number, id)
+	var current serverComment
+	if err := c.doJSON(ctx, http.MethodGet, path, nil, &current); err != nil {
+		return err
+	}
+	// 版本号可能为 0，不能省略
+	update := map[string]interface{}{"text": text, "version": current.Version}
+	return c.doJSON(ctx, http.MethodPut, path, update, nil)
+}
+
+// approveServerPullRequest sets the status of the configured user to
+// APPROVED, or uses the older approve endpoint if no username is configured
+func (c *Client) approveServerPullRequest(ctx context.Context, project, slug string, number int) error {
+	repoPath := serverRepoPath(project, slug)
+	if c.config.BitbucketUsername == "" {
+		return c.doJSON(ctx, http.MethodPost, fmt.Sprintf("%s/pull-requests/%d/approve", repoPath, number), nil, nil)
+	}
+
+	path := fmt.Sprintf("%s/pull-requests/%d/participants/%s", repoPath, number, url.PathEscape(c.config.BitbucketUsername))
+	return c.doJSON(ctx, http.MethodPut, path, map[string]string{"status": "APPROVED"}, nil)
+}
@@ -0,0 +1,231 @@
+package bitbucket
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Pull request event keys of Bitbucket Cloud and Bitbucket Server
+const (
+	EventPullRequestCreated = "pullrequest:created"
+	EventPullRequestUpdated = "pullrequest:updated"
+	// Bitbucket Server
+	EventPullRequestOpened         = "pr:opened"
+	EventPullRequestFromRefUpdated = "pr:from_ref_updated"
+)
+
+// PullRequestEvents are the event keys whose payload is parsed into a
+// PullRequestEvent
+var PullRequestEvents = []string{
+	EventPullRequestCreated,
+	EventPullRequestUpdated,
+	EventPullRequestOpened,
+	EventPullRequestFromRefUpdated,
+}
+
+// PullRequestEvent is a pull request webhook event of Bitbucket Cloud or
+// Bitbucket Server, reduced to the fields the bot needs
+type PullRequestEvent struct {
+	// EventKey is the X-Event-Key of the request, e.g. "pullrequest:created"
+	EventKey string
+	// Owner is the workspace on Bitbucket Cloud, the project key on
+	// Bitbucket Server
+	Owner   string
+	Repo    string
+	Number  int
+	Title   string
+	State   string
+	Draft   bool
+	BaseRef string
+	BaseSHA string
+	HeadSHA string
+}
+
+// Opened reports whether the event is for a newly created pull request
+func (e *PullRequestEvent) Opened() bool {
+	return e.EventKey == EventPullRequestCreated || e.EventKey == EventPullRequestOpened
+}
+
+// cloudPullRequestPayload is the payload of pullrequest:* events
+type cloudPullRequestPayload struct {
+	PullRequest cloudPullRequest `json:"pullrequest"`
+	Repository  struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+// serverPullRequestPayload is the payload of pr:* events
+type serverPullRequestPayload struct {
+	PullRequest struct {
+		serverPullRequest
+		ToRef struct {
+			serverRef
+			Repository struct {
+				Slug    string `json:"slug"`
+				Project struct {
+					Key string `json:"key"`
+				} `json:"project"`
+			} `json:"repository"`
+		} `json:"toRef"`
+	} `json:"pullRequest"`
+}
+
+// parsePullRequestEvent normalizes a Bitbucket Cloud or Server payload
+func parsePullRequestEvent(eventKey string, payload []byte) (*PullRequestEvent, error) {
+	event := &PullRequestEvent{EventKey: eventKey}
+
+	if strings.HasPrefix(eventKey, "pullrequest:") {
+		var p cloudPullRequestPayload
+		if err := json.Unmarshal(payload, &p); err != nil {
+			return nil, err
+		}
+		workspace, slug, ok := strings.Cut(p.Repository.FullName, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid repository name %q", p.Repository.FullName)
+		}
+		pr := p.PullRequest
+		event.Owner, event.Repo = workspace, slug
+		event.Number, event.Title, event.State, event.Draft = pr.ID, pr.Title, pr.State, pr.Draft
+		event.BaseRef = pr.Destination.Branch.Name
+		event.BaseSHA = pr.Destination.Commit.Hash
+		event.HeadSHA = pr.Source.Commit.Hash
+		return event, nil
+	}
+
+	var p serverPullRequestPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, err
+	}
+	pr := p.PullRequest
+	event.Owner, event.Repo = pr.ToRef.Repository.Project.Key, pr.ToRef.Repository.Slug
+	event.Number, event.Title, event.State, event.Draft = pr.ID, pr.Title, pr.State, pr.Draft
+	event.BaseRef = pr.ToRef.DisplayID
+	event.BaseSHA = pr.ToRef.LatestCommit
+	event.HeadSHA = pr.FromRef.LatestCommit
+	return event, nil
+}
+
+// WebhookHandler handles Bitbucket webhook events
+type WebhookHandler struct {
+	secret []byte
+	events map[string][]EventHandler
+}
+
+// EventHandler is a function that handles a specific Bitbucket event
+type EventHandler func(payload interface{}) error
+
+// NewWebhookHandler creates a new webhook handler
+func NewWebhookHandler(secret string) *WebhookHandler {
+	return &WebhookHandler{
+		secret: []byte(secret),
+		events: make(map[string][]EventHandler),
+	}
+}
+
+// On registers a handler for a specific event key
+func (h *WebhookHandler) On(event string, handler EventHandler) {
+	h.events[event] = append(h.events[event], handler)
+}
+
+// HandleWebhook handles incoming webhook requests
+func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := h.validatePayload(r)
+	if err != nil {
+		logrus.Errorf("Error validating webhook payload: %v", err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	// Bitbucket Cloud and Server send the event type in the X-Event-Key header
+	event := r.Header.Get("X-Event-Key")
+	if event == "" {
+		logrus.Error("Missing X-Event-Key header")
+		http.Error(w, "Missing event header", http.StatusBadRequest)
+		return
+	}
+
+	logrus.Infof("Received Bitbucket event: %s", event)
+
+	var parsedPayload interface{}
+	switch event {
+	case EventPullRequestCreated, EventPullRequestUpdated, EventPullRequestOpened, EventPullRequestFromRefUpdated:
+		parsedPayload, err = parsePullRequestEvent(event, payload)
+	case "diagnostics:ping":
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		logrus.Warnf("Unsupported event type: %s", event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if err != nil {
+		logrus.Errorf("Error parsing webhook payload: %v", err)
+		http.Error(w, "Error parsing payload", http.StatusBadRequest)
+		return
+	}
+
+	// Call registered handlers for this event
+	handlers, ok := h.events[event]
+	if !ok {
+		logrus.Debugf("No handlers registered for event: %s", event)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	for _, handler := range handlers {
+		if err := handler(parsedPayload); err != nil {
+			logrus.Errorf("Error handling event: %v", err)
+			// Continue processing other handlers
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// validatePayload validates the webhook payload
+func (h *WebhookHandler) validatePayload(r *http.Request) ([]byte, error) {
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no secret is set, skip validation
+	if len(h.secret) == 0 {
+		return payload, nil
+	}
+
+	// Bitbucket sends "sha256=<hex HMAC>" in the X-Hub-Signature header
+	signature := r.Header.Get("X-Hub-Signature")
+	if signature == "" {
+		return nil, fmt.Errorf("missing Bitbucket signature header")
+	}
+
+	if !validateSignature(h.secret, signature, payload) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	return payload, nil
+}
+
+// validateSignature validates the HMAC SHA-256 signature of a payload
+func validateSignature(secret []byte, signature string, payload []byte) bool {
+	signature, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
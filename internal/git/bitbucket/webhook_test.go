@@ -0,0 +1,105 @@
+package bitbucket
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleWebhook(t *testing.T) {
+	tests := []struct {
+		file  string
+		event string
+		want  PullRequestEvent
+	}{
+		{
+			file:  "cloud_pullrequest_created.json",
+			event: EventPullRequestCreated,
+			want: PullRequestEvent{
+				EventKey: EventPullRequestCreated,
+				Owner:    "team", Repo: "service", Number: 7,
+				Title: "[ai-review] Add greeting", State: "OPEN",
+				BaseRef: "main", BaseSHA: "ba9876543210", HeadSHA: "0123456789ab",
+			},
+		},
+		{
+			file:  "server_pr_from_ref_updated.json",
+			event: EventPullRequestFromRefUpdated,
+			want: PullRequestEvent{
+				EventKey: EventPullRequestFromRefUpdated,
+				Owner:    "PROJ", Repo: "service", Number: 12,
+				Title: "Add greeting", State: "OPEN",
+				BaseRef: "master", BaseSHA: "2222222222222222222222222222222222222222", HeadSHA: "1111111111111111111111111111111111111111",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			payload, err := os.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got *PullRequestEvent
+			handler := NewWebhookHandler("secret")
+			for _, event := range PullRequestEvents {
+				handler.On(event, func(payload interface{}) error {
+					got = payload.(*PullRequestEvent)
+					return nil
+				})
+			}
+
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write(payload)
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(payload))
+			req.Header.Set("X-Event-Key", tt.event)
+			req.Header.Set("X-Hub-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+			rec := httptest.NewRecorder()
+			handler.HandleWebhook(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("HandleWebhook() status = %d", rec.Code)
+			}
+			if got == nil || *got != tt.want {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && got.Opened() != (tt.event == EventPullRequestCreated) {
+				t.Errorf("Opened() = %v", got.Opened())
+			}
+		})
+	}
+}
+
+func TestHandleWebhookInvalidSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		signature string
+	}{
+		{name: "wrong signature", signature: "sha256=00"},
+		// 配置了密钥时，未签名的请求同样拒绝
+		{name: "missing signature", signature: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewWebhookHandler("secret")
+			req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader([]byte(`{}`)))
+			req.Header.Set("X-Event-Key", EventPullRequestCreated)
+			if tt.signature != "" {
+				req.Header.Set("X-Hub-Signature", tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			handler.HandleWebhook(rec, req)
+
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("HandleWebhook() status = %d, want %d", rec.Code, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -2,6 +2,8 @@ package git
 
 import (
 	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/git/azuredevops"
+	"github.com/eust-w/ai_code_reviewer/internal/git/bitbucket"
 	"github.com/eust-w/ai_code_reviewer/internal/git/gitea"
 	"github.com/eust-w/ai_code_reviewer/internal/git/github"
 	"github.com/eust-w/ai_code_reviewer/internal/git/gitlab"
@@ -23,6 +25,16 @@ func createGiteaClient(cfg *config.Config) (models.GitPlatform, error) {
 	return gitea.NewClient(cfg)
 }
 
+// 创建Bitbucket客户端的工厂方法
+func createBitbucketClient(cfg *config.Config) (models.GitPlatform, error) {
+	return bitbucket.NewClient(cfg)
+}
+
+// 创建Azure DevOps客户端的工厂方法
+func createAzureDevOpsClient(cfg *config.Config) (models.GitPlatform, error) {
+	return azuredevops.NewClient(cfg)
+}
+
 // 创建GitHub webhook处理程序的工厂方法
 func createGitHubWebhookHandler(secret string) WebhookHandler {
 	return github.NewWebhookHandler(secret)
@@ -37,3 +49,13 @@ func createGitLabWebhookHandler(secret string) WebhookHandler {
 func createGiteaWebhookHandler(secret string) WebhookHandler {
 	return gitea.NewWebhookHandler(secret)
 }
+
+// 创建Bitbucket webhook处理程序的工厂方法
+func createBitbucketWebhookHandler(secret string) WebhookHandler {
+	return bitbucket.NewWebhookHandler(secret)
+}
+
+// 创建Azure DevOps webhook处理程序的工厂方法
+func createAzureDevOpsWebhookHandler(secret string) WebhookHandler {
+	return azuredevops.NewWebhookHandler(secret)
+}
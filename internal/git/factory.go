@@ -25,26 +25,54 @@ func NewFactory(cfg *config.Config) *Factory {
 type PlatformType string
 
 const (
-	GitHubPlatform PlatformType = "github"
-	GitLabPlatform PlatformType = "gitlab"
-	GiteaPlatform  PlatformType = "gitea"
+	GitHubPlatform      PlatformType = "github"
+	GitLabPlatform      PlatformType = "gitlab"
+	GiteaPlatform       PlatformType = "gitea"
+	BitbucketPlatform   PlatformType = "bitbucket"
+	AzureDevOpsPlatform PlatformType = "azuredevops"
 )
 
 // CreatePlatform creates a platform client based on configuration
 func (f *Factory) CreatePlatform() (models.GitPlatform, error) {
-	platform := strings.ToLower(f.config.Platform)
+	return createPlatform(f.config)
+}
+
+// CreatePlatforms creates a client for each platform of a multi-platform
+// server, keyed by platform name. Each client gets its own copy of the
+// configuration with Platform set to its platform.
+func (f *Factory) CreatePlatforms() (map[string]models.GitPlatform, error) {
+	platforms := make(map[string]models.GitPlatform, len(f.config.Platforms))
+	for _, name := range f.config.Platforms {
+		client, err := createPlatform(f.config.ForPlatform(name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s client: %w", name, err)
+		}
+		platforms[name] = client
+	}
+	return platforms, nil
+}
+
+// createPlatform creates the client of the platform of a configuration
+func createPlatform(cfg *config.Config) (models.GitPlatform, error) {
+	platform := strings.ToLower(cfg.Platform)
 	
 	switch platform {
 	case string(GitHubPlatform):
 		logrus.Info("Creating GitHub platform client")
 		// 使用动态导入的方式避免导入循环
-		return createGitHubClient(f.config)
+		return createGitHubClient(cfg)
 	case string(GitLabPlatform):
 		logrus.Info("Creating GitLab platform client")
-		return createGitLabClient(f.config)
+		return createGitLabClient(cfg)
 	case string(GiteaPlatform):
 		logrus.Info("Creating Gitea platform client")
-		return createGiteaClient(f.config)
+		return createGiteaClient(cfg)
+	case string(BitbucketPlatform):
+		logrus.Info("Creating Bitbucket platform client")
+		return createBitbucketClient(cfg)
+	case string(AzureDevOpsPlatform):
+		logrus.Info("Creating Azure DevOps platform client")
+		return createAzureDevOpsClient(cfg)
 	default:
 		return nil, fmt.Errorf("unsupported platform: %s", platform)
 	}
@@ -64,6 +92,10 @@ func (f *Factory) CreateWebhookHandler(secret string) (WebhookHandler, error) {
 		return createGitLabWebhookHandler(secret), nil
 	case string(GiteaPlatform):
 		return createGiteaWebhookHandler(secret), nil
+	case string(BitbucketPlatform):
+		return createBitbucketWebhookHandler(secret), nil
+	case string(AzureDevOpsPlatform):
+		return createAzureDevOpsWebhookHandler(secret), nil
 	default:
 		return nil, fmt.Errorf("unsupported platform: %s", platform)
 	}
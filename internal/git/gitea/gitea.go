@@ -4,12 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"strings"
+	"sync"
 
 	"code.gitea.io/sdk/gitea"
 	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
 	"github.com/eust-w/ai_code_reviewer/internal/models"
 	"github.com/sirupsen/logrus"
 )
@@ -18,6 +19,10 @@ import (
 type Client struct {
 	client *gitea.Client
 	config *config.Config
+
+	mu sync.Mutex
+	// userID is the ID of the authenticated user, once looked up
+	userID int64
 }
 
 // NewClient creates a new Gitea client
@@ -88,215 +93,208 @@ func (c *Client) GetPullRequestLabels(ctx context.Context, owner, repo string, n
 	return labels, nil
 }
 
+// giteaPageSize is the page size used for paginated Gitea API calls
+const giteaPageSize = 50
+
 // CompareCommits compares two commits and returns the files that changed
 func (c *Client) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
-	// 获取PR列表，找到匹配base和head的PR
 	logrus.Debugf("Comparing commits for %s/%s: base=%s, head=%s", owner, repo, base, head)
 	
-	// 获取仓库的所有PR
-	prs, _, err := c.client.ListRepoPullRequests(owner, repo, gitea.ListPullRequestsOptions{
-		State: gitea.StateOpen,
-		Sort: "recentupdate",
-	})
+	commits, err := c.compareCommits(owner, repo, base, head)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to list pull requests: %w", err)
-	}
-	
-	// 寻找匹配的PR
-	var prIndex int64
-	for _, pr := range prs {
-		if pr.Head.Sha == head {
-			prIndex = pr.Index
-			break
-		}
-	}
-	
-	if prIndex == 0 {
-		logrus.Warnf("No matching PR found for head SHA: %s", head)
-		// 如果找不到匹配的PR，返回一个基本的比较结果
-		gitCommits := []*models.Commit{
-			{
-				SHA: head,
-			},
-			{
-				SHA: base,
-			},
-		}
-		return []*models.CommitFile{}, gitCommits, nil
+		return nil, nil, err
 	}
 	
-	// 获取PR的文件变更
-	logrus.Debugf("Found matching PR #%d", prIndex)
-	
-	// Gitea SDK没有直接提供获取PR文件的方法
-	// 我们需要使用GetPullRequest获取PR信息，然后手动构建文件列表
-	pr, _, err := c.client.GetPullRequest(owner, repo, prIndex)
+	raw, err := c.commitDiff(owner, repo, base, head, commits)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get PR details: %w", err)
+		return nil, nil, fmt.Errorf("failed to get diff of %s...%s: %w", base, head, err)
 	}
-	
-	// 由于Gitea SDK没有直接提供获取PR文件的方法
-	// 我们将使用PR的diff信息来获取文件变更
-	logrus.Debugf("Attempting to get PR diff for PR #%d", prIndex)
-	
-	// 创建文件列表
-	files := make([]*models.CommitFile, 0)
-	
-	// 获取PR的diff
-	diffURL := fmt.Sprintf("%s/api/v1/repos/%s/%s/pulls/%d.diff", 
-		c.config.GiteaBaseURL, 
-		owner, 
-		repo, 
-		prIndex)
-	
-	logrus.Debugf("Fetching diff from: %s", diffURL)
-	
-	// 创建HTTP请求
-	req, err := http.NewRequest("GET", diffURL, nil)
+	fileDiffs, err := diff.ParseFileDiffs(string(raw))
 	if err != nil {
-		logrus.Errorf("Failed to create request for PR diff: %v", err)
-		// 如果无法获取diff，返回空文件列表
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
+		return nil, nil, fmt.Errorf("failed to parse diff of %s...%s: %w", base, head, err)
 	}
-	
-	// 添加认证信息
-	req.Header.Add("Authorization", fmt.Sprintf("token %s", c.config.GiteaToken))
-	
-	// 发送请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		logrus.Errorf("Failed to get PR diff: %v", err)
-		// 如果无法获取diff，返回空文件列表
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
+	files := make([]*models.CommitFile, 0, len(fileDiffs))
+	for _, fd := range fileDiffs {
+		files = append(files, models.NewCommitFile(fd, c.contentsURL(owner, repo, fd.Path(), head)))
 	}
-	defer resp.Body.Close()
-	
-	// 检查响应状态码
-	if resp.StatusCode != http.StatusOK {
-		logrus.Errorf("Failed to get PR diff, status code: %d", resp.StatusCode)
-		// 如果无法获取diff，返回空文件列表
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
+	return files, commits, nil
+}
+
+// commitDiff gets the raw diff between base and head from the diff of the
+// head commit, which is only that diff if base is the first parent of head.
+// Gitea has no API for the diff of several commits; its compare page is not
+// served to API tokens.
+func (c *Client) commitDiff(owner, repo, base, head string, commits []*models.Commit) ([]byte, error) {
+	// 合并提交的比较包含被合并的提交，需要确认基准是第一个父提交
+	if len(commits) != 1 || commits[0].SHA != head {
+		commit, _, err := c.client.GetSingleCommit(owner, repo, head)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Parents) == 0 || commit.Parents[0].SHA != base {
+			return nil, fmt.Errorf("%s is not the parent of %s and Gitea has no API for the diff of %d commits", base, head, len(commits))
+		}
 	}
-	
-	// 读取diff内容
-	diffContent, err := io.ReadAll(resp.Body)
+	raw, _, err := c.client.GetCommitDiff(owner, repo, head)
+	return raw, err
+}
+
+// ComparePullRequest returns the files changed by a pull request and its
+// commits, oldest first
+func (c *Client) ComparePullRequest(ctx context.Context, owner, repo string, number int, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	files, err := c.listPullRequestFiles(owner, repo, int64(number), head)
 	if err != nil {
-		logrus.Errorf("Failed to read PR diff: %v", err)
-		// 如果无法读取diff，返回空文件列表
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
+		return nil, nil, err
 	}
 	
-	// 解析diff内容，提取文件信息
-	// 这里使用一个简化的方法来解析diff
-	// 在实际生产环境中，您可能需要使用更复杂的diff解析器
-	
-	// 将diff内容转换为字符串
-	diffString := string(diffContent)
-	
-	// 打印diff内容的前100个字符，帮助调试
-	if len(diffString) > 0 {
-		previewLen := 100
-		if len(diffString) < previewLen {
-			previewLen = len(diffString)
+	commits, err := c.compareCommits(owner, repo, base, head)
+	if err != nil {
+		// compare API 需要 Gitea 1.22，旧版本使用 PR 的提交列表
+		logrus.Debugf("Failed to compare %s...%s: %v, listing pull request commits instead", base, head, err)
+		commits, err = c.listPullRequestCommits(owner, repo, int64(number), head)
+		if err != nil {
+			return nil, nil, err
 		}
-		logrus.Debugf("Diff content preview: %s...", diffString[:previewLen])
-	} else {
-		logrus.Warn("Empty diff content received")
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
 	}
+	return files, commits, nil
+}
 
-	// 使用更简单的方法提取文件名
-	// 将diff按文件分割
-	fileDiffs := strings.Split(diffString, "diff --git ")
-	
-	// 第一个元素是空的，跳过
-	if len(fileDiffs) > 0 {
-		fileDiffs = fileDiffs[1:]
+// compareCommits lists the commits between base and head, oldest first
+func (c *Client) compareCommits(owner, repo, base, head string) ([]*models.Commit, error) {
+	comparison, _, err := c.client.CompareCommits(owner, repo, base, head)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare %s...%s: %w", base, head, err)
 	}
-	
-	logrus.Debugf("Found %d file diffs", len(fileDiffs))
-	
-	// 如果没有文件变更，直接返回空列表
-	if len(fileDiffs) == 0 {
-		logrus.Warn("No file diffs found")
-		return files, []*models.Commit{{SHA: head}, {SHA: base}}, nil
+	return toCommits(comparison.Commits, head), nil
+}
+
+// listPullRequestCommits lists all commits of a pull request, oldest first
+func (c *Client) listPullRequestCommits(owner, repo string, index int64, head string) ([]*models.Commit, error) {
+	var all []*gitea.Commit
+	opts := gitea.ListPullRequestCommitsOptions{ListOptions: gitea.ListOptions{Page: 1, PageSize: giteaPageSize}}
+	for {
+		commits, resp, err := c.client.ListPullRequestCommits(owner, repo, index, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list commits of pull request #%d: %w", index, err)
+		}
+		all = append(all, commits...)
+		next := nextPage(resp, opts.Page, len(commits))
+		if next == 0 {
+			break
+		}
+		opts.Page = next
 	}
-	
-	for _, fileDiff := range fileDiffs {
-		// 从文件diff中提取文件名
-		// 格式例如: "a/file.txt b/file.txt"
-		parts := strings.Split(fileDiff, " ")
-		if len(parts) < 1 {
+	return toCommits(all, head), nil
+}
+
+// toCommits converts Gitea commits, which are listed newest first, to
+// models commits ordered oldest first
+func toCommits(commits []*gitea.Commit, head string) []*models.Commit {
+	result := make([]*models.Commit, 0, len(commits))
+	for _, commit := range commits {
+		if commit == nil || commit.CommitMeta == nil {
 			continue
 		}
-		
-		// 提取文件名，去除 "a/" 前缀
-		filePath := parts[0]
-		if strings.HasPrefix(filePath, "a/") {
-			filePath = filePath[2:]
-		}
-		
-		filename := filePath
-		
-		// 确定文件状态
-		status := "modified" // 默认为修改
-		
-		// 检查是否是新文件
-		if strings.Contains(fileDiff, "new file mode") {
-			status = "added"
+		result = append(result, &models.Commit{SHA: commit.SHA})
+	}
+	if len(result) > 1 && result[0].SHA == head {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
 		}
-		
-		// 检查是否是删除的文件
-		if strings.Contains(fileDiff, "deleted file mode") {
-			status = "removed"
+	}
+	return result
+}
+
+// listPullRequestFiles lists all files changed by a pull request with their
+// patches. The file list comes from the paginated files API, the patches
+// from the diff of the pull request.
+func (c *Client) listPullRequestFiles(owner, repo string, index int64, head string) ([]*models.CommitFile, error) {
+	var changed []*gitea.ChangedFile
+	opts := gitea.ListPullRequestFilesOptions{ListOptions: gitea.ListOptions{Page: 1, PageSize: giteaPageSize}}
+	for {
+		files, resp, err := c.client.ListPullRequestFiles(owner, repo, index, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of pull request #%d: %w", index, err)
 		}
-		
-		// 提取patch部分
-		// 找到第一个@@标记，这是patch的开始
-		patchStart := strings.Index(fileDiff, "@@")
-		if patchStart == -1 {
-			// 如果没有@@标记，跳过这个文件
-			logrus.Debugf("No patch found for file %s", filename)
-			continue
+		changed = append(changed, files...)
+		next := nextPage(resp, opts.Page, len(files))
+		if next == 0 {
+			break
 		}
-		
-		// 提取patch部分
-		patch := fileDiff[patchStart:]
-		
-		// 创建文件对象
-		files = append(files, &models.CommitFile{
-			Filename:    filename,
-			Status:      status,
-			Patch:       patch,
-			ContentsURL: fmt.Sprintf("%s/api/v1/repos/%s/%s/contents/%s?ref=%s", 
-				c.config.GiteaBaseURL, 
-				owner, 
-				repo, 
-				filename,
-				head),
-		})
+		opts.Page = next
 	}
 	
-	logrus.Debugf("Found %d changed files in PR #%d", len(files), prIndex)
-	
-	// 获取提交信息
-	pr, _, err = c.client.GetPullRequest(owner, repo, prIndex)
+	raw, _, err := c.client.GetPullRequestDiff(owner, repo, index, gitea.PullRequestDiffOptions{})
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get PR details: %w", err)
+		return nil, fmt.Errorf("failed to get diff of pull request #%d: %w", index, err)
 	}
-	
-	// 创建提交列表
-	gitCommits := []*models.Commit{
-		{
-			SHA: pr.Head.Sha,
-		},
-		{
-			SHA: pr.Base.Sha,
-		},
+	fileDiffs, err := diff.ParseFileDiffs(string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse diff of pull request #%d: %w", index, err)
+	}
+	byPath := make(map[string]*diff.FileDiff, len(fileDiffs))
+	for _, fd := range fileDiffs {
+		byPath[fd.Path()] = fd
 	}
 	
-	return files, gitCommits, nil
+	files := make([]*models.CommitFile, 0, len(changed))
+	for _, file := range changed {
+		fd := giteaFileDiff(file, byPath[file.Filename])
+		contentsURL := file.ContentsURL
+		if contentsURL == "" {
+			contentsURL = c.contentsURL(owner, repo, fd.Path(), head)
+		}
+		files = append(files, models.NewCommitFile(fd, contentsURL))
+	}
+	logrus.Debugf("Found %d changed files in PR #%d", len(files), index)
+	return files, nil
+}
+
+// giteaFileDiff merges the metadata of a changed file with its parsed patch,
+// which is nil if the diff of the pull request has no entry for the file
+func giteaFileDiff(file *gitea.ChangedFile, parsed *diff.FileDiff) *diff.FileDiff {
+	fd := parsed
+	if fd == nil {
+		// 没有补丁的文件（例如二进制文件或超出 diff 限制的文件）
+		fd = &diff.FileDiff{IsBinary: file.Additions == 0 && file.Deletions == 0}
+	}
+	fd.Status = diff.NormalizeStatus(file.Status)
+	fd.NewPath = file.Filename
+	fd.OldPath = file.PreviousFilename
+	if fd.OldPath == "" {
+		fd.OldPath = file.Filename
+	}
+	switch fd.Status {
+	case diff.StatusAdded:
+		fd.OldPath = ""
+	case diff.StatusRemoved:
+		fd.NewPath = ""
+	}
+	fd.Additions = file.Additions
+	fd.Deletions = file.Deletions
+	return fd
+}
+
+// nextPage returns the page after page, or 0 if it was the last one. Gitea
+// sends Link headers; without them a full page means there may be more.
+func nextPage(resp *gitea.Response, page, count int) int {
+	if resp != nil && (resp.NextPage > 0 || resp.PrevPage > 0 || resp.LastPage > 0) {
+		return resp.NextPage
+	}
+	if count < giteaPageSize {
+		return 0
+	}
+	return page + 1
+}
+
+// contentsURL returns the contents API URL of a file at ref
+func (c *Client) contentsURL(owner, repo, path, ref string) string {
+	return fmt.Sprintf("%s/api/v1/repos/%s/%s/contents/%s?ref=%s",
+		strings.TrimSuffix(c.config.GiteaBaseURL, "/"),
+		owner,
+		repo,
+		path,
+		ref)
 }
 
 // CreateReview creates a review on a pull request
@@ -306,6 +304,79 @@ func (c *Client) CreateReview(ctx context.Context, owner, repo string, number in
 		return nil
 	}
 	
+	if c.useReviewAPI() {
+		err := c.createPullReview(owner, repo, number, commitID, comments, body)
+		if err == nil {
+			return nil
+		}
+		if c.config.GiteaReviewMode == config.GiteaReviewReview {
+			return fmt.Errorf("failed to create pull review: %w", err)
+		}
+		logrus.Warnf("Failed to create pull review: %v, posting a combined comment instead", err)
+	}
+	
+	return c.createCombinedComment(owner, repo, number, comments, body)
+}
+
+// useReviewAPI reports whether reviews are posted through the pull request
+// review API, which Gitea supports since 1.12
+func (c *Client) useReviewAPI() bool {
+	switch c.config.GiteaReviewMode {
+	case config.GiteaReviewReview:
+		return true
+	case config.GiteaReviewComment:
+		return false
+	}
+	
+	// SDK 会缓存服务端版本，只请求一次
+	if err := c.client.CheckServerVersionConstraint(">= 1.12"); err != nil {
+		logrus.Debugf("Gitea pull review API not available: %v", err)
+		return false
+	}
+	return true
+}
+
+// createPullReview posts a review with inline comments
+func (c *Client) createPullReview(owner, repo string, number int, commitID string, comments []*models.ReviewComment, body string) error {
+	opts := gitea.CreatePullReviewOptions{
+		State:    gitea.ReviewStateComment,
+		Body:     body,
+		CommitID: commitID,
+		Comments: make([]gitea.CreatePullReviewComment, 0, len(comments)),
+	}
+	for _, comment := range comments {
+		reviewComment := gitea.CreatePullReviewComment{
+			Path: comment.Path,
+			Body: comment.Body,
+		}
+		// Gitea 的 position 是文件行号，不支持多行范围，定位到最后一行
+		if comment.Side == "LEFT" {
+			reviewComment.OldLineNum = int64(comment.Line)
+		} else {
+			reviewComment.NewLineNum = int64(comment.Line)
+		}
+		opts.Comments = append(opts.Comments, reviewComment)
+	}
+	
+	_, _, err := c.client.CreatePullReview(owner, repo, int64(number), opts)
+	return err
+}
+
+// ApprovePullRequest approves a pull request with an APPROVED pull review
+func (c *Client) ApprovePullRequest(ctx context.Context, owner, repo string, number int) error {
+	// 评论模式或旧版本的 Gitea 不使用审查 API，无法批准
+	if !c.useReviewAPI() {
+		return errors.New("approving pull requests requires the Gitea pull review API")
+	}
+	_, _, err := c.client.CreatePullReview(owner, repo, int64(number), gitea.CreatePullReviewOptions{
+		State: gitea.ReviewStateApproved,
+	})
+	return err
+}
+
+// createCombinedComment posts the review body and all comments as a single
+// issue comment
+func (c *Client) createCombinedComment(owner, repo string, number int, comments []*models.ReviewComment, body string) error {
 	// 如果有评论，则将所有评论合并到一个评论中
 	if len(comments) > 0 {
 		// 获取配置的语言
@@ -331,10 +402,11 @@ func (c *Client) CreateReview(ctx context.Context, owner, repo string, number in
 		// 为每个文件创建一个部分
 		for i, comment := range comments {
 			// 添加文件标题
+			location := formatCommentLocation(comment)
 			if language == "english" {
-				combinedBody += fmt.Sprintf("### File: %s\n\n%s", comment.Path, comment.Body)
+				combinedBody += fmt.Sprintf("### File: %s\n\n%s", location, comment.Body)
 			} else {
-				combinedBody += fmt.Sprintf("### 文件: %s\n\n%s", comment.Path, comment.Body)
+				combinedBody += fmt.Sprintf("### 文件: %s\n\n%s", location, comment.Body)
 			}
 			
 			// 如果不是最后一个评论，添加分隔符
@@ -364,6 +436,23 @@ func (c *Client) CreateReview(ctx context.Context, owner, repo string, number in
 	return nil
 }
 
+// formatCommentLocation renders the file and line range a comment is anchored to
+func formatCommentLocation(comment *models.ReviewComment) string {
+	if comment.Line <= 0 {
+		return comment.Path
+	}
+	
+	prefix := "L"
+	if comment.Side == "LEFT" {
+		// 旧文件中的行（已删除的行）
+		prefix = "-L"
+	}
+	if comment.StartLine > 0 && comment.StartLine < comment.Line {
+		return fmt.Sprintf("%s %s%d-%d", comment.Path, prefix, comment.StartLine, comment.Line)
+	}
+	return fmt.Sprintf("%s %s%d", comment.Path, prefix, comment.Line)
+}
+
 // CreatePRComment creates a comment on a pull request
 func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error {
 	_, _, err := c.client.CreateIssueComment(owner, repo, int64(number), gitea.CreateIssueCommentOption{
@@ -373,6 +462,71 @@ func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number
 	return err
 }
 
+// ListPRComments lists the issue comments of a pull request
+func (c *Client) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*models.PRComment, error) {
+	userID, err := c.currentUserID()
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []*models.PRComment
+	opts := gitea.ListIssueCommentOptions{ListOptions: gitea.ListOptions{Page: 1, PageSize: giteaPageSize}}
+	for {
+		page, resp, err := c.client.ListIssueComments(owner, repo, int64(number), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list comments of pull request #%d: %w", number, err)
+		}
+		for _, comment := range page {
+			comments = append(comments, &models.PRComment{
+				ID:   comment.ID,
+				Body: comment.Body,
+				Mine: comment.Poster != nil && comment.Poster.ID == userID,
+			})
+		}
+		next := nextPage(resp, opts.Page, len(page))
+		if next == 0 {
+			return comments, nil
+		}
+		opts.Page = next
+	}
+}
+
+// currentUserID returns the ID of the user the token belongs to
+func (c *Client) currentUserID() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.userID != 0 {
+		return c.userID, nil
+	}
+
+	user, _, err := c.client.GetMyUserInfo()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the authenticated user: %w", err)
+	}
+	c.userID = user.ID
+	return c.userID, nil
+}
+
+// UpdatePRComment replaces the body of an issue comment of a pull request
+func (c *Client) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	_, _, err := c.client.EditIssueComment(owner, repo, id, gitea.EditIssueCommentOption{
+		Body: body,
+	})
+	return err
+}
+
+// SetCommitStatus sets the review status of a commit
+func (c *Client) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	// Gitea 的提交状态与 models 中的状态取值相同
+	_, _, err := c.client.CreateStatus(owner, repo, sha, gitea.CreateStatusOption{
+		State:       gitea.StatusState(state),
+		TargetURL:   targetURL,
+		Description: description,
+		Context:     models.StatusContext,
+	})
+	return err
+}
+
 // GetRepoVariable gets a repository variable
 // Note: Gitea doesn't have a direct equivalent to GitHub's repository variables
 // We'll use repository secrets as a proxy
@@ -382,6 +536,19 @@ func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string)
 	return "", fmt.Errorf("getting repository variables is not supported in Gitea")
 }
 
+// GetFileContent gets the raw content of a file at the given ref
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	content, resp, err := c.client.GetFile(owner, repo, ref, path)
+	if err != nil {
+		if (resp != nil && resp.StatusCode == http.StatusNotFound) || IsNotFound(err) {
+			return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+		}
+		return nil, err
+	}
+	
+	return content, nil
+}
+
 // IsNotFound checks if an error is a 404 Not Found error
 func IsNotFound(err error) bool {
 	if err == nil {
@@ -0,0 +1,341 @@
+package gitea
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// giteaStandIn mimics the Gitea API endpoints used for reviews
+type giteaStandIn struct {
+	version string
+	// reviewStatus is the status returned by the review endpoint
+	reviewStatus  int
+	reviews       []map[string]interface{}
+	issueComments []string
+	// editedComments maps the paths of edited comments to their new body
+	editedComments map[string]string
+	statuses       []map[string]interface{}
+}
+
+func (s *giteaStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.URL.Path == "/api/v1/version":
+		_, _ = w.Write([]byte(`{"version":"` + s.version + `"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/pulls/3/reviews":
+		if s.reviewStatus != 0 {
+			w.WriteHeader(s.reviewStatus)
+			_, _ = w.Write([]byte(`{"message":"review rejected"}`))
+			return
+		}
+		var review map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &review)
+		s.reviews = append(s.reviews, review)
+		_, _ = w.Write([]byte(`{"id":1,"state":"COMMENT"}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/issues/3/comments":
+		var comment struct {
+			Body string `json:"body"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &comment)
+		s.issueComments = append(s.issueComments, comment.Body)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/repos/owner/repo/issues/3/comments":
+		if r.URL.Query().Get("page") == "2" {
+			_, _ = w.Write([]byte(`[{"id":12,"body":"second","user":{"id":5,"login":"review-bot"}}]`))
+			return
+		}
+		w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next", <`+r.URL.Path+`?page=2>; rel="last"`)
+		_, _ = w.Write([]byte(`[{"id":11,"body":"first","user":{"id":6,"login":"alice"}}]`))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/user":
+		_, _ = w.Write([]byte(`{"id":5,"login":"review-bot"}`))
+	case r.Method == http.MethodPatch && strings.HasPrefix(r.URL.Path, "/api/v1/repos/owner/repo/issues/comments/"):
+		var comment struct {
+			Body string `json:"body"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &comment)
+		if s.editedComments == nil {
+			s.editedComments = make(map[string]string)
+		}
+		s.editedComments[r.URL.Path] = comment.Body
+		_, _ = w.Write([]byte(`{"id":12}`))
+	case r.URL.Path == "/api/v1/repos/owner/repo/pulls/3/files":
+		// 分两页返回，第一页带 Link 头
+		if r.URL.Query().Get("page") == "2" {
+			serveTestdata(w, "pull_files_2.json")
+			return
+		}
+		w.Header().Set("Link", `<`+r.URL.Path+`?page=2>; rel="next", <`+r.URL.Path+`?page=2>; rel="last"`)
+		serveTestdata(w, "pull_files_1.json")
+	case r.URL.Path == "/api/v1/repos/owner/repo/pulls/3.diff":
+		serveTestdata(w, "pull.diff")
+	case r.URL.Path == "/api/v1/repos/owner/repo/pulls/3/commits":
+		_, _ = w.Write([]byte(`[{"sha":"head"},{"sha":"first"}]`))
+	case r.URL.Path == "/api/v1/repos/owner/repo/compare/base...head":
+		serveTestdata(w, "compare.json")
+	case r.URL.Path == "/api/v1/repos/owner/repo/compare/first...head":
+		_, _ = w.Write([]byte(`{"total_commits":1,"commits":[{"sha":"head"}]}`))
+	case r.URL.Path == "/api/v1/repos/owner/repo/compare/main...head":
+		_, _ = w.Write([]byte(`{"total_commits":2,"commits":[{"sha":"head"},{"sha":"feature"}]}`))
+	case r.URL.Path == "/api/v1/repos/owner/repo/git/commits/head":
+		_, _ = w.Write([]byte(`{"sha":"head","parents":[{"sha":"main"},{"sha":"feature"}]}`))
+	case r.URL.Path == "/api/v1/repos/owner/repo/git/commits/head.diff":
+		serveTestdata(w, "commit.diff")
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v1/repos/owner/repo/statuses/head":
+		var status map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &status)
+		s.statuses = append(s.statuses, status)
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveTestdata(w http.ResponseWriter, name string) {
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+var testComments = []*models.ReviewComment{
+	{Path: "main.go", Line: 12, Side: "RIGHT", Body: "check the error"},
+	{Path: "main.go", Line: 7, Side: "LEFT", Body: "this was still used"},
+}
+
+func newTestClient(t *testing.T, standIn *giteaStandIn, mode string) *Client {
+	t.Helper()
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{
+		Platform:        "gitea",
+		GiteaToken:      "test-token",
+		GiteaBaseURL:    server.URL,
+		GiteaReviewMode: mode,
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestCreateReviewUsesReviewAPI(t *testing.T) {
+	standIn := &giteaStandIn{version: "1.21.4"}
+	client := newTestClient(t, standIn, config.GiteaReviewAuto)
+
+	if err := client.CreateReview(context.Background(), "owner", "repo", 3, "abc123", testComments, "Summary"); err != nil {
+		t.Fatalf("CreateReview() error = %v", err)
+	}
+	if len(standIn.reviews) != 1 || len(standIn.issueComments) != 0 {
+		t.Fatalf("got %d reviews and %d issue comments, want 1 review", len(standIn.reviews), len(standIn.issueComments))
+	}
+
+	review := standIn.reviews[0]
+	if review["event"] != "COMMENT" || review["body"] != "Summary" || review["commit_id"] != "abc123" {
+		t.Errorf("review = %v", review)
+	}
+	comments, _ := review["comments"].([]interface{})
+	if len(comments) != 2 {
+		t.Fatalf("review has %d comments, want 2", len(comments))
+	}
+	added := comments[0].(map[string]interface{})
+	deleted := comments[1].(map[string]interface{})
+	if added["new_position"] != float64(12) || added["old_position"] != float64(0) {
+		t.Errorf("added line comment = %v", added)
+	}
+	if deleted["old_position"] != float64(7) || deleted["new_position"] != float64(0) {
+		t.Errorf("deleted line comment = %v", deleted)
+	}
+}
+
+func TestCreateReviewFallsBackToComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		standIn *giteaStandIn
+		mode    string
+	}{
+		{name: "old server", standIn: &giteaStandIn{version: "1.11.5"}, mode: config.GiteaReviewAuto},
+		{name: "review rejected", standIn: &giteaStandIn{version: "1.21.4", reviewStatus: http.StatusUnprocessableEntity}, mode: config.GiteaReviewAuto},
+		{name: "comment mode", standIn: &giteaStandIn{version: "1.21.4"}, mode: config.GiteaReviewComment},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.standIn, tt.mode)
+			if err := client.CreateReview(context.Background(), "owner", "repo", 3, "abc123", testComments, "Summary"); err != nil {
+				t.Fatalf("CreateReview() error = %v", err)
+			}
+			if len(tt.standIn.reviews) != 0 || len(tt.standIn.issueComments) != 1 {
+				t.Fatalf("got %d reviews and %d issue comments, want 1 issue comment", len(tt.standIn.reviews), len(tt.standIn.issueComments))
+			}
+			if !strings.Contains(tt.standIn.issueComments[0], "main.go L12") || !strings.Contains(tt.standIn.issueComments[0], "main.go -L7") {
+				t.Errorf("combined comment = %q", tt.standIn.issueComments[0])
+			}
+		})
+	}
+}
+
+func TestCreateReviewModeReturnsError(t *testing.T) {
+	standIn := &giteaStandIn{version: "1.21.4", reviewStatus: http.StatusUnprocessableEntity}
+	client := newTestClient(t, standIn, config.GiteaReviewReview)
+
+	if err := client.CreateReview(context.Background(), "owner", "repo", 3, "abc123", testComments, "Summary"); err == nil {
+		t.Fatal("CreateReview() error = nil, want the review error")
+	}
+	if len(standIn.issueComments) != 0 {
+		t.Errorf("review mode should not fall back to an issue comment")
+	}
+}
+
+func TestApprovePullRequest(t *testing.T) {
+	standIn := &giteaStandIn{version: "1.21.4"}
+	client := newTestClient(t, standIn, config.GiteaReviewAuto)
+
+	if err := client.ApprovePullRequest(context.Background(), "owner", "repo", 3); err != nil {
+		t.Fatalf("ApprovePullRequest() error = %v", err)
+	}
+	if len(standIn.reviews) != 1 || standIn.reviews[0]["event"] != "APPROVED" {
+		t.Fatalf("reviews = %v, want one APPROVED review", standIn.reviews)
+	}
+
+	// 评论模式下不提交审查
+	client = newTestClient(t, standIn, config.GiteaReviewComment)
+	if err := client.ApprovePullRequest(context.Background(), "owner", "repo", 3); err == nil {
+		t.Error("ApprovePullRequest() in comment mode error = nil")
+	}
+}
+
+func TestComparePullRequest(t *testing.T) {
+	for _, version := range []string{"1.22.3", "1.21.4"} {
+		t.Run(version, func(t *testing.T) {
+			client := newTestClient(t, &giteaStandIn{version: version}, config.GiteaReviewAuto)
+
+			files, commits, err := client.ComparePullRequest(context.Background(), "owner", "repo", 3, "base", "head")
+			if err != nil {
+				t.Fatalf("ComparePullRequest() error = %v", err)
+			}
+
+			// 1.21 没有 compare API，提交来自 PR 的提交列表
+			if len(commits) != 2 || commits[0].SHA != "first" || commits[1].SHA != "head" {
+				t.Errorf("commits = %v, want first, head", commitSHAs(commits))
+			}
+
+			if len(files) != 3 {
+				t.Fatalf("got %d files, want 3 from both pages", len(files))
+			}
+			main, renamed, binary := files[0], files[1], files[2]
+			if main.Filename != "main.go" || main.Status != "modified" || len(main.Diff.Hunks) != 1 {
+				t.Errorf("main.go = %+v", main.Diff)
+			}
+			if main.ContentsURL != "https://gitea.example.com/api/v1/repos/owner/repo/contents/main.go?ref=head" {
+				t.Errorf("main.go contents URL = %q", main.ContentsURL)
+			}
+			if renamed.Filename != "helpers.go" || renamed.Diff.OldPath != "util.go" || renamed.Status != "renamed" ||
+				!strings.Contains(renamed.Patch, "+func b() {}") {
+				t.Errorf("helpers.go = %+v", renamed.Diff)
+			}
+			if binary.Filename != "logo.png" || binary.Status != "added" || !binary.Diff.IsBinary {
+				t.Errorf("logo.png = %+v", binary.Diff)
+			}
+		})
+	}
+}
+
+func TestCompareCommits(t *testing.T) {
+	client := newTestClient(t, &giteaStandIn{version: "1.22.3"}, config.GiteaReviewAuto)
+
+	// 单个提交使用提交的 diff
+	files, commits, err := client.CompareCommits(context.Background(), "owner", "repo", "first", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+	if len(commits) != 1 || len(files) != 1 || files[0].Filename != "main.go" {
+		t.Errorf("got commits %v and %d files, want head and main.go", commitSHAs(commits), len(files))
+	}
+
+	// 合并提交与第一个父提交的比较也使用提交的 diff
+	files, commits, err = client.CompareCommits(context.Background(), "owner", "repo", "main", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+	if len(commits) != 2 || len(files) != 1 || files[0].Filename != "main.go" {
+		t.Errorf("got commits %v and %d files, want 2 commits and main.go", commitSHAs(commits), len(files))
+	}
+
+	// Gitea 没有多个提交的 diff API，返回错误而不是只包含最后一个提交的 diff
+	if _, _, err := client.CompareCommits(context.Background(), "owner", "repo", "base", "head"); err == nil || !strings.Contains(err.Error(), "no API for the diff of 2 commits") {
+		t.Errorf("CompareCommits() of several commits error = %v", err)
+	}
+
+	// 找不到 compare 结果时返回错误而不是空列表
+	if _, _, err := client.CompareCommits(context.Background(), "owner", "repo", "other", "head"); err == nil {
+		t.Error("CompareCommits() error = nil for an unknown range")
+	}
+}
+
+func commitSHAs(commits []*models.Commit) []string {
+	shas := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		shas = append(shas, commit.SHA)
+	}
+	return shas
+}
+
+func TestSetCommitStatus(t *testing.T) {
+	standIn := &giteaStandIn{version: "1.22.3"}
+	client := newTestClient(t, standIn, config.GiteaReviewAuto)
+
+	err := client.SetCommitStatus(context.Background(), "owner", "repo", "head", models.StatusFailure, "Review found issues", "https://gitea.example.com/owner/repo/pulls/3")
+	if err != nil {
+		t.Fatalf("SetCommitStatus() error = %v", err)
+	}
+	if len(standIn.statuses) != 1 {
+		t.Fatalf("got %d statuses, want 1", len(standIn.statuses))
+	}
+	status := standIn.statuses[0]
+	if status["state"] != "failure" || status["context"] != models.StatusContext ||
+		status["target_url"] != "https://gitea.example.com/owner/repo/pulls/3" {
+		t.Errorf("status = %v", status)
+	}
+}
+
+func TestPRComments(t *testing.T) {
+	standIn := &giteaStandIn{version: "1.22.3"}
+	client := newTestClient(t, standIn, config.GiteaReviewAuto)
+
+	comments, err := client.ListPRComments(context.Background(), "owner", "repo", 3)
+	if err != nil {
+		t.Fatalf("ListPRComments() error = %v", err)
+	}
+	if len(comments) != 2 || comments[0].ID != 11 || comments[1].Body != "second" {
+		t.Fatalf("comments = %+v, want both pages", comments)
+	}
+	if comments[0].Mine || !comments[1].Mine {
+		t.Errorf("comments = %+v, want only comment 12 written by the bot", comments)
+	}
+
+	if err := client.UpdatePRComment(context.Background(), "owner", "repo", 3, 12, "updated"); err != nil {
+		t.Fatalf("UpdatePRComment() error = %v", err)
+	}
+	if got := standIn.editedComments["/api/v1/repos/owner/repo/issues/comments/12"]; got != "updated" {
+		t.Errorf("edited comments = %v, want comment 12 updated", standIn.editedComments)
+	}
+}
@@ -0,0 +1,282 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v60/github"
+	"github.com/sirupsen/logrus"
+)
+
+// tokenRefreshMargin is how long before expiry an installation token is
+// replaced, so that a token never expires in the middle of a review
+const tokenRefreshMargin = 5 * time.Minute
+
+// jwtLifetime is the lifetime of app JWTs; GitHub accepts at most 10 minutes
+const jwtLifetime = 9 * time.Minute
+
+// tokenExchangeTimeout bounds the exchange of an installation token, which
+// does not stop when the request that started it is canceled
+const tokenExchangeTimeout = 30 * time.Second
+
+type installationIDKey struct{}
+
+// WithInstallationID returns a context whose GitHub API requests are
+// authenticated as the given app installation, e.g. the installation.id of
+// a webhook event. Without it the installation is looked up from the
+// repository of the request.
+func WithInstallationID(ctx context.Context, id int64) context.Context {
+	if id == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, installationIDKey{}, id)
+}
+
+func installationIDFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(installationIDKey{}).(int64)
+	return id
+}
+
+// installationToken is a cached installation access token
+type installationToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+// tokenCall is an exchange of an installation token in flight. Requests
+// that need the token meanwhile wait for it instead of exchanging their own.
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
+}
+
+// AppTransport authenticates GitHub API requests as a GitHub App
+// installation. Installation tokens are exchanged with the app JWT, cached
+// per installation and refreshed before they expire.
+type AppTransport struct {
+	appID int64
+	key   *rsa.PrivateKey
+	// defaultInstallationID is used for requests that are neither tied to
+	// an installation by their context nor to a repository by their path
+	defaultInstallationID int64
+	base                  http.RoundTripper
+	// apps calls the app endpoints authenticated with the JWT
+	apps *github.Client
+	now  func() time.Time
+
+	mu     sync.Mutex
+	tokens map[int64]*installationToken
+	// calls holds the token exchanges in flight per installation
+	calls map[int64]*tokenCall
+	// installations maps "owner/repo" to its installation ID
+	installations map[string]int64
+}
+
+// NewAppTransport creates a transport for the GitHub App with the given ID
+// and PEM encoded private key. baseURL is the API root of a GitHub
+// Enterprise Server instance, or empty for github.com.
+func NewAppTransport(appID int64, privateKey []byte, defaultInstallationID int64, baseURL string, base http.RoundTripper) (*AppTransport, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub App private key: %w", err)
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	t := &AppTransport{
+		appID:                 appID,
+		key:                   key,
+		defaultInstallationID: defaultInstallationID,
+		base:                  base,
+		now:                   time.Now,
+		tokens:                make(map[int64]*installationToken),
+		calls:                 make(map[int64]*tokenCall),
+		installations:         make(map[string]int64),
+	}
+	t.apps, err = newGitHubClient(&http.Client{Transport: &jwtTransport{app: t}}, baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// parsePrivateKey parses a PKCS#1 or PKCS#8 PEM encoded RSA key. GitHub
+// generates PKCS#1 keys.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("not an RSA key")
+	}
+	return key, nil
+}
+
+// JWT returns a JWT signed with the app private key, valid for jwtLifetime
+func (t *AppTransport) JWT() (string, error) {
+	// 签发时间提前一分钟，容忍与 GitHub 之间的时钟偏差
+	now := t.now().Add(-time.Minute)
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iat": now.Unix(),
+		"exp": now.Add(jwtLifetime).Unix(),
+		"iss": strconv.FormatInt(t.appID, 10),
+	})
+
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+// repoPathPattern matches the repository of a REST API path
+var repoPathPattern = regexp.MustCompile(`/repos/([^/]+)/([^/]+)`)
+
+// RoundTrip implements http.RoundTripper
+func (t *AppTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	id := installationIDFromContext(ctx)
+	if id == 0 {
+		if match := repoPathPattern.FindStringSubmatch(req.URL.Path); match != nil {
+			var err error
+			if id, err = t.repoInstallation(ctx, match[1], match[2]); err != nil {
+				return nil, err
+			}
+		} else {
+			id = t.defaultInstallationID
+		}
+	}
+	if id == 0 {
+		return nil, fmt.Errorf("no GitHub App installation for %s %s", req.Method, req.URL.Path)
+	}
+
+	token, err := t.Token(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// RoundTripper 不能修改原请求
+	authed := req.Clone(ctx)
+	authed.Header.Set("Authorization", "token "+token)
+	return t.base.RoundTrip(authed)
+}
+
+// Token returns a valid access token of an installation, exchanging a new
+// one when the cached token expires within tokenRefreshMargin. Concurrent
+// requests of an installation share one exchange, and requests of other
+// installations are not held up by it.
+func (t *AppTransport) Token(ctx context.Context, installationID int64) (string, error) {
+	t.mu.Lock()
+	if cached, ok := t.tokens[installationID]; ok && t.now().Add(tokenRefreshMargin).Before(cached.expiresAt) {
+		t.mu.Unlock()
+		return cached.token, nil
+	}
+	call, ok := t.calls[installationID]
+	if !ok {
+		call = &tokenCall{done: make(chan struct{})}
+		t.calls[installationID] = call
+		// 交换不随发起请求取消，等待同一令牌的其他请求仍能拿到结果
+		exchangeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenExchangeTimeout)
+		go func() {
+			defer cancel()
+			t.exchange(exchangeCtx, installationID, call)
+		}()
+	}
+	t.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// exchange creates an access token of an installation, caches it and
+// finishes call with it
+func (t *AppTransport) exchange(ctx context.Context, installationID int64, call *tokenCall) {
+	token, _, err := t.apps.Apps.CreateInstallationToken(ctx, installationID, nil)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.calls, installationID)
+	defer close(call.done)
+	if err != nil {
+		call.err = fmt.Errorf("failed to create token for installation %d: %w", installationID, err)
+		return
+	}
+	logrus.Debugf("Created GitHub App token for installation %d, expires at %s", installationID, token.GetExpiresAt())
+
+	call.token = token.GetToken()
+	t.tokens[installationID] = &installationToken{
+		token:     token.GetToken(),
+		expiresAt: token.GetExpiresAt().Time,
+	}
+}
+
+// repoInstallation returns the installation of the app on a repository
+func (t *AppTransport) repoInstallation(ctx context.Context, owner, repo string) (int64, error) {
+	fullName := owner + "/" + repo
+
+	t.mu.Lock()
+	id, ok := t.installations[fullName]
+	t.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	installation, _, err := t.apps.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return 0, fmt.Errorf("GitHub App is not installed on %s: %w", fullName, err)
+	}
+
+	t.mu.Lock()
+	t.installations[fullName] = installation.GetID()
+	t.mu.Unlock()
+	return installation.GetID(), nil
+}
+
+// jwtTransport authenticates requests to the app endpoints with the app JWT
+type jwtTransport struct {
+	app *AppTransport
+}
+
+func (t *jwtTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	jwt, err := t.app.JWT()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+	authed := req.Clone(req.Context())
+	authed.Header.Set("Authorization", "Bearer "+jwt)
+	return t.app.base.RoundTrip(authed)
+}
@@ -0,0 +1,242 @@
+package github
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+// appStandIn is a GitHub Enterprise Server stand-in serving the app
+// endpoints and one pull request
+type appStandIn struct {
+	t   *testing.T
+	key *rsa.PublicKey
+	now time.Time
+
+	mu sync.Mutex
+	// issued counts the tokens created per installation
+	issued           map[string]int
+	lookups          int
+	prAuthorizations []string
+	// hold is an installation whose token exchanges signal holding and
+	// wait for release before they are answered
+	hold    string
+	holding chan struct{}
+	release chan struct{}
+}
+
+func (s *appStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.hold != "" && r.URL.Path == "/api/v3/app/installations/"+s.hold+"/access_tokens" {
+		s.holding <- struct{}{}
+		<-s.release
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/api/v3/repos/octo/service/installation":
+		s.verifyJWT(r)
+		s.lookups++
+		_, _ = w.Write([]byte(`{"id":42}`))
+	case strings.HasPrefix(r.URL.Path, "/api/v3/app/installations/") && r.Method == http.MethodPost:
+		s.verifyJWT(r)
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v3/app/installations/"), "/access_tokens")
+		s.issued[id]++
+		// 令牌有效期一小时
+		fmt.Fprintf(w, `{"token":"token-%s-%d","expires_at":%q}`, id, s.issued[id], s.now.Add(time.Hour).Format(time.RFC3339))
+	case r.URL.Path == "/api/v3/app":
+		s.verifyJWT(r)
+		_, _ = w.Write([]byte(`{"id":1234,"slug":"ai-review"}`))
+	case r.URL.Path == "/api/v3/repos/octo/service/issues/1/comments":
+		_, _ = w.Write([]byte(`[{"id":1,"body":"summary","user":{"login":"ai-review[bot]","type":"Bot"}},
+			{"id":2,"body":"fake summary","user":{"login":"ai-review","type":"User"}}]`))
+	case r.URL.Path == "/api/v3/repos/octo/service/pulls/1":
+		s.prAuthorizations = append(s.prAuthorizations, r.Header.Get("Authorization"))
+		_, _ = w.Write([]byte(`{"number":1,"title":"Add greeting"}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verifyJWT checks that a request carries a valid RS256 app JWT
+func (s *appStandIn) verifyJWT(r *http.Request) {
+	jwt, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(jwt, ".")
+	if !ok || len(parts) != 3 {
+		s.t.Errorf("%s: Authorization = %q, want app JWT", r.URL.Path, r.Header.Get("Authorization"))
+		return
+	}
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(s.key, crypto.SHA256, digest[:], signature); err != nil {
+		s.t.Errorf("JWT signature: %v", err)
+	}
+
+	var claims struct {
+		Iss string `json:"iss"`
+		Iat int64  `json:"iat"`
+		Exp int64  `json:"exp"`
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	_ = json.Unmarshal(payload, &claims)
+	if claims.Iss != "1234" || claims.Exp-claims.Iat > int64(10*time.Minute/time.Second) || claims.Iat > s.now.Unix() {
+		s.t.Errorf("JWT claims = %+v", claims)
+	}
+}
+
+func newAppTestClient(t *testing.T) (*Client, *AppTransport, *appStandIn, *time.Time) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	standIn := &appStandIn{t: t, key: &key.PublicKey, now: now, issued: make(map[string]int)}
+	server := httptest.NewServer(standIn)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{
+		Platform:            "github",
+		GithubBaseURL:       server.URL + "/api/v3",
+		GithubAppID:         1234,
+		GithubAppPrivateKey: string(privateKey),
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	transport := client.client.Client().Transport.(*AppTransport)
+	transport.now = func() time.Time { return now }
+	return client, transport, standIn, &now
+}
+
+func TestAppInstallationTokens(t *testing.T) {
+	client, transport, standIn, now := newAppTestClient(t)
+	ctx := context.Background()
+
+	// 未指定安装时，按仓库查找安装并缓存
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetPullRequest(ctx, "octo", "service", 1); err != nil {
+			t.Fatalf("GetPullRequest() error = %v", err)
+		}
+	}
+	if standIn.lookups != 1 || standIn.issued["42"] != 1 {
+		t.Errorf("lookups = %d, tokens = %v, want one of each", standIn.lookups, standIn.issued)
+	}
+
+	// 令牌在过期前 tokenRefreshMargin 内刷新
+	*now = now.Add(time.Hour - tokenRefreshMargin + time.Second)
+	standIn.now = *now
+	transport.now = func() time.Time { return *now }
+	if _, err := client.GetPullRequest(ctx, "octo", "service", 1); err != nil {
+		t.Fatalf("GetPullRequest() error = %v", err)
+	}
+
+	// webhook 事件中的 installation.id 优先
+	if _, err := client.GetPullRequest(WithInstallationID(ctx, 7), "octo", "service", 1); err != nil {
+		t.Fatalf("GetPullRequest() error = %v", err)
+	}
+
+	want := []string{"token token-42-1", "token token-42-1", "token token-42-2", "token token-7-1"}
+	if strings.Join(standIn.prAuthorizations, ",") != strings.Join(want, ",") {
+		t.Errorf("Authorization headers = %v, want %v", standIn.prAuthorizations, want)
+	}
+}
+
+func TestAppTokenExchangeShared(t *testing.T) {
+	_, transport, standIn, _ := newAppTestClient(t)
+	standIn.hold, standIn.holding, standIn.release = "9", make(chan struct{}, 1), make(chan struct{})
+	ctx := context.Background()
+
+	// 同一安装的并发请求共用一次令牌交换
+	tokens := make([]string, 5)
+	errs := make([]error, len(tokens))
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], errs[i] = transport.Token(ctx, 9)
+		}()
+	}
+	<-standIn.holding
+
+	// 交换期间其他安装的请求不被阻塞，取消的请求不再等待
+	if token, err := transport.Token(ctx, 7); err != nil || token != "token-7-1" {
+		t.Errorf("Token(7) = %q, %v while another installation exchanges its token", token, err)
+	}
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := transport.Token(canceled, 9); err != context.Canceled {
+		t.Errorf("Token() with a canceled context error = %v", err)
+	}
+
+	close(standIn.release)
+	wg.Wait()
+	for i := range tokens {
+		if errs[i] != nil || tokens[i] != "token-9-1" {
+			t.Errorf("Token(9) = %q, %v, want token-9-1", tokens[i], errs[i])
+		}
+	}
+	if standIn.issued["9"] != 1 {
+		t.Errorf("issued %d tokens for installation 9, want 1", standIn.issued["9"])
+	}
+}
+
+func TestAppCommentsOfTheBot(t *testing.T) {
+	client, _, _, _ := newAppTestClient(t)
+
+	comments, err := client.ListPRComments(context.Background(), "octo", "service", 1)
+	if err != nil {
+		t.Fatalf("ListPRComments() error = %v", err)
+	}
+	// 只有 App 的机器人用户写的评论属于机器人
+	if len(comments) != 2 || !comments[0].Mine || comments[1].Mine {
+		t.Errorf("comments = %+v, want only the first written by the bot", comments)
+	}
+}
+
+func TestAppClientRequiresPrivateKey(t *testing.T) {
+	if _, err := NewClient(&config.Config{Platform: "github", GithubAppID: 1234}); err == nil {
+		t.Error("NewClient() without private key error = nil")
+	}
+	if _, err := NewClient(&config.Config{Platform: "github", GithubAppID: 1234, GithubAppPrivateKey: "not a key"}); err == nil {
+		t.Error("NewClient() with invalid private key error = nil")
+	}
+}
+
+func TestCloneURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    string
+	}{
+		{"", "https://github.com/octo/service.git"},
+		{"https://github.example.com/api/v3/", "https://github.example.com/octo/service.git"},
+	}
+	for _, tt := range tests {
+		client, err := NewClient(&config.Config{GithubToken: "token", GithubBaseURL: tt.baseURL})
+		if err != nil {
+			t.Fatalf("NewClient(%q) error = %v", tt.baseURL, err)
+		}
+		if got := client.CloneURL("octo", "service"); got != tt.want {
+			t.Errorf("CloneURL() with base URL %q = %q, want %q", tt.baseURL, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+	"github.com/google/go-github/v60/github"
+)
+
+// CheckRunName is the name of the check run created for reviews
+const CheckRunName = "AI Code Review"
+
+// maxAnnotationsPerRequest is the number of annotations GitHub accepts in
+// one check run request
+const maxAnnotationsPerRequest = 50
+
+// maxCheckRunSummary is the maximum length of a check run summary
+const maxCheckRunSummary = 65535
+
+// StartCheckRun creates an in-progress check run for a commit. Check runs
+// can only be created when authenticated as a GitHub App.
+func (c *Client) StartCheckRun(ctx context.Context, owner, repo, headSHA string) (int64, error) {
+	run, _, err := c.client.Checks.CreateCheckRun(ctx, owner, repo, github.CreateCheckRunOptions{
+		Name:      CheckRunName,
+		HeadSHA:   headSHA,
+		Status:    github.String("in_progress"),
+		StartedAt: &github.Timestamp{Time: time.Now()},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create check run: %w", err)
+	}
+	return run.GetID(), nil
+}
+
+// CompleteCheckRun completes a check run with the review result
+func (c *Client) CompleteCheckRun(ctx context.Context, owner, repo string, id int64, result *models.CheckRunResult) error {
+	summary := result.Summary
+	if len(summary) > maxCheckRunSummary {
+		summary = summary[:maxCheckRunSummary-3] + "..."
+	}
+
+	annotations := make([]*github.CheckRunAnnotation, 0, len(result.Annotations))
+	for _, a := range result.Annotations {
+		end := a.EndLine
+		if end < a.StartLine {
+			end = a.StartLine
+		}
+		annotations = append(annotations, &github.CheckRunAnnotation{
+			Path:            github.String(a.Path),
+			StartLine:       github.Int(a.StartLine),
+			EndLine:         github.Int(end),
+			AnnotationLevel: github.String("warning"),
+			Message:         github.String(a.Message),
+		})
+	}
+
+	// 注释分批追加，最后一批同时完成检查运行
+	for {
+		batch := annotations
+		if len(batch) > maxAnnotationsPerRequest {
+			batch = batch[:maxAnnotationsPerRequest]
+		}
+		annotations = annotations[len(batch):]
+
+		opts := github.UpdateCheckRunOptions{
+			Name: CheckRunName,
+			Output: &github.CheckRunOutput{
+				Title:       github.String(result.Title),
+				Summary:     github.String(summary),
+				Annotations: batch,
+			},
+		}
+		if len(annotations) == 0 {
+			opts.Status = github.String("completed")
+			opts.Conclusion = github.String(result.Conclusion)
+			opts.CompletedAt = &github.Timestamp{Time: time.Now()}
+		}
+		if _, _, err := c.client.Checks.UpdateCheckRun(ctx, owner, repo, id, opts); err != nil {
+			return fmt.Errorf("failed to update check run %d: %w", id, err)
+		}
+		if len(annotations) == 0 {
+			return nil
+		}
+	}
+}
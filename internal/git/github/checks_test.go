@@ -0,0 +1,72 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+func TestCheckRun(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		body["method"] = r.Method + " " + r.URL.Path
+		requests = append(requests, body)
+		_, _ = w.Write([]byte(`{"id":99}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ctx := context.Background()
+
+	id, err := client.StartCheckRun(ctx, "octo", "service", "head")
+	if err != nil || id != 99 {
+		t.Fatalf("StartCheckRun() = %d, %v", id, err)
+	}
+	if requests[0]["method"] != "POST /api/v3/repos/octo/service/check-runs" || requests[0]["status"] != "in_progress" ||
+		requests[0]["head_sha"] != "head" || requests[0]["name"] != CheckRunName {
+		t.Errorf("create request = %v", requests[0])
+	}
+
+	// 120 条注释需要分三次提交，只有最后一次完成检查运行
+	result := &models.CheckRunResult{Conclusion: models.CheckConclusionFailure, Title: "Risks found", Summary: "## Summary"}
+	for i := 1; i <= 120; i++ {
+		result.Annotations = append(result.Annotations, &models.CheckAnnotation{Path: "main.go", StartLine: i, Message: fmt.Sprintf("finding %d", i)})
+	}
+	if err := client.CompleteCheckRun(ctx, "octo", "service", id, result); err != nil {
+		t.Fatalf("CompleteCheckRun() error = %v", err)
+	}
+
+	updates := requests[1:]
+	if len(updates) != 3 {
+		t.Fatalf("got %d update requests, want 3", len(updates))
+	}
+	for i, update := range updates {
+		output, _ := update["output"].(map[string]interface{})
+		annotations, _ := output["annotations"].([]interface{})
+		wantCount := []int{50, 50, 20}[i]
+		if update["method"] != "PATCH /api/v3/repos/octo/service/check-runs/99" || len(annotations) != wantCount || output["summary"] != "## Summary" {
+			t.Errorf("update %d = %s with %d annotations", i, update["method"], len(annotations))
+		}
+		last := i == len(updates)-1
+		if (update["status"] == "completed") != last || (update["conclusion"] == "failure") != last {
+			t.Errorf("update %d status = %v, conclusion = %v", i, update["status"], update["conclusion"])
+		}
+	}
+	first := updates[0]["output"].(map[string]interface{})["annotations"].([]interface{})[0].(map[string]interface{})
+	if first["start_line"] != float64(1) || first["end_line"] != float64(1) || first["annotation_level"] != "warning" {
+		t.Errorf("annotation = %v", first)
+	}
+}
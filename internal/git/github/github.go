@@ -1,11 +1,17 @@
 package github
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
 	"github.com/eust-w/ai_code_reviewer/internal/models"
 	"github.com/google/go-github/v60/github"
 	"github.com/sirupsen/logrus"
@@ -16,13 +22,24 @@ import (
 type Client struct {
 	client *github.Client
 	config *config.Config
+	// app is the transport of GitHub App authentication, nil for tokens
+	app *AppTransport
+
+	mu sync.Mutex
+	// login is the login of the authenticated user, once looked up
+	login string
 }
 
-// NewClient creates a new GitHub client
+// NewClient creates a new GitHub client. It authenticates as a GitHub App
+// installation when GithubAppID is set, otherwise with GithubToken.
 func NewClient(cfg *config.Config) (*Client, error) {
+	if cfg.GithubAppID != 0 {
+		return newAppClient(cfg)
+	}
+
 	// 只有当选择的平台是GitHub时，才检查令牌
 	if cfg.Platform == "github" && cfg.GithubToken == "" {
-		return nil, errors.New("GitHub token is required when using GitHub platform")
+		return nil, errors.New("GitHub token or GitHub App is required when using GitHub platform")
 	}
 
 	ts := oauth2.StaticTokenSource(
@@ -30,12 +47,70 @@ func NewClient(cfg *config.Config) (*Client, error) {
 	)
 	tc := oauth2.NewClient(context.Background(), ts)
 	
+	client, err := newGitHubClient(tc, cfg.GithubBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		client: client,
+		config: cfg,
+	}, nil
+}
+
+// newAppClient creates a client authenticated as a GitHub App installation
+func newAppClient(cfg *config.Config) (*Client, error) {
+	privateKey := []byte(cfg.GithubAppPrivateKey)
+	if len(privateKey) == 0 {
+		if cfg.GithubAppPrivateKeyPath == "" {
+			return nil, errors.New("GitHub App private key is required when using GitHub App authentication")
+		}
+		var err error
+		if privateKey, err = os.ReadFile(cfg.GithubAppPrivateKeyPath); err != nil {
+			return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+		}
+	}
+
+	transport, err := NewAppTransport(cfg.GithubAppID, privateKey, cfg.GithubAppInstallationID, cfg.GithubBaseURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	client, err := newGitHubClient(&http.Client{Transport: transport}, cfg.GithubBaseURL)
+	if err != nil {
+		return nil, err
+	}
+	logrus.Infof("Authenticating as GitHub App %d", cfg.GithubAppID)
 	return &Client{
-		client: github.NewClient(tc),
+		client: client,
 		config: cfg,
+		app:    transport,
 	}, nil
 }
 
+// newGitHubClient creates a go-github client for github.com, or for the
+// GitHub Enterprise Server instance at baseURL
+func newGitHubClient(httpClient *http.Client, baseURL string) (*github.Client, error) {
+	client := github.NewClient(httpClient)
+	if baseURL == "" {
+		return client, nil
+	}
+	uploadURL := strings.TrimSuffix(strings.TrimSuffix(baseURL, "/"), "/api/v3")
+	client, err := client.WithEnterpriseURLs(baseURL, uploadURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GitHub base URL: %w", err)
+	}
+	return client, nil
+}
+
+// CloneURL returns the HTTPS clone URL of a repository
+func (c *Client) CloneURL(owner, repo string) string {
+	base := c.client.BaseURL
+	if base.Host == "api.github.com" {
+		return fmt.Sprintf("https://github.com/%s/%s.git", owner, repo)
+	}
+	// GitHub Enterprise Server 的 API 位于 /api/v3 下
+	return fmt.Sprintf("%s://%s/%s/%s.git", base.Scheme, base.Host, owner, repo)
+}
+
 // GetPullRequest gets a pull request by number
 func (c *Client) GetPullRequest(ctx context.Context, owner, repo string, number int) (*models.PullRequest, error) {
 	pr, _, err := c.client.PullRequests.Get(ctx, owner, repo, number)
@@ -70,50 +145,225 @@ func (c *Client) GetPullRequestLabels(ctx context.Context, owner, repo string, n
 	return extractLabels(pr.Labels), nil
 }
 
-// CompareCommits compares two commits and returns the files that changed
+// perPage is the page size of list requests, the largest GitHub accepts
+const perPage = 100
+
+// maxCompareFiles is the number of files the compare API lists at most
+const maxCompareFiles = 300
+
+// comparison is a compare API result with every page of commits
+type comparison struct {
+	files   []*github.CommitFile
+	commits []*models.Commit
+	// mergeBase is the commit the changes are relative to
+	mergeBase string
+}
+
+// compare compares two commits, following the commit pages. GitHub lists
+// the changed files on the first page only.
+func (c *Client) compare(ctx context.Context, owner, repo, base, head string) (*comparison, error) {
+	result := &comparison{mergeBase: base}
+	opts := &github.ListOptions{PerPage: perPage}
+	for {
+		page, resp, err := c.client.Repositories.CompareCommits(ctx, owner, repo, base, head, opts)
+		if err != nil {
+			return nil, err
+		}
+		if opts.Page == 0 {
+			result.files = page.Files
+			if sha := page.GetMergeBaseCommit().GetSHA(); sha != "" {
+				result.mergeBase = sha
+			}
+		}
+		for _, commit := range page.Commits {
+			result.commits = append(result.commits, &models.Commit{
+				SHA: commit.GetSHA(),
+			})
+		}
+		if resp.NextPage == 0 {
+			return result, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// CompareCommits compares two commits and returns the files that changed.
+// The compare API lists at most 300 files; pull requests with more files
+// are compared with ComparePullRequest.
 func (c *Client) CompareCommits(ctx context.Context, owner, repo, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
-	comparison, _, err := c.client.Repositories.CompareCommits(ctx, owner, repo, base, head, &github.ListOptions{})
+	comparison, err := c.compare(ctx, owner, repo, base, head)
 	if err != nil {
 		return nil, nil, err
 	}
+	if len(comparison.files) >= maxCompareFiles {
+		logrus.Warnf("Comparison of %s...%s in %s/%s is truncated to %d files", base, head, owner, repo, len(comparison.files))
+	}
 	
-	files := make([]*models.CommitFile, 0, len(comparison.Files))
-	for _, file := range comparison.Files {
-		files = append(files, &models.CommitFile{
-			Filename:    file.GetFilename(),
-			Status:      file.GetStatus(),
-			Patch:       file.GetPatch(),
-			ContentsURL: file.GetContentsURL(),
-		})
+	files := c.commitFiles(ctx, owner, repo, comparison.mergeBase, comparison.files)
+	return files, comparison.commits, nil
+}
+
+// ComparePullRequest returns the files changed by a pull request and its
+// commits. Files are listed with the pull request files API, which returns
+// up to 3000 files instead of the 300 of the compare API.
+func (c *Client) ComparePullRequest(ctx context.Context, owner, repo string, number int, base, head string) ([]*models.CommitFile, []*models.Commit, error) {
+	var prFiles []*github.CommitFile
+	opts := &github.ListOptions{PerPage: perPage}
+	for {
+		page, resp, err := c.client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to list files of PR #%d: %w", number, err)
+		}
+		prFiles = append(prFiles, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
 	}
 	
-	commits := make([]*models.Commit, 0, len(comparison.Commits))
-	for _, commit := range comparison.Commits {
-		commits = append(commits, &models.Commit{
-			SHA: commit.GetSHA(),
-		})
+	// 提交列表仍然来自比较 API，PR 的提交 API 最多只返回 250 个
+	comparison, err := c.compare(ctx, owner, repo, base, head)
+	if err != nil {
+		return nil, nil, err
 	}
 	
-	return files, commits, nil
+	files := c.commitFiles(ctx, owner, repo, comparison.mergeBase, prFiles)
+	return files, comparison.commits, nil
+}
+
+// commitFiles converts the changed files of the compare or pull request
+// files API. Files GitHub did not include a patch for are diffed from their
+// blobs at base and head.
+func (c *Client) commitFiles(ctx context.Context, owner, repo, base string, ghFiles []*github.CommitFile) []*models.CommitFile {
+	files := make([]*models.CommitFile, 0, len(ghFiles))
+	for _, file := range ghFiles {
+		fd := githubFileDiff(file)
+		if missingPatch(file) {
+			logrus.Infof("GitHub omitted the patch of %s, diffing its blobs", file.GetFilename())
+			rebuilt, err := c.blobFileDiff(ctx, owner, repo, base, file)
+			if err != nil {
+				logrus.Warnf("Failed to diff blobs of %s: %v", file.GetFilename(), err)
+			} else {
+				fd = rebuilt
+			}
+		}
+		files = append(files, models.NewCommitFile(fd, file.GetContentsURL()))
+	}
+	return files
+}
+
+// missingPatch reports whether GitHub left out the patch of a changed text
+// file, which it does when the diff is too large
+func missingPatch(file *github.CommitFile) bool {
+	return file.Patch == nil && file.GetChanges() > 0
+}
+
+// blobFileDiff diffs the blob of a file at base with its blob at head
+func (c *Client) blobFileDiff(ctx context.Context, owner, repo, base string, file *github.CommitFile) (*models.FileDiff, error) {
+	status := diff.NormalizeStatus(file.GetStatus())
+	oldPath := file.GetPreviousFilename()
+	if oldPath == "" {
+		oldPath = file.GetFilename()
+	}
+	
+	var oldContent, newContent []byte
+	var err error
+	if status != diff.StatusAdded {
+		if oldContent, err = c.GetFileContent(ctx, owner, repo, oldPath, base); err != nil {
+			return nil, err
+		}
+	}
+	if status != diff.StatusRemoved {
+		// 文件的 sha 是头提交中的 blob
+		if newContent, _, err = c.client.Git.GetBlobRaw(ctx, owner, repo, file.GetSHA()); err != nil {
+			return nil, fmt.Errorf("failed to get blob %s: %w", file.GetSHA(), err)
+		}
+	}
+	
+	if isBinary(oldContent) || isBinary(newContent) {
+		fd, err := diff.NewFileDiff(file.GetPreviousFilename(), file.GetFilename(), file.GetStatus(), "")
+		if err != nil {
+			return nil, err
+		}
+		fd.IsBinary = true
+		return fd, nil
+	}
+	
+	patch, err := diff.Unified(string(oldContent), string(newContent), diff.DefaultContextLines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff %s: %w", file.GetFilename(), err)
+	}
+	fd, err := diff.NewFileDiff(file.GetPreviousFilename(), file.GetFilename(), file.GetStatus(), patch)
+	if err != nil {
+		return nil, err
+	}
+	fd.Additions = file.GetAdditions()
+	fd.Deletions = file.GetDeletions()
+	return fd, nil
+}
+
+// isBinary reports whether content looks like a binary file, the same
+// heuristic git uses
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// githubFileDiff converts a changed file of the compare API to a file diff
+func githubFileDiff(file *github.CommitFile) *models.FileDiff {
+	fd, err := diff.NewFileDiff(file.GetPreviousFilename(), file.GetFilename(), file.GetStatus(), file.GetPatch())
+	if err != nil {
+		logrus.Warnf("Failed to parse patch of %s: %v", file.GetFilename(), err)
+		fd, _ = diff.NewFileDiff(file.GetPreviousFilename(), file.GetFilename(), file.GetStatus(), "")
+		fd.Patch = file.GetPatch()
+	}
+
+	// 超大的补丁会被 GitHub 省略，行数以 API 统计为准
+	fd.Additions = file.GetAdditions()
+	fd.Deletions = file.GetDeletions()
+	// 二进制文件没有 patch，也没有行数变化
+	if file.Patch == nil && file.GetChanges() == 0 && fd.Status != diff.StatusRenamed && fd.Status != diff.StatusCopied {
+		fd.IsBinary = true
+	}
+	return fd
 }
 
 // CreateReview creates a review on a pull request
 func (c *Client) CreateReview(ctx context.Context, owner, repo string, number int, commitID string, comments []*models.ReviewComment, body string) error {
 	ghComments := make([]*github.DraftReviewComment, 0, len(comments))
 	for _, comment := range comments {
-		ghComments = append(ghComments, &github.DraftReviewComment{
-			Path:     github.String(comment.Path),
-			Body:     github.String(comment.Body),
-			Position: github.Int(comment.Position),
-		})
+		ghComment := &github.DraftReviewComment{
+			Path: github.String(comment.Path),
+			Body: github.String(comment.Body),
+		}
+		
+		// 优先使用行号定位，position 仅作为兼容方式
+		if comment.Line > 0 {
+			ghComment.Line = github.Int(comment.Line)
+			ghComment.Side = github.String(comment.Side)
+			if comment.StartLine > 0 && comment.StartLine < comment.Line {
+				ghComment.StartLine = github.Int(comment.StartLine)
+				ghComment.StartSide = github.String(comment.StartSide)
+			}
+		} else {
+			ghComment.Position = github.Int(comment.Position)
+		}
+		
+		ghComments = append(ghComments, ghComment)
 	}
 	
-	_, _, err := c.client.PullRequests.CreateReview(ctx, owner, repo, number, &github.PullRequestReviewRequest{
+	review := &github.PullRequestReviewRequest{
 		CommitID: github.String(commitID),
-		Body:     github.String(body),
 		Event:    github.String("COMMENT"),
 		Comments: ghComments,
-	})
+	}
+	// 只有行内评论的审查不需要正文
+	if body != "" {
+		review.Body = github.String(body)
+	}
+	_, _, err := c.client.PullRequests.CreateReview(ctx, owner, repo, number, review)
 	
 	return err
 }
@@ -126,6 +376,101 @@ func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number
 	return err
 }
 
+// ListPRComments lists the issue comments of a pull request
+func (c *Client) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*models.PRComment, error) {
+	login, err := c.authenticatedLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var comments []*models.PRComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: perPage}}
+	for {
+		page, resp, err := c.client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list comments of PR #%d: %w", number, err)
+		}
+		for _, comment := range page {
+			comments = append(comments, &models.PRComment{
+				ID:   comment.GetID(),
+				Body: comment.GetBody(),
+				Mine: comment.GetUser().GetLogin() == login,
+			})
+		}
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// authenticatedLogin returns the login of the user the client authenticates
+// as. Comments of a GitHub App are written by its bot user "<slug>[bot]".
+func (c *Client) authenticatedLogin(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.login != "" {
+		return c.login, nil
+	}
+
+	if c.app != nil {
+		app, _, err := c.app.apps.Apps.Get(ctx, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to get the authenticated GitHub App: %w", err)
+		}
+		c.login = app.GetSlug() + "[bot]"
+	} else {
+		user, _, err := c.client.Users.Get(ctx, "")
+		if err != nil {
+			return "", fmt.Errorf("failed to get the authenticated user: %w", err)
+		}
+		c.login = user.GetLogin()
+	}
+	return c.login, nil
+}
+
+// UpdatePRComment replaces the body of an issue comment of a pull request
+func (c *Client) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	_, _, err := c.client.Issues.EditComment(ctx, owner, repo, id, &github.IssueComment{
+		Body: github.String(body),
+	})
+	return err
+}
+
+// SetCommitStatus sets the review status of a commit
+func (c *Client) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	status := &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(models.StatusContext),
+	}
+	if targetURL != "" {
+		status.TargetURL = github.String(targetURL)
+	}
+	_, _, err := c.client.Repositories.CreateStatus(ctx, owner, repo, sha, status)
+	return err
+}
+
+// IsCollaborator reports whether a user has write or admin permission on a
+// repository
+func (c *Client) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error) {
+	level, _, err := c.client.Repositories.GetPermissionLevel(ctx, owner, repo, user)
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get permission of %s on %s/%s: %w", user, owner, repo, err)
+	}
+	
+	// maintain 和 triage 角色分别映射为 write 和 read
+	switch level.GetPermission() {
+	case "admin", "write":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
 // GetRepoVariable gets a repository variable
 func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error) {
 	variable, _, err := c.client.Actions.GetRepoVariable(ctx, owner, repo, name)
@@ -136,6 +481,39 @@ func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string)
 	return variable.Value, nil
 }
 
+// GetFileContent gets the raw content of a file at the given ref
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	fileContent, _, _, err := c.client.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{
+		Ref: ref,
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+		}
+		return nil, err
+	}
+	if fileContent == nil {
+		// 路径是一个目录
+		return nil, fmt.Errorf("%s@%s is not a file: %w", path, ref, models.ErrNotFound)
+	}
+	
+	// 超过 1MB 的文件不随内容接口返回，改为读取 blob
+	if fileContent.GetEncoding() == "none" {
+		content, _, err := c.client.Git.GetBlobRaw(ctx, owner, repo, fileContent.GetSHA())
+		if err != nil {
+			return nil, fmt.Errorf("failed to get blob of %s: %w", path, err)
+		}
+		return content, nil
+	}
+	
+	content, err := fileContent.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	
+	return []byte(content), nil
+}
+
 // IsNotFound checks if an error is a 404 Not Found error
 func IsNotFound(err error) bool {
 	if err == nil {
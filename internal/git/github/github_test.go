@@ -0,0 +1,214 @@
+package github
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
+)
+
+// compareStandIn serves a paginated comparison whose large file has no patch
+type compareStandIn struct {
+	t *testing.T
+}
+
+func (s *compareStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	const repoPath = "/api/v3/repos/octo/service"
+	page := r.URL.Query().Get("page")
+
+	switch r.URL.Path {
+	case repoPath + "/compare/base...head":
+		if r.URL.Query().Get("per_page") != "100" {
+			s.t.Errorf("compare per_page = %q, want 100", r.URL.Query().Get("per_page"))
+		}
+		if page == "" {
+			s.next(w, r, 2)
+			_, _ = w.Write([]byte(`{
+				"merge_base_commit": {"sha": "mergebase"},
+				"commits": [{"sha": "c1"}, {"sha": "c2"}],
+				"files": [
+					{"filename": "small.go", "status": "modified", "additions": 1, "deletions": 1, "changes": 2,
+					 "patch": "@@ -1 +1 @@\n-old\n+new", "contents_url": "https://api.github.com/repos/octo/service/contents/small.go?ref=head"},
+					{"filename": "big.go", "status": "modified", "sha": "newblob", "additions": 1, "deletions": 1, "changes": 2,
+					 "contents_url": "https://api.github.com/repos/octo/service/contents/big.go?ref=head"},
+					{"filename": "logo.png", "status": "added", "changes": 0,
+					 "contents_url": "https://api.github.com/repos/octo/service/contents/logo.png?ref=head"}
+				]
+			}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"merge_base_commit": {"sha": "mergebase"}, "commits": [{"sha": "c3"}]}`))
+	case repoPath + "/pulls/5/files":
+		if page == "" {
+			s.next(w, r, 2)
+			_, _ = w.Write([]byte(`[{"filename": "small.go", "status": "modified", "changes": 2, "patch": "@@ -1 +1 @@\n-old\n+new"}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"filename": "big.go", "status": "modified", "sha": "newblob", "changes": 2}]`))
+	case repoPath + "/contents/big.go":
+		if ref := r.URL.Query().Get("ref"); ref != "mergebase" {
+			s.t.Errorf("old content ref = %q, want the merge base", ref)
+		}
+		// 超过 1MB 的文件没有内容
+		_, _ = w.Write([]byte(`{"type": "file", "name": "big.go", "path": "big.go", "sha": "oldblob", "size": 2000000, "encoding": "none", "content": ""}`))
+	case repoPath + "/git/blobs/oldblob":
+		_, _ = w.Write([]byte("package big\n\nvar x = 1\n"))
+	case repoPath + "/git/blobs/newblob":
+		_, _ = w.Write([]byte("package big\n\nvar x = 2\n"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// next links the response to the given page of the same request
+func (s *compareStandIn) next(w http.ResponseWriter, r *http.Request, page int) {
+	query := r.URL.Query()
+	query.Set("page", fmt.Sprint(page))
+	w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, query.Encode()))
+}
+
+func newCompareTestClient(t *testing.T) *Client {
+	t.Helper()
+	server := httptest.NewServer(&compareStandIn{t: t})
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestCompareCommitsPaginatesAndDiffsBlobs(t *testing.T) {
+	client := newCompareTestClient(t)
+
+	files, commits, err := client.CompareCommits(context.Background(), "octo", "service", "base", "head")
+	if err != nil {
+		t.Fatalf("CompareCommits() error = %v", err)
+	}
+
+	var shas []string
+	for _, commit := range commits {
+		shas = append(shas, commit.SHA)
+	}
+	if strings.Join(shas, ",") != "c1,c2,c3" {
+		t.Errorf("commits = %v, want c1,c2,c3", shas)
+	}
+
+	if len(files) != 3 {
+		t.Fatalf("got %d files, want 3", len(files))
+	}
+	big := files[1].Diff
+	if big.IsBinary || len(big.Hunks) != 1 || !strings.Contains(big.Patch, "-var x = 1") || !strings.Contains(big.Patch, "+var x = 2") {
+		t.Errorf("big.go diff = %+v, want a patch rebuilt from the blobs", big)
+	}
+	if big.Additions != 1 || big.Deletions != 1 {
+		t.Errorf("big.go additions/deletions = %d/%d, want 1/1", big.Additions, big.Deletions)
+	}
+	if !files[2].Diff.IsBinary {
+		t.Errorf("logo.png is not binary")
+	}
+}
+
+func TestComparePullRequestListsFiles(t *testing.T) {
+	client := newCompareTestClient(t)
+
+	files, commits, err := client.ComparePullRequest(context.Background(), "octo", "service", 5, "base", "head")
+	if err != nil {
+		t.Fatalf("ComparePullRequest() error = %v", err)
+	}
+	if len(commits) != 3 {
+		t.Errorf("got %d commits, want 3", len(commits))
+	}
+	if len(files) != 2 || files[0].Filename != "small.go" || files[1].Filename != "big.go" {
+		t.Fatalf("files = %v, want small.go and big.go", files)
+	}
+	if files[1].Diff.Status != diff.StatusModified || !strings.Contains(files[1].Patch, "+var x = 2") {
+		t.Errorf("big.go patch = %q", files[1].Patch)
+	}
+}
+
+func TestPRComments(t *testing.T) {
+	var edited string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"login": "review-bot"}`))
+	})
+	mux.HandleFunc("/api/v3/repos/octo/service/issues/5/comments", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "" {
+			query := r.URL.Query()
+			query.Set("page", "2")
+			w.Header().Set("Link", fmt.Sprintf(`<http://%s%s?%s>; rel="next"`, r.Host, r.URL.Path, query.Encode()))
+			_, _ = w.Write([]byte(`[{"id": 1, "body": "first", "user": {"login": "octocat"}}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": 2, "body": "second", "user": {"login": "review-bot"}}]`))
+	})
+	mux.HandleFunc("/api/v3/repos/octo/service/issues/comments/2", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			t.Errorf("method = %s, want PATCH", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		edited = string(body)
+		_, _ = w.Write([]byte(`{"id": 2}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	comments, err := client.ListPRComments(context.Background(), "octo", "service", 5)
+	if err != nil {
+		t.Fatalf("ListPRComments() error = %v", err)
+	}
+	if len(comments) != 2 || comments[1].ID != 2 || comments[1].Body != "second" {
+		t.Fatalf("comments = %+v, want both pages", comments)
+	}
+	if comments[0].Mine || !comments[1].Mine {
+		t.Errorf("comments = %+v, want only the second written by the bot", comments)
+	}
+
+	if err := client.UpdatePRComment(context.Background(), "octo", "service", 5, 2, "updated"); err != nil {
+		t.Fatalf("UpdatePRComment() error = %v", err)
+	}
+	if !strings.Contains(edited, `"body":"updated"`) {
+		t.Errorf("edit request = %s, want the new body", edited)
+	}
+}
+
+func TestIsCollaborator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/repos/octo/service/collaborators/maintainer/permission":
+			_, _ = w.Write([]byte(`{"permission": "write", "role_name": "maintain"}`))
+		case "/api/v3/repos/octo/service/collaborators/reader/permission":
+			_, _ = w.Write([]byte(`{"permission": "read", "role_name": "triage"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	for user, want := range map[string]bool{"maintainer": true, "reader": false, "stranger": false} {
+		got, err := client.IsCollaborator(context.Background(), "octo", "service", user)
+		if err != nil {
+			t.Fatalf("IsCollaborator(%s) error = %v", user, err)
+		}
+		if got != want {
+			t.Errorf("IsCollaborator(%s) = %v, want %v", user, got, want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package github
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// Review threads are only available through the GraphQL API
+
+const reviewThreadsQuery = `query($owner: String!, $repo: String!, $number: Int!, $cursor: String) {
+  repository(owner: $owner, name: $repo) {
+    pullRequest(number: $number) {
+      reviewThreads(first: 100, after: $cursor) {
+        pageInfo { hasNextPage endCursor }
+        nodes {
+          id
+          isResolved
+          path
+          diffSide
+          originalStartLine
+          originalLine
+          comments(first: 1) { nodes { body originalCommit { oid } } }
+        }
+      }
+    }
+  }
+}`
+
+const resolveReviewThreadMutation = `mutation($id: ID!) {
+  resolveReviewThread(input: {threadId: $id}) { thread { id } }
+}`
+
+type graphQLRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+type graphQLError struct {
+	Message string `json:"message"`
+}
+
+// ListReviewThreads lists the review comment threads of a pull request
+func (c *Client) ListReviewThreads(ctx context.Context, owner, repo string, number int) ([]*models.ReviewThread, error) {
+	var threads []*models.ReviewThread
+	variables := map[string]interface{}{"owner": owner, "repo": repo, "number": number}
+	for {
+		var data struct {
+			Repository struct {
+				PullRequest struct {
+					ReviewThreads struct {
+						PageInfo struct {
+							HasNextPage bool   `json:"hasNextPage"`
+							EndCursor   string `json:"endCursor"`
+						} `json:"pageInfo"`
+						Nodes []struct {
+							ID                string `json:"id"`
+							IsResolved        bool   `json:"isResolved"`
+							Path              string `json:"path"`
+							DiffSide          string `json:"diffSide"`
+							OriginalStartLine int    `json:"originalStartLine"`
+							OriginalLine      int    `json:"originalLine"`
+							Comments          struct {
+								Nodes []struct {
+									Body           string `json:"body"`
+									OriginalCommit struct {
+										OID string `json:"oid"`
+									} `json:"originalCommit"`
+								} `json:"nodes"`
+							} `json:"comments"`
+						} `json:"nodes"`
+					} `json:"reviewThreads"`
+				} `json:"pullRequest"`
+			} `json:"repository"`
+		}
+		if err := c.graphQL(ctx, reviewThreadsQuery, variables, &data); err != nil {
+			return nil, fmt.Errorf("failed to list review threads of PR #%d: %w", number, err)
+		}
+
+		page := data.Repository.PullRequest.ReviewThreads
+		for _, node := range page.Nodes {
+			// original* 是线程在发布时所在提交中的位置
+			thread := &models.ReviewThread{
+				ID:        node.ID,
+				Path:      node.Path,
+				Resolved:  node.IsResolved,
+				StartLine: node.OriginalStartLine,
+				Line:      node.OriginalLine,
+				Side:      node.DiffSide,
+			}
+			if len(node.Comments.Nodes) > 0 {
+				thread.Body = node.Comments.Nodes[0].Body
+				thread.CommitID = node.Comments.Nodes[0].OriginalCommit.OID
+			}
+			threads = append(threads, thread)
+		}
+		if !page.PageInfo.HasNextPage {
+			return threads, nil
+		}
+		variables["cursor"] = page.PageInfo.EndCursor
+	}
+}
+
+// ResolveReviewThread resolves a review comment thread
+func (c *Client) ResolveReviewThread(ctx context.Context, owner, repo string, number int, id string) error {
+	if err := c.graphQL(ctx, resolveReviewThreadMutation, map[string]interface{}{"id": id}, nil); err != nil {
+		return fmt.Errorf("failed to resolve review thread %s: %w", id, err)
+	}
+	return nil
+}
+
+// graphQL runs a GraphQL query and decodes its data into out if it is not
+// nil
+func (c *Client) graphQL(ctx context.Context, query string, variables map[string]interface{}, out interface{}) error {
+	req, err := c.client.NewRequest(http.MethodPost, c.graphQLURL(), &graphQLRequest{Query: query, Variables: variables})
+	if err != nil {
+		return err
+	}
+
+	var resp struct {
+		Data   interface{}    `json:"data"`
+		Errors []graphQLError `json:"errors"`
+	}
+	resp.Data = out
+	if _, err := c.client.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	// GraphQL 错误随 200 响应返回
+	if len(resp.Errors) > 0 {
+		messages := make([]string, 0, len(resp.Errors))
+		for _, e := range resp.Errors {
+			messages = append(messages, e.Message)
+		}
+		return errors.New(strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// graphQLURL returns the GraphQL endpoint: /graphql on github.com and
+// /api/graphql on GitHub Enterprise Server, whose REST API is at /api/v3
+func (c *Client) graphQLURL() string {
+	u := *c.client.BaseURL
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/v3") + "/graphql"
+	return u.String()
+}
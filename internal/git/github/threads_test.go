@@ -0,0 +1,86 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+)
+
+func TestReviewThreads(t *testing.T) {
+	var resolved []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/graphql" {
+			http.NotFound(w, r)
+			return
+		}
+		var req graphQLRequest
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &req)
+
+		switch {
+		case strings.HasPrefix(req.Query, "mutation"):
+			resolved = append(resolved, req.Variables["id"].(string))
+			_, _ = w.Write([]byte(`{"data": {"resolveReviewThread": {"thread": {"id": "T1"}}}}`))
+		case req.Variables["cursor"] == nil:
+			_, _ = w.Write([]byte(`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+				"pageInfo": {"hasNextPage": true, "endCursor": "c1"},
+				"nodes": [{"id": "T1", "isResolved": false, "path": "main.go", "diffSide": "RIGHT", "originalStartLine": 3, "originalLine": 5,
+					"comments": {"nodes": [{"body": "first", "originalCommit": {"oid": "abc123"}}]}}]
+			}}}}}`))
+		case req.Variables["cursor"] == "c1":
+			_, _ = w.Write([]byte(`{"data": {"repository": {"pullRequest": {"reviewThreads": {
+				"pageInfo": {"hasNextPage": false},
+				"nodes": [{"id": "T2", "isResolved": true, "path": "util.go", "comments": {"nodes": [{"body": "second"}]}}]
+			}}}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"errors": [{"message": "unexpected cursor"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+
+	threads, err := client.ListReviewThreads(context.Background(), "octo", "service", 5)
+	if err != nil {
+		t.Fatalf("ListReviewThreads() error = %v", err)
+	}
+	if len(threads) != 2 || threads[0].ID != "T1" || threads[0].Body != "first" || threads[0].Resolved ||
+		threads[1].Path != "util.go" || !threads[1].Resolved {
+		t.Fatalf("threads = %+v, want T1 and T2", threads)
+	}
+	if first := threads[0]; first.CommitID != "abc123" || first.StartLine != 3 || first.Line != 5 || first.Side != "RIGHT" {
+		t.Errorf("anchor of T1 = %s %d-%d %s, want abc123 3-5 RIGHT", first.CommitID, first.StartLine, first.Line, first.Side)
+	}
+
+	if err := client.ResolveReviewThread(context.Background(), "octo", "service", 5, "T1"); err != nil {
+		t.Fatalf("ResolveReviewThread() error = %v", err)
+	}
+	if len(resolved) != 1 || resolved[0] != "T1" {
+		t.Errorf("resolved threads = %v, want T1", resolved)
+	}
+}
+
+func TestGraphQLErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"errors": [{"message": "Resource not accessible by integration"}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&config.Config{Platform: "github", GithubToken: "token", GithubBaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	err = client.ResolveReviewThread(context.Background(), "octo", "service", 5, "T1")
+	if err == nil || !strings.Contains(err.Error(), "Resource not accessible by integration") {
+		t.Errorf("ResolveReviewThread() error = %v, want the GraphQL error", err)
+	}
+}
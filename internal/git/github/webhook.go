@@ -57,6 +57,12 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		parsedPayload = &github.PullRequestEvent{}
 	case "push":
 		parsedPayload = &github.PushEvent{}
+	case "check_run":
+		parsedPayload = &github.CheckRunEvent{}
+	case "issue_comment":
+		parsedPayload = &github.IssueCommentEvent{}
+	case "pull_request_review_comment":
+		parsedPayload = &github.PullRequestReviewCommentEvent{}
 	case "ping":
 		w.WriteHeader(http.StatusOK)
 		return
@@ -2,13 +2,16 @@ package gitlab
 
 import (
 	"context"
+	"crypto/sha1"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
 	"github.com/eust-w/ai_code_reviewer/internal/models"
 	"github.com/sirupsen/logrus"
 	"github.com/xanzy/go-gitlab"
@@ -28,6 +31,10 @@ func Int(v int) *int {
 type Client struct {
 	client *gitlab.Client
 	config *config.Config
+
+	mu sync.Mutex
+	// userID is the ID of the authenticated user, once looked up
+	userID int
 }
 
 // NewClient creates a new GitLab client
@@ -137,31 +144,39 @@ func (c *Client) CompareCommits(ctx context.Context, owner, repo, base, head str
 	
 	// Convert diffs to our format
 	files := make([]*models.CommitFile, len(comparison.Diffs))
-	for i, diff := range comparison.Diffs {
-		status := "modified"
-		if diff.NewFile {
-			status = "added"
-		} else if diff.DeletedFile {
-			status = "deleted"
-		} else if diff.RenamedFile {
-			status = "renamed"
-		}
-		
-		files[i] = &models.CommitFile{
-			Filename:    diff.NewPath,
-			Status:      status,
-			Patch:       diff.Diff,
-			ContentsURL: fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s", 
-				c.config.GitlabBaseURL, 
-				projectPath, 
-				strings.ReplaceAll(diff.NewPath, "/", "%2F"),
-				head),
-		}
+	for i, d := range comparison.Diffs {
+		contentsURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s", 
+			c.config.GitlabBaseURL, 
+			projectPath, 
+			strings.ReplaceAll(d.NewPath, "/", "%2F"),
+			head)
+		files[i] = models.NewCommitFile(gitlabFileDiff(d), contentsURL)
 	}
 	
 	return files, commits, nil
 }
 
+// gitlabFileDiff converts a diff of the compare API to a file diff
+func gitlabFileDiff(d *gitlab.Diff) *models.FileDiff {
+	status := diff.StatusModified
+	switch {
+	case d.NewFile:
+		status = diff.StatusAdded
+	case d.DeletedFile:
+		status = diff.StatusRemoved
+	case d.RenamedFile:
+		status = diff.StatusRenamed
+	}
+
+	fd, err := diff.NewFileDiff(d.OldPath, d.NewPath, status, d.Diff)
+	if err != nil {
+		logrus.Warnf("Failed to parse diff of %s: %v", d.NewPath, err)
+		fd, _ = diff.NewFileDiff(d.OldPath, d.NewPath, status, "")
+		fd.Patch = d.Diff
+	}
+	return fd
+}
+
 // CreateReview creates a review on a merge request
 func (c *Client) CreateReview(ctx context.Context, owner, repo string, number int, commitID string, comments []*models.ReviewComment, body string) error {
 	projectPath := fmt.Sprintf("%s/%s", owner, repo)
@@ -170,38 +185,51 @@ func (c *Client) CreateReview(ctx context.Context, owner, repo string, number in
 	if body != "" {
 		_, _, err := c.client.Notes.CreateMergeRequestNote(projectPath, number, &gitlab.CreateMergeRequestNoteOptions{
 			Body: &body,
-		})
+		}, gitlab.WithContext(ctx))
 		if err != nil {
 			return err
 		}
 	}
 	
+	if len(comments) == 0 {
+		return nil
+	}
+	
+	// 讨论的位置必须基于合并请求自身的 diff_refs 和 diff
+	positioner, err := c.newDiffPositioner(ctx, projectPath, number)
+	if err != nil {
+		logrus.Warnf("Failed to load the diff of merge request !%d: %v, posting comments as notes", number, err)
+	} else if positioner.refs.HeadSha != commitID {
+		logrus.Debugf("Merge request !%d head is %s, reviewed commit is %s", number, positioner.refs.HeadSha, commitID)
+	}
+	
 	// Then create individual file comments
 	for _, comment := range comments {
-		// GitLab requires line numbers instead of positions
-		// We'll use a simplified approach here
-		lineNum := 1 // Default to line 1 if we can't determine
-		
-		// Create the comment
-		commentBody := comment.Body
-		
-		// 创建讨论
-		_, _, err := c.client.Discussions.CreateMergeRequestDiscussion(
-			projectPath, 
-			number, 
-			&gitlab.CreateMergeRequestDiscussionOptions{
-				Body: &commentBody,
-				Position: &gitlab.PositionOptions{
-					BaseSHA:      String("base"),  // 使用占位符
-					StartSHA:     String("start"), // 使用占位符
-					HeadSHA:      String(commitID),
-					NewPath:      String(comment.Path),
-					NewLine:      Int(lineNum),
-				},
-			},
-		)
+		if positioner != nil {
+			position, err := positioner.position(comment)
+			if err == nil {
+				commentBody := comment.Body
+				_, _, err = c.client.Discussions.CreateMergeRequestDiscussion(
+					projectPath, 
+					number, 
+					&gitlab.CreateMergeRequestDiscussionOptions{
+						Body:     &commentBody,
+						Position: position,
+					},
+					gitlab.WithContext(ctx),
+				)
+				if err == nil {
+					continue
+				}
+			}
+			logrus.Warnf("Failed to position comment on %s:%d: %v, posting it as a note", comment.Path, comment.Line, err)
+		}
 		
-		if err != nil {
+		// 无法定位时退回到普通评论，不丢失审查意见
+		noteBody := formatUnpositionedComment(comment)
+		if _, _, err := c.client.Notes.CreateMergeRequestNote(projectPath, number, &gitlab.CreateMergeRequestNoteOptions{
+			Body: &noteBody,
+		}, gitlab.WithContext(ctx)); err != nil {
 			logrus.Errorf("Failed to create comment on file %s: %v", comment.Path, err)
 			// Continue with other comments
 		}
@@ -210,6 +238,255 @@ func (c *Client) CreateReview(ctx context.Context, owner, repo string, number in
 	return nil
 }
 
+// ListReviewThreads lists the resolvable discussions on the diff of a merge
+// request
+func (c *Client) ListReviewThreads(ctx context.Context, owner, repo string, number int) ([]*models.ReviewThread, error) {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	opts := &gitlab.ListMergeRequestDiscussionsOptions{PerPage: 100}
+	var threads []*models.ReviewThread
+	for {
+		discussions, resp, err := c.client.Discussions.ListMergeRequestDiscussions(projectPath, number, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list discussions of merge request !%d: %w", number, err)
+		}
+		for _, discussion := range discussions {
+			if len(discussion.Notes) == 0 {
+				continue
+			}
+			note := discussion.Notes[0]
+			if !note.Resolvable || note.Position == nil {
+				continue
+			}
+			threads = append(threads, reviewThread(discussion.ID, note))
+		}
+		if resp.NextPage == 0 {
+			return threads, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// reviewThread converts the first note of a discussion on the diff to a
+// review thread anchored to the lines of the note
+func reviewThread(id string, note *gitlab.Note) *models.ReviewThread {
+	position := note.Position
+	thread := &models.ReviewThread{
+		ID:       id,
+		Path:     position.NewPath,
+		Body:     note.Body,
+		Resolved: note.Resolved,
+		CommitID: position.HeadSHA,
+		Line:     position.NewLine,
+		Side:     "RIGHT",
+	}
+	// 只有旧行号的评论位于被删除的行上
+	if position.NewLine == 0 {
+		thread.Path, thread.Line, thread.Side = position.OldPath, position.OldLine, "LEFT"
+	}
+	if thread.Path == "" {
+		thread.Path = position.OldPath
+	}
+	if position.LineRange != nil && position.LineRange.StartRange != nil {
+		thread.StartLine = position.LineRange.StartRange.NewLine
+		if thread.Side == "LEFT" {
+			thread.StartLine = position.LineRange.StartRange.OldLine
+		}
+	}
+	return thread
+}
+
+// ResolveReviewThread resolves a merge request discussion
+func (c *Client) ResolveReviewThread(ctx context.Context, owner, repo string, number int, id string) error {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	_, _, err := c.client.Discussions.ResolveMergeRequestDiscussion(projectPath, number, id, &gitlab.ResolveMergeRequestDiscussionOptions{
+		Resolved: gitlab.Ptr(true),
+	}, gitlab.WithContext(ctx))
+	return err
+}
+
+// diffRefs are the commits a merge request diff is based on
+type diffRefs struct {
+	BaseSha  string
+	HeadSha  string
+	StartSha string
+}
+
+// diffPositioner maps review comments onto the diff of a merge request
+type diffPositioner struct {
+	refs diffRefs
+	// files 按新路径和旧路径索引
+	files map[string]*models.FileDiff
+}
+
+// newDiffPositioner loads the diff_refs and the file diffs of a merge request
+func (c *Client) newDiffPositioner(ctx context.Context, projectPath string, number int) (*diffPositioner, error) {
+	mr, _, err := c.client.MergeRequests.GetMergeRequest(projectPath, number, nil, gitlab.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get merge request: %w", err)
+	}
+	if mr.DiffRefs.BaseSha == "" || mr.DiffRefs.HeadSha == "" {
+		return nil, errors.New("merge request has no diff_refs")
+	}
+
+	diffs, err := c.listMergeRequestDiffs(ctx, projectPath, number)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &diffPositioner{
+		refs: diffRefs{
+			BaseSha:  mr.DiffRefs.BaseSha,
+			HeadSha:  mr.DiffRefs.HeadSha,
+			StartSha: mr.DiffRefs.StartSha,
+		},
+		files: make(map[string]*models.FileDiff, len(diffs)),
+	}
+	for _, d := range diffs {
+		fd := gitlabFileDiff(&gitlab.Diff{
+			Diff:        d.Diff,
+			NewPath:     d.NewPath,
+			OldPath:     d.OldPath,
+			NewFile:     d.NewFile,
+			RenamedFile: d.RenamedFile,
+			DeletedFile: d.DeletedFile,
+		})
+		if fd.OldPath != "" {
+			p.files[fd.OldPath] = fd
+		}
+		if fd.NewPath != "" {
+			p.files[fd.NewPath] = fd
+		}
+	}
+	return p, nil
+}
+
+// listMergeRequestDiffs returns all file diffs of a merge request. GitLab
+// versions before 15.7 only have the deprecated changes endpoint.
+func (c *Client) listMergeRequestDiffs(ctx context.Context, projectPath string, number int) ([]*gitlab.MergeRequestDiff, error) {
+	opts := &gitlab.ListMergeRequestDiffsOptions{ListOptions: gitlab.ListOptions{PerPage: 100}}
+	all := make([]*gitlab.MergeRequestDiff, 0)
+	for {
+		diffs, resp, err := c.client.MergeRequests.ListMergeRequestDiffs(projectPath, number, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			if IsNotFound(err) && len(all) == 0 {
+				mr, _, err := c.client.MergeRequests.GetMergeRequestChanges(projectPath, number, nil, gitlab.WithContext(ctx))
+				if err != nil {
+					return nil, fmt.Errorf("failed to get merge request changes: %w", err)
+				}
+				return mr.Changes, nil
+			}
+			return nil, fmt.Errorf("failed to list merge request diffs: %w", err)
+		}
+		all = append(all, diffs...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// position returns the discussion position of a comment. Added lines are
+// positioned by new_line, deleted lines by old_line and context lines by both.
+func (p *diffPositioner) position(comment *models.ReviewComment) (*gitlab.PositionOptions, error) {
+	fd, ok := p.files[comment.Path]
+	if !ok {
+		return nil, errors.New("file is not part of the merge request diff")
+	}
+
+	end, endHunk, err := findCommentLine(fd, comment.Line, comment.Side)
+	if err != nil {
+		return nil, err
+	}
+
+	oldPath, newPath := fd.OldPath, fd.NewPath
+	if oldPath == "" {
+		oldPath = newPath
+	}
+	if newPath == "" {
+		newPath = oldPath
+	}
+
+	position := &gitlab.PositionOptions{
+		BaseSHA:      String(p.refs.BaseSha),
+		StartSHA:     String(p.refs.StartSha),
+		HeadSHA:      String(p.refs.HeadSha),
+		PositionType: String("text"),
+		NewPath:      String(newPath),
+		OldPath:      String(oldPath),
+	}
+	switch end.Type {
+	case diff.LineAdded:
+		position.NewLine = Int(end.NewLine)
+	case diff.LineDeleted:
+		position.OldLine = Int(end.OldLine)
+	default:
+		position.OldLine = Int(end.OldLine)
+		position.NewLine = Int(end.NewLine)
+	}
+
+	// 多行评论使用 line_range，起始行无法定位时退回到单行
+	if comment.StartLine > 0 && comment.StartLine < comment.Line {
+		side := comment.StartSide
+		if side == "" {
+			side = comment.Side
+		}
+		if start, startHunk, err := findCommentLine(fd, comment.StartLine, side); err == nil {
+			position.LineRange = &gitlab.LineRangeOptions{
+				Start: linePosition(newPath, startHunk, start),
+				End:   linePosition(newPath, endHunk, end),
+			}
+		}
+	}
+
+	return position, nil
+}
+
+// findCommentLine looks up the diff line of a comment line number
+func findCommentLine(fd *models.FileDiff, number int, side string) (*diff.Line, *diff.Hunk, error) {
+	diffSide := diff.SideRight
+	if side == string(diff.SideLeft) {
+		diffSide = diff.SideLeft
+	}
+	line, hunk := diff.FindLine(fd.Hunks, number, diffSide)
+	if line == nil {
+		return nil, nil, fmt.Errorf("line %d (%s) is not part of the diff", number, diffSide)
+	}
+	return line, hunk, nil
+}
+
+// linePosition builds a line_range end point. The line code is the SHA-1 of
+// the file path followed by the old and new line counters at the line.
+func linePosition(path string, hunk *diff.Hunk, line *diff.Line) *gitlab.LinePositionOptions {
+	lineType := "new"
+	if line.Type == diff.LineDeleted {
+		lineType = "old"
+	}
+	oldPos, newPos := hunk.LineCounters()
+	index := 0
+	for i, l := range hunk.Lines {
+		if l == line {
+			index = i
+			break
+		}
+	}
+	code := fmt.Sprintf("%x_%d_%d", sha1.Sum([]byte(path)), oldPos[index], newPos[index])
+	return &gitlab.LinePositionOptions{
+		LineCode: String(code),
+		Type:     String(lineType),
+	}
+}
+
+// formatUnpositionedComment renders a comment that could not be attached to
+// the diff as a general note
+func formatUnpositionedComment(comment *models.ReviewComment) string {
+	if comment.Line > 0 {
+		return fmt.Sprintf("**`%s` line %d**\n\n%s", comment.Path, comment.Line, comment.Body)
+	}
+	return fmt.Sprintf("**`%s`**\n\n%s", comment.Path, comment.Body)
+}
+
 // CreatePRComment creates a comment on a merge request
 func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error {
 	projectPath := fmt.Sprintf("%s/%s", owner, repo)
@@ -221,6 +498,124 @@ func (c *Client) CreatePRComment(ctx context.Context, owner, repo string, number
 	return err
 }
 
+// ListPRComments lists the notes of a merge request, without the system
+// notes GitLab creates for pushes and other events
+func (c *Client) ListPRComments(ctx context.Context, owner, repo string, number int) ([]*models.PRComment, error) {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	userID, err := c.currentUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	opts := &gitlab.ListMergeRequestNotesOptions{
+		ListOptions: gitlab.ListOptions{PerPage: 100},
+		OrderBy:     gitlab.Ptr("created_at"),
+		Sort:        gitlab.Ptr("asc"),
+	}
+	var comments []*models.PRComment
+	for {
+		notes, resp, err := c.client.Notes.ListMergeRequestNotes(projectPath, number, opts, gitlab.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list notes of merge request !%d: %w", number, err)
+		}
+		for _, note := range notes {
+			if note.System {
+				continue
+			}
+			comments = append(comments, &models.PRComment{
+				ID:   int64(note.ID),
+				Body: note.Body,
+				Mine: note.Author.ID == userID,
+			})
+		}
+		if resp.NextPage == 0 {
+			return comments, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// currentUserID returns the ID of the user the token belongs to
+func (c *Client) currentUserID(ctx context.Context) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.userID != 0 {
+		return c.userID, nil
+	}
+	
+	user, _, err := c.client.Users.CurrentUser(gitlab.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the authenticated user: %w", err)
+	}
+	c.userID = user.ID
+	return c.userID, nil
+}
+
+// UpdatePRComment replaces the body of a merge request note
+func (c *Client) UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	_, _, err := c.client.Notes.UpdateMergeRequestNote(projectPath, number, int(id), &gitlab.UpdateMergeRequestNoteOptions{
+		Body: &body,
+	}, gitlab.WithContext(ctx))
+	return err
+}
+
+// SetCommitStatus sets the review status of a commit as an external
+// pipeline status, which merge request approval rules can depend on
+func (c *Client) SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	opts := &gitlab.SetCommitStatusOptions{
+		State:       commitStatusState(state),
+		Name:        gitlab.Ptr(models.StatusContext),
+		Description: gitlab.Ptr(description),
+	}
+	if targetURL != "" {
+		opts.TargetURL = gitlab.Ptr(targetURL)
+	}
+	_, _, err := c.client.Commits.SetCommitStatus(projectPath, sha, opts, gitlab.WithContext(ctx))
+	return err
+}
+
+// commitStatusState maps a review status to a GitLab commit status state.
+// GitLab has no error state, so errors are reported as failed.
+func commitStatusState(state string) gitlab.BuildStateValue {
+	switch state {
+	case models.StatusPending:
+		// 审查正在进行，显示为运行中
+		return gitlab.Running
+	case models.StatusSuccess:
+		return gitlab.Success
+	default:
+		return gitlab.Failed
+	}
+}
+
+// IsCollaborator reports whether a user is a member of a project, directly
+// or through its groups, with at least developer access
+func (c *Client) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error) {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	users, _, err := c.client.Users.ListUsers(&gitlab.ListUsersOptions{Username: &user}, gitlab.WithContext(ctx))
+	if err != nil {
+		return false, fmt.Errorf("failed to look up user %s: %w", user, err)
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+	
+	member, _, err := c.client.ProjectMembers.GetInheritedProjectMember(projectPath, users[0].ID, gitlab.WithContext(ctx))
+	if err != nil {
+		if IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get membership of %s in %s: %w", user, projectPath, err)
+	}
+	return member.AccessLevel >= gitlab.DeveloperPermissions, nil
+}
+
 // GetRepoVariable gets a repository variable
 func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error) {
 	projectPath := fmt.Sprintf("%s/%s", owner, repo)
@@ -240,6 +635,23 @@ func (c *Client) GetRepoVariable(ctx context.Context, owner, repo, name string)
 	return variable.Value, nil
 }
 
+// GetFileContent gets the raw content of a file at the given ref
+func (c *Client) GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error) {
+	projectPath := fmt.Sprintf("%s/%s", owner, repo)
+	
+	content, _, err := c.client.RepositoryFiles.GetRawFile(projectPath, path, &gitlab.GetRawFileOptions{
+		Ref: &ref,
+	}, gitlab.WithContext(ctx))
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, fmt.Errorf("%s@%s: %w", path, ref, models.ErrNotFound)
+		}
+		return nil, err
+	}
+	
+	return content, nil
+}
+
 // IsNotFound checks if an error is a 404 Not Found error
 func IsNotFound(err error) bool {
 	if err == nil {
@@ -0,0 +1,332 @@
+package gitlab
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/config"
+	"github.com/eust-w/ai_code_reviewer/internal/models"
+)
+
+// gitlabStandIn replays recorded GitLab API responses and records the
+// discussions and notes created by the client
+type gitlabStandIn struct {
+	mu          sync.Mutex
+	discussions []map[string]interface{}
+	notes       []string
+	statuses    []map[string]interface{}
+	// updated maps the IDs of updated notes to their new body
+	updated map[string]string
+	// resolved lists the IDs of resolved discussions
+	resolved []string
+	// rejectBody makes discussion creation fail for comments with this body
+	rejectBody string
+}
+
+func (s *gitlabStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+	const mrPath = "/api/v4/projects/group%2Fproject/merge_requests/7"
+
+	switch {
+	case r.Method == http.MethodGet && path == mrPath:
+		serveFixture(w, "testdata/merge_request.json")
+	case r.Method == http.MethodGet && path == mrPath+"/diffs":
+		serveFixture(w, "testdata/merge_request_diffs.json")
+	case r.Method == http.MethodGet && path == mrPath+"/discussions":
+		_, _ = w.Write([]byte(`[
+			{"id": "d1", "notes": [{"body": "inline", "resolvable": true, "resolved": false,
+			  "position": {"head_sha": "abc123", "new_path": "main.go", "new_line": 3,
+			    "line_range": {"start": {"new_line": 2}, "end": {"new_line": 3}}}}]},
+			{"id": "d2", "notes": [{"body": "question", "resolvable": true}]},
+			{"id": "d3", "notes": [{"body": "deleted line", "resolvable": true, "resolved": true,
+			  "position": {"old_path": "old.go", "old_line": 7}}]},
+			{"id": "d4", "individual_note": true, "notes": [{"body": "added 1 commit", "system": true}]}
+		]`))
+	case r.Method == http.MethodPut && strings.HasPrefix(path, mrPath+"/discussions/"):
+		if r.URL.Query().Get("resolved") != "true" {
+			data, _ := io.ReadAll(r.Body)
+			if !strings.Contains(string(data), `"resolved":true`) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		s.mu.Lock()
+		s.resolved = append(s.resolved, strings.TrimPrefix(path, mrPath+"/discussions/"))
+		s.mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":"d1"}`))
+	case r.Method == http.MethodPost && path == mrPath+"/discussions":
+		var body map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		if body["body"] == s.rejectBody {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"message":"400 Bad request - Note {:line_code=>[\"can't be blank\"]}"}`))
+			return
+		}
+		s.mu.Lock()
+		s.discussions = append(s.discussions, body)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":"6a9c1750b37d513a43987b574953fceb50b03ce7"}`))
+	case r.Method == http.MethodPost && path == mrPath+"/notes":
+		var body struct {
+			Body string `json:"body"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		s.mu.Lock()
+		s.notes = append(s.notes, body.Body)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":302}`))
+	case r.Method == http.MethodPost && path == "/api/v4/projects/group%2Fproject/statuses/head":
+		var body map[string]interface{}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		s.mu.Lock()
+		s.statuses = append(s.statuses, body)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":93}`))
+	case r.Method == http.MethodGet && path == mrPath+"/notes":
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("X-Next-Page", "2")
+			_, _ = w.Write([]byte(`[{"id": 301, "body": "added 1 commit", "system": true}, {"id": 302, "body": "review", "author": {"id": 9}}]`))
+			return
+		}
+		_, _ = w.Write([]byte(`[{"id": 303, "body": "question", "author": {"id": 10}}]`))
+	case r.Method == http.MethodGet && path == "/api/v4/user":
+		_, _ = w.Write([]byte(`{"id": 9, "username": "review-bot"}`))
+	case r.Method == http.MethodPut && strings.HasPrefix(path, mrPath+"/notes/"):
+		var body struct {
+			Body string `json:"body"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		s.mu.Lock()
+		if s.updated == nil {
+			s.updated = make(map[string]string)
+		}
+		s.updated[strings.TrimPrefix(path, mrPath+"/notes/")] = body.Body
+		s.mu.Unlock()
+		_, _ = w.Write([]byte(`{"id":302}`))
+	case r.Method == http.MethodGet && path == "/api/v4/users":
+		switch r.URL.Query().Get("username") {
+		case "dev":
+			_, _ = w.Write([]byte(`[{"id": 11, "username": "dev"}]`))
+		case "guest":
+			_, _ = w.Write([]byte(`[{"id": 12, "username": "guest"}]`))
+		default:
+			_, _ = w.Write([]byte(`[]`))
+		}
+	case r.Method == http.MethodGet && path == "/api/v4/projects/group%2Fproject/members/all/11":
+		_, _ = w.Write([]byte(`{"id": 11, "username": "dev", "access_level": 30}`))
+	case r.Method == http.MethodGet && path == "/api/v4/projects/group%2Fproject/members/all/12":
+		_, _ = w.Write([]byte(`{"id": 12, "username": "guest", "access_level": 10}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func serveFixture(w http.ResponseWriter, name string) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := NewClient(&config.Config{
+		Platform:      "gitlab",
+		GitlabToken:   "test-token",
+		GitlabBaseURL: server.URL + "/api/v4",
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+func TestCreateReviewPositionsDiscussions(t *testing.T) {
+	standIn := &gitlabStandIn{rejectBody: "rejected by GitLab"}
+	client := newTestClient(t, standIn)
+
+	comments := []*models.ReviewComment{
+		{Path: "client.go", Line: 14, Side: "RIGHT", Body: "added line"},
+		{Path: "client.go", Line: 13, Side: "LEFT", Body: "deleted line"},
+		{Path: "client.go", Line: 17, Side: "RIGHT", Body: "context line"},
+		{Path: "internal/util.go", Line: 1, Side: "RIGHT", Body: "renamed file"},
+		{Path: "client.go", Line: 40, Side: "RIGHT", Body: "outside the diff"},
+		{Path: "client.go", Line: 15, Side: "RIGHT", Body: "rejected by GitLab"},
+	}
+	err := client.CreateReview(context.Background(), "group", "project", 7, "b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0", comments, "Summary")
+	if err != nil {
+		t.Fatalf("CreateReview() error = %v", err)
+	}
+
+	want := []map[string]interface{}{
+		{"new_path": "client.go", "old_path": "client.go", "new_line": float64(14)},
+		{"new_path": "client.go", "old_path": "client.go", "old_line": float64(13)},
+		{"new_path": "client.go", "old_path": "client.go", "old_line": float64(16), "new_line": float64(17)},
+		{"new_path": "internal/util.go", "old_path": "util.go", "new_line": float64(1)},
+	}
+	if len(standIn.discussions) != len(want) {
+		t.Fatalf("created %d discussions, want %d: %v", len(standIn.discussions), len(want), standIn.discussions)
+	}
+	for i, w := range want {
+		position, _ := standIn.discussions[i]["position"].(map[string]interface{})
+		if position["base_sha"] != "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567" ||
+			position["head_sha"] != "b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0" ||
+			position["start_sha"] != "0a1b2c3d4e5f60718293a4b5c6d7e8f901234567" ||
+			position["position_type"] != "text" {
+			t.Errorf("discussion %d does not use the diff_refs: %v", i, position)
+		}
+		for _, key := range []string{"new_path", "old_path", "new_line", "old_line"} {
+			if position[key] != w[key] {
+				t.Errorf("discussion %d %s = %v, want %v", i, key, position[key], w[key])
+			}
+		}
+	}
+
+	// 总结和两条无法定位的评论作为普通评论发布
+	if len(standIn.notes) != 3 || standIn.notes[0] != "Summary" {
+		t.Fatalf("notes = %q, want the summary and 2 fallback notes", standIn.notes)
+	}
+	if !strings.Contains(standIn.notes[1], "client.go") || !strings.Contains(standIn.notes[1], "outside the diff") {
+		t.Errorf("fallback note = %q", standIn.notes[1])
+	}
+	if !strings.Contains(standIn.notes[2], "rejected by GitLab") {
+		t.Errorf("fallback note = %q", standIn.notes[2])
+	}
+}
+
+func TestCreateReviewMultiLineRange(t *testing.T) {
+	standIn := &gitlabStandIn{}
+	client := newTestClient(t, standIn)
+
+	comments := []*models.ReviewComment{
+		{Path: "client.go", StartLine: 14, StartSide: "RIGHT", Line: 15, Side: "RIGHT", Body: "range"},
+	}
+	if err := client.CreateReview(context.Background(), "group", "project", 7, "", comments, ""); err != nil {
+		t.Fatalf("CreateReview() error = %v", err)
+	}
+
+	if len(standIn.discussions) != 1 {
+		t.Fatalf("created %d discussions, want 1", len(standIn.discussions))
+	}
+	position := standIn.discussions[0]["position"].(map[string]interface{})
+	lineRange, ok := position["line_range"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("position has no line_range: %v", position)
+	}
+	start := lineRange["start"].(map[string]interface{})
+	end := lineRange["end"].(map[string]interface{})
+	if !strings.HasSuffix(start["line_code"].(string), "_14_14") || !strings.HasSuffix(end["line_code"].(string), "_14_15") {
+		t.Errorf("line_range = %v", lineRange)
+	}
+}
+
+func TestSetCommitStatusMapsStates(t *testing.T) {
+	standIn := &gitlabStandIn{}
+	client := newTestClient(t, standIn)
+
+	states := map[string]string{
+		models.StatusPending: "running",
+		models.StatusSuccess: "success",
+		models.StatusFailure: "failed",
+		models.StatusError:   "failed",
+	}
+	for state, want := range states {
+		standIn.statuses = nil
+		if err := client.SetCommitStatus(context.Background(), "group", "project", "head", state, "AI review", "https://gitlab.example.com/group/project/-/merge_requests/7"); err != nil {
+			t.Fatalf("SetCommitStatus(%s) error = %v", state, err)
+		}
+		if len(standIn.statuses) != 1 {
+			t.Fatalf("SetCommitStatus(%s) sent %d statuses, want 1", state, len(standIn.statuses))
+		}
+		status := standIn.statuses[0]
+		if status["state"] != want || status["name"] != models.StatusContext {
+			t.Errorf("SetCommitStatus(%s) sent %v, want state %s", state, status, want)
+		}
+	}
+}
+
+func TestPRComments(t *testing.T) {
+	standIn := &gitlabStandIn{}
+	client := newTestClient(t, standIn)
+
+	comments, err := client.ListPRComments(context.Background(), "group", "project", 7)
+	if err != nil {
+		t.Fatalf("ListPRComments() error = %v", err)
+	}
+	// 系统备注不是评论
+	if len(comments) != 2 || comments[0].ID != 302 || comments[1].Body != "question" {
+		t.Fatalf("comments = %+v, want notes 302 and 303", comments)
+	}
+	if !comments[0].Mine || comments[1].Mine {
+		t.Errorf("comments = %+v, want only note 302 written by the bot", comments)
+	}
+
+	if err := client.UpdatePRComment(context.Background(), "group", "project", 7, 302, "updated"); err != nil {
+		t.Fatalf("UpdatePRComment() error = %v", err)
+	}
+	if standIn.updated["302"] != "updated" {
+		t.Errorf("updated notes = %v, want 302 updated", standIn.updated)
+	}
+}
+
+func TestReviewThreads(t *testing.T) {
+	standIn := &gitlabStandIn{}
+	client := newTestClient(t, standIn)
+
+	threads, err := client.ListReviewThreads(context.Background(), "group", "project", 7)
+	if err != nil {
+		t.Fatalf("ListReviewThreads() error = %v", err)
+	}
+	// 只有差异上的讨论是审查线程
+	if len(threads) != 2 || threads[0].ID != "d1" || threads[0].Path != "main.go" ||
+		threads[1].Path != "old.go" || !threads[1].Resolved {
+		t.Fatalf("threads = %+v, want d1 and d3", threads)
+	}
+	if d1 := threads[0]; d1.CommitID != "abc123" || d1.StartLine != 2 || d1.Line != 3 || d1.Side != "RIGHT" {
+		t.Errorf("anchor of d1 = %s %d-%d %s, want abc123 2-3 RIGHT", d1.CommitID, d1.StartLine, d1.Line, d1.Side)
+	}
+	if d3 := threads[1]; d3.Line != 7 || d3.Side != "LEFT" {
+		t.Errorf("anchor of d3 = %d %s, want old line 7", d3.Line, d3.Side)
+	}
+
+	if err := client.ResolveReviewThread(context.Background(), "group", "project", 7, "d1"); err != nil {
+		t.Fatalf("ResolveReviewThread() error = %v", err)
+	}
+	if len(standIn.resolved) != 1 || standIn.resolved[0] != "d1" {
+		t.Errorf("resolved = %v, want d1", standIn.resolved)
+	}
+}
+
+func TestIsCollaborator(t *testing.T) {
+	client := newTestClient(t, &gitlabStandIn{})
+
+	for user, want := range map[string]bool{"dev": true, "guest": false, "stranger": false} {
+		got, err := client.IsCollaborator(context.Background(), "group", "project", user)
+		if err != nil {
+			t.Fatalf("IsCollaborator(%s) error = %v", user, err)
+		}
+		if got != want {
+			t.Errorf("IsCollaborator(%s) = %v, want %v", user, got, want)
+		}
+	}
+}
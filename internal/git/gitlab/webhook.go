@@ -58,6 +58,9 @@ func (h *WebhookHandler) HandleWebhook(w http.ResponseWriter, r *http.Request) {
 		parsedPayload = &gitlab.MergeEvent{}
 	case "Push Hook":
 		parsedPayload = &gitlab.PushEvent{}
+	case "Note Hook":
+		// 合并请求以外的评论在处理时跳过
+		parsedPayload = &gitlab.MergeCommentEvent{}
 	case "System Hook":
 		// System hooks need special handling
 		// GitLab SDK没有直接提供SystemHookEvent类型，使用通用map
@@ -7,6 +7,33 @@ import (
 // 使用models包中定义的接口和数据结构
 type Platform = models.GitPlatform
 type CommitFile = models.CommitFile
+type FileDiff = models.FileDiff
 type Commit = models.Commit
 type PullRequest = models.PullRequest
 type ReviewComment = models.ReviewComment
+type PRComment = models.PRComment
+type PullRequestComparer = models.PullRequestComparer
+type PullRequestApprover = models.PullRequestApprover
+type ReviewThread = models.ReviewThread
+type ReviewThreadResolver = models.ReviewThreadResolver
+type CollaboratorChecker = models.CollaboratorChecker
+type PullRequestStatusReporter = models.PullRequestStatusReporter
+type CheckRunReporter = models.CheckRunReporter
+type CheckRunResult = models.CheckRunResult
+type CheckAnnotation = models.CheckAnnotation
+
+// 审查状态
+const (
+	StatusPending = models.StatusPending
+	StatusSuccess = models.StatusSuccess
+	StatusFailure = models.StatusFailure
+	StatusError   = models.StatusError
+)
+
+// 检查运行结论
+const (
+	CheckConclusionSuccess   = models.CheckConclusionSuccess
+	CheckConclusionFailure   = models.CheckConclusionFailure
+	CheckConclusionNeutral   = models.CheckConclusionNeutral
+	CheckConclusionCancelled = models.CheckConclusionCancelled
+)
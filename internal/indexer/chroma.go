@@ -65,7 +65,15 @@ func NewChromaStorage(config *StorageConfig) (*ChromaStorage, error) {
 	// 创建向量服务
 	// 使用OpenAI向量服务，如果没有配置则返回nil
 	var vectorSvc VectorService
-	if config.OpenAIAPIKey != "" {
+	if config.OllamaBaseURL != "" {
+		var err error
+		vectorSvc, err = NewOllamaVectorService(config.OllamaBaseURL, config.OllamaModel, config.OllamaAPIFormat)
+		if err != nil {
+			logrus.Warnf("Failed to create Ollama vector service: %v", err)
+			return nil, fmt.Errorf("failed to create vector service: %w", err)
+		}
+		logrus.Infof("Created Chroma storage with Ollama vector service")
+	} else if config.OpenAIAPIKey != "" {
 		var err error
 		vectorSvc, err = NewOpenAIVectorService(config.OpenAIAPIKey, config.OpenAIModel)
 		if err != nil {
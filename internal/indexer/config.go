@@ -22,7 +22,7 @@ type Config struct {
 	LocalStoragePath string
 
 	// 向量服务配置
-	VectorType      string // "openai", "local", "simple", "llm_proxy"
+	VectorType      string // "openai", "local", "simple", "llm_proxy", "ollama"
 	OpenAIAPIKey    string
 	OpenAIModel     string
 	LocalModelPath  string
@@ -33,6 +33,11 @@ type Config struct {
 	LLMProxyModel    string
 	LLMProxyProvider string
 
+	// 本地模型服务配置（Ollama / llama.cpp）
+	OllamaBaseURL   string
+	OllamaModel     string
+	OllamaAPIFormat string // "ollama" 或 "openai"
+
 	// 索引配置
 	MaxFileSizeBytes int64
 	ChunkSize        int
@@ -54,6 +59,8 @@ func NewConfigFromEnv() *Config {
 		OpenAIModel:     "text-embedding-3-small",
 		LLMProxyModel:   "text-embedding-3-large", // 默认使用Azure模型
 		LLMProxyProvider: "azure",                  // 默认使用Azure提供商
+		OllamaModel:     "nomic-embed-text",
+		OllamaAPIFormat: "ollama",
 		MaxFileSizeBytes: 1024 * 1024, // 1MB
 		ChunkSize:       500,          // 500行
 	}
@@ -127,6 +134,19 @@ func NewConfigFromEnv() *Config {
 		config.LLMProxyProvider = val
 	}
 
+	// 本地模型服务配置，与审查使用同一个服务
+	if val := os.Getenv("OLLAMA_BASE_URL"); val != "" {
+		config.OllamaBaseURL = val
+	}
+	
+	if val := os.Getenv("INDEXER_OLLAMA_MODEL"); val != "" {
+		config.OllamaModel = val
+	}
+	
+	if val := os.Getenv("OLLAMA_API_FORMAT"); val != "" {
+		config.OllamaAPIFormat = strings.ToLower(val)
+	}
+
 	// 索引配置
 	if val := os.Getenv("INDEXER_MAX_FILE_SIZE"); val != "" {
 		if size, err := strconv.ParseInt(val, 10, 64); err == nil {
@@ -201,6 +221,13 @@ func (c *Config) Validate() error {
 				c.LLMProxyModel = "text-embedding-3-large" // 默认使用Azure模型
 			}
 		}
+	case "ollama":
+		if c.OllamaBaseURL == "" {
+			return fmt.Errorf("ollama base url is required when vector type is ollama")
+		}
+		if c.OllamaModel == "" {
+			c.OllamaModel = "nomic-embed-text"
+		}
 	case "simple":
 		// 简单向量服务不需要额外配置
 	default:
@@ -238,6 +265,11 @@ func (c *Config) CreateStorage() (Storage, error) {
 			LLMProxyModel:    c.LLMProxyModel,
 			LLMProxyProvider: c.LLMProxyProvider,
 		}
+		if c.VectorType == "ollama" {
+			storageConfig.OllamaBaseURL = c.OllamaBaseURL
+			storageConfig.OllamaModel = c.OllamaModel
+			storageConfig.OllamaAPIFormat = c.OllamaAPIFormat
+		}
 		return NewChromaStorage(storageConfig)
 	case "local":
 		return NewLocalStorage(c.LocalStoragePath)
@@ -263,8 +295,10 @@ func (c *Config) CreateVectorService() (VectorService, error) {
 			LLMProxyProvider: c.LLMProxyProvider,
 		}
 		return NewVectorService(vectorConfig)
+	case "ollama":
+		return NewOllamaVectorService(c.OllamaBaseURL, c.OllamaModel, c.OllamaAPIFormat)
 	default:
-		return nil, fmt.Errorf("unsupported vector type: %s, please use 'openai' or 'llm_proxy'", c.VectorType)
+		return nil, fmt.Errorf("unsupported vector type: %s, please use 'openai', 'llm_proxy' or 'ollama'", c.VectorType)
 	}
 }
 
@@ -295,6 +329,11 @@ func (c *Config) CreateIndexManager() (*IndexManager, error) {
 			LLMProxyModel:    c.LLMProxyModel,
 			LLMProxyProvider: c.LLMProxyProvider,
 		}
+		if c.VectorType == "ollama" {
+			storageConfig.OllamaBaseURL = c.OllamaBaseURL
+			storageConfig.OllamaModel = c.OllamaModel
+			storageConfig.OllamaAPIFormat = c.OllamaAPIFormat
+		}
 		storage, err = NewChromaStorage(storageConfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Chroma storage: %w", err)
@@ -350,6 +389,10 @@ func (c *Config) LogConfig() {
 		logrus.Info("  LLM Proxy API Key: [REDACTED]")
 		logrus.Infof("  LLM Proxy Provider: %s", c.LLMProxyProvider)
 		logrus.Infof("  LLM Proxy Model: %s", c.LLMProxyModel)
+	case "ollama":
+		logrus.Infof("  Ollama Base URL: %s", c.OllamaBaseURL)
+		logrus.Infof("  Ollama API Format: %s", c.OllamaAPIFormat)
+		logrus.Infof("  Ollama Model: %s", c.OllamaModel)
 	case "simple":
 		logrus.Info("  Using simple rule-based vector embeddings")
 	}
@@ -84,6 +84,25 @@ func TestConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "ollama without base url",
+			config: &Config{
+				StorageType:      "local",
+				LocalStoragePath: "./data",
+				VectorType:       "ollama",
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid ollama config",
+			config: &Config{
+				StorageType:      "local",
+				LocalStoragePath: "./data",
+				VectorType:       "ollama",
+				OllamaBaseURL:    "http://localhost:11434",
+			},
+			wantErr: false,
+		},
 		{
 			name: "local vector without model path",
 			config: &Config{
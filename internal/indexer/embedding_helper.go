@@ -2,12 +2,12 @@ package indexer
 
 import (
 	"fmt"
-	"math"
 	"os"
 	"strconv"
 	"strings"
 	"unicode/utf8"
 
+	"github.com/eust-w/ai_code_reviewer/internal/tokenizer"
 	"github.com/sirupsen/logrus"
 )
 
@@ -84,10 +84,18 @@ func FormatEmbeddingModel(model, provider string) string {
 	return model
 }
 
+// EmbeddingTokenizerFamily 是嵌入模型使用的分词器目录名
+const EmbeddingTokenizerFamily = "embedding"
+
 // SplitTextIntoChunks 将文本分割成多个块，以适应模型的上下文窗口大小
 // maxTokens: 模型的最大上下文窗口大小（以token为单位）
 // overlap: 相邻块之间的重叠token数，以保持上下文连贯性
 func SplitTextIntoChunks(text string, maxTokens int, overlap int) []string {
+	return splitTextIntoChunks(text, maxTokens, overlap, EstimateTokenCount)
+}
+
+// splitTextIntoChunks 按 countTokens 测量每个块，保证每块不超过 maxTokens
+func splitTextIntoChunks(text string, maxTokens int, overlap int, countTokens func(string) int) []string {
 	if maxTokens <= 0 {
 		maxTokens = 8000 // 默认使用8000作为安全值
 	}
@@ -101,62 +109,60 @@ func SplitTextIntoChunks(text string, maxTokens int, overlap int) []string {
 		overlap = maxTokens/2
 	}
 	
-	// 估算文本的token数量
-	// 这是一个简单的估算，实际token数量取决于模型的分词器
-	// 一般来说，对于英文文本，1个token约等于4个字符
-	// 对于中文文本，1个token约等于1-2个字符
-	// 这里我们使用一个保守的估计：平均每个UTF-8字符约等于1个token
-	runeCount := utf8.RuneCountInString(text)
+	tokenCount := countTokens(text)
 	
 	// 如果文本的token数量小于maxTokens，则直接返回整个文本
-	if runeCount <= maxTokens {
+	if tokenCount <= maxTokens {
 		return []string{text}
 	}
 	
-	// 计算每个块的大小（以字符为单位）
-	chunkSize := maxTokens - overlap
-	
-	// 计算需要多少个块
-	numChunks := int(math.Ceil(float64(runeCount) / float64(chunkSize)))
-	
-	// 将文本分割成多个块
-	chunks := make([]string, 0, numChunks)
+	// 先按整个文本的平均 token 密度估计块的长度，再逐块测量；
+	// 中日韩文字等密集区域的块超出上限时按比例收缩
 	runes := []rune(text)
+	guess := int(float64(maxTokens) * float64(len(runes)) / float64(tokenCount))
+	if guess < 1 {
+		guess = 1
+	}
 	
-	for i := 0; i < numChunks; i++ {
-		start := i * chunkSize
-		if i > 0 {
-			// 对于除第一个块外的所有块，从前一个块的末尾减去overlap开始
-			start = i*chunkSize - overlap
+	var chunks []string
+	for start := 0; start < len(runes); {
+		end := min(start+guess, len(runes))
+		for end-start > 1 {
+			tokens := countTokens(string(runes[start:end]))
+			if tokens <= maxTokens {
+				break
+			}
+			// 至少收缩一个字符，保证循环结束
+			shrunk := start + (end-start)*maxTokens/tokens
+			end = max(min(shrunk, end-1), start+1)
 		}
-		
-		end := start + maxTokens
-		if end > len(runes) {
-			end = len(runes)
-		}
-		
-		chunk := string(runes[start:end])
-		chunks = append(chunks, chunk)
+		chunks = append(chunks, string(runes[start:end]))
 		
 		// 如果已经处理到文本末尾，则退出循环
 		if end == len(runes) {
 			break
 		}
+		
+		// 下一块从本块末尾减去重叠部分开始，重叠的 token 数按本块的密度换算成字符数
+		next := end - (end-start)*overlap/maxTokens
+		start = max(next, start+1)
 	}
 	
 	logrus.Infof("Split text into %d chunks (original size: %d characters, max tokens: %d, overlap: %d)",
-		len(chunks), runeCount, maxTokens, overlap)
+		len(chunks), len(runes), maxTokens, overlap)
 	
 	return chunks
 }
 
-// EstimateTokenCount 估算文本的token数量
-// 这是一个简单的估算，实际token数量取决于模型的分词器
+// EstimateTokenCount 计算文本的token数量
+// 使用 TOKENIZER_DIR 下 embedding 模型族的分词器；未配置时保守地按每个字符一个token计算，
+// 估算器对 ASCII 文本按四个字节一个token计算，可能低估嵌入模型的token数量
 func EstimateTokenCount(text string) int {
-	// 对于英文文本，1个token约等于4个字符
-	// 对于中文文本，1个token约等于1-2个字符
-	// 这里我们使用一个保守的估计：平均每个UTF-8字符约等于1个token
-	return utf8.RuneCountInString(text)
+	tk := tokenizer.ForModel(EmbeddingTokenizerFamily)
+	if _, ok := tk.(tokenizer.Estimator); ok {
+		return utf8.RuneCountInString(text)
+	}
+	return tk.CountTokens(text)
 }
 
 // GetChromaConcurrency 从环境变量获取Chroma的并发处理数量
@@ -0,0 +1,55 @@
+package indexer
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/eust-w/ai_code_reviewer/internal/tokenizer"
+)
+
+func TestSplitTextIntoChunksFitsDenseText(t *testing.T) {
+	// 代码部分四个字节一个 token，中文部分一个字一个 token，
+	// 按平均密度估计的块在中文部分会超出上限
+	var b strings.Builder
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&b, "x := %d // code\n", i)
+	}
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&b, "第%d行中文说明\n", i)
+	}
+	text := b.String()
+	countTokens := tokenizer.Estimator{}.CountTokens
+	const maxTokens, overlap = 1000, 100
+
+	chunks := splitTextIntoChunks(text, maxTokens, overlap, countTokens)
+	if len(chunks) < 2 {
+		t.Fatalf("got %d chunks, want the text split", len(chunks))
+	}
+	end := 0
+	for i, chunk := range chunks {
+		if tokens := countTokens(chunk); tokens > maxTokens {
+			t.Errorf("chunk %d has %d tokens, want at most %d", i, tokens, maxTokens)
+		}
+		// 每块从上一块的末尾之前开始，相邻块之间没有遗漏
+		start := strings.Index(text, chunk)
+		if start < 0 || start > end || (i > 0 && start == 0) {
+			t.Fatalf("chunk %d starts at %d, want it to overlap the previous chunk ending at %d", i, start, end)
+		}
+		end = start + len(chunk)
+	}
+	if end != len(text) {
+		t.Errorf("chunks end at %d, want %d", end, len(text))
+	}
+}
+
+func TestEstimateTokenCountWithoutTokenizer(t *testing.T) {
+	if os.Getenv("TOKENIZER_DIR") != "" {
+		t.Skip("TOKENIZER_DIR is set")
+	}
+	// 没有嵌入模型的分词器时每个字符按一个 token 计算
+	if got := EstimateTokenCount("func main() {}\n中文"); got != 17 {
+		t.Errorf("EstimateTokenCount() = %d, want 17", got)
+	}
+}
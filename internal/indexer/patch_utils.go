@@ -4,20 +4,49 @@ import (
 	"fmt"
 	"strings"
 	
+	"github.com/eust-w/ai_code_reviewer/internal/tokenizer"
 	"github.com/sirupsen/logrus"
 )
 
 // EnrichPatchWithContext 使用代码上下文信息增强补丁
 // 这将帮助代码审查工具更好地理解代码变更的上下文
 func EnrichPatchWithContext(patch string, context *CodeContext) string {
+	return EnrichPatchWithContextBudget(patch, context, nil, 0)
+}
+
+// EnrichPatchWithContextBudget 与 EnrichPatchWithContext 相同，但增强后的
+// 补丁不超过 maxTokens 个 token（按 tk 计数）。上下文按导入、定义、引用、
+// 依赖、相似代码的顺序加入，放不下的部分被丢弃；maxTokens <= 0 表示不限制
+func EnrichPatchWithContextBudget(patch string, context *CodeContext, tk tokenizer.Tokenizer, maxTokens int) string {
 	if context == nil {
 		logrus.Info("No code context available for enrichment")
 		return patch
 	}
+	if tk == nil {
+		tk = tokenizer.Estimator{}
+	}
 
 	var enriched strings.Builder
 	logrus.Info("开始增强补丁，添加代码上下文信息")
 
+	// 补丁本身总是保留，上下文只能使用剩余的预算
+	limited := maxTokens > 0
+	remaining := 0
+	if limited {
+		remaining = maxTokens - tk.CountTokens(patch)
+	}
+	addSection := func(name string, section *strings.Builder) {
+		if limited {
+			tokens := tk.CountTokens(section.String())
+			if tokens > remaining {
+				logrus.Infof("丢弃%s上下文：需要 %d 个 token，剩余预算 %d", name, tokens, remaining)
+				return
+			}
+			remaining -= tokens
+		}
+		enriched.WriteString(section.String())
+	}
+
 	// 添加导入信息
 	if len(context.Imports) > 0 {
 		logrus.Infof("添加 %d 个相关导入", len(context.Imports))
@@ -25,12 +54,14 @@ func EnrichPatchWithContext(patch string, context *CodeContext) string {
 			logrus.Debugf("导入: %s", imp)
 		}
 		
-		enriched.WriteString("/* Relevant imports:\n")
+		var section strings.Builder
+		section.WriteString("/* Relevant imports:\n")
 		for _, imp := range context.Imports {
-			enriched.WriteString(imp)
-			enriched.WriteString("\n")
+			section.WriteString(imp)
+			section.WriteString("\n")
 		}
-		enriched.WriteString("*/\n\n")
+		section.WriteString("*/\n\n")
+		addSection("导入", &section)
 	} else {
 		logrus.Info("没有找到相关导入")
 	}
@@ -46,11 +77,13 @@ func EnrichPatchWithContext(patch string, context *CodeContext) string {
 			logrus.Debugf("定义: %s = %s", name, shortDef)
 		}
 		
-		enriched.WriteString("/* Relevant definitions:\n")
+		var section strings.Builder
+		section.WriteString("/* Relevant definitions:\n")
 		for name, def := range context.Definitions {
-			enriched.WriteString(fmt.Sprintf("// %s\n%s\n\n", name, def))
+			section.WriteString(fmt.Sprintf("// %s\n%s\n\n", name, def))
 		}
-		enriched.WriteString("*/\n\n")
+		section.WriteString("*/\n\n")
+		addSection("定义", &section)
 	} else {
 		logrus.Info("没有找到相关定义")
 	}
@@ -62,12 +95,14 @@ func EnrichPatchWithContext(patch string, context *CodeContext) string {
 			logrus.Debugf("引用: %s", ref)
 		}
 		
-		enriched.WriteString("/* Relevant references:\n")
+		var section strings.Builder
+		section.WriteString("/* Relevant references:\n")
 		for _, ref := range context.References {
-			enriched.WriteString(ref)
-			enriched.WriteString("\n")
+			section.WriteString(ref)
+			section.WriteString("\n")
 		}
-		enriched.WriteString("*/\n\n")
+		section.WriteString("*/\n\n")
+		addSection("引用", &section)
 	} else {
 		logrus.Info("没有找到相关引用")
 	}
@@ -79,12 +114,14 @@ func EnrichPatchWithContext(patch string, context *CodeContext) string {
 			logrus.Debugf("依赖: %s", dep)
 		}
 		
-		enriched.WriteString("/* Dependencies:\n")
+		var section strings.Builder
+		section.WriteString("/* Dependencies:\n")
 		for _, dep := range context.Dependencies {
-			enriched.WriteString(dep)
-			enriched.WriteString("\n")
+			section.WriteString(dep)
+			section.WriteString("\n")
 		}
-		enriched.WriteString("*/\n\n")
+		section.WriteString("*/\n\n")
+		addSection("依赖", &section)
 	} else {
 		logrus.Info("没有找到相关依赖")
 	}
@@ -102,12 +139,14 @@ func EnrichPatchWithContext(patch string, context *CodeContext) string {
 			logrus.Debugf("内容摘要: %s", shortContent)
 		}
 		
-		enriched.WriteString("/* Similar code patterns:\n")
+		var section strings.Builder
+		section.WriteString("/* Similar code patterns:\n")
 		for _, snippet := range context.SimilarCode {
-			enriched.WriteString(fmt.Sprintf("From %s (lines %d-%d, similarity: %.2f):\n%s\n\n", 
+			section.WriteString(fmt.Sprintf("From %s (lines %d-%d, similarity: %.2f):\n%s\n\n", 
 				snippet.Filename, snippet.LineStart, snippet.LineEnd, snippet.Similarity, snippet.Content))
 		}
-		enriched.WriteString("*/\n\n")
+		section.WriteString("*/\n\n")
+		addSection("相似代码", &section)
 	} else {
 		logrus.Info("没有找到相似代码片段")
 	}
@@ -48,6 +48,9 @@ type StorageConfig struct {
 	LLMProxyAPIKey   string
 	LLMProxyModel    string
 	LLMProxyProvider string
+	OllamaBaseURL    string
+	OllamaModel      string
+	OllamaAPIFormat  string
 }
 
 // NewStorage 创建存储实例
@@ -406,3 +406,150 @@ func (s *LLMProxyVectorService) getEmbedding(ctx context.Context, model, input s
 func (s *LLMProxyVectorService) Close() error {
 	return nil
 }
+
+// OllamaVectorService 使用本地模型服务（Ollama 或 llama.cpp）的向量服务
+type OllamaVectorService struct {
+	baseURL   string
+	model     string
+	apiFormat string
+	client    *http.Client
+}
+
+// ollamaEmbedRequest Ollama /api/embed 请求结构
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// ollamaEmbedResponse Ollama /api/embed 响应结构
+type ollamaEmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+	Error      string      `json:"error"`
+}
+
+// NewOllamaVectorService 创建本地模型向量服务
+// apiFormat 为 "ollama" 时使用 /api/embed，为 "openai" 时使用 llama.cpp 的 /v1/embeddings
+func NewOllamaVectorService(baseURL, model, apiFormat string) (*OllamaVectorService, error) {
+	if baseURL == "" {
+		return nil, fmt.Errorf("ollama base url is required")
+	}
+	if model == "" {
+		model = "nomic-embed-text"
+	}
+	if apiFormat == "" {
+		apiFormat = "ollama"
+	}
+	if apiFormat != "ollama" && apiFormat != "openai" {
+		return nil, fmt.Errorf("unsupported ollama api format: %s", apiFormat)
+	}
+
+	logrus.Infof("Creating Ollama vector service with base url: %s, model: %s, format: %s", baseURL, model, apiFormat)
+
+	return &OllamaVectorService{
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		model:     model,
+		apiFormat: apiFormat,
+		client: &http.Client{
+			// 本地模型首次加载较慢
+			Timeout: 120 * time.Second,
+		},
+	}, nil
+}
+
+// EmbedCode 将代码片段转换为向量
+func (s *OllamaVectorService) EmbedCode(ctx context.Context, language, content string) ([]float32, error) {
+	logrus.Infof("Embedding code with Ollama (model: %s)", s.model)
+
+	// 添加语言信息作为上下文
+	input := fmt.Sprintf("Language: %s\n\n%s", language, content)
+	return s.getEmbedding(ctx, input)
+}
+
+// EmbedQuery 将查询转换为向量
+func (s *OllamaVectorService) EmbedQuery(ctx context.Context, query string) ([]float32, error) {
+	logrus.Infof("Embedding query with Ollama (model: %s)", s.model)
+	return s.getEmbedding(ctx, query)
+}
+
+// getEmbedding 从本地模型服务获取嵌入向量
+func (s *OllamaVectorService) getEmbedding(ctx context.Context, input string) ([]float32, error) {
+	var (
+		endpoint string
+		reqData  interface{}
+	)
+	if s.apiFormat == "openai" {
+		endpoint = s.baseURL + "/v1/embeddings"
+		reqData = OpenAIEmbeddingRequest{Model: s.model, Input: []string{input}}
+	} else {
+		endpoint = s.baseURL + "/api/embed"
+		reqData = ollamaEmbedRequest{Model: s.model, Input: []string{input}}
+	}
+
+	// 将请求数据转换为JSON
+	reqBody, err := json.Marshal(reqData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request data: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// 发送请求
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 读取响应体
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// 检查响应状态码
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Ollama API request failed with status code %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	// 解析响应数据
+	var embedding []float32
+	if s.apiFormat == "openai" {
+		var embedResp OpenAIEmbeddingResponse
+		if err := json.Unmarshal(respBody, &embedResp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if len(embedResp.Data) > 0 {
+			embedding = embedResp.Data[0].Embedding
+		}
+	} else {
+		var embedResp ollamaEmbedResponse
+		if err := json.Unmarshal(respBody, &embedResp); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		}
+		if embedResp.Error != "" {
+			return nil, fmt.Errorf("Ollama API error: %s", embedResp.Error)
+		}
+		if len(embedResp.Embeddings) > 0 {
+			embedding = embedResp.Embeddings[0]
+		}
+	}
+
+	// 检查是否有嵌入数据
+	if len(embedding) == 0 {
+		return nil, fmt.Errorf("no embedding data in response")
+	}
+
+	logrus.Infof("Successfully obtained embedding vector with %d dimensions", len(embedding))
+
+	return embedding, nil
+}
+
+// Close 关闭服务
+func (s *OllamaVectorService) Close() error {
+	return nil
+}
@@ -0,0 +1,110 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOllamaVectorService(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiFormat string
+		path      string
+		response  string
+		want      []float32
+		wantErr   bool
+	}{
+		{
+			name:      "ollama",
+			apiFormat: "ollama",
+			path:      "/api/embed",
+			response:  `{"model": "nomic-embed-text", "embeddings": [[0.1, 0.2, 0.3]]}`,
+			want:      []float32{0.1, 0.2, 0.3},
+		},
+		{
+			name:      "llama.cpp",
+			apiFormat: "openai",
+			path:      "/v1/embeddings",
+			response:  `{"object": "list", "data": [{"object": "embedding", "embedding": [0.4, 0.5], "index": 0}]}`,
+			want:      []float32{0.4, 0.5},
+		},
+		{
+			name:      "ollama error",
+			apiFormat: "ollama",
+			path:      "/api/embed",
+			response:  `{"error": "model \"nomic-embed-text\" not found"}`,
+			wantErr:   true,
+		},
+		{
+			name:      "no embeddings",
+			apiFormat: "openai",
+			path:      "/v1/embeddings",
+			response:  `{"object": "list", "data": []}`,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != tt.path {
+					http.NotFound(w, r)
+					return
+				}
+				// 两种格式的请求体相同：模型名和输入列表
+				var req struct {
+					Model string   `json:"model"`
+					Input []string `json:"input"`
+				}
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+					t.Errorf("failed to decode request: %v", err)
+				}
+				if req.Model != "nomic-embed-text" || len(req.Input) != 1 || req.Input[0] != "find the parser" {
+					t.Errorf("request = %+v", req)
+				}
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			service, err := NewOllamaVectorService(server.URL+"/", "", tt.apiFormat)
+			if err != nil {
+				t.Fatalf("NewOllamaVectorService() error = %v", err)
+			}
+			got, err := service.EmbedQuery(context.Background(), "find the parser")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EmbedQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("EmbedQuery() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOllamaVectorServiceHTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model is loading", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	service, err := NewOllamaVectorService(server.URL, "nomic-embed-text", "ollama")
+	if err != nil {
+		t.Fatalf("NewOllamaVectorService() error = %v", err)
+	}
+	if _, err := service.EmbedCode(context.Background(), "go", "package main"); err == nil {
+		t.Error("EmbedCode() error = nil, want the status error")
+	}
+}
+
+func TestNewOllamaVectorServiceFormat(t *testing.T) {
+	if _, err := NewOllamaVectorService("http://localhost:11434", "", "grpc"); err == nil {
+		t.Error("NewOllamaVectorService() with unknown format error = nil")
+	}
+	if _, err := NewOllamaVectorService("", "", "ollama"); err == nil {
+		t.Error("NewOllamaVectorService() without base URL error = nil")
+	}
+}
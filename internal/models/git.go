@@ -2,14 +2,36 @@ package models
 
 import (
 	"context"
+	"errors"
+
+	"github.com/eust-w/ai_code_reviewer/internal/diff"
 )
 
+// ErrNotFound is returned (wrapped) by platforms when a requested resource does not exist
+var ErrNotFound = errors.New("not found")
+
+// FileDiff is the parsed diff of a single file, shared by all platforms
+type FileDiff = diff.FileDiff
+
 // CommitFile represents a file changed in a commit
 type CommitFile struct {
 	Filename    string
 	Status      string
 	Patch       string
 	ContentsURL string
+	// Diff is the parsed patch with paths, status and line counts
+	Diff *FileDiff
+}
+
+// NewCommitFile creates a commit file from a parsed file diff
+func NewCommitFile(fd *FileDiff, contentsURL string) *CommitFile {
+	return &CommitFile{
+		Filename:    fd.Path(),
+		Status:      fd.Status,
+		Patch:       fd.Patch,
+		ContentsURL: contentsURL,
+		Diff:        fd,
+	}
 }
 
 // Commit represents a git commit
@@ -32,9 +54,29 @@ type PullRequest struct {
 
 // ReviewComment represents a comment on a pull request
 type ReviewComment struct {
-	Path     string
-	Body     string
+	Path string
+	Body string
+	// Position is the GitHub diff position of the commented line
 	Position int
+	// Line is the line number in the file on Side. For multi-line comments it
+	// is the last line of the range.
+	Line int
+	// Side is "RIGHT" for the new version of the file or "LEFT" for the old one
+	Side string
+	// StartLine and StartSide describe the first line of a multi-line
+	// comment; StartLine is 0 for single-line comments
+	StartLine int
+	StartSide string
+}
+
+// PRComment is a general (not inline) comment on a pull request
+type PRComment struct {
+	// ID identifies the comment for UpdatePRComment
+	ID   int64
+	Body string
+	// Mine is set for comments written by the user the client authenticates
+	// as, i.e. the bot. Only these may be trusted to hold its hidden state.
+	Mine bool
 }
 
 // GitPlatform defines the interface for git hosting platforms
@@ -54,6 +96,132 @@ type GitPlatform interface {
 	// CreatePRComment creates a comment on a pull request
 	CreatePRComment(ctx context.Context, owner, repo string, number int, body string) error
 	
+	// ListPRComments lists the general comments of a pull request, oldest
+	// first, marking the ones written by the bot as Mine
+	ListPRComments(ctx context.Context, owner, repo string, number int) ([]*PRComment, error)
+	
+	// UpdatePRComment replaces the body of a comment listed by
+	// ListPRComments
+	UpdatePRComment(ctx context.Context, owner, repo string, number int, id int64, body string) error
+	
 	// GetRepoVariable gets a repository variable
 	GetRepoVariable(ctx context.Context, owner, repo, name string) (string, error)
+	
+	// GetFileContent gets the raw content of a file at the given ref.
+	// It returns an error wrapping ErrNotFound if the file does not exist.
+	GetFileContent(ctx context.Context, owner, repo, path, ref string) ([]byte, error)
+	
+	// SetCommitStatus sets the review status of a commit. state is one of
+	// the Status* constants and targetURL links to the review.
+	SetCommitStatus(ctx context.Context, owner, repo, sha, state, description, targetURL string) error
+}
+
+// PullRequestComparer is implemented by platforms that can list the changes
+// of a pull request directly, which is more reliable than comparing commits
+// when the platform's compare API is limited
+type PullRequestComparer interface {
+	// ComparePullRequest returns the files changed by a pull request and its
+	// commits, oldest first
+	ComparePullRequest(ctx context.Context, owner, repo string, number int, base, head string) ([]*CommitFile, []*Commit, error)
+}
+
+// PullRequestApprover is implemented by platforms that can approve a pull
+// request on behalf of the bot
+type PullRequestApprover interface {
+	// ApprovePullRequest approves a pull request
+	ApprovePullRequest(ctx context.Context, owner, repo string, number int) error
+}
+
+// ReviewThread is a thread of inline review comments on a pull request
+type ReviewThread struct {
+	// ID identifies the thread for ResolveReviewThread
+	ID   string
+	Path string
+	// Body is the body of the first comment of the thread
+	Body     string
+	Resolved bool
+	// CommitID is the commit the thread was started on. StartLine and Line
+	// are the lines of that commit it is anchored to, on Side ("LEFT" for
+	// lines of the base); Line is 0 if the platform does not tell.
+	CommitID  string
+	StartLine int
+	Line      int
+	Side      string
+}
+
+// ReviewThreadResolver is implemented by platforms whose inline review
+// comments form threads that can be resolved
+type ReviewThreadResolver interface {
+	// ListReviewThreads lists the inline comment threads of a pull request
+	ListReviewThreads(ctx context.Context, owner, repo string, number int) ([]*ReviewThread, error)
+	// ResolveReviewThread marks a thread as resolved
+	ResolveReviewThread(ctx context.Context, owner, repo string, number int, id string) error
+}
+
+// CollaboratorChecker is implemented by platforms that can tell whether a
+// user may push to a repository, which chat-ops commands in pull request
+// comments require
+type CollaboratorChecker interface {
+	// IsCollaborator reports whether a user, by login name, has write access
+	// to a repository
+	IsCollaborator(ctx context.Context, owner, repo, user string) (bool, error)
+}
+
+// StatusContext identifies the review among the statuses of a commit or
+// pull request
+const StatusContext = "ai-code-review"
+
+// Review statuses reported to platforms with a native status API
+const (
+	StatusPending = "pending"
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusError   = "error"
+)
+
+// PullRequestStatusReporter is implemented by platforms that can attach the
+// review verdict to a pull request as a status, e.g. for branch policies
+type PullRequestStatusReporter interface {
+	// SetPullRequestStatus sets the review status of a pull request. state
+	// is one of the Status* constants.
+	SetPullRequestStatus(ctx context.Context, owner, repo string, number int, state, description string) error
+}
+
+// Conclusions of a completed check run
+const (
+	CheckConclusionSuccess = "success"
+	CheckConclusionFailure = "failure"
+	CheckConclusionNeutral = "neutral"
+	// CheckConclusionCancelled ends the check run of a review that was
+	// superseded by a newer commit
+	CheckConclusionCancelled = "cancelled"
+)
+
+// CheckAnnotation is a finding attached to lines of the new version of a
+// file
+type CheckAnnotation struct {
+	Path      string
+	StartLine int
+	EndLine   int
+	Message   string
+}
+
+// CheckRunResult is the outcome of a review reported as a check run
+type CheckRunResult struct {
+	// Conclusion is one of the CheckConclusion* constants
+	Conclusion string
+	Title      string
+	// Summary is the markdown output of the check run
+	Summary     string
+	Annotations []*CheckAnnotation
+}
+
+// CheckRunReporter is implemented by platforms that can report reviews as
+// check runs, which branch protection can require
+type CheckRunReporter interface {
+	// StartCheckRun creates an in-progress check run for a commit and
+	// returns its ID
+	StartCheckRun(ctx context.Context, owner, repo, headSHA string) (int64, error)
+	// CompleteCheckRun completes a check run with the review result
+	CompleteCheckRun(ctx context.Context, owner, repo string, id int64, result *CheckRunResult) error
 }
@@ -0,0 +1,470 @@
+// Package queue runs webhook-triggered reviews as jobs on a bounded pool of
+// workers. Jobs are persisted so that they survive restarts.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrClosed is returned by Enqueue once the queue is shutting down
+var ErrClosed = errors.New("queue is closed")
+
+// ErrSuperseded is the cause of the cancellation of a running job when a
+// job for a newer revision of its group is queued
+var ErrSuperseded = errors.New("superseded by a newer revision")
+
+// Job is a queued review job
+type Job struct {
+	ID string `json:"id"`
+	// Kind selects the handler of the job
+	Kind string `json:"kind"`
+	// Key groups jobs that share a concurrency limit, e.g. a repository
+	Key string `json:"key"`
+	// Group and Revision coalesce review jobs, e.g. the pull request and
+	// its head commit. A review job of a group replaces the review jobs of
+	// other revisions of the group and is dropped if a job of the same kind
+	// is already queued or running for its revision. Jobs without a group
+	// and jobs of kinds not registered with HandleReview are never
+	// coalesced. Jobs of the same group never run at the same time.
+	Group    string `json:"group,omitempty"`
+	Revision string `json:"revision,omitempty"`
+	// Payload is the JSON encoded event of the job
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// Decode decodes the payload of a job
+func (j *Job) Decode(v interface{}) error {
+	if err := json.Unmarshal(j.Payload, v); err != nil {
+		return fmt.Errorf("invalid payload of %s job %s: %w", j.Kind, j.ID, err)
+	}
+	return nil
+}
+
+// Handler runs a job. Its context is canceled when the queue stops before
+// the job finished; the job then stays in the store and runs again after a
+// restart.
+type Handler func(ctx context.Context, job *Job) error
+
+// Options configures a queue
+type Options struct {
+	// Debounce delays grouped review jobs, so that a burst of pushes results
+	// in a single job for the last revision
+	Debounce time.Duration
+	// Workers is the number of jobs run at the same time
+	Workers int
+	// KeyConcurrency is the number of jobs with the same key run at the same
+	// time; 0 means no limit
+	KeyConcurrency int
+	// Store persists the jobs; nil keeps them in memory only
+	Store *Store
+}
+
+// Queue is a job queue with a bounded pool of workers
+type Queue struct {
+	opts     Options
+	handlers map[string]Handler
+	// reviews holds the kinds registered with HandleReview
+	reviews map[string]bool
+
+	// ctx is passed to handlers and canceled when the queue stops
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []*Job
+	// running counts the running jobs per key
+	running map[string]int
+	// active holds the running jobs with the cancel functions of their
+	// contexts
+	active  map[string]*activeJob
+	closing bool
+}
+
+type activeJob struct {
+	queue  *Queue
+	job    *Job
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	// review is set once the job declared the revision it reviews with
+	// ReviewRevision
+	review bool
+}
+
+type activeJobKey struct{}
+
+// ReviewRevision declares that the job running with ctx reviews a revision
+// of its group, e.g. a comment command that reviews the head commit it
+// looked up. Review jobs queued later for other revisions of the group then
+// supersede it like a review job. Jobs of the group that are already queued
+// are left alone, as they may be for a newer revision. It does nothing
+// outside of a job or for a job without a group.
+func ReviewRevision(ctx context.Context, revision string) {
+	active, ok := ctx.Value(activeJobKey{}).(*activeJob)
+	if !ok || active.job.Group == "" {
+		return
+	}
+	q := active.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	active.job.Revision = revision
+	active.review = true
+}
+
+// New creates a queue. Register handlers with Handle and call Start to run
+// jobs.
+func New(opts Options) *Queue {
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		opts:     opts,
+		handlers: make(map[string]Handler),
+		reviews:  make(map[string]bool),
+		ctx:      ctx,
+		cancel:   cancel,
+		running:  make(map[string]int),
+		active:   make(map[string]*activeJob),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Handle registers the handler of a job kind. Handlers must be registered
+// before Start.
+func (q *Queue) Handle(kind string, handler Handler) {
+	q.handlers[kind] = handler
+}
+
+// HandleReview registers the handler of a job kind that reviews a revision
+// of its group. Review jobs of a group supersede each other; jobs of other
+// kinds never replace or absorb them, even for the same group.
+func (q *Queue) HandleReview(kind string, handler Handler) {
+	q.Handle(kind, handler)
+	q.reviews[kind] = true
+}
+
+// Start queues the jobs the previous run left in the store and starts the
+// workers
+func (q *Queue) Start() error {
+	if q.opts.Store != nil {
+		jobs, err := q.opts.Store.Load()
+		if err != nil {
+			return err
+		}
+
+		q.mu.Lock()
+		// Start 之前入队的任务已经在存储中
+		queued := make(map[string]bool, len(q.pending))
+		for _, job := range q.pending {
+			queued[job.ID] = true
+		}
+		resumed := make([]*Job, 0, len(jobs))
+		for _, job := range jobs {
+			if !queued[job.ID] {
+				resumed = append(resumed, job)
+			}
+		}
+		// 上次遗留的任务排在新任务之前
+		q.pending = append(resumed, q.pending...)
+		q.mu.Unlock()
+
+		if len(resumed) > 0 {
+			logrus.Infof("Resuming %d queued review jobs", len(resumed))
+		}
+	}
+
+	for i := 0; i < q.opts.Workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	return nil
+}
+
+// Enqueue adds a job with the given kind, concurrency key and payload
+func (q *Queue) Enqueue(kind, key string, payload interface{}) error {
+	return q.EnqueueRevision(kind, key, "", "", payload)
+}
+
+// EnqueueRevision adds a job for a revision of a group. For review jobs,
+// pending review jobs of the group for other revisions are dropped and
+// running ones are canceled with ErrSuperseded, and a job of the same kind
+// for the same revision that is already pending or running absorbs the new
+// one, e.g. a redelivered webhook.
+func (q *Queue) EnqueueRevision(kind, key, group, revision string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s job: %w", kind, err)
+	}
+	job := &Job{
+		ID:        uuid.NewString(),
+		Kind:      kind,
+		Key:       key,
+		Group:     group,
+		Revision:  revision,
+		Payload:   data,
+		CreatedAt: time.Now(),
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closing {
+		return ErrClosed
+	}
+	if group != "" {
+		if queued := q.duplicate(job); queued != nil {
+			logrus.Infof("%s job for %s@%s already queued as %s, dropping it", kind, group, revision, queued.ID)
+			return nil
+		}
+	}
+	if q.opts.Store != nil {
+		if err := q.opts.Store.Save(job); err != nil {
+			return err
+		}
+	}
+	if group != "" {
+		q.supersede(job)
+	}
+	q.pending = append(q.pending, job)
+	q.cond.Broadcast()
+	logrus.Debugf("Queued %s job %s for %s, %d pending", kind, job.ID, key, len(q.pending))
+	return nil
+}
+
+// duplicate returns the pending or running review job of the same kind for
+// the group and revision of a new review job, or nil if there is none. It
+// must be called with q.mu held.
+func (q *Queue) duplicate(job *Job) *Job {
+	if !q.reviews[job.Kind] {
+		return nil
+	}
+	same := func(other *Job) bool {
+		return other.Kind == job.Kind && other.Group == job.Group && other.Revision == job.Revision
+	}
+	for _, pending := range q.pending {
+		if same(pending) {
+			return pending
+		}
+	}
+	for _, active := range q.active {
+		if same(active.job) {
+			return active.job
+		}
+	}
+	return nil
+}
+
+// supersede drops the pending review jobs and cancels the running review
+// jobs of the group of a new review job that are for other revisions. It
+// must be called with q.mu held.
+func (q *Queue) supersede(newer *Job) {
+	if !q.reviews[newer.Kind] {
+		return
+	}
+	// 只有审查任务之间相互取代
+	stale := func(job *Job, review bool) bool {
+		return review && job.Group == newer.Group && job.Revision != newer.Revision
+	}
+
+	pending := q.pending[:0]
+	for _, job := range q.pending {
+		if stale(job, q.reviews[job.Kind]) {
+			logrus.Infof("%s job %s for %s@%s superseded by %s", job.Kind, job.ID, job.Group, job.Revision, newer.Revision)
+			q.delete(job)
+			continue
+		}
+		pending = append(pending, job)
+	}
+	q.pending = pending
+
+	for _, active := range q.active {
+		if stale(active.job, q.reviews[active.job.Kind] || active.review) {
+			logrus.Infof("Canceling %s job %s for %s@%s, superseded by %s", active.job.Kind, active.job.ID, active.job.Group, active.job.Revision, newer.Revision)
+			active.cancel(ErrSuperseded)
+		}
+	}
+}
+
+// Len returns the number of jobs waiting to run
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+// Shutdown stops accepting jobs and waits until the workers have drained
+// the queue. If ctx expires first, running jobs are canceled and the jobs
+// that did not finish stay in the store for the next start.
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.mu.Lock()
+	q.closing = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+	}
+
+	logrus.Warnf("Queue not drained in time, canceling running jobs; %d pending jobs remain", q.Len())
+	q.cancel()
+	q.mu.Lock()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	<-drained
+	return ctx.Err()
+}
+
+func (q *Queue) worker() {
+	defer q.wg.Done()
+	for {
+		active := q.next()
+		if active == nil {
+			return
+		}
+		q.run(active)
+		active.cancel(nil)
+
+		job := active.job
+		q.mu.Lock()
+		delete(q.active, job.ID)
+		q.running[job.Key]--
+		if q.running[job.Key] <= 0 {
+			delete(q.running, job.Key)
+		}
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	}
+}
+
+// next waits for the oldest job whose key is below its concurrency limit,
+// whose group has no running job and whose debounce window has passed. It
+// returns nil once the queue is stopped, or closing with no jobs left. The
+// job is registered as active so that newer revisions can cancel it.
+func (q *Queue) next() *activeJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if q.ctx.Err() != nil {
+			return nil
+		}
+
+		// 同一分组的任务依次执行，例如 PR 的审查与评论命令
+		busy := make(map[string]bool, len(q.active))
+		for _, active := range q.active {
+			busy[active.job.Group] = true
+		}
+
+		now := time.Now()
+		var wait time.Duration
+		for i, job := range q.pending {
+			if q.opts.KeyConcurrency > 0 && q.running[job.Key] >= q.opts.KeyConcurrency {
+				continue
+			}
+			if job.Group != "" && busy[job.Group] {
+				continue
+			}
+			// 关闭时不再等待，直接执行剩余任务
+			if q.reviews[job.Kind] && job.Group != "" && !q.closing {
+				if remaining := job.CreatedAt.Add(q.opts.Debounce).Sub(now); remaining > 0 {
+					if wait == 0 || remaining < wait {
+						wait = remaining
+					}
+					continue
+				}
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[job.Key]++
+			ctx, cancel := context.WithCancelCause(q.ctx)
+			active := &activeJob{queue: q, job: job, cancel: cancel}
+			active.ctx = context.WithValue(ctx, activeJobKey{}, active)
+			q.active[job.ID] = active
+			return active
+		}
+		if q.closing && len(q.pending) == 0 {
+			return nil
+		}
+
+		var timer *time.Timer
+		if wait > 0 {
+			timer = time.AfterFunc(wait, q.wake)
+		}
+		q.cond.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// wake wakes the workers waiting for a job
+func (q *Queue) wake() {
+	q.mu.Lock()
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// run runs a job and removes it from the store, unless it was interrupted
+// by the queue stopping
+func (q *Queue) run(active *activeJob) {
+	job, ctx := active.job, active.ctx
+	handler, ok := q.handlers[job.Kind]
+	if !ok {
+		logrus.Errorf("No handler for %s job %s, dropping it", job.Kind, job.ID)
+		q.delete(job)
+		return
+	}
+
+	start := time.Now()
+	err := q.call(ctx, handler, job)
+	if q.ctx.Err() != nil {
+		// 停止时被中断的任务保留在存储中，重启后重新执行
+		logrus.Warnf("%s job %s for %s interrupted by shutdown", job.Kind, job.ID, job.Key)
+		return
+	}
+	if errors.Is(context.Cause(ctx), ErrSuperseded) {
+		logrus.Infof("%s job %s for %s@%s canceled after %s, superseded by a newer revision", job.Kind, job.ID, job.Group, job.Revision, time.Since(start))
+	} else if err != nil {
+		logrus.Errorf("%s job %s for %s failed: %v", job.Kind, job.ID, job.Key, err)
+	} else {
+		logrus.Infof("%s job %s for %s finished in %s", job.Kind, job.ID, job.Key, time.Since(start))
+	}
+	q.delete(job)
+}
+
+// call runs a handler, turning panics into errors
+func (q *Queue) call(ctx context.Context, handler Handler, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+func (q *Queue) delete(job *Job) {
+	if q.opts.Store == nil {
+		return
+	}
+	if err := q.opts.Store.Delete(job.ID); err != nil {
+		logrus.Warnf("%v", err)
+	}
+}
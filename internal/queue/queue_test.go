@@ -0,0 +1,392 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueLimitsConcurrencyPerKey(t *testing.T) {
+	q := New(Options{Workers: 3, KeyConcurrency: 1})
+
+	var mu sync.Mutex
+	running := make(map[string]int)
+	maxRunning := make(map[string]int)
+	total, maxTotal, done := 0, 0, 0
+	q.Handle("review", func(ctx context.Context, job *Job) error {
+		mu.Lock()
+		running[job.Key]++
+		total++
+		if running[job.Key] > maxRunning[job.Key] {
+			maxRunning[job.Key] = running[job.Key]
+		}
+		if total > maxTotal {
+			maxTotal = total
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		running[job.Key]--
+		total--
+		done++
+		mu.Unlock()
+		return nil
+	})
+
+	for i := 0; i < 4; i++ {
+		for _, key := range []string{"github/octo/a", "github/octo/b"} {
+			if err := q.Enqueue("review", key, map[string]int{"number": i}); err != nil {
+				t.Fatalf("Enqueue() error = %v", err)
+			}
+		}
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if done != 8 {
+		t.Errorf("ran %d jobs, want 8", done)
+	}
+	for key, max := range maxRunning {
+		if max != 1 {
+			t.Errorf("%s ran %d jobs at once, want 1", key, max)
+		}
+	}
+	if maxTotal != 2 {
+		t.Errorf("ran %d jobs at once, want 2 (one per repository)", maxTotal)
+	}
+}
+
+func TestQueueResumesInterruptedJobs(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+
+	// 第一次运行：任务一直执行到停止时被取消
+	started := make(chan struct{})
+	q := New(Options{Workers: 1, Store: store})
+	q.Handle("review", func(ctx context.Context, job *Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err := q.Enqueue("review", "gitlab/group/project", map[string]string{"sha": "abc"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := q.Enqueue("review", "gitlab/group/project", map[string]string{"sha": "def"}); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := q.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown() error = %v, want deadline exceeded", err)
+	}
+	if err := q.Enqueue("review", "gitlab/group/project", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue() after Shutdown error = %v, want ErrClosed", err)
+	}
+
+	// 第二次运行：两个任务按入队顺序恢复执行
+	var shas []string
+	resumed := New(Options{Workers: 1, Store: store})
+	resumed.Handle("review", func(ctx context.Context, job *Job) error {
+		var payload map[string]string
+		if err := job.Decode(&payload); err != nil {
+			return err
+		}
+		shas = append(shas, payload["sha"])
+		return nil
+	})
+	if err := resumed.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := resumed.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(shas) != "[abc def]" {
+		t.Errorf("resumed jobs = %v, want [abc def]", shas)
+	}
+
+	jobs, err := store.Load()
+	if err != nil || len(jobs) != 0 {
+		t.Errorf("store has %d jobs after drain (err %v), want 0", len(jobs), err)
+	}
+}
+
+func TestQueueRecoversFromPanics(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	q := New(Options{Workers: 1, Store: store})
+	ran := 0
+	q.Handle("review", func(ctx context.Context, job *Job) error {
+		ran++
+		if ran == 1 {
+			panic("boom")
+		}
+		return nil
+	})
+	for i := 0; i < 2; i++ {
+		if err := q.Enqueue("review", "gitea/owner/repo", i); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	if ran != 2 {
+		t.Errorf("ran %d jobs, want 2", ran)
+	}
+	// 失败的任务不会重试
+	if jobs, _ := store.Load(); len(jobs) != 0 {
+		t.Errorf("store has %d jobs, want 0", len(jobs))
+	}
+}
+
+func TestQueueDebouncesRevisions(t *testing.T) {
+	q := New(Options{Workers: 2, Debounce: 50 * time.Millisecond})
+	var mu sync.Mutex
+	var revisions []string
+	q.HandleReview("review", func(ctx context.Context, job *Job) error {
+		mu.Lock()
+		revisions = append(revisions, job.Revision)
+		mu.Unlock()
+		return nil
+	})
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// 一分钟内连续推送三次，只审查最后一次
+	for _, sha := range []string{"a", "b", "c"} {
+		if err := q.EnqueueRevision("review", "github/octo/service", "github/octo/service#1", sha, nil); err != nil {
+			t.Fatalf("EnqueueRevision() error = %v", err)
+		}
+	}
+	// 其他 PR 的任务不受影响
+	if err := q.EnqueueRevision("review", "github/octo/service", "github/octo/service#2", "x", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	if len(revisions) != 0 {
+		t.Errorf("ran %v before the debounce window passed", revisions)
+	}
+	mu.Unlock()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(revisions) != "[c x]" {
+		t.Errorf("ran revisions %v, want [c x]", revisions)
+	}
+}
+
+func TestQueueCollapsesSameRevision(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	q := New(Options{Workers: 1, Store: store})
+	var ran []string
+	q.HandleReview("review", func(ctx context.Context, job *Job) error {
+		ran = append(ran, job.Kind+"@"+job.Revision)
+		return nil
+	})
+	q.Handle("check", func(ctx context.Context, job *Job) error {
+		ran = append(ran, job.Kind+"@"+job.Revision)
+		return nil
+	})
+
+	// 重新投递的 webhook 合并到已排队的任务中，其他类型的任务不受影响
+	group := "github/octo/service#1"
+	for _, kind := range []string{"review", "review", "check"} {
+		if err := q.EnqueueRevision(kind, "github/octo/service", group, "a", nil); err != nil {
+			t.Fatalf("EnqueueRevision() error = %v", err)
+		}
+	}
+	if q.Len() != 2 {
+		t.Fatalf("Len() = %d, want 2", q.Len())
+	}
+	if jobs, _ := store.Load(); len(jobs) != 2 {
+		t.Errorf("store has %d jobs, want 2", len(jobs))
+	}
+
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(ran) != "[review@a check@a]" {
+		t.Errorf("ran %v, want [review@a check@a]", ran)
+	}
+}
+
+func TestQueueCancelsSupersededJob(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	q := New(Options{Workers: 2, KeyConcurrency: 1, Store: store})
+
+	started := make(chan struct{})
+	causes := make(chan error, 1)
+	var finished []string
+	q.HandleReview("review", func(ctx context.Context, job *Job) error {
+		if job.Revision == "old" {
+			close(started)
+			<-ctx.Done()
+			causes <- context.Cause(ctx)
+			return ctx.Err()
+		}
+		finished = append(finished, job.Revision)
+		return nil
+	})
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	group := "gitea/owner/repo#3"
+	if err := q.EnqueueRevision("review", "gitea/owner/repo", group, "old", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	<-started
+	// 同一提交的重复事件不会取消正在进行的审查
+	if err := q.EnqueueRevision("review", "gitea/owner/repo", group, "old", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	select {
+	case cause := <-causes:
+		t.Fatalf("job canceled by an event for the same revision: %v", cause)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	if err := q.EnqueueRevision("review", "gitea/owner/repo", group, "new", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	select {
+	case cause := <-causes:
+		if !errors.Is(cause, ErrSuperseded) {
+			t.Errorf("cancel cause = %v, want ErrSuperseded", cause)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("superseded job was not canceled")
+	}
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(finished) != "[new]" {
+		t.Errorf("finished revisions %v, want [new]", finished)
+	}
+	if jobs, _ := store.Load(); len(jobs) != 0 {
+		t.Errorf("store has %d jobs, want 0", len(jobs))
+	}
+}
+
+func TestQueueOnlyReviewsSupersede(t *testing.T) {
+	q := New(Options{Workers: 1})
+	var ran []string
+	q.HandleReview("review", func(ctx context.Context, job *Job) error {
+		ran = append(ran, job.Kind+"@"+job.Revision)
+		return nil
+	})
+	q.Handle("check", func(ctx context.Context, job *Job) error {
+		ran = append(ran, job.Kind+"@"+job.Revision)
+		return nil
+	})
+
+	// 旧提交的其他类型事件既不取代新提交的审查，也不会被它取代
+	group := "github/octo/service#1"
+	if err := q.EnqueueRevision("check", "github/octo/service", group, "a", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	if err := q.EnqueueRevision("review", "github/octo/service", group, "b", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	if err := q.EnqueueRevision("check", "github/octo/service", group, "a", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	if q.Len() != 3 {
+		t.Fatalf("Len() = %d, want 3", q.Len())
+	}
+
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(ran) != "[check@a review@b check@a]" {
+		t.Errorf("ran %v, want [check@a review@b check@a]", ran)
+	}
+}
+
+func TestQueueSupersedesDeclaredReview(t *testing.T) {
+	q := New(Options{Workers: 2})
+
+	started := make(chan struct{})
+	causes := make(chan error, 1)
+	var ran []string
+	q.Handle("command", func(ctx context.Context, job *Job) error {
+		// 命令在执行时才知道审查的提交
+		ReviewRevision(ctx, "a")
+		close(started)
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	q.HandleReview("review", func(ctx context.Context, job *Job) error {
+		select {
+		case cause := <-causes:
+			if !errors.Is(cause, ErrSuperseded) {
+				t.Errorf("command cancel cause = %v, want ErrSuperseded", cause)
+			}
+			ran = append(ran, job.Kind+"@"+job.Revision)
+		default:
+			t.Errorf("%s job for %s ran while the command was running", job.Kind, job.Revision)
+		}
+		return nil
+	})
+	if err := q.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	group := "github/octo/service#1"
+	if err := q.EnqueueRevision("command", "github/octo/service", group, "", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+	<-started
+	if err := q.EnqueueRevision("review", "github/octo/service", group, "b", nil); err != nil {
+		t.Fatalf("EnqueueRevision() error = %v", err)
+	}
+
+	if err := q.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+	if fmt.Sprint(ran) != "[review@b]" {
+		t.Errorf("ran %v, want [review@b] after the command was superseded", ran)
+	}
+}
@@ -0,0 +1,137 @@
+package tokenizer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	hf "github.com/sugarme/tokenizer"
+	"github.com/sugarme/tokenizer/decoder"
+	"github.com/sugarme/tokenizer/model/bpe"
+	"github.com/sugarme/tokenizer/pretokenizer"
+	"github.com/sugarme/tokenizer/pretrained"
+	"github.com/sugarme/tokenizer/processor"
+)
+
+// Vocabulary file names looked up in a family directory
+const (
+	TokenizerJSONFile = "tokenizer.json"
+	VocabFile         = "vocab.json"
+	MergesFile        = "merges.txt"
+)
+
+// BPE counts tokens with a byte-pair encoding vocabulary loaded from disk
+type BPE struct {
+	name string
+	// 底层分词器并非完全并发安全
+	mu sync.Mutex
+	tk *hf.Tokenizer
+	// fallback is used if the text cannot be encoded
+	fallback Tokenizer
+}
+
+// LoadBPE loads a tokenizer from dir. A Hugging Face tokenizer.json is
+// preferred; otherwise dir must contain a GPT-2 style byte-level vocab.json
+// and merges.txt.
+func LoadBPE(name, dir string) (*BPE, error) {
+	var (
+		tk  *hf.Tokenizer
+		err error
+	)
+	if path := filepath.Join(dir, TokenizerJSONFile); fileExists(path) {
+		tk, err = pretrained.FromFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", path, err)
+		}
+	} else {
+		tk, err = newByteLevelBPE(filepath.Join(dir, VocabFile), filepath.Join(dir, MergesFile))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &BPE{
+		name:     "bpe:" + name,
+		tk:       tk,
+		fallback: Estimator{},
+	}, nil
+}
+
+// newByteLevelBPE builds a GPT-2 style byte-level BPE tokenizer
+func newByteLevelBPE(vocabPath, mergesPath string) (*hf.Tokenizer, error) {
+	if !fileExists(vocabPath) || !fileExists(mergesPath) {
+		return nil, fmt.Errorf("neither %s nor %s and %s found", TokenizerJSONFile, VocabFile, MergesFile)
+	}
+
+	model, err := bpe.NewBpeFromFiles(vocabPath, mergesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load BPE vocabulary: %w", err)
+	}
+
+	tk := hf.NewTokenizer(model)
+	pretok := pretokenizer.NewByteLevel()
+	pretok.SetAddPrefixSpace(false)
+	pretok.SetTrimOffsets(false)
+	tk.WithPreTokenizer(pretok)
+	tk.WithPostProcessor(processor.NewByteLevelProcessing(pretok))
+	tk.WithDecoder(decoder.NewBpeDecoder("Ġ"))
+	return tk, nil
+}
+
+// Name returns the name of the tokenizer
+func (b *BPE) Name() string {
+	return b.name
+}
+
+// CountTokens returns the number of tokens of text
+func (b *BPE) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	b.mu.Lock()
+	encoding, err := b.tk.EncodeSingle(text, false)
+	b.mu.Unlock()
+	if err != nil {
+		logrus.Debugf("%s failed to encode text: %v, estimating instead", b.name, err)
+		return b.fallback.CountTokens(text)
+	}
+	return len(encoding.Ids)
+}
+
+// LoadDir registers a tokenizer for every subdirectory of dir that holds a
+// vocabulary. The directory name is the model family, e.g.
+//
+//	$TOKENIZER_DIR/claude/tokenizer.json
+//	$TOKENIZER_DIR/gpt/vocab.json, merges.txt
+//	$TOKENIZER_DIR/default/tokenizer.json
+func LoadDir(r *Registry, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		family := entry.Name()
+		tk, err := LoadBPE(family, filepath.Join(dir, family))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", family, err))
+			continue
+		}
+		r.Register(family, tk)
+		logrus.Infof("Loaded %s tokenizer for model family %q", tk.Name(), family)
+	}
+	return errors.Join(errs...)
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,137 @@
+// Package tokenizer counts tokens the way the target model does, so that
+// patches and context can be sized against real context windows.
+package tokenizer
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Tokenizer counts the tokens of a text for one model family
+type Tokenizer interface {
+	// Name identifies the tokenizer in logs, e.g. "bpe:gpt" or "estimate"
+	Name() string
+
+	// CountTokens returns the number of tokens of text
+	CountTokens(text string) int
+}
+
+// Estimator approximates token counts without a vocabulary. ASCII text,
+// which is mostly code, is counted as one token per four bytes; every other
+// rune, such as CJK characters, is counted as one token.
+type Estimator struct{}
+
+// Name returns the name of the estimator
+func (Estimator) Name() string {
+	return "estimate"
+}
+
+// CountTokens estimates the number of tokens of text
+func (Estimator) CountTokens(text string) int {
+	ascii, other := 0, 0
+	for i := 0; i < len(text); {
+		if text[i] < utf8.RuneSelf {
+			ascii++
+			i++
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		other++
+		i += size
+	}
+	return (ascii+3)/4 + other
+}
+
+// Registry maps model families to tokenizers
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]Tokenizer
+	fallback Tokenizer
+}
+
+// NewRegistry creates a registry that uses fallback for unknown models
+func NewRegistry(fallback Tokenizer) *Registry {
+	if fallback == nil {
+		fallback = Estimator{}
+	}
+	return &Registry{
+		families: make(map[string]Tokenizer),
+		fallback: fallback,
+	}
+}
+
+// Register sets the tokenizer of a model family. The family is matched
+// against model names case-insensitively, e.g. "claude" matches
+// "aws/claude-3-5-sonnet".
+func (r *Registry) Register(family string, tk Tokenizer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.families[strings.ToLower(family)] = tk
+}
+
+// Families returns the registered family names
+func (r *Registry) Families() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ForModel returns the tokenizer of the longest family contained in the
+// model name, the "default" family if registered, or the fallback
+func (r *Registry) ForModel(model string) Tokenizer {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	model = strings.ToLower(model)
+	var best string
+	for family := range r.families {
+		if family != "default" && strings.Contains(model, family) && len(family) > len(best) {
+			best = family
+		}
+	}
+	if best != "" {
+		return r.families[best]
+	}
+	if tk, ok := r.families["default"]; ok {
+		return tk
+	}
+	return r.fallback
+}
+
+var (
+	defaultOnce     sync.Once
+	defaultRegistry *Registry
+)
+
+// Default returns the process-wide registry. On first use it loads the
+// vocabularies found under TOKENIZER_DIR, see LoadDir.
+func Default() *Registry {
+	defaultOnce.Do(func() {
+		defaultRegistry = NewRegistry(Estimator{})
+		dir := os.Getenv("TOKENIZER_DIR")
+		if dir == "" {
+			logrus.Debug("TOKENIZER_DIR not set, estimating token counts")
+			return
+		}
+		if err := LoadDir(defaultRegistry, dir); err != nil {
+			logrus.Warnf("Failed to load tokenizers from %s: %v, estimating token counts", dir, err)
+		}
+	})
+	return defaultRegistry
+}
+
+// ForModel returns the tokenizer of the default registry for model
+func ForModel(model string) Tokenizer {
+	return Default().ForModel(model)
+}
@@ -0,0 +1,79 @@
+package tokenizer
+
+import "testing"
+
+func TestEstimatorCountTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want int
+	}{
+		{name: "empty", text: "", want: 0},
+		{name: "ascii", text: "func main() {}", want: 4},
+		{name: "cjk", text: "代码审查", want: 4},
+		{name: "mixed", text: "// 修复 bug", want: 2 + 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (Estimator{}).CountTokens(tt.text); got != tt.want {
+				t.Errorf("CountTokens(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+// fixedTokenizer counts every text as the same number of tokens
+type fixedTokenizer struct {
+	name  string
+	count int
+}
+
+func (f fixedTokenizer) Name() string           { return f.name }
+func (f fixedTokenizer) CountTokens(string) int { return f.count }
+
+func TestRegistryForModel(t *testing.T) {
+	r := NewRegistry(nil)
+	r.Register("gpt", fixedTokenizer{name: "gpt"})
+	r.Register("gpt-4o", fixedTokenizer{name: "gpt-4o"})
+	r.Register("Claude", fixedTokenizer{name: "claude"})
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{model: "gpt-4-turbo", want: "gpt"},
+		{model: "gpt-4o-mini", want: "gpt-4o"},
+		{model: "aws/claude-3-5-sonnet", want: "claude"},
+		{model: "qwen2.5-coder", want: "estimate"},
+	}
+	for _, tt := range tests {
+		if got := r.ForModel(tt.model).Name(); got != tt.want {
+			t.Errorf("ForModel(%q) = %s, want %s", tt.model, got, tt.want)
+		}
+	}
+
+	r.Register("default", fixedTokenizer{name: "default"})
+	if got := r.ForModel("qwen2.5-coder").Name(); got != "default" {
+		t.Errorf("ForModel() with default family = %s, want default", got)
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	r := NewRegistry(nil)
+	if err := LoadDir(r, "testdata"); err != nil {
+		t.Fatalf("LoadDir() error = %v", err)
+	}
+
+	tk := r.ForModel("tiny-model")
+	if tk.Name() != "bpe:tiny" {
+		t.Fatalf("ForModel() = %s, want bpe:tiny", tk.Name())
+	}
+	// "hello" 和 " world" 各自合并为一个 token
+	if got := tk.CountTokens("hello world"); got != 2 {
+		t.Errorf("CountTokens() = %d, want 2", got)
+	}
+	if got := tk.CountTokens("hello wo"); got != 3 {
+		t.Errorf("CountTokens() = %d, want 3", got)
+	}
+}